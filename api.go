@@ -2,6 +2,7 @@
 package scotty
 
 import (
+	"fmt"
 	"github.com/Symantec/scotty/hostid"
 	"github.com/Symantec/scotty/metrics"
 	"github.com/Symantec/scotty/sources"
@@ -19,6 +20,8 @@ const (
 	FailedToConnect Status = -1 - iota
 	// connected, but failed to collect metrics
 	FailedToPoll
+	// failed to connect repeatedly, waiting before trying again
+	BackingOff
 )
 
 const (
@@ -48,6 +51,8 @@ func (s Status) String() string {
 		return "failed to connect"
 	case FailedToPoll:
 		return "failed to poll"
+	case BackingOff:
+		return "backing off"
 	default:
 		return ""
 	}
@@ -64,6 +69,8 @@ type State struct {
 	pollDuration          time.Duration
 	connectorName         string
 	status                Status
+	consecutiveFailures   uint
+	retryTime             time.Time
 }
 
 // ConnectorName returns the name of the connector
@@ -108,6 +115,52 @@ func (s *State) Status() Status {
 	return s.status
 }
 
+// ConsecutiveFailures returns how many times in a row connecting to the
+// endpoint has failed. ConsecutiveFailures returns 0 unless the status
+// is FailedToConnect or BackingOff.
+func (s *State) ConsecutiveFailures() uint {
+	return s.consecutiveFailures
+}
+
+// RetryTime returns the earliest time the endpoint will be polled again
+// after failing to connect. RetryTime returns the zero time if the
+// endpoint is not backing off.
+func (s *State) RetryTime() time.Time {
+	return s.retryTime
+}
+
+// TimeoutError is the error that Endpoint instances pass to
+// Logger.LogError when connecting to or polling an endpoint takes too long.
+type TimeoutError struct {
+	// FailedToConnect or FailedToPoll
+	Status Status
+	// How long the Endpoint waited
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Status == FailedToConnect {
+		return fmt.Sprintf("Timed out connecting after %v", e.Timeout)
+	}
+	return fmt.Sprintf("Timed out polling after %v", e.Timeout)
+}
+
+// IsTimeout returns true if err is a *TimeoutError.
+func IsTimeout(err error) bool {
+	_, ok := err.(*TimeoutError)
+	return ok
+}
+
+// PollTimeouts limits how long collecting metrics from an endpoint may
+// take. Zero values mean no limit.
+type PollTimeouts struct {
+	// Maximum time to connect
+	Connect time.Duration
+	// Maximum time to download the metrics once connected. This does
+	// not include time spent waiting to poll.
+	Poll time.Duration
+}
+
 // Logger is the interface for instances that log metric collection events.
 // Endpoint instances call Logger methods immediately after updating themselves.
 // Logger instances must be safe to use among multiple goroutines.
@@ -118,7 +171,8 @@ type Logger interface {
 	LogResponse(
 		e *Endpoint, response metrics.List, timestamp time.Time) error
 	// Called when error happens collecting metrics from a given
-	// endpoint.
+	// endpoint. If connecting or polling took too long, err is a
+	// *TimeoutError.
 	// Also called when an error clears. In such a case both err and
 	// state are nil.
 	LogError(e *Endpoint, err error, state *State)
@@ -136,12 +190,14 @@ type Endpoint struct {
 	onePollAtATime chan bool
 	// These fields read and changed only by goroutine that has the
 	// onePollAtATime semaphore
-	state        *State
-	errored      bool
-	lock         sync.Mutex
-	isTls        bool
-	resourcePort uint
-	resource     sources.Resource
+	state               *State
+	errored             bool
+	consecutiveFailures uint
+	retryTime           time.Time
+	lock                sync.Mutex
+	isTls               bool
+	resourcePort        uint
+	resource            sources.Resource
 }
 
 // NewEndpointWithConnector creates a new endpoint for given host, port
@@ -179,9 +235,22 @@ func (e *Endpoint) ConnectorName() string {
 // isTls indicates whether or not TLS should be used
 // port is the port to use to connect.
 // logger logs collection events for this polling
+// If this instance is backing off after failing to connect, Poll neither
+// connects nor blocks; it just puts this instance in the BackingOff state.
 func (e *Endpoint) Poll(
 	sweepStartTime time.Time, isTls bool, port uint, logger Logger) {
-	e.poll(sweepStartTime, isTls, port, logger)
+	e.poll(sweepStartTime, isTls, port, PollTimeouts{}, logger)
+}
+
+// PollWithTimeouts works like Poll except that it gives up connecting
+// or polling when it takes longer than timeouts allows.
+func (e *Endpoint) PollWithTimeouts(
+	sweepStartTime time.Time,
+	isTls bool,
+	port uint,
+	timeouts PollTimeouts,
+	logger Logger) {
+	e.poll(sweepStartTime, isTls, port, timeouts, logger)
 }
 
 // SetConcurrentPolls sets the maximum number of concurrent polls.
@@ -209,3 +278,22 @@ func SetConcurrentConnects(x uint) {
 func ConcurrentConnects() uint {
 	return concurrentConnects
 }
+
+// SetBackoff makes endpoints that fail to connect wait before trying to
+// connect again so that dead endpoints do not tie up connection slots.
+// After failing to connect once, an endpoint waits min before its next
+// attempt. Each further failure in a row doubles the wait up to max.
+// Once the wait is over, the next call to Poll tries connecting once;
+// if that succeeds, the endpoint goes back to being polled normally.
+// min of 0, the default, means no backoff.
+// Call SetBackoff at the beginning of the main() function before
+// calling Endpoint.Poll
+func SetBackoff(min, max time.Duration) {
+	setBackoff(min, max)
+}
+
+// Backoff returns the minimum and maximum time to wait before connecting
+// to an endpoint that failed to connect.
+func Backoff() (min, max time.Duration) {
+	return backoffMin, backoffMax
+}
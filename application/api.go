@@ -5,6 +5,7 @@ import (
 	"github.com/Symantec/scotty"
 	"github.com/Symantec/scotty/hostid"
 	"github.com/Symantec/scotty/namesandports"
+	"github.com/Symantec/scotty/sources"
 	"time"
 )
 
@@ -16,6 +17,19 @@ const (
 	HealthAgentPort = 6910
 )
 
+// Connectors maps protocols to the connectors that read the metrics of
+// applications using them. See namesandports.Record. Applications whose
+// protocol has no connector are read as tricorder applications.
+// A nil Connectors has no connectors.
+type Connectors map[string]sources.Connector
+
+// Add makes applications whose protocol is protocol use conn to read their
+// metrics. Add returns an error if protocol already has a connector or
+// names a built in protocol. c must be non-nil.
+func (c Connectors) Add(protocol string, conn sources.Connector) error {
+	return c.add(protocol, conn)
+}
+
 // Statistics for reading metrics for a particular application
 type EndpointStats struct {
 	// Time metrics were last read. Zero means never read.
@@ -40,6 +54,12 @@ type EndpointStats struct {
 
 	// Status: e.g Synced, Polling, Connecting etc.
 	Status scotty.Status
+
+	// Number of times in a row scotty failed to connect.
+	ConsecutiveFailures uint
+
+	// When scotty will next try to connect. Zero means not backing off.
+	RetryTime time.Time
 }
 
 // Staleness is a convenience routine that returns now minus the last read
@@ -66,6 +86,12 @@ func (e *EndpointStats) LastErrorTimeStr() string {
 	return e.LastErrorTime.Format("2006-01-02T15:04:05")
 }
 
+// RetryTimeStr is a convenience routine that reports retry time
+// as a string formatted as 2006-01-02T15:04:05.
+func (e *EndpointStats) RetryTimeStr() string {
+	return e.RetryTime.Format("2006-01-02T15:04:05")
+}
+
 // Application represents an application
 type Application struct {
 
@@ -87,6 +113,12 @@ type Application struct {
 	// True if this application is active. An application is active if
 	// health agent reports it. The value of this field can change.
 	Active bool
+
+	// True if this application pushes its metrics to scotty. scotty
+	// never polls such applications. Applications that push their
+	// metrics become inactive when they stop pushing; see
+	// InactivatePushApplications.
+	Push bool
 }
 
 // Group contains applications running on a particular machine
@@ -94,6 +126,7 @@ type Group struct {
 	host              *hostid.HostID
 	apps              map[string]*applicationDataType
 	countToInactivate int
+	connectors        Connectors
 }
 
 // NewGroup returns a new group instance. host identifies the machine;
@@ -106,12 +139,68 @@ func NewGroup(host *hostid.HostID, countToInactivate int) (
 	return newGroup(host, countToInactivate)
 }
 
+// NewPushGroup returns a new group instance for a machine that scotty
+// knows about only because applications on it push their metrics.
+// Unlike NewGroup, the new group does not contain the health agent.
+func NewPushGroup(host *hostid.HostID, countToInactivate int) *Group {
+	return newPushGroup(host, countToInactivate)
+}
+
+// SetConnectors tells this instance which connectors read the metrics of
+// newly reported applications. Applications already reported keep their
+// connector. Initially, all applications are read as tricorder
+// applications. See Connectors.
+func (g *Group) SetConnectors(connectors Connectors) {
+	g.connectors = connectors
+}
+
+// AddHealthAgent adds the health agent running on port 6910 to this
+// instance and returns its scotty.Endpoint. If this instance already
+// contains the health agent, AddHealthAgent returns nil.
+func (g *Group) AddHealthAgent() *scotty.Endpoint {
+	return g.addHealthAgent()
+}
+
+// AddPushApplication returns the scotty.Endpoint of the application with
+// given name that pushes its metrics to scotty adding that application if
+// necessary. isNew is true if AddPushApplication added the application.
+// If the application was inactive, AddPushApplication marks it active and
+// sets activated to true. If this instance already has an application by
+// that name that scotty polls, AddPushApplication returns nil.
+func (g *Group) AddPushApplication(name string) (
+	ep *scotty.Endpoint, isNew, activated bool) {
+	return g.addPushApplication(name)
+}
+
+// InactivatePushApplications marks each active application that pushes
+// its metrics inactive if it last pushed metrics before lastPushedBefore.
+// The LastReadTime field holds when an application last pushed metrics.
+// Applications that have yet to push metrics stay active.
+// InactivatePushApplications returns the scotty.Endpoint of each
+// application it marked inactive.
+func (g *Group) InactivatePushApplications(lastPushedBefore time.Time) (
+	inactive []*scotty.Endpoint) {
+	return g.inactivatePushApplications(lastPushedBefore)
+}
+
+// PushedSince returns true if an application that pushes its metrics
+// pushed them at or after t. Applications that have yet to push metrics
+// count as having pushed them just now.
+func (g *Group) PushedSince(t time.Time) bool {
+	return g.pushedSince(t)
+}
+
 // SetApplications tells this instance the names and ports of running
 // applications. If a previously reported application isn't reported
 // countToInactive times (see NewGroup) then SetApplications marks that
 // application inactive. If an inactive application is listed in namesAndPorts,
 // SetApplications marks it as active. namesandports need not include the
 // health agent running on port 6910. That application is always active.
+// SetApplications leaves applications that push their metrics alone.
+//
+// The protocol in namesAndPorts determines how scotty reads the metrics of
+// a newly reported application. Changing the protocol of an application
+// already reported has no effect.
 //
 // SetApplications returns the scotty.Endpoint for newly reported applications,
 // previously inactive applications marked active, and previously active
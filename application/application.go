@@ -1,12 +1,16 @@
 package application
 
 import (
+	"errors"
+	"fmt"
 	"github.com/Symantec/scotty"
 	"github.com/Symantec/scotty/hostid"
 	"github.com/Symantec/scotty/namesandports"
 	"github.com/Symantec/scotty/sources"
 	"github.com/Symantec/scotty/sources/jsonsource"
+	"github.com/Symantec/scotty/sources/promsource"
 	"github.com/Symantec/scotty/sources/trisource"
+	"time"
 )
 
 var (
@@ -16,28 +20,132 @@ var (
 			jsonsource.GetConnector(),
 		},
 		100)
+	kPrometheusConnector = promsource.GetConnector()
+	kPushConnector       = pushConnectorType{}
 )
 
+var (
+	errPushApplication = errors.New(
+		"application: application pushes its metrics")
+)
+
+// pushConnectorType is the connector for applications that push their
+// metrics. Since scotty never polls such applications, connecting always
+// fails.
+type pushConnectorType struct {
+}
+
+func (p pushConnectorType) Connect(
+	host string, port uint, config sources.Config) (sources.Poller, error) {
+	return nil, errPushApplication
+}
+
+func (p pushConnectorType) Name() string {
+	return "push"
+}
+
+func (c Connectors) add(protocol string, conn sources.Connector) error {
+	if protocol == namesandports.Tricorder ||
+		protocol == namesandports.Prometheus {
+		return fmt.Errorf(
+			"application: Cannot replace built in protocol %s", protocol)
+	}
+	if c[protocol] != nil {
+		return fmt.Errorf(
+			"application: Protocol already has a connector: %s", protocol)
+	}
+	c[protocol] = conn
+	return nil
+}
+
+// connectorFor returns the connector for applications using given protocol.
+func (c Connectors) connectorFor(protocol string) sources.Connector {
+	if protocol == namesandports.Prometheus {
+		return kPrometheusConnector
+	}
+	if conn := c[protocol]; conn != nil {
+		return conn
+	}
+	return kConnector
+}
+
 type applicationDataType struct {
 	A             Application
 	InactiveCount int
 }
 
 func newGroup(host *hostid.HostID, countToInactivate int) (*Group, *scotty.Endpoint) {
+	result := newPushGroup(host, countToInactivate)
+	return result, result.addHealthAgent()
+}
+
+func newPushGroup(host *hostid.HostID, countToInactivate int) *Group {
+	return &Group{
+		host:              host,
+		apps:              make(map[string]*applicationDataType),
+		countToInactivate: countToInactivate}
+}
+
+func (g *Group) addHealthAgent() *scotty.Endpoint {
+	if g.apps[HealthAgentName] != nil {
+		return nil
+	}
 	ep := scotty.NewEndpointWithConnector(
-		host, HealthAgentName, kConnector)
-	appData := &applicationDataType{
+		g.host, HealthAgentName, kConnector)
+	g.apps[HealthAgentName] = &applicationDataType{
 		A: Application{
 			EP:     ep,
 			Port:   HealthAgentPort,
 			Active: true},
 	}
-	apps := make(map[string]*applicationDataType)
-	apps[HealthAgentName] = appData
-	return &Group{
-		host:              host,
-		apps:              apps,
-		countToInactivate: countToInactivate}, ep
+	return ep
+}
+
+func (g *Group) addPushApplication(name string) (
+	ep *scotty.Endpoint, isNew, activated bool) {
+	appData := g.apps[name]
+	if appData != nil {
+		if !appData.A.Push {
+			return nil, false, false
+		}
+		if !appData.A.Active {
+			appData.A.Active = true
+			activated = true
+		}
+		return appData.A.EP, false, activated
+	}
+	ep = scotty.NewEndpointWithConnector(g.host, name, kPushConnector)
+	g.apps[name] = &applicationDataType{
+		A: Application{
+			EP:     ep,
+			Push:   true,
+			Active: true,
+		},
+	}
+	return ep, true, false
+}
+
+func (g *Group) inactivatePushApplications(lastPushedBefore time.Time) (
+	inactive []*scotty.Endpoint) {
+	for _, appData := range g.apps {
+		a := &appData.A
+		if a.Push && a.Active && !a.LastReadTime.IsZero() &&
+			a.LastReadTime.Before(lastPushedBefore) {
+			a.Active = false
+			inactive = append(inactive, a.EP)
+		}
+	}
+	return
+}
+
+func (g *Group) pushedSince(t time.Time) bool {
+	for _, appData := range g.apps {
+		a := &appData.A
+		if a.Push && (a.LastReadTime.IsZero() || !a.LastReadTime.Before(t)) {
+			return true
+		}
+	}
+	return false
 }
 
 func (g *Group) modify(name string, mod func(*EndpointStats)) {
@@ -72,9 +180,13 @@ func (g *Group) setApplications(namesAndPorts namesandports.NamesAndPorts) (
 			continue
 		}
 		appData := g.apps[name]
+		// Applications pushing their metrics stay as they are.
+		if appData != nil && appData.A.Push {
+			continue
+		}
 		if appData == nil {
 			ep := scotty.NewEndpointWithConnector(
-				g.host, name, kConnector)
+				g.host, name, g.connectors.connectorFor(record.Protocol))
 			appData := &applicationDataType{
 				A: Application{
 					EP:     ep,
@@ -99,7 +211,7 @@ func (g *Group) setApplications(namesAndPorts namesandports.NamesAndPorts) (
 	}
 	// inactivate apps
 	for name, appData := range g.apps {
-		if name == HealthAgentName {
+		if name == HealthAgentName || appData.A.Push {
 			continue
 		}
 		if _, ok := namesAndPorts[name]; !ok {
@@ -1,13 +1,16 @@
 package application_test
 
 import (
+	"errors"
 	"fmt"
 	"github.com/Symantec/scotty"
 	"github.com/Symantec/scotty/application"
 	"github.com/Symantec/scotty/hostid"
 	"github.com/Symantec/scotty/namesandports"
+	"github.com/Symantec/scotty/sources"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 func TestApi(t *testing.T) {
@@ -131,6 +134,135 @@ func TestApi(t *testing.T) {
 				So(group.ByName("dominator").Port, ShouldEqual, 6972)
 			})
 		})
+		Convey("Push applications work", func() {
+			So(group.AddHealthAgent(), ShouldBeNil)
+			ep, isNew, _ := group.AddPushApplication("scotty")
+			So(ep, ShouldBeNil)
+			So(isNew, ShouldBeFalse)
+			ep, isNew, activated := group.AddPushApplication("job")
+			So(ep.AppName(), ShouldEqual, "job")
+			So(isNew, ShouldBeTrue)
+			So(activated, ShouldBeFalse)
+			again, isNew, activated := group.AddPushApplication("job")
+			So(again, ShouldEqual, ep)
+			So(isNew, ShouldBeFalse)
+			So(activated, ShouldBeFalse)
+			So(group.ByName("job").Push, ShouldBeTrue)
+			So(group.ByName("job").Active, ShouldBeTrue)
+			newApps, active, inactive := group.SetApplications(
+				namesandports.NamesAndPorts{
+					"job": {Port: 7000},
+				})
+			So(newApps, ShouldHaveLength, 0)
+			So(active, ShouldHaveLength, 0)
+			So(inactive, ShouldHaveLength, 0)
+			So(group.ByName("job").Active, ShouldBeTrue)
+			So(group.ByName("job").Port, ShouldEqual, 0)
+
+			// Not pushed yet
+			now := time.Now()
+			So(group.InactivatePushApplications(now), ShouldHaveLength, 0)
+			group.Modify("job", func(stats *application.EndpointStats) {
+				stats.LastReadTime = now.Add(-time.Minute)
+			})
+			So(
+				group.InactivatePushApplications(now.Add(-time.Hour)),
+				ShouldHaveLength,
+				0)
+			So(
+				group.InactivatePushApplications(now),
+				shouldHaveHostAndNames,
+				"ahost",
+				"job")
+			So(group.ByName("job").Active, ShouldBeFalse)
+			So(group.InactivatePushApplications(now), ShouldHaveLength, 0)
+			_, _, activated = group.AddPushApplication("job")
+			So(activated, ShouldBeTrue)
+			So(group.ByName("job").Active, ShouldBeTrue)
+		})
+	})
+}
+
+func TestPushGroup(t *testing.T) {
+	Convey("Push groups start without the health agent", t, func() {
+		group := application.NewPushGroup(
+			&hostid.HostID{HostName: "ahost"}, 1)
+		So(group.Applications(), ShouldHaveLength, 0)
+		healthAgentEp := group.AddHealthAgent()
+		So(healthAgentEp.AppName(), ShouldEqual, application.HealthAgentName)
+		So(group.ByName(application.HealthAgentName).Active, ShouldBeTrue)
+		So(group.AddHealthAgent(), ShouldBeNil)
+	})
+}
+
+type fakeConnectorType string
+
+func (f fakeConnectorType) Connect(
+	host string, port uint, config sources.Config) (sources.Poller, error) {
+	return nil, errors.New("fake connector")
+}
+
+func (f fakeConnectorType) Name() string {
+	return string(f)
+}
+
+func TestConnectors(t *testing.T) {
+	Convey("Protocols with connectors use their connector", t, func() {
+		connectors := make(application.Connectors)
+		So(connectors.Add("fake", fakeConnectorType("fake")), ShouldBeNil)
+		So(
+			connectors.Add("fake", fakeConnectorType("fake")),
+			ShouldNotBeNil)
+		So(
+			connectors.Add(
+				namesandports.Prometheus, fakeConnectorType("fake")),
+			ShouldNotBeNil)
+		So(
+			connectors.Add(
+				namesandports.Tricorder, fakeConnectorType("fake")),
+			ShouldNotBeNil)
+		group, _ := application.NewGroup(&hostid.HostID{HostName: "ahost"}, 3)
+		group.SetConnectors(connectors)
+		newApps, _, _ := group.SetApplications(
+			namesandports.NamesAndPorts{
+				"lb":      {Port: 8404, Protocol: "fake"},
+				"prom":    {Port: 9100, Protocol: namesandports.Prometheus},
+				"unknown": {Port: 7000, Protocol: "unknown"},
+			})
+		So(newApps, ShouldHaveLength, 3)
+		So(group.ByName("lb").EP.ConnectorName(), ShouldEqual, "fake")
+		So(group.ByName("prom").EP.ConnectorName(), ShouldEqual, "prometheus")
+		So(
+			group.ByName("unknown").EP.ConnectorName(),
+			ShouldEqual,
+			group.ByName(application.HealthAgentName).EP.ConnectorName())
+
+		Convey("Other groups don't see these connectors", func() {
+			other, _ := application.NewGroup(
+				&hostid.HostID{HostName: "bhost"}, 3)
+			other.SetApplications(
+				namesandports.NamesAndPorts{
+					"lb": {Port: 8404, Protocol: "fake"},
+				})
+			So(
+				other.ByName("lb").EP.ConnectorName(),
+				ShouldEqual,
+				other.ByName(application.HealthAgentName).EP.ConnectorName())
+		})
+
+		Convey("Applications keep their connector", func() {
+			group.SetConnectors(nil)
+			group.SetApplications(
+				namesandports.NamesAndPorts{
+					"lb":  {Port: 8404, Protocol: "fake"},
+					"lb2": {Port: 8405, Protocol: "fake"},
+				})
+			So(group.ByName("lb").EP.ConnectorName(), ShouldEqual, "fake")
+			So(
+				group.ByName("lb2").EP.ConnectorName(),
+				ShouldEqual,
+				group.ByName(application.HealthAgentName).EP.ConnectorName())
+		})
 	})
 }
 
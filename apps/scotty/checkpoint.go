@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty/machine"
+	"github.com/Symantec/scotty/store"
+	"github.com/Symantec/tricorder/go/tricorder"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	kCheckpointFileName = "store.checkpoint"
+	kWALDirName         = "wal"
+)
+
+// Metrics for checkpointing the store
+type checkpointMetricsType struct {
+	// Number of checkpoints written successfully
+	WriteCount uint64
+	// Number of checkpoints that could not be written
+	ErrorCount uint64
+	// How long the last checkpoint took to write
+	LastWriteDuration time.Duration
+	// When the last checkpoint was written
+	LastWriteTime time.Time
+}
+
+type checkpointMetricsStoreType struct {
+	lock    sync.Mutex
+	metrics checkpointMetricsType
+}
+
+func (c *checkpointMetricsStoreType) Metrics(metrics *checkpointMetricsType) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	*metrics = c.metrics
+}
+
+func (c *checkpointMetricsStoreType) LogWrite(
+	start time.Time, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err != nil {
+		c.metrics.ErrorCount++
+		return
+	}
+	c.metrics.WriteCount++
+	c.metrics.LastWriteTime = start
+	c.metrics.LastWriteDuration = time.Since(start)
+}
+
+func (c *checkpointMetricsStoreType) RegisterMetrics() (err error) {
+	var metrics checkpointMetricsType
+	group := tricorder.NewGroup()
+	group.RegisterUpdateFunc(func() time.Time {
+		c.Metrics(&metrics)
+		return time.Now()
+	})
+	if err = tricorder.RegisterMetricInGroup(
+		"/store/checkpoint/writeCount",
+		&metrics.WriteCount,
+		group,
+		units.None,
+		"Number of checkpoints written"); err != nil {
+		return
+	}
+	if err = tricorder.RegisterMetricInGroup(
+		"/store/checkpoint/errorCount",
+		&metrics.ErrorCount,
+		group,
+		units.None,
+		"Number of checkpoints that failed"); err != nil {
+		return
+	}
+	if err = tricorder.RegisterMetricInGroup(
+		"/store/checkpoint/lastWriteDuration",
+		&metrics.LastWriteDuration,
+		group,
+		units.Second,
+		"Time taken to write last checkpoint"); err != nil {
+		return
+	}
+	if err = tricorder.RegisterMetricInGroup(
+		"/store/checkpoint/lastWriteTime",
+		&metrics.LastWriteTime,
+		group,
+		units.None,
+		"Time last checkpoint was written"); err != nil {
+		return
+	}
+	return
+}
+
+func checkpointFileName() string {
+	return path.Join(*fCheckpointDir, kCheckpointFileName)
+}
+
+// readCheckpoint reads the store checkpoint. readCheckpoint returns nil if
+// checkpointing is turned off, or if there is no checkpoint to read.
+func readCheckpoint(logger log.Logger) *store.Checkpoint {
+	if *fCheckpointDir == "" {
+		return nil
+	}
+	file, err := os.Open(checkpointFileName())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Println(err)
+		}
+		return nil
+	}
+	defer file.Close()
+	checkpoint, err := store.ReadCheckpoint(bufio.NewReader(file))
+	if err != nil {
+		logger.Printf("Ignoring checkpoint: %v\n", err)
+		return nil
+	}
+	logger.Printf("Read checkpoint for %d endpoints\n", checkpoint.Len())
+	return checkpoint
+}
+
+// writeCheckpoint writes the checkpoint to a temporary file and then
+// renames it so that a crash while writing never clobbers the last good
+// checkpoint.
+func writeCheckpoint(endpointStore *machine.EndpointStore) error {
+	fileName := checkpointFileName()
+	tempFileName := fileName + ".tmp"
+	file, err := os.Create(tempFileName)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	if err := endpointStore.WriteCheckpoint(writer); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFileName, fileName)
+}
+
+// startWAL replays the write-ahead log onto endpointStore and starts a
+// new one. startWAL returns nil if the write-ahead log is turned off.
+func startWAL(
+	endpointStore *machine.EndpointStore, logger log.Logger) *store.WAL {
+	if *fCheckpointDir == "" || !*fWAL {
+		return nil
+	}
+	dir := path.Join(*fCheckpointDir, kWALDirName)
+	var replay *store.WALReplay
+	if _, err := os.Stat(dir); err == nil {
+		if replay, err = store.ReadWAL(dir); err != nil {
+			logger.Printf("Ignoring write-ahead log: %v\n", err)
+			replay = nil
+		} else {
+			logger.Printf(
+				"Read write-ahead log for %d endpoints, %d segments torn\n",
+				replay.Len(), replay.SkippedCount())
+		}
+	}
+	wal, err := store.OpenWAL(dir, *fWALSegmentBytes)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	dirSpec, err := tricorder.RegisterDirectory("/store/wal")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err := wal.RegisterMetrics(dirSpec); err != nil {
+		logger.Fatal(err)
+	}
+	endpointStore.SetWAL(wal, replay)
+	return wal
+}
+
+// writeCheckpointAndTruncate writes the checkpoint. If wal is non-nil,
+// writeCheckpointAndTruncate also removes the write-ahead log segments
+// that the new checkpoint makes unnecessary.
+func writeCheckpointAndTruncate(
+	endpointStore *machine.EndpointStore, wal *store.WAL) error {
+	if wal == nil {
+		return writeCheckpoint(endpointStore)
+	}
+	seq, err := wal.Rotate()
+	if err != nil {
+		return err
+	}
+	if err := writeCheckpoint(endpointStore); err != nil {
+		return err
+	}
+	return wal.RemoveSegmentsBefore(seq)
+}
+
+// startCheckpointLoop periodically checkpoints the store. It is a no-op
+// if checkpointing is turned off. wal is the write-ahead log or nil if
+// there is none.
+func startCheckpointLoop(
+	endpointStore *machine.EndpointStore,
+	wal *store.WAL,
+	logger log.Logger) {
+	if *fCheckpointDir == "" {
+		return
+	}
+	if err := os.MkdirAll(*fCheckpointDir, 0755); err != nil {
+		logger.Fatal(err)
+	}
+	metricsStore := &checkpointMetricsStoreType{}
+	if err := metricsStore.RegisterMetrics(); err != nil {
+		logger.Fatal(err)
+	}
+	go func() {
+		for {
+			time.Sleep(*fCheckpointFrequency)
+			start := time.Now()
+			err := writeCheckpointAndTruncate(endpointStore, wal)
+			if err != nil {
+				logger.Println(err)
+			}
+			metricsStore.LogWrite(start, err)
+		}
+	}()
+}
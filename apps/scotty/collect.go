@@ -13,6 +13,7 @@ import (
 	"github.com/Symantec/scotty/endpointdata"
 	"github.com/Symantec/scotty/lib/dynconfig"
 	"github.com/Symantec/scotty/lib/keyedqueue"
+	"github.com/Symantec/scotty/lib/pollsched"
 	"github.com/Symantec/scotty/lib/trimetrics"
 	"github.com/Symantec/scotty/lib/yamlutil"
 	"github.com/Symantec/scotty/machine"
@@ -59,6 +60,35 @@ var (
 		"cisBufferSize",
 		40,
 		"CIS Buffer Size")
+	fStaggerPolls = flag.Bool(
+		"staggerPolls",
+		false,
+		"Spread polls of endpoints evenly over their poll intervals instead of polling them all at once")
+	fPollJitter = flag.Float64(
+		"pollJitter",
+		0.0,
+		"Delay each staggered poll by a random amount up to this ratio (0.0 - 1.0) of the poll interval")
+	fConnectTimeout = flag.Duration(
+		"connectTimeout",
+		time.Minute,
+		"Maximum time to connect to an endpoint. 0 means no limit.")
+	fPollTimeout = flag.Duration(
+		"pollTimeout",
+		2*time.Minute,
+		"Maximum time to poll an endpoint once connected. 0 means no limit.")
+	fMinBackoff = flag.Duration(
+		"minBackoff",
+		time.Minute,
+		"Time to wait before connecting again to an endpoint that failed to connect. 0 means no backoff.")
+	fMaxBackoff = flag.Duration(
+		"maxBackoff",
+		30*time.Minute,
+		"Maximum time to wait before connecting again to an endpoint that keeps failing to connect")
+)
+
+const (
+	// Polls that come due within this much of each other share a sweep.
+	kMinTimeBetweenSweeps = time.Second
 )
 
 // toInstanceMap converts a slice of instanceIds to a map of instanceIds.
@@ -100,11 +130,16 @@ func newConnectionErrorsType() *connectionErrorsType {
 }
 
 func (e *connectionErrorsType) Set(
-	m *collector.Endpoint, err error, timestamp time.Time) {
+	m *collector.Endpoint, err error, state *collector.State) {
 	newError := &messages.Error{
-		HostName:  m.HostName(),
-		Timestamp: duration.SinceEpoch(timestamp).String(),
-		Error:     err.Error(),
+		HostName:            m.HostName(),
+		Timestamp:           duration.SinceEpoch(state.Timestamp()).String(),
+		Error:               err.Error(),
+		Timeout:             collector.IsTimeout(err),
+		ConsecutiveFailures: state.ConsecutiveFailures(),
+	}
+	if retryTime := state.RetryTime(); !retryTime.IsZero() {
+		newError.RetryTime = duration.SinceEpoch(retryTime).String()
 	}
 	e.lock.Lock()
 	defer e.lock.Unlock()
@@ -179,7 +214,7 @@ func (l *loggerType) LogError(e *collector.Endpoint, err error, state *collector
 	if err == nil {
 		l.ConnectionErrors.Clear(e)
 	} else {
-		l.ConnectionErrors.Set(e, err, state.Timestamp())
+		l.ConnectionErrors.Set(e, err, state)
 	}
 	l.AppStats.ReportError(e, err, state.Timestamp())
 }
@@ -268,14 +303,30 @@ func (l *loggerType) LogResponse(
 
 func (l *loggerType) reportNewNamesForSuggest(
 	list metrics.List) {
+	reportNewNamesForSuggest(
+		list, l.EndpointData.NamesSentToSuggest, l.MetricNameAdder)
+}
+
+// reportNewNamesForSuggest adds the names of the metrics in list that are
+// not in namesSent to adder and then adds them to namesSent.
+func reportNewNamesForSuggest(
+	list metrics.List, namesSent map[string]bool, adder suggest.Adder) {
 	length := list.Len()
 	for i := 0; i < length; i++ {
 		var value metrics.Value
 		list.Index(i, &value)
-		if types.FromGoValue(value.Value).CanToFromFloat() {
-			if !l.EndpointData.NamesSentToSuggest[value.Path] {
-				l.MetricNameAdder.Add(value.Path)
-				l.EndpointData.NamesSentToSuggest[value.Path] = true
+		kind := types.FromGoValue(value.Value)
+		if kind.CanToFromFloat() {
+			if !namesSent[value.Path] {
+				adder.Add(value.Path)
+				namesSent[value.Path] = true
+			}
+		} else if kind == types.Dist {
+			if !namesSent[value.Path] {
+				for _, name := range store.DistributionDerivedNames(value.Path) {
+					adder.Add(name)
+				}
+				namesSent[value.Path] = true
 			}
 		}
 	}
@@ -325,11 +376,14 @@ func startCollector(
 	metricNameAdder suggest.Adder,
 	memoryChecker memoryCheckerType,
 	myHostName *stringType,
+	discovered *discoveredEndpointsType,
 	logger log.Logger) {
 	collector.SetConcurrentPolls(*fPollCount)
 	collector.SetConcurrentConnects(*fConnectionCount)
+	collector.SetBackoff(*fMinBackoff, *fMaxBackoff)
 
 	sweepDurationDist := tricorder.NewGeometricBucketer(1, 100000.0).NewCumulativeDistribution()
+	duePollsPerSweepDist := tricorder.NewGeometricBucketer(1, 100000.0).NewCumulativeDistribution()
 	collectionBucketer := tricorder.NewArbitraryBucketer(0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1.0, 2.0, 5.0, 10.0, 20.0, 30.0, 50.0, 100.0)
 	collectionTimesDist := collectionBucketer.NewCumulativeDistribution()
 	tricorderCollectionTimesDist := collectionBucketer.NewCumulativeDistribution()
@@ -360,7 +414,14 @@ func startCollector(
 		"collector/sweepDuration",
 		sweepDurationDist,
 		units.Millisecond,
-		"Sweep duration"); err != nil {
+		"Time to start all the polls due in a sweep"); err != nil {
+		logger.Fatal(err)
+	}
+	if err := tricorder.RegisterMetric(
+		"collector/duePollsPerSweep",
+		duePollsPerSweepDist,
+		units.None,
+		"Number of polls due in each sweep"); err != nil {
 		logger.Fatal(err)
 	}
 	programStartTime := time.Now()
@@ -454,15 +515,31 @@ func startCollector(
 		}
 	}
 
-	// Metric collection goroutine. Collect metrics periodically.
+	pollSettings := newPollSettingsCache(newDynPollIntervals(logger))
+
+	// Metric collection goroutine. Each sweep polls the endpoints that are
+	// due and then sleeps until the next endpoint is due.
 	go func() {
 		endpointToData := make(
 			map[*collector.Endpoint]*endpointdata.EndpointData)
 		endpointObservations := machine.NewEndpointObservations()
+		scheduler := newPollScheduler()
 		for {
 			endpoints, metricStore := endpointStore.AllActiveWithStore()
 			sweepTime := time.Now()
+			var dueCount uint
+			pollSettings.Refresh()
 			for _, endpoint := range endpoints {
+				// Applications that push their metrics are never polled
+				if endpoint.App.Push {
+					continue
+				}
+				settings := pollSettings.Get(endpoint.App.EP)
+				if !scheduler.IsDue(
+					endpoint.App.EP, sweepTime, settings.Interval) {
+					continue
+				}
+				dueCount++
 				endpointData := endpointToData[endpoint.App.EP]
 				if endpointData == nil {
 					endpointData = endpointdata.NewEndpointData()
@@ -503,20 +580,23 @@ func startCollector(
 
 				portNum := endpoint.App.Port
 				isTLS := endpoint.App.IsTLS
-				endpoint.App.EP.Poll(sweepTime, isTLS, portNum, pollLogger)
+				endpoint.App.EP.PollWithTimeouts(
+					sweepTime, isTLS, portNum, settings.Timeouts, pollLogger)
 			}
-			sweepDuration := time.Now().Sub(sweepTime)
-			sweepDurationDist.Add(sweepDuration)
-			memoryChecker.Check()
-			if sweepDuration < *fCollectionFrequency {
-				time.Sleep((*fCollectionFrequency) - sweepDuration)
+			scheduler.Prune()
+			pollSettings.Prune()
+			if dueCount > 0 {
+				sweepDurationDist.Add(time.Now().Sub(sweepTime))
+				duePollsPerSweepDist.Add(float64(dueCount))
 			}
+			memoryChecker.Check()
+			time.Sleep(timeUntilNextSweep(scheduler))
 			if myHostNameStr := myHostName.String(); myHostNameStr != "" {
 				endpointObservations.MaybeAddApp(myHostNameStr, *fName, *fPort)
 			}
 			endpointStore.UpdateEndpoints(
 				duration.TimeToFloat(time.Now()),
-				endpointObservations.GetAll())
+				discovered.Observations(endpointObservations.GetAll()))
 		}
 	}()
 
@@ -542,6 +622,36 @@ func startCollector(
 	}
 }
 
+// endpointName returns the name of endpoint for staggering polls.
+func endpointName(endpoint interface{}) string {
+	ep := endpoint.(*collector.Endpoint)
+	return ep.HostName() + ":" + ep.AppName()
+}
+
+// newPollScheduler returns the scheduler for polling endpoints.
+func newPollScheduler() *pollsched.Scheduler {
+	if !*fStaggerPolls {
+		return pollsched.New()
+	}
+	return pollsched.NewStaggered(endpointName, *fPollJitter)
+}
+
+// timeUntilNextSweep returns how long to wait before the next sweep.
+// It waits no longer than -collectionFrequency so that new endpoints
+// don't wait too long for their first poll.
+func timeUntilNextSweep(scheduler *pollsched.Scheduler) time.Duration {
+	result := *fCollectionFrequency
+	if nextDue, ok := scheduler.NextDue(); ok {
+		if untilDue := nextDue.Sub(time.Now()); untilDue < result {
+			result = untilDue
+		}
+	}
+	if result < kMinTimeBetweenSweeps {
+		result = kMinTimeBetweenSweeps
+	}
+	return result
+}
+
 func startSnapshotLoop(
 	parentDir string,
 	config *dynconfig.DynConfig,
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"github.com/Symantec/Dominator/lib/mdb"
+	"github.com/Symantec/scotty/machine"
+	"github.com/Symantec/scotty/namesandports"
+	"sync"
+)
+
+// discoveredHostType is a host that scotty learns about from somewhere
+// other than mdb and the health agent.
+type discoveredHostType struct {
+	// Empty means unknown
+	IpAddress string
+	// If true, scotty doesn't poll the health agent on this host.
+	NoHealthAgent bool
+	// The applications on this host
+	Apps namesandports.NamesAndPorts
+	// Arbitrary labels for this host. Labels become tags of the host
+	// just like tags in mdb.
+	Labels map[string]string
+}
+
+// discoveryType discovers hosts and the applications running on them.
+type discoveryType interface {
+	// Hosts returns the hosts currently discovered keyed by host name.
+	// Callers must not modify the returned map.
+	Hosts() map[string]*discoveredHostType
+}
+
+// discoveredEndpointsType merges the hosts and applications from several
+// discovery instances with the hosts from mdb and the applications that
+// health agents report.
+//
+// When discovery instances disagree about the applications or labels of
+// a host, the instance listed last wins. When they disagree about the IP
+// address of a host, the first instance that reports an IP address wins.
+//
+// Hosts that a discovery instance stops reporting become inactive like
+// hosts removed from mdb. Applications that a discovery instance stops
+// reporting become inactive after they are missing from enough calls to
+// Refresh like applications that a health agent stops reporting.
+type discoveredEndpointsType struct {
+	discoveries  []discoveryType
+	observations *machine.EndpointObservations
+
+	mu sync.Mutex
+	// Every host ever discovered
+	seenHosts map[string]bool
+	// Currently discovered hosts
+	hosts map[string]*discoveredHostType
+}
+
+func newDiscoveredEndpoints(
+	discoveries ...discoveryType) *discoveredEndpointsType {
+	return &discoveredEndpointsType{
+		discoveries:  discoveries,
+		observations: machine.NewEndpointObservations(),
+		seenHosts:    make(map[string]bool),
+	}
+}
+
+// Refresh asks each discovery instance for its current hosts and records
+// the applications on them. The caller should call Refresh periodically.
+func (d *discoveredEndpointsType) Refresh() {
+	hosts := make(map[string]*discoveredHostType)
+	for _, discovery := range d.discoveries {
+		for hostName, host := range discovery.Hosts() {
+			merged := hosts[hostName]
+			if merged == nil {
+				merged = &discoveredHostType{}
+				hosts[hostName] = merged
+			}
+			if merged.IpAddress == "" {
+				merged.IpAddress = host.IpAddress
+			}
+			merged.NoHealthAgent = merged.NoHealthAgent || host.NoHealthAgent
+			for name, record := range host.Apps {
+				if merged.Apps == nil {
+					merged.Apps = make(namesandports.NamesAndPorts)
+				}
+				merged.Apps[name] = record
+			}
+			for key, value := range host.Labels {
+				if merged.Labels == nil {
+					merged.Labels = make(map[string]string)
+				}
+				merged.Labels[key] = value
+			}
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hosts = hosts
+	for hostName := range hosts {
+		d.seenHosts[hostName] = true
+	}
+	// Keep reporting hosts that went away with no applications so that
+	// their applications become inactive.
+	for hostName := range d.seenHosts {
+		var apps namesandports.NamesAndPorts
+		if host := hosts[hostName]; host != nil {
+			apps = host.Apps
+		}
+		d.observations.Save(hostName, apps)
+	}
+}
+
+// Machines merges the discovered hosts with the hosts from mdb.
+// Discovered hosts override the IP address and tags of mdb hosts with the
+// same name.
+// withoutHealthAgent contains the names of the hosts on which scotty
+// should not poll the health agent. Pass the results to
+// machine.EndpointStore.UpdateMachinesWithoutHealthAgents.
+func (d *discoveredEndpointsType) Machines(mdbMachines []mdb.Machine) (
+	machines []mdb.Machine, withoutHealthAgent map[string]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	withoutHealthAgent = make(map[string]bool)
+	machines = make([]mdb.Machine, 0, len(mdbMachines)+len(d.hosts))
+	for _, m := range mdbMachines {
+		if host := d.hosts[m.Hostname]; host != nil {
+			if host.IpAddress != "" {
+				m.IpAddress = host.IpAddress
+			}
+			m.Tags = mergeLabels(m.Tags, host.Labels)
+			if host.NoHealthAgent {
+				withoutHealthAgent[m.Hostname] = true
+			}
+		}
+		machines = append(machines, m)
+	}
+	inMdb := make(map[string]bool, len(mdbMachines))
+	for i := range mdbMachines {
+		inMdb[mdbMachines[i].Hostname] = true
+	}
+	for hostName, host := range d.hosts {
+		if inMdb[hostName] {
+			continue
+		}
+		machines = append(
+			machines,
+			mdb.Machine{
+				Hostname:  hostName,
+				IpAddress: host.IpAddress,
+				Tags:      host.Labels,
+			})
+		if host.NoHealthAgent {
+			withoutHealthAgent[hostName] = true
+		}
+	}
+	return
+}
+
+// Observations merges the applications on discovered hosts with
+// healthAgentObservations. Discovered applications override applications
+// with the same name that health agents report. Pass the result to
+// machine.EndpointStore.UpdateEndpoints.
+func (d *discoveredEndpointsType) Observations(
+	healthAgentObservations map[string]machine.EndpointObservation) map[string]machine.EndpointObservation {
+	return machine.MergeEndpointObservations(
+		healthAgentObservations, d.observations.GetAll())
+}
+
+// mergeLabels returns tags with labels added. mergeLabels doesn't modify
+// tags.
+func mergeLabels(tags, labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return tags
+	}
+	result := make(map[string]string, len(tags)+len(labels))
+	for key, value := range tags {
+		result[key] = value
+	}
+	for key, value := range labels {
+		result[key] = value
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/Symantec/scotty/namesandports"
+	"testing"
+)
+
+type staticDiscoveryType map[string]*discoveredHostType
+
+func (s staticDiscoveryType) Hosts() map[string]*discoveredHostType {
+	return s
+}
+
+func TestDiscoveredEndpointsRefresh(t *testing.T) {
+	first := staticDiscoveryType{
+		"host1": {
+			IpAddress: "10.0.0.1",
+			Apps: namesandports.NamesAndPorts{
+				"app": {Port: 1001},
+			},
+			Labels: map[string]string{"env": "dev", "team": "a"},
+		},
+	}
+	second := staticDiscoveryType{
+		"host1": {
+			IpAddress: "10.0.0.2",
+			Apps: namesandports.NamesAndPorts{
+				"app": {Port: 2001},
+			},
+			Labels: map[string]string{"env": "prod"},
+		},
+		"host2": {NoHealthAgent: true},
+	}
+	discovered := newDiscoveredEndpoints(first, second)
+	discovered.Refresh()
+	machines, withoutHealthAgent := discovered.Machines(nil)
+	if len(machines) != 2 {
+		t.Fatalf("Expected 2 machines, got %v", machines)
+	}
+	hosts := discovered.hosts
+	// First IP address wins
+	if ip := hosts["host1"].IpAddress; ip != "10.0.0.1" {
+		t.Errorf("Expected 10.0.0.1, got %s", ip)
+	}
+	// Last applications and labels win
+	if port := hosts["host1"].Apps["app"].Port; port != 2001 {
+		t.Errorf("Expected 2001, got %d", port)
+	}
+	if env := hosts["host1"].Labels["env"]; env != "prod" {
+		t.Errorf("Expected prod, got %s", env)
+	}
+	if team := hosts["host1"].Labels["team"]; team != "a" {
+		t.Errorf("Expected a, got %s", team)
+	}
+	if !withoutHealthAgent["host2"] || withoutHealthAgent["host1"] {
+		t.Errorf("Wrong hosts without health agent: %v", withoutHealthAgent)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty/discovery/dnssrv"
+	"github.com/Symantec/scotty/lib/dynconfig"
+	"github.com/Symantec/scotty/lib/yamlutil"
+	"github.com/Symantec/scotty/namesandports"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+var (
+	fDnsSrvMinRefresh = flag.Duration(
+		"dnsSrvMinRefresh",
+		30*time.Second,
+		"Minimum time between lookups of an SRV record in dnssrv.yaml")
+	fDnsSrvMaxRefresh = flag.Duration(
+		"dnsSrvMaxRefresh",
+		time.Hour,
+		"Maximum time between lookups of an SRV record in dnssrv.yaml")
+)
+
+// dnsSrvRecordConfigType represents a single SRV record in dnssrv.yaml
+type dnsSrvRecordConfigType struct {
+	// The SRV record e.g "_metrics._tcp.example.com"
+	Name string `yaml:"name"`
+	// The application name for the endpoints in the SRV record. If a
+	// target appears with several ports, the port is appended to the
+	// application name e.g "node exporter:9100".
+	App string `yaml:"app"`
+	TLS bool   `yaml:"tls"`
+	// How the application exposes its metrics: "tricorder", the default,
+	// "prometheus", "snmp" if scrapers.yaml has an snmp section, or the
+	// protocol of a scraper in scrapers.yaml.
+	Protocol      string            `yaml:"protocol"`
+	NoHealthAgent bool              `yaml:"noHealthAgent"`
+	Labels        map[string]string `yaml:"labels"`
+}
+
+func (r *dnsSrvRecordConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type dnsSrvRecordFields dnsSrvRecordConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*dnsSrvRecordFields)(r))
+}
+
+// dnsSrvConfigType represents dnssrv.yaml.
+type dnsSrvConfigType struct {
+	// The DNS server e.g "10.0.0.2:53". Empty means the system
+	// resolver. Since the system resolver doesn't report TTLs, records
+	// are then looked up every -dnsSrvMaxRefresh.
+	Server  string                   `yaml:"server"`
+	Records []dnsSrvRecordConfigType `yaml:"records"`
+}
+
+func (c *dnsSrvConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type dnsSrvFields dnsSrvConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*dnsSrvFields)(c))
+}
+
+func (c *dnsSrvConfigType) Reset() {
+	*c = dnsSrvConfigType{}
+}
+
+func newDnsSrvConfig(reader io.Reader) (interface{}, error) {
+	var config dnsSrvConfigType
+	if err := yamlutil.Read(reader, &config); err != nil {
+		return nil, err
+	}
+	for _, record := range config.Records {
+		if record.Name == "" || record.App == "" {
+			return nil, fmt.Errorf("SRV record needs name and app")
+		}
+	}
+	return &config, nil
+}
+
+// dnsSrvDiscoveryType discovers hosts and applications from the SRV
+// records in dnssrv.yaml.
+type dnsSrvDiscoveryType struct {
+	config *dynconfig.DynConfig
+	logger log.Logger
+
+	// Used only by the goroutine calling Refresh
+	current  *dnsSrvConfigType
+	resolver *dnssrv.Resolver
+	names    []string
+
+	mu           sync.Mutex
+	hosts        map[string]*discoveredHostType
+	lookupCount  uint64
+	failureCount uint64
+}
+
+// update starts using the latest dnssrv.yaml. update returns true if
+// dnssrv.yaml changed since the last call.
+func (d *dnsSrvDiscoveryType) update() bool {
+	config := d.config.Get().(*dnsSrvConfigType)
+	if config == d.current {
+		return false
+	}
+	if d.current == nil || config.Server != d.current.Server {
+		d.resolver = dnssrv.NewResolver(
+			&dnssrv.Client{Server: config.Server},
+			*fDnsSrvMinRefresh,
+			*fDnsSrvMaxRefresh)
+	}
+	d.current = config
+	d.names = make([]string, len(config.Records))
+	for i, record := range config.Records {
+		d.names[i] = record.Name
+	}
+	return true
+}
+
+// Hosts returns the hosts in the SRV records as of the last refresh.
+func (d *dnsSrvDiscoveryType) Hosts() map[string]*discoveredHostType {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.hosts
+}
+
+// Metrics for discovering endpoints from SRV records
+type dnsSrvStatsType struct {
+	// Number of hosts in the SRV records
+	HostCount int
+	// Number of SRV record lookups
+	LookupCount uint64
+	// Number of failed SRV record lookups
+	FailureCount uint64
+}
+
+func (d *dnsSrvDiscoveryType) getStats(stats *dnsSrvStatsType) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats.HostCount = len(d.hosts)
+	stats.LookupCount = d.lookupCount
+	stats.FailureCount = d.failureCount
+}
+
+// Refresh looks up the SRV records that have expired or that are new in
+// dnssrv.yaml.
+func (d *dnsSrvDiscoveryType) Refresh(now time.Time) {
+	changed := d.update()
+	lookups, errs := d.resolver.Refresh(d.names, now)
+	for _, err := range errs {
+		d.logger.Println(err)
+	}
+	var hosts map[string]*discoveredHostType
+	if changed || lookups > 0 {
+		hosts = d.recordsToHosts()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lookupCount += uint64(lookups)
+	d.failureCount += uint64(len(errs))
+	if changed || lookups > 0 {
+		d.hosts = hosts
+	}
+}
+
+// timeUntilRefresh returns how long to wait before calling Refresh again.
+// It waits no longer than -dnsSrvMinRefresh so that changes to
+// dnssrv.yaml take effect soon.
+func (d *dnsSrvDiscoveryType) timeUntilRefresh(now time.Time) time.Duration {
+	result := *fDnsSrvMinRefresh
+	if next, ok := d.resolver.NextRefresh(); ok {
+		if untilNext := next.Sub(now); untilNext < result {
+			result = untilNext
+		}
+	}
+	if result < 0 {
+		return 0
+	}
+	return result
+}
+
+// recordsToHosts groups the targets of the SRV records by host.
+func (d *dnsSrvDiscoveryType) recordsToHosts() map[string]*discoveredHostType {
+	result := make(map[string]*discoveredHostType)
+	for _, config := range d.current.Records {
+		answers := d.resolver.Records(config.Name)
+		portCounts := make(map[string]int)
+		for _, answer := range answers {
+			portCounts[answer.Target]++
+		}
+		protocol := config.Protocol
+		if protocol == "tricorder" {
+			protocol = namesandports.Tricorder
+		}
+		for _, answer := range answers {
+			host := result[answer.Target]
+			if host == nil {
+				host = &discoveredHostType{
+					Apps: make(namesandports.NamesAndPorts),
+				}
+				result[answer.Target] = host
+			}
+			appName := config.App
+			if portCounts[answer.Target] > 1 {
+				appName = fmt.Sprintf("%s:%d", config.App, answer.Port)
+			}
+			host.NoHealthAgent = host.NoHealthAgent || config.NoHealthAgent
+			host.Apps.AddWithProtocol(
+				appName, uint(answer.Port), config.TLS, protocol)
+			host.Labels = mergeLabels(host.Labels, config.Labels)
+		}
+	}
+	return result
+}
+
+func (d *dnsSrvDiscoveryType) registerMetrics() error {
+	var stats dnsSrvStatsType
+	return registerGroupMetrics(
+		func() { d.getStats(&stats) },
+		groupMetricType{
+			"dnssrv/hosts", &stats.HostCount, "Hosts in SRV records"},
+		groupMetricType{
+			"dnssrv/lookups", &stats.LookupCount, "SRV record lookups"},
+		groupMetricType{
+			"dnssrv/lookupFailures",
+			&stats.FailureCount,
+			"Failed SRV record lookups"})
+}
+
+// startDnsSrvDiscovery reads dnssrv.yaml in the config directory and
+// returns a discovery that follows the SRV records in it. If there is no
+// dnssrv.yaml, startDnsSrvDiscovery returns nil. Changes to dnssrv.yaml
+// take effect within -dnsSrvMinRefresh.
+func startDnsSrvDiscovery(logger log.Logger) *dnsSrvDiscoveryType {
+	configFile := path.Join(*fConfigDir, "dnssrv.yaml")
+	if _, err := os.Stat(configFile); err != nil {
+		return nil
+	}
+	config, err := dynconfig.NewInitialized(
+		configFile,
+		newDnsSrvConfig,
+		"dnssrv",
+		logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	result := &dnsSrvDiscoveryType{config: config, logger: logger}
+	if err := result.registerMetrics(); err != nil {
+		logger.Fatal(err)
+	}
+	result.Refresh(time.Now())
+	go func() {
+		// The resolver looks up only the records that have expired.
+		for {
+			time.Sleep(result.timeUntilRefresh(time.Now()))
+			result.Refresh(time.Now())
+		}
+	}()
+	return result
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty/discovery/filesd"
+	"github.com/Symantec/scotty/namesandports"
+	"sync"
+	"time"
+)
+
+var (
+	fFileSdPollDir = flag.String(
+		"fileSdPollDir",
+		"",
+		"Directory of JSON or YAML target files listing endpoints to poll. Empty means none.")
+	fFileSdPollInterval = flag.Duration(
+		"fileSdPollInterval",
+		30*time.Second,
+		"Amount of time between polls of fileSdPollDir for changed target files.")
+)
+
+// fileSdDiscoveryType discovers hosts and applications from the target
+// files in -fileSdPollDir.
+type fileSdDiscoveryType struct {
+	poller *filesd.Poller
+	logger log.Logger
+
+	mu         sync.Mutex
+	hosts      map[string]*discoveredHostType
+	errorCount uint64
+}
+
+func newFileSdDiscovery(
+	dir string, logger log.Logger) *fileSdDiscoveryType {
+	return &fileSdDiscoveryType{
+		poller: filesd.NewPoller(dir),
+		logger: logger,
+	}
+}
+
+// Hosts returns the hosts in the target files as of the last scan.
+func (f *fileSdDiscoveryType) Hosts() map[string]*discoveredHostType {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hosts
+}
+
+// Metrics for discovering endpoints from target files
+type fileSdStatsType struct {
+	// Number of hosts in the target files
+	HostCount int
+	// Number of errors reading target files
+	ErrorCount uint64
+}
+
+func (f *fileSdDiscoveryType) getStats(stats *fileSdStatsType) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats.HostCount = len(f.hosts)
+	stats.ErrorCount = f.errorCount
+}
+
+// Scan rereads changed target files.
+func (f *fileSdDiscoveryType) Scan() {
+	changed, errs := f.poller.Scan()
+	for _, err := range errs {
+		f.logger.Println(err)
+	}
+	var hosts map[string]*discoveredHostType
+	if changed {
+		hosts = targetsToHosts(f.poller.Targets())
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errorCount += uint64(len(errs))
+	if changed {
+		f.hosts = hosts
+	}
+}
+
+func (f *fileSdDiscoveryType) registerMetrics() error {
+	var stats fileSdStatsType
+	return registerGroupMetrics(
+		func() { f.getStats(&stats) },
+		groupMetricType{
+			"filesd/hosts", &stats.HostCount, "Hosts in target files"},
+		groupMetricType{
+			"filesd/errors",
+			&stats.ErrorCount,
+			"Errors reading target files"})
+}
+
+// targetsToHosts groups targets by host.
+func targetsToHosts(
+	targets []*filesd.Target) map[string]*discoveredHostType {
+	result := make(map[string]*discoveredHostType)
+	for _, target := range targets {
+		host := result[target.Host]
+		if host == nil {
+			host = &discoveredHostType{
+				Apps: make(namesandports.NamesAndPorts),
+			}
+			result[target.Host] = host
+		}
+		host.NoHealthAgent = host.NoHealthAgent || target.NoHealthAgent
+		host.Apps.AddWithProtocol(
+			target.App, target.Port, target.TLS, target.Protocol)
+		host.Labels = mergeLabels(host.Labels, target.Labels)
+	}
+	return result
+}
+
+// startFileSdDiscovery returns a discovery that polls the target files
+// in -fileSdPollDir every -fileSdPollInterval.
+func startFileSdDiscovery(logger log.Logger) *fileSdDiscoveryType {
+	result := newFileSdDiscovery(*fFileSdPollDir, logger)
+	if err := result.registerMetrics(); err != nil {
+		logger.Fatal(err)
+	}
+	result.Scan()
+	go func() {
+		for range time.Tick(*fFileSdPollInterval) {
+			result.Scan()
+		}
+	}()
+	return result
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty/graphite"
+	"github.com/Symantec/scotty/lib/dynconfig"
+	"github.com/Symantec/scotty/lib/yamlutil"
+	"github.com/Symantec/scotty/machine"
+	"github.com/Symantec/scotty/metrics"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	fGraphiteAddress = flag.String(
+		"graphiteAddress",
+		"",
+		"TCP and UDP address on which to receive Graphite plaintext metrics e.g :2003. Empty means don't receive Graphite plaintext metrics.")
+	fGraphitePickleAddress = flag.String(
+		"graphitePickleAddress",
+		"",
+		"TCP address on which to receive Graphite pickle metrics e.g :2004. Empty means don't receive Graphite pickle metrics.")
+	fGraphiteFlushInterval = flag.Duration(
+		"graphiteFlushInterval",
+		10*time.Second,
+		"How often to write Graphite metrics to the store.")
+	fGraphiteAppName = flag.String(
+		"graphiteAppName",
+		"graphite",
+		"Application name for Graphite metrics whose template has no app component.")
+	fGraphiteAllowedNetworks = flag.String(
+		"graphiteAllowedNetworks",
+		"",
+		"Comma separated networks e.g 10.0.0.0/8 from which to accept Graphite metrics. Empty means any network.")
+	fGraphiteUnknownHosts = flag.Bool(
+		"graphiteUnknownHosts",
+		false,
+		"Accept Graphite metrics for hosts missing from mdb. If false, a template host component must name a host in mdb and metrics without one must come from the IP address of a host in mdb.")
+)
+
+// graphiteTemplateConfigType represents a single template in graphite.yaml
+type graphiteTemplateConfigType struct {
+	// e.g "servers.*". Empty means any metric.
+	Filter string `yaml:"filter"`
+	// e.g "-.host.app.metric*"
+	Template string `yaml:"template"`
+}
+
+func (t *graphiteTemplateConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type graphiteTemplateFields graphiteTemplateConfigType
+	return yamlutil.StrictUnmarshalYAML(
+		unmarshal, (*graphiteTemplateFields)(t))
+}
+
+// graphiteConfigType represents graphite.yaml. The first template that
+// applies to a metric name wins.
+type graphiteConfigType struct {
+	Templates []graphiteTemplateConfigType `yaml:"templates"`
+}
+
+func (g *graphiteConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type graphiteFields graphiteConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*graphiteFields)(g))
+}
+
+func (g *graphiteConfigType) Reset() {
+	*g = graphiteConfigType{}
+}
+
+func newGraphiteTemplates(reader io.Reader) (interface{}, error) {
+	var config graphiteConfigType
+	if err := yamlutil.Read(reader, &config); err != nil {
+		return nil, err
+	}
+	templates := make(graphite.Templates, len(config.Templates))
+	for i, t := range config.Templates {
+		var err error
+		if templates[i], err = graphite.NewTemplate(
+			t.Filter, t.Template); err != nil {
+			return nil, err
+		}
+	}
+	return templates, nil
+}
+
+// dynGraphiteTemplatesType follows changes to graphite.yaml.
+type dynGraphiteTemplatesType struct {
+	// nil if there is no graphite.yaml
+	config *dynconfig.DynConfig
+}
+
+// Get returns the current templates.
+func (d *dynGraphiteTemplatesType) Get() graphite.Templates {
+	if d.config == nil {
+		return nil
+	}
+	return d.config.Get().(graphite.Templates)
+}
+
+// newDynGraphiteTemplates reads graphite.yaml in the config directory.
+func newDynGraphiteTemplates(logger log.Logger) *dynGraphiteTemplatesType {
+	configFile := path.Join(*fConfigDir, "graphite.yaml")
+	if _, err := os.Stat(configFile); err != nil {
+		return &dynGraphiteTemplatesType{}
+	}
+	config, err := dynconfig.NewInitialized(
+		configFile,
+		newGraphiteTemplates,
+		"graphite",
+		logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return &dynGraphiteTemplatesType{config: config}
+}
+
+// graphiteSinkType buffers the Graphite metrics of one application.
+type graphiteSinkType struct {
+	*graphite.Buffer
+}
+
+// Flush drops the metrics that have not been received for -pushStaleness
+// and returns the rest.
+func (g graphiteSinkType) Flush(now time.Time) metrics.SimpleList {
+	return g.Buffer.Flush(now.Add(-*fPushStaleness))
+}
+
+// graphiteListenerType buffers Graphite metrics per application and writes
+// them to the store.
+//
+// The templates in graphite.yaml map each metric name to a host, an
+// application, and a metric path. When the template has no app component,
+// the application is -graphiteAppName.
+type graphiteListenerType struct {
+	pushListenerType
+	Templates *dynGraphiteTemplatesType
+}
+
+func newGraphiteListener(
+	endpointStore *machine.EndpointStore,
+	writer *pushWriterType,
+	logger log.Logger) *graphiteListenerType {
+	return &graphiteListenerType{
+		pushListenerType: pushListenerType{
+			Protocol:       "Graphite",
+			ES:             endpointStore,
+			Writer:         writer,
+			Logger:         logger,
+			DefaultAppName: *fGraphiteAppName,
+			NewSink: func() pushSinkType {
+				return graphiteSinkType{graphite.NewBuffer()}
+			},
+		},
+		Templates: newDynGraphiteTemplates(logger),
+	}
+}
+
+// handleMetric buffers a single Graphite metric from given sender.
+func (g *graphiteListenerType) handleMetric(
+	templates graphite.Templates, sender net.IP, m *graphite.Metric) {
+	hostName, appName, path, ok := templates.Apply(m.Name)
+	if !ok {
+		g.LogReceived(false)
+		return
+	}
+	sink := g.Sink(sender, hostName, appName)
+	if sink == nil {
+		return
+	}
+	sink.(graphiteSinkType).Add(path, m.Value, m.Timestamp)
+	g.LogReceived(true)
+}
+
+// handleLine buffers a single plaintext line from given sender.
+func (g *graphiteListenerType) handleLine(
+	templates graphite.Templates, sender net.IP, line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	m, err := graphite.Parse(line)
+	if err != nil {
+		g.LogReceived(false)
+		return
+	}
+	g.handleMetric(templates, sender, m)
+}
+
+// handlePacket buffers each plaintext line in a packet.
+func (g *graphiteListenerType) handlePacket(sender net.IP, packet []byte) {
+	templates := g.Templates.Get()
+	for _, line := range strings.Split(string(packet), "\n") {
+		g.handleLine(templates, sender, line)
+	}
+}
+
+func (g *graphiteListenerType) servePlaintextConn(
+	sender net.IP, conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		g.handleLine(g.Templates.Get(), sender, scanner.Text())
+	}
+}
+
+func (g *graphiteListenerType) servePickleConn(sender net.IP, conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		metricList, err := graphite.ReadPickle(reader)
+		if err != nil {
+			if err != io.EOF {
+				g.LogReceived(false)
+				g.Logger.Printf(
+					"Error reading Graphite pickle from %s: %v", sender, err)
+			}
+			return
+		}
+		templates := g.Templates.Get()
+		for _, m := range metricList {
+			g.handleMetric(templates, sender, m)
+		}
+	}
+}
+
+// startGraphiteListener starts receiving Graphite metrics on
+// -graphiteAddress and -graphitePickleAddress.
+func startGraphiteListener(
+	endpointStore *machine.EndpointStore,
+	writer *pushWriterType,
+	logger log.Logger) {
+	listener := newGraphiteListener(endpointStore, writer, logger)
+	allowedNetworks, err := parseNetworks(*fGraphiteAllowedNetworks)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	listener.AllowedNetworks = allowedNetworks
+	listener.UnknownHosts = *fGraphiteUnknownHosts
+	if err := listener.RegisterMetrics("graphite", "metrics"); err != nil {
+		logger.Fatal(err)
+	}
+	if *fGraphiteAddress != "" {
+		conn, err := net.ListenPacket("udp", *fGraphiteAddress)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		go listener.ServeUDP(conn, listener.handlePacket)
+		tcpListener, err := net.Listen("tcp", *fGraphiteAddress)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		go listener.ServeTCP(tcpListener, listener.servePlaintextConn)
+	}
+	if *fGraphitePickleAddress != "" {
+		pickleListener, err := net.Listen("tcp", *fGraphitePickleAddress)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		go listener.ServeTCP(pickleListener, listener.servePickleConn)
+	}
+	listener.StartFlushing(*fGraphiteFlushInterval)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"time"
+)
+
+// groupMetricType is a single metric in a group of metrics that tricorder
+// updates together. Value points to a field of the struct that the
+// update function of the group fills in.
+type groupMetricType struct {
+	Path        string
+	Value       interface{}
+	Description string
+}
+
+// registerGroupMetrics registers metrics without units that tricorder
+// updates together by calling update.
+func registerGroupMetrics(
+	update func(), metrics ...groupMetricType) error {
+	group := tricorder.NewGroup()
+	group.RegisterUpdateFunc(func() time.Time {
+		update()
+		return time.Now()
+	})
+	for _, metric := range metrics {
+		if err := tricorder.RegisterMetricInGroup(
+			metric.Path,
+			metric.Value,
+			group,
+			units.None,
+			metric.Description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty/lib/dynconfig"
+	"github.com/Symantec/scotty/lib/yamlutil"
+	"github.com/Symantec/scotty/namesandports"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"path"
+)
+
+// inventoryAppConfigType represents a single application in apps.yaml
+type inventoryAppConfigType struct {
+	Name string `yaml:"name"`
+	Port uint   `yaml:"port"`
+	TLS  bool   `yaml:"tls"`
+	// How the application exposes its metrics: "tricorder", the default,
+	// "prometheus", "snmp" if scrapers.yaml has an snmp section, or the
+	// protocol of a scraper in scrapers.yaml.
+	Protocol string `yaml:"protocol"`
+}
+
+func (a *inventoryAppConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type inventoryAppFields inventoryAppConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*inventoryAppFields)(a))
+}
+
+// inventoryHostConfigType represents a single host in apps.yaml
+type inventoryHostConfigType struct {
+	Name string `yaml:"name"`
+	// Optional. Overrides the IP address from mdb.
+	IpAddress string `yaml:"ipAddress"`
+	// If true, scotty doesn't poll the health agent on this host.
+	NoHealthAgent bool                     `yaml:"noHealthAgent"`
+	Apps          []inventoryAppConfigType `yaml:"apps"`
+}
+
+func (h *inventoryHostConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type inventoryHostFields inventoryHostConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*inventoryHostFields)(h))
+}
+
+// inventoryConfigType represents apps.yaml, the static inventory of hosts
+// and applications. Hosts in apps.yaml are added to the hosts in mdb.
+// Applications in apps.yaml are added to the applications that the health
+// agent reports and override those with the same name.
+type inventoryConfigType struct {
+	Hosts []inventoryHostConfigType `yaml:"hosts"`
+}
+
+func (i *inventoryConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type inventoryFields inventoryConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*inventoryFields)(i))
+}
+
+func (i *inventoryConfigType) Reset() {
+	*i = inventoryConfigType{}
+}
+
+// newInventory reads the inventory in apps.yaml from reader. Older
+// releases shipped apps.yaml as a top level list of applications. newInventory
+// logs to logger that it ignores such a list and returns no hosts.
+func newInventory(reader io.Reader, logger log.Logger) (interface{}, error) {
+	var content bytes.Buffer
+	if _, err := content.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+	if isLegacyInventory(content.Bytes()) {
+		logger.Println(
+			"Ignoring apps.yaml: a list of applications is no longer supported; use hosts: instead")
+		return make(map[string]*discoveredHostType), nil
+	}
+	var config inventoryConfigType
+	if err := yamlutil.Read(&content, &config); err != nil {
+		return nil, err
+	}
+	result := make(map[string]*discoveredHostType, len(config.Hosts))
+	for _, hostConfig := range config.Hosts {
+		if hostConfig.Name == "" {
+			return nil, fmt.Errorf("Host name required")
+		}
+		if result[hostConfig.Name] != nil {
+			return nil, fmt.Errorf("Duplicate host %s", hostConfig.Name)
+		}
+		host := &discoveredHostType{
+			IpAddress:     hostConfig.IpAddress,
+			NoHealthAgent: hostConfig.NoHealthAgent,
+			Apps:          make(namesandports.NamesAndPorts),
+		}
+		for _, app := range hostConfig.Apps {
+			if app.Name == "" || app.Port == 0 {
+				return nil, fmt.Errorf(
+					"Application on %s needs name and port",
+					hostConfig.Name)
+			}
+			if _, ok := host.Apps[app.Name]; ok {
+				return nil, fmt.Errorf(
+					"Duplicate application %s on %s",
+					app.Name, hostConfig.Name)
+			}
+			protocol := app.Protocol
+			if protocol == "tricorder" {
+				protocol = namesandports.Tricorder
+			}
+			host.Apps.AddWithProtocol(app.Name, app.Port, app.TLS, protocol)
+		}
+		result[hostConfig.Name] = host
+	}
+	return result, nil
+}
+
+// isLegacyInventory returns true if content is a top level YAML list, the
+// form apps.yaml had before it listed hosts.
+func isLegacyInventory(content []byte) bool {
+	var value interface{}
+	if err := yaml.Unmarshal(content, &value); err != nil {
+		return false
+	}
+	_, ok := value.([]interface{})
+	return ok
+}
+
+// dynInventoryType follows changes to apps.yaml.
+type dynInventoryType struct {
+	// nil if there is no apps.yaml
+	config *dynconfig.DynConfig
+}
+
+// Hosts returns the hosts in apps.yaml keyed by host name.
+func (d *dynInventoryType) Hosts() map[string]*discoveredHostType {
+	if d.config == nil {
+		return nil
+	}
+	return d.config.Get().(map[string]*discoveredHostType)
+}
+
+// newDynInventory reads apps.yaml in the config directory.
+func newDynInventory(logger log.Logger) *dynInventoryType {
+	configFile := path.Join(*fConfigDir, "apps.yaml")
+	if _, err := os.Stat(configFile); err != nil {
+		return &dynInventoryType{}
+	}
+	config, err := dynconfig.NewInitialized(
+		configFile,
+		func(reader io.Reader) (interface{}, error) {
+			return newInventory(reader, logger)
+		},
+		"apps",
+		logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return &dynInventoryType{config: config}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	stdlog "log"
+	"strings"
+	"testing"
+)
+
+func TestInventory(t *testing.T) {
+	var logged bytes.Buffer
+	logger := stdlog.New(&logged, "", 0)
+	hosts, err := newInventory(strings.NewReader(`
+hosts:
+  - name: host1
+    apps:
+      - name: node exporter
+        port: 9100
+        protocol: prometheus
+`), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := hosts.(map[string]*discoveredHostType)["host1"]
+	if host == nil || host.Apps["node exporter"].Port != 9100 {
+		t.Errorf("Expected node exporter on host1, got %v", hosts)
+	}
+	if _, err := newInventory(strings.NewReader("hosts: []\nport: 7\n"), logger); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+	if logged.Len() != 0 {
+		t.Errorf("Expected nothing logged, got %s", logged.String())
+	}
+}
+
+func TestLegacyInventoryIgnored(t *testing.T) {
+	var logged bytes.Buffer
+	logger := stdlog.New(&logged, "", 0)
+	hosts, err := newInventory(strings.NewReader(`
+- port: 6910
+  name: Health Metrics
+  protocol: tricorder
+`), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts.(map[string]*discoveredHostType)) != 0 {
+		t.Errorf("Expected no hosts, got %v", hosts)
+	}
+	if !strings.Contains(logged.String(), "Ignoring apps.yaml") {
+		t.Errorf("Expected legacy apps.yaml to be logged, got %q", logged.String())
+	}
+}
@@ -387,6 +387,94 @@ func (h latestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// pageUsageHandler handles serving api/pageUsage requests. It lists
+// the endpoints using the most pages first.
+type pageUsageHandler struct {
+	ES     *machine.EndpointStore
+	Logger log.Logger
+}
+
+func (h pageUsageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	w.Header().Set("Content-Type", "application/json")
+	usages := h.ES.Store().PageUsageByEndpoint()
+	data := make([]*messages.PageUsage, 0, len(usages))
+	for _, usage := range usages {
+		ep, ok := usage.EndpointId.(*collector.Endpoint)
+		if !ok {
+			continue
+		}
+		data = append(data, &messages.PageUsage{
+			HostName:          ep.HostName(),
+			AppName:           ep.AppName(),
+			PageCount:         usage.PageCount,
+			InactivePageCount: usage.InactivePageCount,
+			Quota:             usage.Quota,
+		})
+	}
+	err := encodeJson(w, data, r.Form.Get("format") == "text")
+	if err != nil {
+		h.Logger.Printf("pageUsageHandler: cannot encode json: %v", err)
+		httpError(w, 500)
+	}
+}
+
+// cardinalityHandler handles serving api/cardinality requests. It lists
+// the n endpoints and the n applications with the most time series first.
+// n comes from the "n" parameter and defaults to 10.
+type cardinalityHandler struct {
+	ES     *machine.EndpointStore
+	Logger log.Logger
+}
+
+func (h cardinalityHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	w.Header().Set("Content-Type", "application/json")
+	n, err := strconv.Atoi(r.Form.Get("n"))
+	if err != nil || n < 0 {
+		n = 10
+	}
+	metricStore := h.ES.Store()
+	byEndpoint := metricStore.CardinalityByEndpoint()
+	byApp := metricStore.CardinalityByApp()
+	data := messages.Cardinality{
+		Endpoints: make([]*messages.EndpointCardinality, 0, n),
+		Apps:      make([]*messages.AppCardinality, 0, n),
+	}
+	for _, c := range byEndpoint {
+		if len(data.Endpoints) == n {
+			break
+		}
+		ep, ok := c.EndpointId.(*collector.Endpoint)
+		if !ok {
+			continue
+		}
+		data.Endpoints = append(data.Endpoints, &messages.EndpointCardinality{
+			HostName:            ep.HostName(),
+			AppName:             ep.AppName(),
+			SeriesCount:         c.SeriesCount,
+			ActiveSeriesCount:   c.ActiveSeriesCount,
+			NewSeriesCount:      c.NewSeriesCount,
+			TotalNewSeriesCount: c.TotalNewSeriesCount,
+		})
+	}
+	for i := 0; i < len(byApp) && i < n; i++ {
+		data.Apps = append(data.Apps, &messages.AppCardinality{
+			AppName:             byApp[i].AppName,
+			EndpointCount:       byApp[i].EndpointCount,
+			SeriesCount:         byApp[i].SeriesCount,
+			ActiveSeriesCount:   byApp[i].ActiveSeriesCount,
+			NewSeriesCount:      byApp[i].NewSeriesCount,
+			TotalNewSeriesCount: byApp[i].TotalNewSeriesCount,
+		})
+	}
+	err = encodeJson(w, &data, r.Form.Get("format") == "text")
+	if err != nil {
+		h.Logger.Printf("cardinalityHandler: cannot encode json: %v", err)
+		httpError(w, 500)
+	}
+}
+
 type earliestHandler struct {
 	ES     *machine.EndpointStore
 	Logger log.Logger
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"github.com/Symantec/Dominator/lib/log"
+	collector "github.com/Symantec/scotty"
+	"github.com/Symantec/scotty/lib/dynconfig"
+	"github.com/Symantec/scotty/lib/yamlutil"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"time"
+)
+
+// pollIntervalRuleConfigType represents a single rule in pollintervals.yaml
+type pollIntervalRuleConfigType struct {
+	// Application name e.g "health agent". Empty means any application.
+	App string `yaml:"app"`
+	// Regular expression for host names e.g "^db-". Empty means any host.
+	Host string `yaml:"host"`
+	// How often to poll matching endpoints e.g "5m". Zero means the
+	// default.
+	Interval time.Duration `yaml:"interval"`
+	// Maximum time to connect to matching endpoints. Zero means the
+	// default.
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+	// Maximum time to poll matching endpoints once connected. Zero means
+	// the default.
+	PollTimeout time.Duration `yaml:"pollTimeout"`
+}
+
+func (r *pollIntervalRuleConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type pollIntervalRuleFields pollIntervalRuleConfigType
+	return yamlutil.StrictUnmarshalYAML(
+		unmarshal, (*pollIntervalRuleFields)(r))
+}
+
+// pollIntervalsConfigType represents pollintervals.yaml. The first rule
+// matching an endpoint wins. Endpoints matching no rule are polled at the
+// default interval with the default timeouts.
+type pollIntervalsConfigType struct {
+	// Zero means use -collectionFrequency
+	Default time.Duration `yaml:"default"`
+	// Zero means use -connectTimeout
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+	// Zero means use -pollTimeout
+	PollTimeout time.Duration                `yaml:"pollTimeout"`
+	Rules       []pollIntervalRuleConfigType `yaml:"rules"`
+}
+
+func (p *pollIntervalsConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type pollIntervalsFields pollIntervalsConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*pollIntervalsFields)(p))
+}
+
+func (p *pollIntervalsConfigType) Reset() {
+	*p = pollIntervalsConfigType{}
+}
+
+type pollIntervalRuleType struct {
+	app      string
+	host     *regexp.Regexp
+	interval time.Duration
+	timeouts collector.PollTimeouts
+}
+
+func (r *pollIntervalRuleType) Matches(hostName, appName string) bool {
+	if r.app != "" && r.app != appName {
+		return false
+	}
+	return r.host == nil || r.host.MatchString(hostName)
+}
+
+// pollIntervalsType is the end product of pollintervals.yaml
+type pollIntervalsType struct {
+	defaultInterval time.Duration
+	defaultTimeouts collector.PollTimeouts
+	rules           []pollIntervalRuleType
+}
+
+// rule returns the first rule matching the given endpoint or nil if none
+// match.
+func (p *pollIntervalsType) rule(
+	hostName, appName string) *pollIntervalRuleType {
+	for i := range p.rules {
+		if p.rules[i].Matches(hostName, appName) {
+			return &p.rules[i]
+		}
+	}
+	return nil
+}
+
+// Interval returns how often to poll the given endpoint.
+func (p *pollIntervalsType) Interval(hostName, appName string) time.Duration {
+	if rule := p.rule(hostName, appName); rule != nil && rule.interval > 0 {
+		return rule.interval
+	}
+	if p.defaultInterval > 0 {
+		return p.defaultInterval
+	}
+	return *fCollectionFrequency
+}
+
+// Timeouts returns the connect and poll timeouts for the given endpoint.
+func (p *pollIntervalsType) Timeouts(
+	hostName, appName string) collector.PollTimeouts {
+	result := defaultTimeouts()
+	if p.defaultTimeouts.Connect > 0 {
+		result.Connect = p.defaultTimeouts.Connect
+	}
+	if p.defaultTimeouts.Poll > 0 {
+		result.Poll = p.defaultTimeouts.Poll
+	}
+	if rule := p.rule(hostName, appName); rule != nil {
+		if rule.timeouts.Connect > 0 {
+			result.Connect = rule.timeouts.Connect
+		}
+		if rule.timeouts.Poll > 0 {
+			result.Poll = rule.timeouts.Poll
+		}
+	}
+	return result
+}
+
+// defaultTimeouts returns the timeouts from the command line.
+func defaultTimeouts() collector.PollTimeouts {
+	return collector.PollTimeouts{
+		Connect: *fConnectTimeout, Poll: *fPollTimeout}
+}
+
+func newPollIntervals(reader io.Reader) (interface{}, error) {
+	var config pollIntervalsConfigType
+	if err := yamlutil.Read(reader, &config); err != nil {
+		return nil, err
+	}
+	if config.Default < 0 || config.ConnectTimeout < 0 || config.PollTimeout < 0 {
+		return nil, errors.New("Default poll interval and timeouts cannot be negative")
+	}
+	rules := make([]pollIntervalRuleType, len(config.Rules))
+	for i, rule := range config.Rules {
+		if rule.App == "" && rule.Host == "" {
+			return nil, errors.New("Poll interval rule needs app or host")
+		}
+		if rule.Interval < 0 || rule.ConnectTimeout < 0 || rule.PollTimeout < 0 {
+			return nil, errors.New("Poll interval and timeouts cannot be negative")
+		}
+		if rule.Interval == 0 && rule.ConnectTimeout == 0 && rule.PollTimeout == 0 {
+			return nil, errors.New("Poll interval rule needs interval or timeout")
+		}
+		rules[i] = pollIntervalRuleType{
+			app:      rule.App,
+			interval: rule.Interval,
+			timeouts: collector.PollTimeouts{
+				Connect: rule.ConnectTimeout,
+				Poll:    rule.PollTimeout,
+			},
+		}
+		if rule.Host != "" {
+			var err error
+			if rules[i].host, err = regexp.Compile(rule.Host); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &pollIntervalsType{
+		defaultInterval: config.Default,
+		defaultTimeouts: collector.PollTimeouts{
+			Connect: config.ConnectTimeout,
+			Poll:    config.PollTimeout,
+		},
+		rules: rules}, nil
+}
+
+// dynPollIntervalsType follows changes to pollintervals.yaml.
+type dynPollIntervalsType struct {
+	// nil if there is no pollintervals.yaml
+	config *dynconfig.DynConfig
+}
+
+// Get returns the current poll intervals. Without pollintervals.yaml, all
+// endpoints are polled at -collectionFrequency with -connectTimeout and
+// -pollTimeout.
+func (d *dynPollIntervalsType) Get() *pollIntervalsType {
+	if d.config == nil {
+		return &pollIntervalsType{}
+	}
+	return d.config.Get().(*pollIntervalsType)
+}
+
+// pollSettingsType is how to poll a single endpoint.
+type pollSettingsType struct {
+	Interval time.Duration
+	Timeouts collector.PollTimeouts
+	// The generation when the cache last returned this instance
+	seen uint64
+}
+
+// pollSettingsCacheType remembers the poll settings of each endpoint so
+// that the rules in pollintervals.yaml are matched against an endpoint
+// only when the endpoint is added or pollintervals.yaml changes.
+// pollSettingsCacheType instances are not safe to use with multiple
+// goroutines.
+type pollSettingsCacheType struct {
+	intervals *dynPollIntervalsType
+	// The poll intervals from which the cached settings came
+	current    *pollIntervalsType
+	byEndpoint map[*collector.Endpoint]*pollSettingsType
+	generation uint64
+}
+
+func newPollSettingsCache(
+	intervals *dynPollIntervalsType) *pollSettingsCacheType {
+	return &pollSettingsCacheType{
+		intervals:  intervals,
+		current:    intervals.Get(),
+		byEndpoint: make(map[*collector.Endpoint]*pollSettingsType),
+	}
+}
+
+// Refresh forgets the settings of all endpoints if pollintervals.yaml
+// changed. Call Refresh at the start of each sweep.
+func (c *pollSettingsCacheType) Refresh() {
+	// Without pollintervals.yaml, Get returns a new instance each time
+	// but the settings never change.
+	if c.intervals.config == nil {
+		return
+	}
+	if current := c.intervals.Get(); current != c.current {
+		c.current = current
+		c.byEndpoint = make(map[*collector.Endpoint]*pollSettingsType)
+	}
+}
+
+// Get returns the poll settings of endpoint.
+func (c *pollSettingsCacheType) Get(
+	endpoint *collector.Endpoint) *pollSettingsType {
+	settings := c.byEndpoint[endpoint]
+	if settings == nil {
+		hostName, appName := endpoint.HostName(), endpoint.AppName()
+		settings = &pollSettingsType{
+			Interval: c.current.Interval(hostName, appName),
+			Timeouts: c.current.Timeouts(hostName, appName),
+		}
+		c.byEndpoint[endpoint] = settings
+	}
+	settings.seen = c.generation
+	return settings
+}
+
+// Prune forgets the endpoints that Get has not seen since the previous
+// call to Prune.
+func (c *pollSettingsCacheType) Prune() {
+	for endpoint, settings := range c.byEndpoint {
+		if settings.seen != c.generation {
+			delete(c.byEndpoint, endpoint)
+		}
+	}
+	c.generation++
+}
+
+// newDynPollIntervals reads pollintervals.yaml in the config directory.
+func newDynPollIntervals(logger log.Logger) *dynPollIntervalsType {
+	configFile := path.Join(*fConfigDir, "pollintervals.yaml")
+	if _, err := os.Stat(configFile); err != nil {
+		return &dynPollIntervalsType{}
+	}
+	config, err := dynconfig.NewInitialized(
+		configFile,
+		newPollIntervals,
+		"pollIntervals",
+		logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return &dynPollIntervalsType{config: config}
+}
@@ -246,7 +246,11 @@ func (p *pstoreHandlerType) VisitEndpoint(
 			kLeaseSpan,
 			aMetricStore.RemoveFromRecordCount)
 	}
-	tagGroup := pstore.TagGroup{pstore.TagAppName: appName}
+	tagGroup := make(pstore.TagGroup, len(endpoint.M.Labels)+3)
+	for name, value := range endpoint.M.Labels {
+		tagGroup[name] = value
+	}
+	tagGroup[pstore.TagAppName] = appName
 	if region != "" {
 		tagGroup[pstore.TagRegionName] = region
 	}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty/lib/dynconfig"
+	"github.com/Symantec/scotty/lib/yamlutil"
+	"github.com/Symantec/scotty/machine"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/scotty/sources/jsonsource"
+	"github.com/Symantec/scotty/store"
+	"github.com/Symantec/scotty/suggest"
+	"github.com/Symantec/tricorder/go/tricorder/duration"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Largest request body /api/push accepts
+	kMaxPushBodySize = 32 * 1024 * 1024
+)
+
+var (
+	fPushStaleness = flag.Duration(
+		"pushStaleness",
+		5*time.Minute,
+		"Mark applications that push their metrics inactive once they stop pushing for this long.")
+	fPushHostExpiry = flag.Duration(
+		"pushHostExpiry",
+		time.Hour,
+		"Forget hosts missing from mdb once applications on them stop pushing metrics for this long.")
+)
+
+var (
+	errPolledApplication = errors.New(
+		"Application is polled; it cannot push metrics")
+)
+
+// pushWriterType writes metrics that applications push to scotty into
+// the store so that they show up just like polled metrics.
+type pushWriterType struct {
+	ES              *machine.EndpointStore
+	MetricNameAdder suggest.Adder
+	TotalCounts     totalCountUpdaterType
+
+	lock               sync.Mutex
+	namesSentToSuggest map[string]bool
+}
+
+func newPushWriter(
+	endpointStore *machine.EndpointStore,
+	metricNameAdder suggest.Adder,
+	totalCounts totalCountUpdaterType) *pushWriterType {
+	return &pushWriterType{
+		ES:                 endpointStore,
+		MetricNameAdder:    metricNameAdder,
+		TotalCounts:        totalCounts,
+		namesSentToSuggest: make(map[string]bool),
+	}
+}
+
+// Write writes list as the metrics of the application appName on host
+// hostName at given time. Write creates the endpoint for the application
+// if necessary. Write returns errPolledApplication if scotty polls an
+// application with the same name on the same host. Write returns
+// store.ErrInactive if the host is inactive.
+func (p *pushWriterType) Write(
+	hostName, appName string,
+	list metrics.List,
+	timestamp time.Time) error {
+	endpoint, astore := p.ES.PushEndpoint(hostName, appName)
+	if endpoint == nil {
+		return errPolledApplication
+	}
+	ep := endpoint.App.EP
+	added, err := astore.AddBatch(ep, duration.TimeToFloat(timestamp), list)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	reportNewNamesForSuggest(list, p.namesSentToSuggest, p.MetricNameAdder)
+	p.lock.Unlock()
+	p.ES.LogChangedMetricCount(ep, added)
+	p.ES.LogPush(ep, timestamp)
+	p.TotalCounts.Update(astore, ep)
+	return nil
+}
+
+// startPushStalenessLoop marks applications that stop pushing their
+// metrics for -pushStaleness inactive.
+func startPushStalenessLoop(endpointStore *machine.EndpointStore) {
+	go func() {
+		for range time.Tick(*fCollectionFrequency) {
+			now := time.Now()
+			endpointStore.MarkStalePushEndpoints(
+				duration.TimeToFloat(now), now.Add(-*fPushStaleness))
+			endpointStore.RemoveStalePushMachines(
+				duration.TimeToFloat(now), now.Add(-*fPushHostExpiry))
+		}
+	}()
+}
+
+// pushTokenConfigType represents a single token in push.yaml
+type pushTokenConfigType struct {
+	// The bearer token
+	Token string `yaml:"token"`
+	// Regular expression for the hosts the token may push metrics for
+	// e.g "^batch-". Empty means any host.
+	Host string `yaml:"host"`
+	// The application the token may push metrics for. Empty means any
+	// application.
+	App string `yaml:"app"`
+}
+
+func (t *pushTokenConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type pushTokenFields pushTokenConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*pushTokenFields)(t))
+}
+
+// pushConfigType represents push.yaml.
+type pushConfigType struct {
+	Tokens []pushTokenConfigType `yaml:"tokens"`
+}
+
+func (p *pushConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type pushFields pushConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*pushFields)(p))
+}
+
+func (p *pushConfigType) Reset() {
+	*p = pushConfigType{}
+}
+
+type pushTokenType struct {
+	token []byte
+	host  *regexp.Regexp
+	app   string
+}
+
+func (t *pushTokenType) Allows(token []byte, hostName, appName string) bool {
+	if subtle.ConstantTimeCompare(t.token, token) != 1 {
+		return false
+	}
+	if t.app != "" && t.app != appName {
+		return false
+	}
+	return t.host == nil || t.host.MatchString(hostName)
+}
+
+// pushAuthType is the end product of push.yaml
+type pushAuthType struct {
+	tokens []pushTokenType
+}
+
+// Allows returns true if token may push metrics for the application
+// appName on host hostName.
+func (p *pushAuthType) Allows(token, hostName, appName string) bool {
+	tokenBytes := []byte(token)
+	for i := range p.tokens {
+		if p.tokens[i].Allows(tokenBytes, hostName, appName) {
+			return true
+		}
+	}
+	return false
+}
+
+func newPushAuth(reader io.Reader) (interface{}, error) {
+	var config pushConfigType
+	if err := yamlutil.Read(reader, &config); err != nil {
+		return nil, err
+	}
+	tokens := make([]pushTokenType, len(config.Tokens))
+	for i, token := range config.Tokens {
+		if token.Token == "" {
+			return nil, errors.New("Push token cannot be empty")
+		}
+		tokens[i] = pushTokenType{token: []byte(token.Token), app: token.App}
+		if token.Host != "" {
+			var err error
+			if tokens[i].host, err = regexp.Compile(token.Host); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &pushAuthType{tokens: tokens}, nil
+}
+
+// dynPushAuthType follows changes to push.yaml.
+type dynPushAuthType struct {
+	// nil if there is no push.yaml
+	config *dynconfig.DynConfig
+}
+
+// Allows returns true if token may push metrics for the application
+// appName on host hostName. Without push.yaml, Allows always returns false.
+func (d *dynPushAuthType) Allows(token, hostName, appName string) bool {
+	if d.config == nil {
+		return false
+	}
+	return d.config.Get().(*pushAuthType).Allows(token, hostName, appName)
+}
+
+// newDynPushAuth reads push.yaml in the config directory.
+func newDynPushAuth(logger log.Logger) *dynPushAuthType {
+	configFile := path.Join(*fConfigDir, "push.yaml")
+	if _, err := os.Stat(configFile); err != nil {
+		return &dynPushAuthType{}
+	}
+	config, err := dynconfig.NewInitialized(
+		configFile,
+		newPushAuth,
+		"push",
+		logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return &dynPushAuthType{config: config}
+}
+
+// pushHandler handles POST requests to /api/push/{host}/{app}. The body
+// is a JSON messages.MetricList just like /metricsapi serves. Requests
+// must carry a bearer token from push.yaml that is allowed to push for
+// that host and application.
+type pushHandler struct {
+	Auth   *dynPushAuthType
+	Writer *pushWriterType
+	Logger log.Logger
+}
+
+func (h *pushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		httpError(w, 405)
+		return
+	}
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		httpError(w, 404)
+		return
+	}
+	hostName, appName := parts[0], parts[1]
+	token := bearerToken(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		httpError(w, 401)
+		return
+	}
+	if !h.Auth.Allows(token, hostName, appName) {
+		httpError(w, 403)
+		return
+	}
+	list, err := jsonsource.Decode(
+		http.MaxBytesReader(w, r.Body, kMaxPushBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := metrics.VerifyList(list); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	err = h.Writer.Write(hostName, appName, list, time.Now())
+	switch err {
+	case nil:
+		w.WriteHeader(204)
+	case errPolledApplication, store.ErrInactive:
+		http.Error(w, err.Error(), 409)
+	default:
+		h.Logger.Printf(
+			"pushHandler: cannot write metrics for %s on %s: %v",
+			appName, hostName, err)
+		httpError(w, 500)
+	}
+}
+
+// bearerToken returns the bearer token in the Authorization header of r
+// or the empty string if there is none.
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(prefix):])
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Symantec/Dominator/lib/log/nulllogger"
+	"github.com/Symantec/Dominator/lib/mdb"
+	"github.com/Symantec/scotty/awsinfo"
+	"github.com/Symantec/scotty/machine"
+	"github.com/Symantec/scotty/store"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	kPushYaml = `tokens:
+  - token: secret
+    host: "^host1$"
+`
+	kPushBody = `[
+	{
+		"path": "/proc/load",
+		"description": "Load average",
+		"unit": "None",
+		"kind": "float64",
+		"value": 1.5
+	}
+]`
+)
+
+type nullAdderType struct{}
+
+func (nullAdderType) Add(s string) {}
+
+type nullTotalCountsType struct{}
+
+func (nullTotalCountsType) Update(s *store.Store, endpointId interface{}) {}
+
+func newPushHandlerForTesting(t *testing.T) *pushHandler {
+	dir, err := ioutil.TempDir("", "push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(
+		path.Join(dir, "push.yaml"), []byte(kPushYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldConfigDir := *fConfigDir
+	*fConfigDir = dir
+	defer func() { *fConfigDir = oldConfigDir }()
+	endpointStore := machine.NewEndpointStore(
+		store.NewStore(10, 100, 1.0, 10),
+		awsinfo.Config{CloudWatchRefresh: 5 * time.Minute},
+		0)
+	endpointStore.UpdateMachines(
+		100.0, []mdb.Machine{{Hostname: "host1", IpAddress: "10.1.1.1"}})
+	return &pushHandler{
+		Auth: newDynPushAuth(nulllogger.New()),
+		Writer: newPushWriter(
+			endpointStore, nullAdderType{}, nullTotalCountsType{}),
+		Logger: nulllogger.New(),
+	}
+}
+
+// push sends a request to handler and returns the status code.
+// urlPath is the path after /api/push/.
+func push(
+	handler *pushHandler,
+	method, urlPath, token string,
+	body io.Reader) int {
+	r := httptest.NewRequest(method, "/", body)
+	r.URL.Path = urlPath
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestPushHandler(t *testing.T) {
+	handler := newPushHandlerForTesting(t)
+	body := func() io.Reader { return strings.NewReader(kPushBody) }
+	if code := push(
+		handler, "GET", "host1/job", "secret", nil); code != 405 {
+		t.Errorf("Expected 405, got %d", code)
+	}
+	if code := push(handler, "POST", "host1", "secret", body()); code != 404 {
+		t.Errorf("Expected 404, got %d", code)
+	}
+	if code := push(handler, "POST", "host1/job", "", body()); code != 401 {
+		t.Errorf("Expected 401, got %d", code)
+	}
+	if code := push(
+		handler, "POST", "host1/job", "wrong", body()); code != 403 {
+		t.Errorf("Expected 403 for wrong token, got %d", code)
+	}
+	if code := push(
+		handler, "POST", "host2/job", "secret", body()); code != 403 {
+		t.Errorf("Expected 403 for wrong host, got %d", code)
+	}
+	// scotty polls the health agent
+	if code := push(
+		handler,
+		"POST",
+		"host1/health agent",
+		"secret",
+		body()); code != 409 {
+		t.Errorf("Expected 409, got %d", code)
+	}
+	if code := push(
+		handler, "POST", "host1/job", "secret",
+		strings.NewReader(`[null]`)); code != 400 {
+		t.Errorf("Expected 400 for null metric, got %d", code)
+	}
+	tooBig := io.MultiReader(
+		bytes.NewReader(bytes.Repeat([]byte(" "), kMaxPushBodySize)),
+		body())
+	if code := push(
+		handler, "POST", "host1/job", "secret", tooBig); code != 400 {
+		t.Errorf("Expected 400 for too big body, got %d", code)
+	}
+	if code := push(
+		handler, "POST", "host1/job", "secret", body()); code != 204 {
+		t.Errorf("Expected 204, got %d", code)
+	}
+}
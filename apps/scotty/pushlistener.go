@@ -0,0 +1,317 @@
+package main
+
+import (
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty/machine"
+	"github.com/Symantec/scotty/metrics"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kPushListenerMaxPacketSize = 65535
+)
+
+// pushSourceType identifies the application that pushed metrics to a
+// listener.
+type pushSourceType struct {
+	HostName string
+	AppName  string
+}
+
+// pushSinkType holds the metrics that one application pushed to a
+// listener until the listener writes them to the store.
+type pushSinkType interface {
+	// Flush returns the metrics to write to the store. now is the
+	// current time.
+	Flush(now time.Time) metrics.SimpleList
+}
+
+type pushSourceDataType struct {
+	Sink     pushSinkType
+	LastPush time.Time
+}
+
+// Metrics of a push listener
+type pushListenerStatsType struct {
+	// Number of applications that pushed metrics recently
+	SourceCount int
+	// Number of lines or metrics received
+	Received uint64
+	// Number of lines or metrics that could not be parsed
+	Bad uint64
+	// Number of packets or connections from outside the allowed networks
+	RejectedSenders uint64
+	// Number of lines or metrics for hosts that the listener doesn't accept
+	RejectedHosts uint64
+	// Number of batches that could not be written to the store
+	FlushErrors uint64
+}
+
+// pushListenerType does the work common to the listeners for protocols
+// like StatsD and Graphite where applications push metrics without
+// authenticating. It tracks the sink of each application, writes what
+// the sinks hold to the store, and forgets applications that stopped
+// pushing metrics for -pushStaleness.
+//
+// Since these protocols have no authentication, a listener accepts
+// metrics only from AllowedNetworks and, unless UnknownHosts is true, only
+// for hosts in mdb. When a metric doesn't name its host, the host is the
+// one in mdb with the sender's IP address. If mdb has no such host and
+// UnknownHosts is true, the host is the IP address itself.
+type pushListenerType struct {
+	// The protocol name for log messages e.g "StatsD"
+	Protocol string
+	ES       *machine.EndpointStore
+	Writer   *pushWriterType
+	Logger   log.Logger
+	// Empty means any network
+	AllowedNetworks []*net.IPNet
+	UnknownHosts    bool
+	// The application name for metrics that don't name their application
+	DefaultAppName string
+	// Creates the sink for a new application
+	NewSink func() pushSinkType
+
+	lock    sync.Mutex
+	sources map[pushSourceType]*pushSourceDataType
+	stats   pushListenerStatsType
+}
+
+func (p *pushListenerType) getStats(stats *pushListenerStatsType) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	*stats = p.stats
+	stats.SourceCount = len(p.sources)
+}
+
+// RegisterMetrics registers the metrics of this listener under dir.
+// noun is what the protocol calls a single metric e.g "lines".
+func (p *pushListenerType) RegisterMetrics(dir, noun string) error {
+	var stats pushListenerStatsType
+	capitalNoun := strings.ToUpper(noun[:1]) + noun[1:]
+	return registerGroupMetrics(
+		func() { p.getStats(&stats) },
+		groupMetricType{
+			dir + "/sources",
+			&stats.SourceCount,
+			p.Protocol + " applications that pushed metrics recently"},
+		groupMetricType{
+			dir + "/" + noun,
+			&stats.Received,
+			p.Protocol + " " + noun + " received"},
+		groupMetricType{
+			dir + "/bad" + capitalNoun,
+			&stats.Bad,
+			p.Protocol + " " + noun + " that could not be parsed"},
+		groupMetricType{
+			dir + "/rejectedSenders",
+			&stats.RejectedSenders,
+			p.Protocol + " packets and connections from outside the allowed networks"},
+		groupMetricType{
+			dir + "/rejectedHosts",
+			&stats.RejectedHosts,
+			p.Protocol + " " + noun + " for hosts not accepted"},
+		groupMetricType{
+			dir + "/flushErrors",
+			&stats.FlushErrors,
+			p.Protocol + " batches that could not be written to the store"})
+}
+
+// LogReceived notes that the listener received a single line or metric.
+// ok is false if it could not be parsed.
+func (p *pushListenerType) LogReceived(ok bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats.Received++
+	if !ok {
+		p.stats.Bad++
+	}
+}
+
+func (p *pushListenerType) logRejectedSender() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats.RejectedSenders++
+}
+
+func (p *pushListenerType) logRejectedHost() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats.Received++
+	p.stats.RejectedHosts++
+}
+
+func (p *pushListenerType) logFlushError() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats.FlushErrors++
+}
+
+// isAllowed returns true if sender is in one of AllowedNetworks.
+func (p *pushListenerType) isAllowed(sender net.IP) bool {
+	if len(p.AllowedNetworks) == 0 {
+		return true
+	}
+	for _, network := range p.AllowedNetworks {
+		if network.Contains(sender) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostName returns the name of the host for a metric from sender that
+// names the host hostName or the empty string if this listener doesn't
+// accept the metric. An empty hostName means the metric doesn't name its
+// host.
+func (p *pushListenerType) hostName(sender net.IP, hostName string) string {
+	if hostName != "" {
+		if !p.UnknownHosts && !p.ES.HasMdbHost(hostName) {
+			return ""
+		}
+		return hostName
+	}
+	ipAddress := sender.String()
+	if hostName := p.ES.HostNameByIpAddress(ipAddress); hostName != "" {
+		return hostName
+	}
+	if !p.UnknownHosts {
+		return ""
+	}
+	return ipAddress
+}
+
+// Sink returns the sink for a metric from sender that names its host and
+// application hostName and appName. Sink creates the sink if needed and
+// notes that the application just pushed a metric. Empty hostName or
+// appName means the metric doesn't name its host or application. Sink
+// returns nil if this listener doesn't accept the metric; in that case
+// the caller should not log the metric with LogReceived.
+func (p *pushListenerType) Sink(
+	sender net.IP, hostName, appName string) pushSinkType {
+	hostName = p.hostName(sender, hostName)
+	if hostName == "" {
+		p.logRejectedHost()
+		return nil
+	}
+	if appName == "" {
+		appName = p.DefaultAppName
+	}
+	source := pushSourceType{HostName: hostName, AppName: appName}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.sources == nil {
+		p.sources = make(map[pushSourceType]*pushSourceDataType)
+	}
+	data := p.sources[source]
+	if data == nil {
+		data = &pushSourceDataType{Sink: p.NewSink()}
+		p.sources[source] = data
+	}
+	data.LastPush = time.Now()
+	return data.Sink
+}
+
+// Flush writes the metrics in the sink of every application to the store.
+// Flush forgets applications that have pushed nothing for -pushStaleness
+// so that their endpoints go inactive even though their sinks may still
+// hold metrics.
+func (p *pushListenerType) Flush() {
+	now := time.Now()
+	p.lock.Lock()
+	sinks := make(map[pushSourceType]pushSinkType, len(p.sources))
+	for source, data := range p.sources {
+		if now.Sub(data.LastPush) <= *fPushStaleness {
+			sinks[source] = data.Sink
+		} else {
+			delete(p.sources, source)
+		}
+	}
+	p.lock.Unlock()
+	for source, sink := range sinks {
+		list := sink.Flush(now)
+		if len(list) == 0 {
+			continue
+		}
+		if err := p.Writer.Write(
+			source.HostName, source.AppName, list, now); err != nil {
+			p.logFlushError()
+			p.Logger.Printf(
+				"Error writing %s metrics for %s on %s: %v",
+				p.Protocol, source.AppName, source.HostName, err)
+		}
+	}
+}
+
+// StartFlushing calls Flush every flushInterval.
+func (p *pushListenerType) StartFlushing(flushInterval time.Duration) {
+	go func() {
+		for range time.Tick(flushInterval) {
+			p.Flush()
+		}
+	}()
+}
+
+// ServeUDP calls handlePacket with each packet that conn receives from
+// the allowed networks.
+func (p *pushListenerType) ServeUDP(
+	conn net.PacketConn, handlePacket func(sender net.IP, packet []byte)) {
+	buffer := make([]byte, kPushListenerMaxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			p.Logger.Printf("Error reading %s packet: %v", p.Protocol, err)
+			continue
+		}
+		sender := addr.(*net.UDPAddr).IP
+		if !p.isAllowed(sender) {
+			p.logRejectedSender()
+			continue
+		}
+		handlePacket(sender, buffer[:n])
+	}
+}
+
+// ServeTCP calls serveConn in its own goroutine with each connection that
+// listener accepts from the allowed networks. serveConn need not close
+// the connection.
+func (p *pushListenerType) ServeTCP(
+	listener net.Listener, serveConn func(sender net.IP, conn net.Conn)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			p.Logger.Printf(
+				"Error accepting %s connection: %v", p.Protocol, err)
+			continue
+		}
+		sender := conn.RemoteAddr().(*net.TCPAddr).IP
+		if !p.isAllowed(sender) {
+			p.logRejectedSender()
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			serveConn(sender, conn)
+		}()
+	}
+}
+
+// parseNetworks parses comma separated networks in CIDR notation.
+func parseNetworks(networks string) (result []*net.IPNet, err error) {
+	for _, network := range strings.Split(networks, ",") {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ipNet)
+	}
+	return
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty/lib/dynconfig"
+	"github.com/Symantec/scotty/lib/yamlutil"
+	"github.com/Symantec/scotty/store"
+	"io"
+	"os"
+	"path"
+)
+
+var (
+	kRetentionClassesByName = map[string]store.RetentionClass{
+		"low":    store.LowRetention,
+		"normal": store.NormalRetention,
+		"high":   store.HighRetention,
+	}
+)
+
+// retentionRuleConfigType represents a single rule in retention.yaml
+type retentionRuleConfigType struct {
+	// Regular expression for metric paths e.g "^/sys/memory/"
+	Path string `yaml:"path"`
+	// One of low, normal, or high
+	Class string `yaml:"class"`
+}
+
+func (r *retentionRuleConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type retentionRuleFields retentionRuleConfigType
+	return yamlutil.StrictUnmarshalYAML(
+		unmarshal, (*retentionRuleFields)(r))
+}
+
+// retentionConfigType represents retention.yaml. The first rule matching
+// a metric path wins.
+type retentionConfigType struct {
+	Rules []retentionRuleConfigType `yaml:"rules"`
+}
+
+func (r *retentionConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type retentionFields retentionConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*retentionFields)(r))
+}
+
+func (r *retentionConfigType) Reset() {
+	*r = retentionConfigType{}
+}
+
+func newRetentionClasses(reader io.Reader) (interface{}, error) {
+	var config retentionConfigType
+	if err := yamlutil.Read(reader, &config); err != nil {
+		return nil, err
+	}
+	rules := make([]store.RetentionRule, len(config.Rules))
+	for i, rule := range config.Rules {
+		class, ok := kRetentionClassesByName[rule.Class]
+		if !ok {
+			return nil, fmt.Errorf("Unknown retention class: %s", rule.Class)
+		}
+		rules[i] = store.RetentionRule{PathRegex: rule.Path, Class: class}
+	}
+	classes, err := store.NewRetentionClasses(rules)
+	if err != nil {
+		return nil, err
+	}
+	return classes, nil
+}
+
+// dynRetentionPolicyType is a store.RetentionPolicy that follows changes
+// to retention.yaml.
+type dynRetentionPolicyType struct {
+	config *dynconfig.DynConfig
+}
+
+func (d *dynRetentionPolicyType) RetentionClass(
+	path string) store.RetentionClass {
+	return d.config.Get().(*store.RetentionClasses).RetentionClass(path)
+}
+
+// Current returns the retention classes from the latest retention.yaml so
+// that the store can tell when they change.
+func (d *dynRetentionPolicyType) Current() store.RetentionPolicy {
+	return d.config.Get().(*store.RetentionClasses)
+}
+
+// setRetentionPolicy sets the retention policy of astore from
+// retention.yaml in the config directory. If there is no retention.yaml,
+// setRetentionPolicy leaves astore alone.
+func setRetentionPolicy(astore *store.Store, logger log.Logger) {
+	configFile := path.Join(*fConfigDir, "retention.yaml")
+	if _, err := os.Stat(configFile); err != nil {
+		return
+	}
+	config, err := dynconfig.NewInitialized(
+		configFile,
+		newRetentionClasses,
+		"retention",
+		logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	astore.SetRetentionPolicy(&dynRetentionPolicyType{config: config})
+}
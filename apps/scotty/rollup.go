@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Symantec/scotty/store"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseRollUpTiers parses roll up tiers of the form "1m:1440,10m:1008".
+// An empty string means no roll up tiers.
+func parseRollUpTiers(str string) (result []store.RollUpTier, err error) {
+	if str == "" {
+		return
+	}
+	for _, tierStr := range strings.Split(str, ",") {
+		parts := strings.Split(tierStr, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Bad roll up tier: %s", tierStr)
+		}
+		var tier store.RollUpTier
+		if tier.Duration, err = time.ParseDuration(parts[0]); err != nil {
+			return
+		}
+		if tier.Duration <= 0 {
+			return nil, fmt.Errorf("Bad roll up duration: %s", parts[0])
+		}
+		var count uint64
+		if count, err = strconv.ParseUint(parts[1], 10, 32); err != nil {
+			return
+		}
+		tier.Count = uint(count)
+		result = append(result, tier)
+	}
+	return
+}
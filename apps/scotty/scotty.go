@@ -13,6 +13,7 @@ import (
 	"github.com/Symantec/scotty/apps/scotty/splash"
 	"github.com/Symantec/scotty/awsinfo"
 	"github.com/Symantec/scotty/consul"
+	"github.com/Symantec/scotty/hostlabels"
 	"github.com/Symantec/scotty/influx/qlutils"
 	"github.com/Symantec/scotty/influx/responses"
 	"github.com/Symantec/scotty/lib/apiutil"
@@ -85,14 +86,30 @@ var (
 		"pageCount",
 		30*1000*1000,
 		"Total page count")
+	fCompressPages = flag.Bool(
+		"compressPages",
+		false,
+		"Whether or not to compress pages holding numeric values")
+	fPageQuota = flag.Float64(
+		"pageQuota",
+		0.0,
+		"Ratio of all pages one endpoint may use. 0 means no quota.")
+	fRollUpTiers = flag.String(
+		"rollUpTiers",
+		"",
+		"Comma separated duration:count tiers for rolling up evicted values e.g 1m:1440,10m:1008")
 	fMdbFile = flag.String(
 		"mdbFile",
 		"/var/lib/scotty/mdb",
-		"Name of file from which to read mdb data.")
+		"Name of file from which to read mdb data. Empty means no mdb; use apps.yaml in configDir to list hosts instead.")
+	fHostLabels = flag.String(
+		"hostLabels",
+		"",
+		"Comma separated label=field pairs of mdb fields to attach to metrics as tags e.g owner=Tags.Owner,env=AwsMetadata.Tags.Environment. A label by itself such as cluster means cluster=Tags.cluster.")
 	fCollectionFrequency = flag.Duration(
 		"collectionFrequency",
 		30*time.Second,
-		"Amount of time between metric collections")
+		"Amount of time between metric collections. pollintervals.yaml in configDir may override this for some endpoints.")
 	fPidFile = flag.String(
 		"pidfile", "", "Name of file to write my PID to")
 	fThreshhold = flag.Float64(
@@ -111,6 +128,22 @@ var (
 		"cloudHealthTest", false, "Whether or not this is testing cloudhealth")
 	fCloudWatchTest = flag.Bool(
 		"cloudWatchTest", false, "Whether or not this is testing cloudwatch")
+	fCheckpointDir = flag.String(
+		"checkpointDir",
+		"",
+		"Directory for store checkpoints. Empty means no checkpoints.")
+	fCheckpointFrequency = flag.Duration(
+		"checkpointFrequency",
+		5*time.Minute,
+		"Amount of time between store checkpoints")
+	fWAL = flag.Bool(
+		"wal",
+		false,
+		"Whether to keep a write-ahead log in checkpointDir so that metrics collected since the last checkpoint survive a restart")
+	fWALSegmentBytes = flag.Int64(
+		"walSegmentBytes",
+		64*1024*1024,
+		"Size at which to start a new write-ahead log segment")
 )
 
 type stringType struct {
@@ -183,7 +216,10 @@ func createEndpointStore(
 	logger log.Logger,
 	tagvAdder suggest.Adder,
 	maybeNilMemoryManager *memoryManagerType,
-	myIpAddrs []string) (
+	myIpAddrs []string,
+	discovered *discoveredEndpointsType,
+	hostLabels hostlabels.Config,
+	connectors application.Connectors) (
 	*machine.EndpointStore, *stringType) {
 	myHostName := &stringType{}
 	var astore *store.Store
@@ -204,6 +240,14 @@ func createEndpointStore(
 		astore = store.NewStoreBytesPerPage(
 			*fBytesPerPage, *fPageCount, *fThreshhold, *fDegree)
 	}
+	astore.SetCompressed(*fCompressPages)
+	rollUpTiers, err := parseRollUpTiers(*fRollUpTiers)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	astore.SetRollUpTiers(rollUpTiers)
+	astore.SetPageQuota(*fPageQuota)
+	setRetentionPolicy(astore, logger)
 	dirSpec, err := tricorder.RegisterDirectory("/store")
 	if err != nil {
 		logger.Fatal(err)
@@ -219,45 +263,69 @@ func createEndpointStore(
 			CloudWatchRefresh: *fCloudWatchFreq,
 		},
 		3)
+	stats.SetHostLabels(hostLabels)
+	stats.SetConnectors(connectors)
+	if checkpoint := readCheckpoint(logger); checkpoint != nil {
+		stats.SetCheckpoint(checkpoint)
+	}
+	wal := startWAL(stats, logger)
 	var mdbChannel <-chan *mdb.Mdb
 	if *fMdbLoadTesting > 0 {
 		mdbChannel = loadTestMdbChannel(*fMdbLoadTesting)
-	} else {
+	} else if *fMdbFile != "" {
 		mdbChannel = mdbd.StartMdbDaemon(*fMdbFile, logger)
 	}
-	var machines *mdb.Mdb
-	select {
-	case machines = <-mdbChannel:
-	case <-time.After(30 * time.Second):
-		machines = &mdb.Mdb{}
-		logger.Println("No mdb available.")
+	machines := &mdb.Mdb{}
+	if mdbChannel != nil {
+		select {
+		case machines = <-mdbChannel:
+		case <-time.After(30 * time.Second):
+			logger.Println("No mdb available.")
+		}
 	}
-	for _, machine := range machines.Machines {
+	discovered.Refresh()
+	allMachines, withoutHealthAgent := discovered.Machines(machines.Machines)
+	for i, machine := range allMachines {
 		tagvAdder.Add(machine.Hostname)
 		tagvAdder.Add(machine.IpAddress)
+		for _, value := range hostLabels.Labels(&allMachines[i]) {
+			tagvAdder.Add(value)
+		}
 	}
-	myHostNameStr := getMyHostName(machines.Machines, myIpAddrs)
+	myHostNameStr := getMyHostName(allMachines, myIpAddrs)
 	if myHostNameStr == "" {
 		logger.Println(kHostNotFoundMsg)
 	}
 	myHostName.SetString(myHostNameStr)
 	fmt.Println("My host name", myHostNameStr)
 
-	stats.UpdateMachines(
-		duration.TimeToFloat(time.Now()), machines.Machines)
+	stats.UpdateMachinesWithoutHealthAgents(
+		duration.TimeToFloat(time.Now()), allMachines, withoutHealthAgent)
 	fmt.Println("Initialization complete.")
-	// Endpoint refresher goroutine
+	startCheckpointLoop(stats, wal, logger)
+	// Endpoint refresher goroutine. Runs whenever mdb changes and
+	// periodically to pick up changes in discovered hosts.
 	go func() {
+		mdbMachines := machines.Machines
+		ticker := time.NewTicker(*fCollectionFrequency)
 		for {
-			machines := <-mdbChannel
-			myHostNameStr := getMyHostName(machines.Machines, myIpAddrs)
+			select {
+			case machines := <-mdbChannel:
+				mdbMachines = machines.Machines
+			case <-ticker.C:
+				discovered.Refresh()
+			}
+			allMachines, withoutHealthAgent := discovered.Machines(
+				mdbMachines)
+			myHostNameStr := getMyHostName(allMachines, myIpAddrs)
 			if myHostNameStr == "" {
 				logger.Println(kHostNotFoundMsg)
 			}
 			myHostName.SetString(myHostNameStr)
-			stats.UpdateMachines(
+			stats.UpdateMachinesWithoutHealthAgents(
 				duration.TimeToFloat(time.Now()),
-				machines.Machines)
+				allMachines,
+				withoutHealthAgent)
 		}
 	}()
 	return stats, myHostName
@@ -351,7 +419,8 @@ func performInfluxQuery(
 	queryStr string,
 	epoch string,
 	endpoints *machine.EndpointStore,
-	freq time.Duration) (interface{}, error) {
+	freq time.Duration,
+	labelNames []string) (interface{}, error) {
 	// Special case for show databases. Influx client issues this
 	// when user types "use scotty"
 	switch strings.ToLower(queryStr) {
@@ -397,7 +466,8 @@ func performInfluxQuery(
 	if err != nil {
 		return nil, err
 	}
-	pqs, colNamesForEachStatement, err := qlutils.ParseQuery(query, now)
+	pqs, colNamesForEachStatement, err := qlutils.ParseQuery(
+		query, now, labelNames)
 	if err != nil {
 		return nil, err
 	}
@@ -459,16 +529,42 @@ func main() {
 	logger.Println("My IP Addresses: ", myIPAddrs)
 	// Read configs early so that we will fail fast.
 	maybeNilMemoryManager := maybeCreateMemoryManager(logger)
+	connectors := newScraperConnectors(logger)
+	hostLabels, err := hostlabels.ParseConfig(*fHostLabels)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	hostLabelNames := hostLabels.Names()
 	metricNameEngine := suggest.NewEngine()
 	metricNameAdder := newTsdbAdder(metricNameEngine)
-	tagkEngine := suggest.NewSuggester("appname", "HostName", "region", "ipaddress")
+	// Host labels are tags too
+	tagkEngine := suggest.NewSuggester(
+		append(
+			[]string{"appname", "HostName", "region", "ipaddress"},
+			hostLabelNames...)...)
 	tagvEngine := suggest.NewEngine()
 	tagvAdder := newTsdbAdder(tagvEngine)
 	// TODO: Fix this somehow to include all apps
 	tagvAdder.Add(application.HealthAgentName)
 
+	var discoveries []discoveryType
+	if *fFileSdPollDir != "" {
+		discoveries = append(discoveries, startFileSdDiscovery(logger))
+	}
+	if dnsSrvDiscovery := startDnsSrvDiscovery(logger); dnsSrvDiscovery != nil {
+		discoveries = append(discoveries, dnsSrvDiscovery)
+	}
+	// apps.yaml goes last so that its applications and labels win.
+	discoveries = append(discoveries, newDynInventory(logger))
+	discovered := newDiscoveredEndpoints(discoveries...)
 	endpointStore, myHostName := createEndpointStore(
-		logger, tagvAdder, maybeNilMemoryManager, myIPAddrs)
+		logger,
+		tagvAdder,
+		maybeNilMemoryManager,
+		myIPAddrs,
+		discovered,
+		hostLabels,
+		connectors)
 	rpc.RegisterName(
 		"Scotty",
 		&rpcType{ES: endpointStore},
@@ -496,7 +592,16 @@ func main() {
 		metricNameAdder,
 		&maybeNilMemoryManagerWrapperType{maybeNilMemoryManager},
 		myHostName,
+		discovered,
 		logger)
+	pushWriter := newPushWriter(endpointStore, metricNameAdder, totalCounts)
+	startPushStalenessLoop(endpointStore)
+	if *fStatsdAddress != "" {
+		startStatsdListener(endpointStore, pushWriter, logger)
+	}
+	if *fGraphiteAddress != "" || *fGraphitePickleAddress != "" {
+		startGraphiteListener(endpointStore, pushWriter, logger)
+	}
 
 	http.Handle(
 		"/",
@@ -543,6 +648,30 @@ func main() {
 				Logger: logger,
 			}}))
 
+	http.Handle(
+		"/api/push/",
+		http.StripPrefix(
+			"/api/push/",
+			&pushHandler{
+				Auth:   newDynPushAuth(logger),
+				Writer: pushWriter,
+				Logger: logger,
+			}))
+
+	http.Handle(
+		"/api/pageUsage",
+		gzipHandler{&pageUsageHandler{
+			ES:     endpointStore,
+			Logger: logger,
+		}})
+
+	http.Handle(
+		"/api/cardinality",
+		gzipHandler{&cardinalityHandler{
+			ES:     endpointStore,
+			Logger: logger,
+		}})
+
 	http.Handle(
 		"/api/errors/",
 		gzipHandler{&errorHandler{
@@ -562,7 +691,8 @@ func main() {
 						req.Get("q"),
 						req.Get("epoch"),
 						endpointStore,
-						*fCollectionFrequency)
+						*fCollectionFrequency,
+						hostLabelNames)
 				},
 				nil,
 			),
@@ -581,7 +711,10 @@ func main() {
 		tsdbexec.NewHandler(
 			func(r *tsdbjson.QueryRequest) ([]tsdbjson.TimeSeries, error) {
 				return tsdbexec.Query(
-					r, endpointStore, *fCollectionFrequency)
+					r,
+					endpointStore,
+					*fCollectionFrequency,
+					hostLabelNames)
 			}))
 	tsdbServeMux.Handle(
 		"/api/suggest",
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty/application"
+	"github.com/Symantec/scotty/lib/yamlutil"
+	"github.com/Symantec/scotty/sources"
+	"github.com/Symantec/scotty/sources/httpjsonsource"
+	"github.com/Symantec/scotty/sources/snmpsource"
+	"os"
+	"path"
+)
+
+const (
+	// The protocol of applications read with SNMP
+	kSnmpProtocol = "snmp"
+)
+
+// scraperRuleConfigType represents a single rule of a scraper in
+// scrapers.yaml
+type scraperRuleConfigType httpjsonsource.Rule
+
+func (r *scraperRuleConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type scraperRuleFields scraperRuleConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*scraperRuleFields)(r))
+}
+
+// scraperConfigType represents a single scraper in scrapers.yaml.
+// See httpjsonsource.Config.
+type scraperConfigType struct {
+	// The protocol that applications using this scraper report e.g
+	// "haproxy"
+	Protocol    string                  `yaml:"protocol"`
+	URL         string                  `yaml:"url"`
+	Headers     map[string]string       `yaml:"headers"`
+	BearerToken string                  `yaml:"bearerToken"`
+	Username    string                  `yaml:"username"`
+	Password    string                  `yaml:"password"`
+	Rules       []scraperRuleConfigType `yaml:"rules"`
+}
+
+func (s *scraperConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type scraperFields scraperConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*scraperFields)(s))
+}
+
+func (s *scraperConfigType) SourceConfig() *httpjsonsource.Config {
+	rules := make([]httpjsonsource.Rule, len(s.Rules))
+	for i := range s.Rules {
+		rules[i] = httpjsonsource.Rule(s.Rules[i])
+	}
+	return &httpjsonsource.Config{
+		URL:         s.URL,
+		Headers:     s.Headers,
+		BearerToken: s.BearerToken,
+		Username:    s.Username,
+		Password:    s.Password,
+		Rules:       rules,
+	}
+}
+
+// snmpMetricConfigType represents a single OID in the snmp section of
+// scrapers.yaml. See snmpsource.Metric.
+type snmpMetricConfigType snmpsource.Metric
+
+func (m *snmpMetricConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type snmpMetricFields snmpMetricConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*snmpMetricFields)(m))
+}
+
+// snmpConfigType represents the snmp section of scrapers.yaml.
+type snmpConfigType struct {
+	// The SNMP v2c community e.g "public"
+	Community string `yaml:"community"`
+	// The OIDs to read. Empty means snmpsource.DefaultMetrics.
+	Metrics []snmpMetricConfigType `yaml:"metrics"`
+}
+
+func (s *snmpConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type snmpFields snmpConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*snmpFields)(s))
+}
+
+// NewConnector returns the connector for applications using the snmp
+// protocol.
+func (s *snmpConfigType) NewConnector() (sources.Connector, error) {
+	if s.Community == "" {
+		return nil, errors.New("snmp needs a community")
+	}
+	if len(s.Metrics) == 0 {
+		return snmpsource.NewConnector(s.Community), nil
+	}
+	metrics := make([]snmpsource.Metric, len(s.Metrics))
+	for i := range s.Metrics {
+		metrics[i] = snmpsource.Metric(s.Metrics[i])
+	}
+	return snmpsource.NewConnectorWithMetrics(s.Community, metrics)
+}
+
+// scrapersConfigType represents scrapers.yaml.
+type scrapersConfigType struct {
+	Scrapers []scraperConfigType `yaml:"scrapers"`
+	// If present, applications using the snmp protocol are read with
+	// SNMP v2c.
+	Snmp *snmpConfigType `yaml:"snmp"`
+}
+
+func (s *scrapersConfigType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type scrapersFields scrapersConfigType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*scrapersFields)(s))
+}
+
+func (s *scrapersConfigType) Reset() {
+	*s = scrapersConfigType{}
+}
+
+// newScraperConnectors reads scrapers.yaml in the config directory and
+// returns a connector for each scraper in it keyed by protocol.
+// Applications whose protocol matches a scraper are then read using that
+// scraper. If scrapers.yaml has an snmp section, newScraperConnectors
+// also returns a connector for the snmp protocol. If there is no
+// scrapers.yaml, newScraperConnectors returns nil.
+func newScraperConnectors(logger log.Logger) application.Connectors {
+	configFile := path.Join(*fConfigDir, "scrapers.yaml")
+	if _, err := os.Stat(configFile); err != nil {
+		return nil
+	}
+	var config scrapersConfigType
+	if err := yamlutil.ReadFromFile(configFile, &config); err != nil {
+		logger.Fatal(err)
+	}
+	result := make(application.Connectors)
+	for i := range config.Scrapers {
+		scraper := &config.Scrapers[i]
+		conn, err := httpjsonsource.NewConnector(
+			scraper.Protocol, scraper.SourceConfig())
+		if err != nil {
+			logger.Fatalf("%s: %s: %v", configFile, scraper.Protocol, err)
+		}
+		if err := result.Add(scraper.Protocol, conn); err != nil {
+			logger.Fatalf("%s: %v", configFile, err)
+		}
+	}
+	if config.Snmp != nil {
+		conn, err := config.Snmp.NewConnector()
+		if err != nil {
+			logger.Fatalf("%s: %v", configFile, err)
+		}
+		if err := result.Add(kSnmpProtocol, conn); err != nil {
+			logger.Fatalf("%s: %v", configFile, err)
+		}
+	}
+	return result
+}
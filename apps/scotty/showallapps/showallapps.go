@@ -3,6 +3,7 @@ package showallapps
 import (
 	"fmt"
 	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty"
 	"github.com/Symantec/scotty/lib/httputil"
 	"github.com/Symantec/scotty/machine"
 	"html/template"
@@ -20,6 +21,7 @@ const (
 	Total Endpoints: {{.Summary.TotalEndpoints}}<br>
 	Total Active Endpoints: {{.Summary.TotalActiveEndpoints}}<br>
 	Total Failed Endpoints: {{.Summary.TotalFailedEndpoints}}<br>
+	Total Backing Off Endpoints: {{.Summary.TotalBackingOffEndpoints}}<br>
 	<a href="/showAllApps?up=true">Up only</a>&nbsp;<a href="/showAllApps">All</a>
 	<table border="1" style="width:100%">
 	  <tr>
@@ -45,6 +47,12 @@ const (
 	      <td>Yes</td>
 	      <td>
 	        {{.App.Status}}<br>
+	        \ {{if .App.ConsecutiveFailures}} \
+	          {{.App.ConsecutiveFailures}} failures in a row<br>
+	        \ {{end}} \
+	        \ {{if not .App.RetryTime.IsZero}} \
+	          Retry at {{.App.RetryTimeStr}}<br>
+	        \ {{end}} \
                 {{.App.LastErrorTimeStr}}<br>
 		{{.App.LastError}}
               </td>
@@ -104,15 +112,17 @@ func (v *view) Link(app *machine.Endpoint) *url.URL {
 }
 
 type EndpointSummary struct {
-	TotalEndpoints       int
-	TotalActiveEndpoints int
-	TotalFailedEndpoints int
+	TotalEndpoints           int
+	TotalActiveEndpoints     int
+	TotalFailedEndpoints     int
+	TotalBackingOffEndpoints int
 }
 
 func (e *EndpointSummary) Init(endpoints []*machine.Endpoint) {
 	e.TotalEndpoints = len(endpoints)
 	e.TotalActiveEndpoints = 0
 	e.TotalFailedEndpoints = 0
+	e.TotalBackingOffEndpoints = 0
 	for _, endpoint := range endpoints {
 		if !endpoint.Active() {
 			continue
@@ -120,6 +130,9 @@ func (e *EndpointSummary) Init(endpoints []*machine.Endpoint) {
 		if endpoint.App.Down {
 			e.TotalFailedEndpoints++
 		}
+		if endpoint.App.Status == scotty.BackingOff {
+			e.TotalBackingOffEndpoints++
+		}
 		e.TotalActiveEndpoints++
 	}
 }
@@ -19,6 +19,7 @@ const (
 	Total Endpoints: {{.Summary.TotalEndpoints}}<br>
 	Total Active Endpoints: {{.Summary.TotalActiveEndpoints}}<br>
 	Total Failed Endpoints: {{.Summary.TotalFailedEndpoints}}<br>
+	Total Backing Off Endpoints: {{.Summary.TotalBackingOffEndpoints}}<br>
 	  `
 )
 
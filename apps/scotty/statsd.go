@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/scotty/machine"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/scotty/statsd"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	fStatsdAddress = flag.String(
+		"statsdAddress",
+		"",
+		"UDP address on which to receive StatsD metrics e.g :8125. Empty means don't receive StatsD metrics.")
+	fStatsdTcp = flag.Bool(
+		"statsdTcp",
+		false,
+		"Also receive StatsD metrics over TCP on statsdAddress.")
+	fStatsdFlushInterval = flag.Duration(
+		"statsdFlushInterval",
+		10*time.Second,
+		"How often to write aggregated StatsD metrics to the store.")
+	fStatsdAppName = flag.String(
+		"statsdAppName",
+		"statsd",
+		"Application name for StatsD metrics without an app tag.")
+	fStatsdAllowedNetworks = flag.String(
+		"statsdAllowedNetworks",
+		"",
+		"Comma separated networks e.g 10.0.0.0/8 from which to accept StatsD metrics. Empty means any network.")
+	fStatsdUnknownHosts = flag.Bool(
+		"statsdUnknownHosts",
+		false,
+		"Accept StatsD metrics for hosts missing from mdb. If false, a host tag must name a host in mdb and metrics without one must come from the IP address of a host in mdb.")
+)
+
+// statsdSinkType aggregates the StatsD metrics of one application.
+type statsdSinkType struct {
+	*statsd.Aggregator
+}
+
+// Flush forgets the metrics that have not been added to for
+// -pushStaleness and returns the rest.
+func (s statsdSinkType) Flush(now time.Time) metrics.SimpleList {
+	expireFlushCount := uint64(*fPushStaleness / *fStatsdFlushInterval)
+	if expireFlushCount == 0 {
+		expireFlushCount = 1
+	}
+	s.Expire(expireFlushCount)
+	return s.Aggregator.Flush()
+}
+
+// statsdListenerType aggregates StatsD metrics per application and writes
+// them to the store.
+//
+// A DogStatsD "host" tag names the host sending a metric. Likewise, an
+// "app" tag names the application with -statsdAppName as the default.
+type statsdListenerType struct {
+	pushListenerType
+}
+
+func newStatsdListener(
+	endpointStore *machine.EndpointStore,
+	writer *pushWriterType,
+	logger log.Logger) *statsdListenerType {
+	return &statsdListenerType{
+		pushListenerType: pushListenerType{
+			Protocol:       "StatsD",
+			ES:             endpointStore,
+			Writer:         writer,
+			Logger:         logger,
+			DefaultAppName: *fStatsdAppName,
+			NewSink: func() pushSinkType {
+				return statsdSinkType{statsd.NewAggregator()}
+			},
+		},
+	}
+}
+
+// handleLine adds a single StatsD line from given sender.
+func (s *statsdListenerType) handleLine(sender net.IP, line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	m, err := statsd.Parse(line)
+	if err != nil {
+		s.LogReceived(false)
+		return
+	}
+	sink := s.Sink(sender, m.Tags["host"], m.Tags["app"])
+	if sink == nil {
+		return
+	}
+	s.LogReceived(sink.(statsdSinkType).Add(m) == nil)
+}
+
+// handlePacket adds each line in a StatsD packet.
+func (s *statsdListenerType) handlePacket(sender net.IP, packet []byte) {
+	for _, line := range strings.Split(string(packet), "\n") {
+		s.handleLine(sender, line)
+	}
+}
+
+func (s *statsdListenerType) serveConn(sender net.IP, conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		s.handleLine(sender, scanner.Text())
+	}
+}
+
+// startStatsdListener starts receiving StatsD metrics on -statsdAddress.
+func startStatsdListener(
+	endpointStore *machine.EndpointStore,
+	writer *pushWriterType,
+	logger log.Logger) {
+	listener := newStatsdListener(endpointStore, writer, logger)
+	allowedNetworks, err := parseNetworks(*fStatsdAllowedNetworks)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	listener.AllowedNetworks = allowedNetworks
+	listener.UnknownHosts = *fStatsdUnknownHosts
+	if err := listener.RegisterMetrics("statsd", "lines"); err != nil {
+		logger.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", *fStatsdAddress)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	go listener.ServeUDP(conn, listener.handlePacket)
+	if *fStatsdTcp {
+		tcpListener, err := net.Listen("tcp", *fStatsdAddress)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		go listener.ServeTCP(tcpListener, listener.serveConn)
+	}
+	listener.StartFlushing(*fStatsdFlushInterval)
+}
@@ -1,6 +1,7 @@
 package scotty
 
 import (
+	"context"
 	"github.com/Symantec/scotty/hostid"
 	"github.com/Symantec/scotty/metrics"
 	"github.com/Symantec/scotty/sources"
@@ -18,6 +19,8 @@ var (
 	connectSemaphore   = make(chan bool, concurrentConnects)
 	concurrentPolls    = allowedPollCount()
 	pollSemaphore      = make(chan bool, concurrentPolls)
+	backoffMin         time.Duration
+	backoffMax         time.Duration
 )
 
 func allowedPollCount() uint {
@@ -57,6 +60,42 @@ func setConcurrentPolls(x uint) {
 	}
 }
 
+func setBackoff(min, max time.Duration) {
+	if max < min {
+		max = min
+	}
+	backoffMin = min
+	backoffMax = max
+}
+
+// backoff returns how long to wait before connecting again after failing
+// to connect failureCount times in a row. 0 means don't wait.
+func backoff(failureCount uint) time.Duration {
+	if backoffMin <= 0 || failureCount == 0 {
+		return 0
+	}
+	result := backoffMin
+	for i := uint(1); i < failureCount && result < backoffMax; i++ {
+		result *= 2
+	}
+	if result > backoffMax {
+		result = backoffMax
+	}
+	return result
+}
+
+func backingOff(
+	sweepStartTime time.Time,
+	consecutiveFailures uint,
+	retryTime time.Time) *State {
+	return &State{
+		timestamp:           sweepStartTime,
+		sweepStartTime:      sweepStartTime,
+		consecutiveFailures: consecutiveFailures,
+		retryTime:           retryTime,
+		status:              BackingOff}
+}
+
 func waitingToConnect(sweepStartTime time.Time) *State {
 	return &State{
 		timestamp:      sweepStartTime,
@@ -87,8 +126,12 @@ func (s *State) goToWaitingToPoll(t time.Time) *State {
 	return s.finishedConnecting(t, WaitingToPoll)
 }
 
-func (s *State) goToFailedToConnect(t time.Time) *State {
-	return s.finishedConnecting(t, FailedToConnect)
+func (s *State) goToFailedToConnect(
+	t time.Time, consecutiveFailures uint, retryTime time.Time) *State {
+	result := s.finishedConnecting(t, FailedToConnect)
+	result.consecutiveFailures = consecutiveFailures
+	result.retryTime = retryTime
+	return result
 }
 
 func (s *State) goToPolling(t time.Time) *State {
@@ -129,19 +172,53 @@ func newEndpoint(
 	}
 }
 
+// timeoutContext returns a context that times out after timeout.
+// 0 means no timeout.
+func timeoutContext(timeout time.Duration) (
+	context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// asTimeout returns a *TimeoutError in place of err if ctx timed out.
+func asTimeout(
+	ctx context.Context, err error, status Status, timeout time.Duration) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Status: status, Timeout: timeout}
+	}
+	return err
+}
+
 func (e *Endpoint) pollWithResource(
 	state *State,
 	logger Logger,
-	resource sources.Resource) bool {
+	resource sources.Resource,
+	timeouts PollTimeouts) bool {
 	state = state.goToConnecting(time.Now(), e.conn.Name())
 	e.logState(state, logger)
-	conn, err := e.conn.ResourceConnect(resource)
+	connectCtx, cancelConnect := timeoutContext(timeouts.Connect)
+	conn, err := sources.ResourceConnectContext(connectCtx, e.conn, resource)
 	if err != nil {
-		state = state.goToFailedToConnect(time.Now())
+		err = asTimeout(connectCtx, err, FailedToConnect, timeouts.Connect)
+	}
+	cancelConnect()
+	if err != nil {
+		now := time.Now()
+		e.consecutiveFailures++
+		e.retryTime = time.Time{}
+		if wait := backoff(e.consecutiveFailures); wait > 0 {
+			e.retryTime = now.Add(wait)
+		}
+		state = state.goToFailedToConnect(
+			now, e.consecutiveFailures, e.retryTime)
 		e.logError(err, state, logger)
 		return false
 	}
 	defer conn.Close()
+	e.consecutiveFailures = 0
+	e.retryTime = time.Time{}
 	state = state.goToWaitingToPoll(time.Now())
 	e.logState(state, logger)
 	if pollSemaphore != nil {
@@ -152,7 +229,12 @@ func (e *Endpoint) pollWithResource(
 	}
 	state = state.goToPolling(time.Now())
 	e.logState(state, logger)
-	metrics, err := conn.Poll()
+	pollCtx, cancelPoll := timeoutContext(timeouts.Poll)
+	metrics, err := sources.PollContext(pollCtx, conn)
+	if err != nil {
+		err = asTimeout(pollCtx, err, FailedToPoll, timeouts.Poll)
+	}
+	cancelPoll()
 	if err != nil {
 		state = state.goToFailedToPoll(time.Now())
 		e.logError(err, state, logger)
@@ -174,9 +256,19 @@ func (e *Endpoint) getResource(isTls bool, port uint) sources.Resource {
 	return e.resource
 }
 
-func (e *Endpoint) poll(sweepStartTime time.Time, isTls bool, port uint, logger Logger) {
+func (e *Endpoint) poll(
+	sweepStartTime time.Time,
+	isTls bool,
+	port uint,
+	timeouts PollTimeouts,
+	logger Logger) {
 	select {
 	case e.onePollAtATime <- true:
+		if time.Now().Before(e.retryTime) {
+			e.backOff(sweepStartTime, logger)
+			<-e.onePollAtATime
+			return
+		}
 		state := waitingToConnect(sweepStartTime)
 		e.logState(state, logger)
 		connectSemaphore <- true
@@ -187,13 +279,25 @@ func (e *Endpoint) poll(sweepStartTime time.Time, isTls bool, port uint, logger
 			defer func() {
 				<-connectSemaphore
 			}()
-			e.pollWithResource(state, logger, e.getResource(isTls, port))
+			e.pollWithResource(
+				state, logger, e.getResource(isTls, port), timeouts)
 		}(state)
 	default:
 		return
 	}
 }
 
+// backOff puts this instance in the BackingOff state. Caller must hold
+// the onePollAtATime semaphore.
+func (e *Endpoint) backOff(sweepStartTime time.Time, logger Logger) {
+	if e.state != nil && e.state.status == BackingOff {
+		return
+	}
+	e.logState(
+		backingOff(sweepStartTime, e.consecutiveFailures, e.retryTime),
+		logger)
+}
+
 func (e *Endpoint) logState(state *State, logger Logger) {
 	oldState := e._logState(state)
 	if logger != nil {
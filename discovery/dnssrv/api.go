@@ -0,0 +1,84 @@
+// Package dnssrv discovers endpoints by resolving DNS SRV records.
+//
+// When given a DNS server, this package asks it directly so that it can
+// report the TTL of each record, which net.LookupSRV doesn't, and callers
+// can refresh records when they expire.
+package dnssrv
+
+import (
+	"sync"
+	"time"
+)
+
+// Record is a single SRV record.
+type Record struct {
+	// The target host without the trailing dot e.g "web01.example.com"
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+	// Zero if the Client has no Server
+	TTL time.Duration
+}
+
+// Client looks up SRV records.
+// Client instances are safe to use with multiple goroutines.
+type Client struct {
+	// The DNS server e.g "10.0.0.2:53". The port defaults to 53. Empty
+	// means use the system resolver, which doesn't report TTLs.
+	Server string
+	// How long to wait for an answer. Zero means 5 seconds.
+	Timeout time.Duration
+}
+
+// LookupSRV looks up the SRV records for name e.g
+// "_metrics._tcp.example.com". If Server is set, LookupSRV asks it over
+// UDP and then over TCP if the answer doesn't fit in a UDP packet. If
+// name doesn't exist, LookupSRV returns no records and no error.
+// LookupSRV omits records whose target is "." meaning the service is
+// unavailable.
+func (c *Client) LookupSRV(name string) ([]*Record, error) {
+	return c.lookupSRV(name)
+}
+
+// Resolver keeps the SRV records of several names up to date looking up
+// each name again when its records expire.
+// Resolver instances are safe to use with multiple goroutines.
+type Resolver struct {
+	client     *Client
+	minRefresh time.Duration
+	maxRefresh time.Duration
+	mu         sync.Mutex
+	entries    map[string]*entryType
+}
+
+// NewResolver returns a new Resolver that uses client. Resolver looks up
+// a name again when the smallest TTL of its records expires but never
+// sooner than minRefresh or later than maxRefresh after the last lookup.
+// Records from a Client without a Server expire after maxRefresh. After a failed lookup
+// or a lookup with no records, Resolver tries again after minRefresh.
+func NewResolver(
+	client *Client, minRefresh, maxRefresh time.Duration) *Resolver {
+	return newResolver(client, minRefresh, maxRefresh)
+}
+
+// Refresh looks up each name in names that is due at time now and
+// forgets about names not in names. If a lookup fails, the name keeps
+// the records from its last successful lookup, and Refresh includes the
+// error in errs. lookups is the number of names Refresh looked up.
+func (r *Resolver) Refresh(names []string, now time.Time) (
+	lookups int, errs []error) {
+	return r.refresh(names, now)
+}
+
+// NextRefresh returns the earliest time that Refresh has a name to look
+// up again. NextRefresh returns false if Refresh has looked up no names.
+func (r *Resolver) NextRefresh() (time.Time, bool) {
+	return r.nextRefresh()
+}
+
+// Records returns the current records for name. Callers must not modify
+// the returned slice.
+func (r *Resolver) Records(name string) []*Record {
+	return r.records(name)
+}
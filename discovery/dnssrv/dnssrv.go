@@ -0,0 +1,335 @@
+package dnssrv
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	kDefaultTimeout = 5 * time.Second
+)
+
+var (
+	errIdMismatch = errors.New("dnssrv: Response ID mismatch")
+	errNotReply   = errors.New("dnssrv: Not a response")
+)
+
+func (c *Client) server() string {
+	if _, _, err := net.SplitHostPort(c.Server); err != nil {
+		return net.JoinHostPort(c.Server, "53")
+	}
+	return c.Server
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return kDefaultTimeout
+	}
+	return c.Timeout
+}
+
+func (c *Client) lookupSRV(name string) ([]*Record, error) {
+	if c.Server == "" {
+		return c.lookupSRVWithSystemResolver(name)
+	}
+	id, err := newQueryId()
+	if err != nil {
+		return nil, err
+	}
+	query, err := newQuery(id, name)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.exchangeUDP(id, query)
+	if err != nil {
+		return nil, err
+	}
+	records, truncated, err := parseResponse(id, response)
+	if err != nil || !truncated {
+		return records, err
+	}
+	if response, err = c.exchangeTCP(query); err != nil {
+		return nil, err
+	}
+	records, _, err = parseResponse(id, response)
+	return records, err
+}
+
+func (c *Client) lookupSRVWithSystemResolver(name string) ([]*Record, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+	defer cancel()
+	_, answers, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var result []*Record
+	for _, answer := range answers {
+		target := strings.TrimSuffix(answer.Target, ".")
+		if target == "" {
+			continue
+		}
+		result = append(result, &Record{
+			Target:   target,
+			Port:     answer.Port,
+			Priority: answer.Priority,
+			Weight:   answer.Weight,
+		})
+	}
+	return result, nil
+}
+
+// newQueryId returns a random query ID so that an off-path attacker
+// can't easily guess it.
+func newQueryId() (uint16, error) {
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(id[:]), nil
+}
+
+// newQuery returns a query for the SRV records of name.
+func newQuery(id uint16, name string) ([]byte, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	dnsName, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	message := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{
+				Name:  dnsName,
+				Type:  dnsmessage.TypeSRV,
+				Class: dnsmessage.ClassINET,
+			},
+		},
+	}
+	return message.Pack()
+}
+
+func (c *Client) exchangeUDP(id uint16, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", c.server(), c.timeout())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout()))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore stray answers to other queries.
+		if n >= 2 && binary.BigEndian.Uint16(buffer) == id {
+			return buffer[:n], nil
+		}
+	}
+}
+
+func (c *Client) exchangeTCP(query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", c.server(), c.timeout())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout()))
+	message := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(message, uint16(len(query)))
+	copy(message[2:], query)
+	if _, err := conn.Write(message); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// parseResponse returns the SRV records in response. If response is
+// truncated, parseResponse returns truncated = true and no records.
+func parseResponse(id uint16, response []byte) (
+	records []*Record, truncated bool, err error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		return
+	}
+	if header.ID != id {
+		err = errIdMismatch
+		return
+	}
+	if !header.Response {
+		err = errNotReply
+		return
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return
+	default:
+		err = fmt.Errorf(
+			"dnssrv: Server returned error code %d", header.RCode)
+		return
+	}
+	if header.Truncated {
+		truncated = true
+		return
+	}
+	if err = parser.SkipAllQuestions(); err != nil {
+		return
+	}
+	for {
+		var answer dnsmessage.ResourceHeader
+		answer, err = parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return records, false, nil
+		}
+		if err != nil {
+			return
+		}
+		// Skip CNAMEs and the like.
+		if answer.Type != dnsmessage.TypeSRV ||
+			answer.Class != dnsmessage.ClassINET {
+			if err = parser.SkipAnswer(); err != nil {
+				return
+			}
+			continue
+		}
+		var srv dnsmessage.SRVResource
+		if srv, err = parser.SRVResource(); err != nil {
+			return
+		}
+		target := strings.TrimSuffix(srv.Target.String(), ".")
+		if target == "" {
+			continue
+		}
+		records = append(records, &Record{
+			Target:   target,
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+			TTL:      time.Duration(answer.TTL) * time.Second,
+		})
+	}
+}
+
+// entryType holds the records of one name in a Resolver.
+type entryType struct {
+	records     []*Record
+	nextRefresh time.Time
+}
+
+func newResolver(
+	client *Client, minRefresh, maxRefresh time.Duration) *Resolver {
+	return &Resolver{
+		client:     client,
+		minRefresh: minRefresh,
+		maxRefresh: maxRefresh,
+		entries:    make(map[string]*entryType),
+	}
+}
+
+// refreshInterval returns how long to wait before looking up records
+// again.
+func (r *Resolver) refreshInterval(records []*Record) time.Duration {
+	if len(records) == 0 {
+		return r.minRefresh
+	}
+	result := r.maxRefresh
+	// Without a server, TTLs are unknown.
+	if r.client.Server == "" {
+		return result
+	}
+	for _, record := range records {
+		if record.TTL < result {
+			result = record.TTL
+		}
+	}
+	if result < r.minRefresh {
+		result = r.minRefresh
+	}
+	return result
+}
+
+func (r *Resolver) refresh(names []string, now time.Time) (
+	lookups int, errs []error) {
+	var due []string
+	r.mu.Lock()
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+		entry := r.entries[name]
+		if entry == nil || !now.Before(entry.nextRefresh) {
+			due = append(due, name)
+		}
+	}
+	for name := range r.entries {
+		if !wanted[name] {
+			delete(r.entries, name)
+		}
+	}
+	r.mu.Unlock()
+	for _, name := range due {
+		lookups++
+		records, err := r.client.LookupSRV(name)
+		r.mu.Lock()
+		entry := r.entries[name]
+		if entry == nil {
+			entry = &entryType{}
+			r.entries[name] = entry
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+			entry.nextRefresh = now.Add(r.minRefresh)
+		} else {
+			entry.records = records
+			entry.nextRefresh = now.Add(r.refreshInterval(records))
+		}
+		r.mu.Unlock()
+	}
+	return
+}
+
+func (r *Resolver) records(name string) []*Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry := r.entries[name]; entry != nil {
+		return entry.records
+	}
+	return nil
+}
+
+func (r *Resolver) nextRefresh() (result time.Time, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range r.entries {
+		if !ok || entry.nextRefresh.Before(result) {
+			result = entry.nextRefresh
+			ok = true
+		}
+	}
+	return
+}
@@ -0,0 +1,319 @@
+package dnssrv_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/Symantec/scotty/discovery/dnssrv"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// answerType is an SRV answer that dnsServerType sends.
+type answerType struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+	TTL      uint32
+}
+
+// zoneType is what dnsServerType answers for one name.
+type zoneType struct {
+	Rcode   dnsmessage.RCode
+	Answers []answerType
+	// If set, UDP answers are truncated so the client must use TCP.
+	TCPOnly bool
+}
+
+// dnsServerType is an in-process DNS server that answers SRV queries
+// over UDP and TCP on the same port.
+type dnsServerType struct {
+	udp      net.PacketConn
+	tcp      net.Listener
+	mu       sync.Mutex
+	zones    map[string]*zoneType
+	requests int
+}
+
+func newDNSServer(t *testing.T) *dnsServerType {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.ListenPacket("udp", tcp.Addr().String())
+	if err != nil {
+		tcp.Close()
+		t.Skipf("Cannot listen on UDP port: %v", err)
+	}
+	result := &dnsServerType{
+		udp: udp, tcp: tcp, zones: make(map[string]*zoneType)}
+	go result.serveUDP()
+	go result.serveTCP()
+	return result
+}
+
+func (s *dnsServerType) Addr() string {
+	return s.tcp.Addr().String()
+}
+
+func (s *dnsServerType) Close() {
+	s.udp.Close()
+	s.tcp.Close()
+}
+
+func (s *dnsServerType) Set(name string, zone *zoneType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zones[name] = zone
+}
+
+func (s *dnsServerType) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *dnsServerType) serveUDP() {
+	buffer := make([]byte, 512)
+	for {
+		n, addr, err := s.udp.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		s.udp.WriteTo(s.answer(buffer[:n], true), addr)
+	}
+}
+
+func (s *dnsServerType) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err == nil {
+			query := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, query); err == nil {
+				response := s.answer(query, false)
+				binary.BigEndian.PutUint16(length[:], uint16(len(response)))
+				conn.Write(append(length[:], response...))
+			}
+		}
+		conn.Close()
+	}
+}
+
+// answer returns the response to query. The response repeats the
+// question and compresses names.
+func (s *dnsServerType) answer(query []byte, udp bool) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil
+	}
+	zone := s.zones[strings.TrimSuffix(question.Name.String(), ".")]
+	if zone == nil {
+		zone = &zoneType{Rcode: dnsmessage.RCodeNameError}
+	}
+	truncated := udp && zone.TCPOnly
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		RecursionDesired:   true,
+		RecursionAvailable: true,
+		Truncated:          truncated,
+		RCode:              zone.Rcode,
+	})
+	builder.EnableCompression()
+	builder.StartQuestions()
+	builder.Question(question)
+	if !truncated {
+		builder.StartAnswers()
+		answerHeader := dnsmessage.ResourceHeader{
+			Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}
+		// A CNAME answer that the client should skip
+		builder.CNAMEResource(
+			answerHeader, dnsmessage.CNAMEResource{CNAME: question.Name})
+		for _, answer := range zone.Answers {
+			answerHeader.TTL = answer.TTL
+			target := answer.Target
+			if target != "." {
+				target += "."
+			}
+			builder.SRVResource(answerHeader, dnsmessage.SRVResource{
+				Priority: answer.Priority,
+				Weight:   answer.Weight,
+				Port:     answer.Port,
+				Target:   dnsmessage.MustNewName(target),
+			})
+		}
+	}
+	response, err := builder.Finish()
+	if err != nil {
+		panic(err)
+	}
+	return response
+}
+
+func TestLookupSRV(t *testing.T) {
+	server := newDNSServer(t)
+	defer server.Close()
+	server.Set("_metrics._tcp.example.com", &zoneType{
+		Answers: []answerType{
+			{Target: "web01.example.com", Port: 9100, Priority: 10,
+				Weight: 5, TTL: 300},
+			{Target: "web02.other.net", Port: 9101, TTL: 60},
+			{Target: ".", Port: 9102, TTL: 60},
+		},
+	})
+	server.Set("_big._tcp.example.com", &zoneType{
+		Answers: []answerType{
+			{Target: "web03.example.com", Port: 80, TTL: 30},
+		},
+		TCPOnly: true,
+	})
+	server.Set("_broken._tcp.example.com", &zoneType{
+		Rcode: dnsmessage.RCodeServerFailure,
+	})
+	client := &dnssrv.Client{Server: server.Addr(), Timeout: time.Second}
+	records, err := client.LookupSRV("_metrics._tcp.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*dnssrv.Record{
+		{
+			Target:   "web01.example.com",
+			Port:     9100,
+			Priority: 10,
+			Weight:   5,
+			TTL:      5 * time.Minute,
+		},
+		{
+			Target: "web02.other.net",
+			Port:   9101,
+			TTL:    time.Minute,
+		},
+	}
+	if !reflect.DeepEqual(expected, records) {
+		t.Errorf("Expected %v, got %v", expected, records)
+	}
+	records, err = client.LookupSRV("_big._tcp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []*dnssrv.Record{
+		{Target: "web03.example.com", Port: 80, TTL: 30 * time.Second},
+	}
+	if !reflect.DeepEqual(expected, records) {
+		t.Errorf("Expected %v, got %v", expected, records)
+	}
+	records, err = client.LookupSRV("_missing._tcp.example.com")
+	if err != nil || len(records) != 0 {
+		t.Errorf("Expected no records and no error, got %v, %v", records, err)
+	}
+	if _, err := client.LookupSRV("_broken._tcp.example.com"); err == nil {
+		t.Error("Expected error on server failure")
+	}
+	if _, err := client.LookupSRV("bad..name"); err == nil {
+		t.Error("Expected error on bad name")
+	}
+}
+
+func TestResolver(t *testing.T) {
+	server := newDNSServer(t)
+	defer server.Close()
+	const (
+		kShort = "_short._tcp.example.com"
+		kLong  = "_long._tcp.example.com"
+	)
+	server.Set(kShort, &zoneType{
+		Answers: []answerType{
+			{Target: "a.example.com", Port: 1, TTL: 5},
+			{Target: "b.example.com", Port: 2, TTL: 120},
+		},
+	})
+	server.Set(kLong, &zoneType{
+		Answers: []answerType{
+			{Target: "c.example.com", Port: 3, TTL: 86400},
+		},
+	})
+	client := &dnssrv.Client{Server: server.Addr(), Timeout: time.Second}
+	resolver := dnssrv.NewResolver(client, 30*time.Second, time.Hour)
+	names := []string{kShort, kLong}
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	assertRefresh(t, resolver, names, start, 2, 0)
+	assertTargets(t, resolver.Records(kShort), "a.example.com:1", "b.example.com:2")
+	assertTargets(t, resolver.Records(kLong), "c.example.com:3")
+
+	if next, ok := resolver.NextRefresh(); !ok || !next.Equal(
+		start.Add(30*time.Second)) {
+		t.Errorf("Expected next refresh at 30s, got %v, %v", next, ok)
+	}
+
+	// Nothing is due yet.
+	assertRefresh(t, resolver, names, start.Add(29*time.Second), 0, 0)
+
+	// The 5 second TTL is raised to the 30 second minimum.
+	server.Set(kShort, &zoneType{Rcode: dnsmessage.RCodeServerFailure})
+	assertRefresh(t, resolver, names, start.Add(30*time.Second), 1, 1)
+
+	// A failed lookup keeps the old records.
+	assertTargets(t, resolver.Records(kShort), "a.example.com:1", "b.example.com:2")
+
+	// The 1 day TTL is capped to the hour maximum.
+	server.Set(kShort, &zoneType{})
+	assertRefresh(t, resolver, names, start.Add(time.Hour), 2, 0)
+	assertTargets(t, resolver.Records(kShort))
+	assertTargets(t, resolver.Records(kLong), "c.example.com:3")
+
+	// Forgotten names are looked up again right away.
+	assertRefresh(t, resolver, []string{kShort}, start.Add(time.Hour), 0, 0)
+	if records := resolver.Records(kLong); records != nil {
+		t.Errorf("Expected no records for %s, got %v", kLong, records)
+	}
+	requests := server.Requests()
+	assertRefresh(t, resolver, names, start.Add(time.Hour), 1, 0)
+	if server.Requests() != requests+1 {
+		t.Errorf("Expected 1 more request, got %d", server.Requests()-requests)
+	}
+}
+
+func assertRefresh(
+	t *testing.T,
+	resolver *dnssrv.Resolver,
+	names []string,
+	now time.Time,
+	lookups, errCount int) {
+	actualLookups, errs := resolver.Refresh(names, now)
+	if actualLookups != lookups {
+		t.Errorf("Expected %d lookups, got %d", lookups, actualLookups)
+	}
+	if len(errs) != errCount {
+		t.Errorf("Expected %d errors, got %v", errCount, errs)
+	}
+}
+
+func assertTargets(t *testing.T, records []*dnssrv.Record, expected ...string) {
+	var actual []string
+	for _, record := range records {
+		actual = append(
+			actual, fmt.Sprintf("%s:%d", record.Target, record.Port))
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,86 @@
+// Package filesd discovers endpoints from a directory of target files in
+// the spirit of the file_sd discovery of Prometheus.
+//
+// Each target file is a JSON or YAML list of target groups. For example
+//
+//	[
+//	  {
+//	    "targets": ["web01:9100", "web02:9100"],
+//	    "app": "node exporter",
+//	    "protocol": "prometheus",
+//	    "labels": {"env": "prod"}
+//	  },
+//	  {
+//	    "targets": ["lb01.example.com:8404"],
+//	    "app": "haproxy",
+//	    "tls": true,
+//	    "noHealthAgent": true
+//	  }
+//	]
+//
+// Each target in a group is a host and port. The group gives the
+// application name and how to read its metrics. Files must end in .json,
+// .yaml or .yml; other files are ignored.
+//
+// filesd doesn't watch the directory for changes. Instead, callers poll the
+// directory by calling Scan on a Poller periodically.
+package filesd
+
+import (
+	"io"
+	"sync"
+)
+
+// Target is a single application on a single host.
+type Target struct {
+	Host string
+	Port uint
+	App  string
+	TLS  bool
+	// How the application exposes its metrics e.g "prometheus". Empty
+	// means tricorder.
+	Protocol string
+	// If true, scotty should not poll the health agent on Host.
+	NoHealthAgent bool
+	// Arbitrary labels for Host. Callers must not modify.
+	Labels map[string]string
+}
+
+// Parse reads the target groups in a target file from r and returns the
+// targets in them.
+func Parse(r io.Reader) ([]*Target, error) {
+	return parse(r)
+}
+
+// Poller polls a directory of target files for changes. Poller notices
+// that a target file changed when its modification time or size changes.
+// Poller instances are safe to use with multiple goroutines.
+type Poller struct {
+	dir     string
+	mu      sync.Mutex
+	files   map[string]*fileType
+	targets []*Target
+}
+
+// NewPoller returns a new Poller for target files in dir. The new
+// Poller has no targets until the first call to Scan.
+func NewPoller(dir string) *Poller {
+	return newPoller(dir)
+}
+
+// Scan rereads the target files that were added or changed since the last
+// call to Scan and forgets about the target files that were removed.
+// If a target file can't be read or parsed, Scan keeps the targets
+// that the file previously had and includes the error in errs. changed
+// is true if the targets changed.
+func (p *Poller) Scan() (changed bool, errs []error) {
+	return p.scan()
+}
+
+// Targets returns the current targets sorted by host and then by
+// application. If more than one target file has a target with the same
+// host and application, the file whose name sorts last wins. Callers
+// must not modify the returned slice.
+func (p *Poller) Targets() []*Target {
+	return p.getTargets()
+}
@@ -0,0 +1,209 @@
+package filesd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Symantec/scotty/lib/yamlutil"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// targetGroupType represents a single target group in a target file.
+// Since JSON is a subset of YAML, the YAML parser reads both.
+type targetGroupType struct {
+	Targets       []string          `yaml:"targets"`
+	App           string            `yaml:"app"`
+	TLS           bool              `yaml:"tls"`
+	Protocol      string            `yaml:"protocol"`
+	NoHealthAgent bool              `yaml:"noHealthAgent"`
+	Labels        map[string]string `yaml:"labels"`
+}
+
+func (t *targetGroupType) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+	type targetGroupFields targetGroupType
+	return yamlutil.StrictUnmarshalYAML(unmarshal, (*targetGroupFields)(t))
+}
+
+// fileType is a target file that a Poller has read.
+type fileType struct {
+	modTime time.Time
+	size    int64
+	targets []*Target
+}
+
+func parse(r io.Reader) ([]*Target, error) {
+	var content bytes.Buffer
+	if _, err := content.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	var groups []targetGroupType
+	if err := yaml.Unmarshal(content.Bytes(), &groups); err != nil {
+		return nil, err
+	}
+	var result []*Target
+	for _, group := range groups {
+		if group.App == "" {
+			return nil, fmt.Errorf(
+				"filesd: No app for targets %v", group.Targets)
+		}
+		for _, hostAndPort := range group.Targets {
+			host, port, err := splitHostPort(hostAndPort)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, &Target{
+				Host:          host,
+				Port:          port,
+				App:           group.App,
+				TLS:           group.TLS,
+				Protocol:      group.Protocol,
+				NoHealthAgent: group.NoHealthAgent,
+				Labels:        group.Labels,
+			})
+		}
+	}
+	return result, nil
+}
+
+func splitHostPort(hostAndPort string) (host string, port uint, err error) {
+	host, portStr, err := net.SplitHostPort(hostAndPort)
+	if err != nil {
+		return "", 0, fmt.Errorf("filesd: %v", err)
+	}
+	portNum, err := strconv.ParseUint(portStr, 10, 16)
+	if host == "" || err != nil || portNum == 0 {
+		return "", 0, fmt.Errorf("filesd: Bad target %q", hostAndPort)
+	}
+	return host, uint(portNum), nil
+}
+
+func parseFile(path string) ([]*Target, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	targets, err := parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return targets, nil
+}
+
+func isTargetFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return !strings.HasPrefix(name, ".")
+	}
+	return false
+}
+
+func newPoller(dir string) *Poller {
+	return &Poller{dir: dir, files: make(map[string]*fileType)}
+}
+
+func (p *Poller) scan() (changed bool, errs []error) {
+	infos, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return false, []error{err}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	present := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || !isTargetFile(info.Name()) {
+			continue
+		}
+		present[info.Name()] = true
+		old := p.files[info.Name()]
+		if old != nil && old.modTime.Equal(info.ModTime()) &&
+			old.size == info.Size() {
+			continue
+		}
+		targets, err := parseFile(filepath.Join(p.dir, info.Name()))
+		if err != nil {
+			errs = append(errs, err)
+			if old != nil {
+				// Don't reread the bad file until it changes again.
+				old.modTime = info.ModTime()
+				old.size = info.Size()
+				continue
+			}
+			// Remember that the bad file has no targets yet.
+			p.files[info.Name()] = &fileType{
+				modTime: info.ModTime(), size: info.Size()}
+			continue
+		}
+		p.files[info.Name()] = &fileType{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			targets: targets,
+		}
+		changed = true
+	}
+	for name := range p.files {
+		if !present[name] {
+			delete(p.files, name)
+			changed = true
+		}
+	}
+	if changed {
+		p.targets = p.mergeTargets()
+	}
+	return
+}
+
+// mergeTargets merges the targets of every file. Caller must hold the lock.
+func (p *Poller) mergeTargets() []*Target {
+	names := make([]string, 0, len(p.files))
+	for name := range p.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	type keyType struct {
+		Host string
+		App  string
+	}
+	byKey := make(map[keyType]*Target)
+	for _, name := range names {
+		for _, target := range p.files[name].targets {
+			byKey[keyType{Host: target.Host, App: target.App}] = target
+		}
+	}
+	result := make([]*Target, 0, len(byKey))
+	for _, target := range byKey {
+		result = append(result, target)
+	}
+	sort.Sort(byHostAndApp(result))
+	return result
+}
+
+func (p *Poller) getTargets() []*Target {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.targets
+}
+
+type byHostAndApp []*Target
+
+func (b byHostAndApp) Len() int { return len(b) }
+
+func (b byHostAndApp) Less(i, j int) bool {
+	if b[i].Host != b[j].Host {
+		return b[i].Host < b[j].Host
+	}
+	return b[i].App < b[j].App
+}
+
+func (b byHostAndApp) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
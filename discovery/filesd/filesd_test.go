@@ -0,0 +1,165 @@
+package filesd_test
+
+import (
+	"fmt"
+	"github.com/Symantec/scotty/discovery/filesd"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	kYAMLTargets = `
+- targets: ["web01:9100", "10.1.1.2:9100"]
+  app: node exporter
+  protocol: prometheus
+  labels:
+    env: prod
+- targets: ["lb01.example.com:8404"]
+  app: haproxy
+  tls: true
+  noHealthAgent: true
+`
+	kJSONTargets = `[
+	{"targets": ["web01:6980"], "app": "scotty"},
+	{"targets": ["web01:9101"], "app": "node exporter"}
+]`
+)
+
+func TestParse(t *testing.T) {
+	targets, err := filesd.Parse(strings.NewReader(kYAMLTargets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{"env": "prod"}
+	expected := []*filesd.Target{
+		{
+			Host:     "web01",
+			Port:     9100,
+			App:      "node exporter",
+			Protocol: "prometheus",
+			Labels:   labels,
+		},
+		{
+			Host:     "10.1.1.2",
+			Port:     9100,
+			App:      "node exporter",
+			Protocol: "prometheus",
+			Labels:   labels,
+		},
+		{
+			Host:          "lb01.example.com",
+			Port:          8404,
+			App:           "haproxy",
+			TLS:           true,
+			NoHealthAgent: true,
+		},
+	}
+	if !reflect.DeepEqual(expected, targets) {
+		t.Errorf("Expected %v, got %v", expected, targets)
+	}
+	for _, bad := range []string{
+		`[{"targets": ["web01"], "app": "a"}]`,
+		`[{"targets": ["web01:0"], "app": "a"}]`,
+		`[{"targets": ["web01:70000"], "app": "a"}]`,
+		`[{"targets": [":80"], "app": "a"}]`,
+		`[{"targets": ["web01:80"]}]`,
+		`[{"targets": ["web01:80"], "app": "a", "bogus": 1}]`,
+		`{"targets": ["web01:80"], "app": "a"}`,
+	} {
+		if _, err := filesd.Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected error parsing %s", bad)
+		}
+	}
+}
+
+func TestPoller(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name, contents string) {
+		if err := ioutil.WriteFile(
+			filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	poller := filesd.NewPoller(dir)
+	writeFile("a.yml", kYAMLTargets)
+	writeFile("b.json", kJSONTargets)
+	writeFile("README", "not a target file")
+	assertScan(t, poller, true, 0)
+	assertTargets(
+		t,
+		poller.Targets(),
+		"10.1.1.2/node exporter:9100",
+		"lb01.example.com/haproxy:8404",
+		"web01/node exporter:9101",
+		"web01/scotty:6980")
+	assertScan(t, poller, false, 0)
+
+	// A bad file keeps its old targets
+	writeFile("b.json", "[")
+	assertScan(t, poller, false, 1)
+	assertScan(t, poller, false, 0)
+	assertTargets(
+		t,
+		poller.Targets(),
+		"10.1.1.2/node exporter:9100",
+		"lb01.example.com/haproxy:8404",
+		"web01/node exporter:9101",
+		"web01/scotty:6980")
+
+	// A new bad file has no targets
+	writeFile("c.yaml", "- targets: [web02]\n  app: web")
+	assertScan(t, poller, false, 1)
+
+	os.Remove(filepath.Join(dir, "b.json"))
+	assertScan(t, poller, true, 0)
+	assertTargets(
+		t,
+		poller.Targets(),
+		"10.1.1.2/node exporter:9100",
+		"lb01.example.com/haproxy:8404",
+		"web01/node exporter:9100")
+
+	writeFile("c.yaml", `[{"targets": ["web02:80"], "app": "web"}]`)
+	assertScan(t, poller, true, 0)
+	assertTargets(
+		t,
+		poller.Targets(),
+		"10.1.1.2/node exporter:9100",
+		"lb01.example.com/haproxy:8404",
+		"web01/node exporter:9100",
+		"web02/web:80")
+
+	os.RemoveAll(dir)
+	assertScan(t, poller, false, 1)
+}
+
+func assertScan(
+	t *testing.T, poller *filesd.Poller, changed bool, errCount int) {
+	actualChanged, errs := poller.Scan()
+	if actualChanged != changed {
+		t.Errorf("Expected changed=%v, got %v", changed, actualChanged)
+	}
+	if len(errs) != errCount {
+		t.Errorf("Expected %d errors, got %v", errCount, errs)
+	}
+}
+
+func assertTargets(t *testing.T, targets []*filesd.Target, expected ...string) {
+	var actual []string
+	for _, target := range targets {
+		actual = append(
+			actual,
+			fmt.Sprintf("%s/%s:%d", target.Host, target.App, target.Port))
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,116 @@
+// Package graphite parses metrics in the Graphite plaintext and pickle
+// formats and maps Graphite metric names to scotty endpoints and paths.
+package graphite
+
+import (
+	"github.com/Symantec/scotty/metrics"
+	"io"
+	"sync"
+	"time"
+)
+
+// Metric represents a single Graphite data point.
+type Metric struct {
+	// The dotted metric name e.g "servers.web01.cpu.user"
+	Name      string
+	Value     float64
+	Timestamp time.Time
+}
+
+// Parse parses a single plaintext line such as
+// "servers.web01.cpu.user 12.5 1500000000".
+// A timestamp of -1 means the current time as it does for carbon.
+func Parse(line string) (*Metric, error) {
+	return parse(line)
+}
+
+// ReadPickle reads one message in the pickle protocol from r. Each message
+// is a 4 byte big endian length followed by a pickled list of
+// (name, (timestamp, value)) tuples. ReadPickle returns io.EOF if r
+// has no more messages. ReadPickle understands only the pickle opcodes
+// needed for lists, tuples, strings and numbers; it never runs code.
+func ReadPickle(r io.Reader) ([]*Metric, error) {
+	return readPickle(r)
+}
+
+// Template maps Graphite metric names to a host, an application and a
+// metric path.
+type Template struct {
+	filter []string
+	parts  []string
+}
+
+// NewTemplate returns a new template.
+//
+// filter is a dotted pattern that the leading components of a metric
+// name must match for the template to apply e.g "servers.*". A "*"
+// component matches any single component. An empty filter matches every
+// name.
+//
+// pattern is a dotted list that says what each component of a metric
+// name is e.g "-.host.app.metric*". "host" and "app" components make up
+// the host name and application name joined by dots. "metric"
+// components make up the metric path joined by slashes. "-" components
+// are ignored. The last component may be "metric*" which matches all
+// remaining components of the name. Without "metric*", a name must have
+// exactly as many components as pattern. pattern must have at least one
+// metric component.
+func NewTemplate(filter, pattern string) (*Template, error) {
+	return newTemplate(filter, pattern)
+}
+
+// Apply maps a metric name to a host name, application name and metric
+// path such as "/cpu/user". hostName or appName is empty if the template
+// has no host or app components. ok is false if the template doesn't
+// apply to name.
+func (t *Template) Apply(name string) (
+	hostName, appName, path string, ok bool) {
+	return t.apply(name)
+}
+
+// Templates is a list of templates to try in order.
+type Templates []*Template
+
+// Apply maps name using the first template in t that applies to it. If
+// no template applies, Apply returns empty hostName and appName and maps
+// every component of name to the metric path. ok is false only if name
+// has no usable components.
+func (t Templates) Apply(name string) (
+	hostName, appName, path string, ok bool) {
+	return t.apply(name)
+}
+
+// Buffer holds the latest value of each metric path from one application
+// so that they can be written to the store as a batch. Since the store
+// treats a metric missing from a batch as gone, a Buffer keeps reporting
+// each value until it goes stale. Buffer instances are safe to use with
+// multiple goroutines.
+type Buffer struct {
+	lock   sync.Mutex
+	values map[string]*bufferEntryType
+	// The latest timestamp added
+	latest time.Time
+}
+
+// NewBuffer returns a new, empty buffer.
+func NewBuffer() *Buffer {
+	return newBuffer()
+}
+
+// Add stores value with given timestamp as the latest value for path.
+// Add ignores the value if path already has a value with a later
+// timestamp.
+func (b *Buffer) Add(path string, value float64, timestamp time.Time) {
+	b.add(path, value, timestamp)
+}
+
+// Flush returns the latest value of each path added since
+// lastAddedAfter sorted by path. Flush forgets about paths that were
+// last added before lastAddedAfter. Graphite clients usually send all the
+// metrics of an interval with the same timestamp, so Flush returns every
+// value with the latest timestamp added and the same group Id. This way
+// the store keeps one series of timestamps per application rather than
+// one per path.
+func (b *Buffer) Flush(lastAddedAfter time.Time) metrics.SimpleList {
+	return b.flush(lastAddedAfter)
+}
@@ -0,0 +1,210 @@
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kHost       = "host"
+	kApp        = "app"
+	kMetric     = "metric"
+	kMetricRest = "metric*"
+	kIgnore     = "-"
+)
+
+var (
+	errNoMetric = errors.New(
+		"graphite: Template needs a metric component")
+)
+
+func parse(line string) (*Metric, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("graphite: Expected 3 fields in %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("graphite: Bad value in %q", line)
+	}
+	ts, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || math.IsNaN(ts) || math.IsInf(ts, 0) {
+		return nil, fmt.Errorf("graphite: Bad timestamp in %q", line)
+	}
+	return &Metric{
+		Name:      fields[0],
+		Value:     value,
+		Timestamp: toTime(ts),
+	}, nil
+}
+
+// toTime converts seconds since the epoch to a time. -1 means now.
+func toTime(ts float64) time.Time {
+	if ts == -1 {
+		return time.Now()
+	}
+	secs := math.Floor(ts)
+	return time.Unix(int64(secs), int64((ts-secs)*1e9))
+}
+
+// components returns the non empty components of a dotted name with any
+// slashes replaced.
+func components(name string) (result []string) {
+	for _, component := range strings.Split(name, ".") {
+		if component != "" {
+			result = append(
+				result, strings.Replace(component, "/", "_", -1))
+		}
+	}
+	return
+}
+
+func newTemplate(filter, pattern string) (*Template, error) {
+	parts := strings.Split(pattern, ".")
+	hasMetric := false
+	for i, part := range parts {
+		switch part {
+		case kHost, kApp, kIgnore:
+		case kMetric:
+			hasMetric = true
+		case kMetricRest:
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf(
+					"graphite: %s must be last in %q", kMetricRest, pattern)
+			}
+			hasMetric = true
+		default:
+			return nil, fmt.Errorf(
+				"graphite: Unknown component %q in %q", part, pattern)
+		}
+	}
+	if !hasMetric {
+		return nil, errNoMetric
+	}
+	result := &Template{parts: parts}
+	if filter != "" {
+		result.filter = strings.Split(filter, ".")
+	}
+	return result, nil
+}
+
+func (t *Template) matchesFilter(nameParts []string) bool {
+	if len(nameParts) < len(t.filter) {
+		return false
+	}
+	for i, f := range t.filter {
+		if f != "*" && f != nameParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Template) apply(name string) (
+	hostName, appName, path string, ok bool) {
+	nameParts := components(name)
+	if !t.matchesFilter(nameParts) {
+		return
+	}
+	rest := t.parts[len(t.parts)-1] == kMetricRest
+	if rest {
+		if len(nameParts) < len(t.parts) {
+			return
+		}
+	} else if len(nameParts) != len(t.parts) {
+		return
+	}
+	var hostParts, appParts, metricParts []string
+	for i, part := range t.parts {
+		switch part {
+		case kHost:
+			hostParts = append(hostParts, nameParts[i])
+		case kApp:
+			appParts = append(appParts, nameParts[i])
+		case kMetric:
+			metricParts = append(metricParts, nameParts[i])
+		case kMetricRest:
+			metricParts = append(metricParts, nameParts[i:]...)
+		}
+	}
+	return strings.Join(hostParts, "."),
+		strings.Join(appParts, "."),
+		"/" + strings.Join(metricParts, "/"),
+		true
+}
+
+func (t Templates) apply(name string) (
+	hostName, appName, path string, ok bool) {
+	for _, template := range t {
+		if hostName, appName, path, ok = template.Apply(name); ok {
+			return
+		}
+	}
+	nameParts := components(name)
+	if len(nameParts) == 0 {
+		return
+	}
+	return "", "", "/" + strings.Join(nameParts, "/"), true
+}
+
+const (
+	// The group Id of every value a Buffer flushes. Group Id 0 is for
+	// metrics without timestamps.
+	kGroupId = 1
+)
+
+type bufferEntryType struct {
+	Value     float64
+	Timestamp time.Time
+	LastAdded time.Time
+}
+
+func newBuffer() *Buffer {
+	return &Buffer{
+		values: make(map[string]*bufferEntryType),
+	}
+}
+
+func (b *Buffer) add(path string, value float64, timestamp time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	entry := b.values[path]
+	if entry == nil {
+		entry = &bufferEntryType{}
+		b.values[path] = entry
+	} else if timestamp.Before(entry.Timestamp) {
+		return
+	}
+	entry.Value = value
+	entry.Timestamp = timestamp
+	entry.LastAdded = time.Now()
+	if timestamp.After(b.latest) {
+		b.latest = timestamp
+	}
+}
+
+func (b *Buffer) flush(lastAddedAfter time.Time) metrics.SimpleList {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	result := make(metrics.SimpleList, 0, len(b.values))
+	for path, entry := range b.values {
+		if entry.LastAdded.Before(lastAddedAfter) {
+			delete(b.values, path)
+			continue
+		}
+		result = append(result, metrics.Value{
+			Path:      path,
+			Unit:      units.None,
+			Value:     entry.Value,
+			TimeStamp: b.latest,
+			GroupId:   kGroupId,
+		})
+	}
+	return result.Sorted()
+}
@@ -0,0 +1,200 @@
+package graphite_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/Symantec/scotty/graphite"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	// pickle.dumps(data, protocol=0) and pickle.dumps(data, protocol=2)
+	// where data is
+	// [('servers.web01.cpu.user', (1500000000, 12.5)),
+	//  ('servers.web01.load', (1500000000.5, 3)),
+	//  ('big', (1500000000, 2**70)),
+	//  ('str', (1500000000, '7.25'))]
+	kPickles = []string{
+		"(lp0\n(Vservers.web01.cpu.user\np1\n(I1500000000\nF12.5\ntp2\ntp3\na(Vservers.web01.load\np4\n(F1500000000.5\nI3\ntp5\ntp6\na(Vbig\np7\n(I1500000000\nL1180591620717411303424L\ntp8\ntp9\na(Vstr\np10\n(I1500000000\nV7.25\np11\ntp12\ntp13\na.",
+		"\x80\x02]q\x00(X\x16\x00\x00\x00servers.web01.cpu.userq\x01J\x00/hYG@)\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03X\x12\x00\x00\x00servers.web01.loadq\x04GA\xd6Z\x0b\xc0 \x00\x00K\x03\x86q\x05\x86q\x06X\x03\x00\x00\x00bigq\x07J\x00/hY\x8a\t\x00\x00\x00\x00\x00\x00\x00\x00@\x86q\x08\x86q\tX\x03\x00\x00\x00strq\nJ\x00/hYX\x04\x00\x00\x007.25q\x0b\x86q\x0c\x86q\re.",
+	}
+)
+
+func frame(payload string) []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, uint32(len(payload)))
+	buffer.WriteString(payload)
+	return buffer.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	m, err := graphite.Parse("servers.web01.cpu.user 12.5 1500000000\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &graphite.Metric{
+		Name:      "servers.web01.cpu.user",
+		Value:     12.5,
+		Timestamp: time.Unix(1500000000, 0),
+	}
+	if !reflect.DeepEqual(expected, m) {
+		t.Errorf("Expected %v, got %v", expected, m)
+	}
+	m, err = graphite.Parse("foo 1 -1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(m.Timestamp) > time.Minute {
+		t.Errorf("Expected current time, got %v", m.Timestamp)
+	}
+	for _, line := range []string{
+		"foo", "foo 1", "foo abc 1", "foo 1 abc", "foo NaN 1", "foo 1 2 3"} {
+		if _, err := graphite.Parse(line); err == nil {
+			t.Errorf("Expected error parsing %q", line)
+		}
+	}
+}
+
+func TestReadPickle(t *testing.T) {
+	expected := []*graphite.Metric{
+		{
+			Name:      "servers.web01.cpu.user",
+			Value:     12.5,
+			Timestamp: time.Unix(1500000000, 0),
+		},
+		{
+			Name:      "servers.web01.load",
+			Value:     3,
+			Timestamp: time.Unix(1500000000, 500000000),
+		},
+		{
+			Name:      "big",
+			Value:     1180591620717411303424.0,
+			Timestamp: time.Unix(1500000000, 0),
+		},
+		{
+			Name:      "str",
+			Value:     7.25,
+			Timestamp: time.Unix(1500000000, 0),
+		},
+	}
+	var stream bytes.Buffer
+	for _, pickle := range kPickles {
+		stream.Write(frame(pickle))
+	}
+	for range kPickles {
+		actual, err := graphite.ReadPickle(&stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	}
+	if _, err := graphite.ReadPickle(&stream); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+	for _, bad := range []string{
+		"",
+		"]",
+		"(lp0\n",
+		// A pickle that calls os.system
+		"cos\nsystem\n(S'true'\ntR.",
+		// Not a list
+		"I1\n.",
+		// Wrong tuple shape
+		"]q\x00(X\x03\x00\x00\x00fooK\x01\x86e.",
+		"\x80\x02]q\x00(X\x03\x00\x00\x00fooq\x01h\x09e.",
+	} {
+		if _, err := graphite.ReadPickle(bytes.NewReader(frame(bad))); err == nil {
+			t.Errorf("Expected error reading %q", bad)
+		}
+	}
+	if _, err := graphite.ReadPickle(bytes.NewReader(frame("]")[:3])); err == nil {
+		t.Error("Expected error reading truncated header")
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	mustTemplate := func(filter, pattern string) *graphite.Template {
+		result, err := graphite.NewTemplate(filter, pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	templates := graphite.Templates{
+		mustTemplate("servers.*", "-.host.app.metric*"),
+		mustTemplate("", "app.host.host.metric"),
+	}
+	for name, expected := range map[string][3]string{
+		"servers.web01.nginx.requests.count": {"web01", "nginx", "/requests/count"},
+		"servers.web01.nginx":                {"", "", "/servers/web01/nginx"},
+		"redis.db1.example.hits":             {"db1.example", "redis", "/hits"},
+		"a.b/c..d":                           {"", "", "/a/b_c/d"},
+	} {
+		hostName, appName, path, ok := templates.Apply(name)
+		if !ok {
+			t.Errorf("Expected %q to match", name)
+			continue
+		}
+		actual := [3]string{hostName, appName, path}
+		if actual != expected {
+			t.Errorf("Expected %v for %q, got %v", expected, name, actual)
+		}
+	}
+	if _, _, _, ok := templates.Apply(".."); ok {
+		t.Error("Expected empty name not to match")
+	}
+	for _, pattern := range []string{"host.app", "metric*.host", "host.bogus"} {
+		if _, err := graphite.NewTemplate("", pattern); err == nil {
+			t.Errorf("Expected error for %q", pattern)
+		}
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	buffer := graphite.NewBuffer()
+	ts := time.Unix(1500000000, 0)
+	buffer.Add("/load", 3, ts)
+	buffer.Add("/cpu", 12.5, ts)
+	buffer.Add("/load", 4, ts.Add(time.Minute))
+	// Older values are ignored
+	buffer.Add("/load", 5, ts.Add(time.Second))
+	expected := metrics.SimpleList{
+		{
+			Path:      "/cpu",
+			Unit:      units.None,
+			Value:     12.5,
+			TimeStamp: ts.Add(time.Minute),
+			GroupId:   1,
+		},
+		{
+			Path:      "/load",
+			Unit:      units.None,
+			Value:     4.0,
+			TimeStamp: ts.Add(time.Minute),
+			GroupId:   1,
+		},
+	}
+	actual := buffer.Flush(time.Now().Add(-time.Hour))
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if err := metrics.VerifyList(actual); err != nil {
+		t.Error(err)
+	}
+	// Values stay until they go stale.
+	actual = buffer.Flush(time.Now().Add(-time.Hour))
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if actual = buffer.Flush(time.Now().Add(time.Hour)); len(actual) != 0 {
+		t.Errorf("Expected nothing, got %v", actual)
+	}
+}
@@ -0,0 +1,540 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// This file contains a decoder for the subset of the python pickle format
+// that carbon clients use.
+
+const (
+	// Largest pickle message we accept
+	kMaxPickleSize = 16 * 1024 * 1024
+)
+
+const (
+	kOpMark            = '('
+	kOpStop            = '.'
+	kOpPop             = '0'
+	kOpFloat           = 'F'
+	kOpInt             = 'I'
+	kOpBinInt          = 'J'
+	kOpBinInt1         = 'K'
+	kOpLong            = 'L'
+	kOpBinInt2         = 'M'
+	kOpNone            = 'N'
+	kOpString          = 'S'
+	kOpBinString       = 'T'
+	kOpShortBinString  = 'U'
+	kOpUnicode         = 'V'
+	kOpBinUnicode      = 'X'
+	kOpAppend          = 'a'
+	kOpAppends         = 'e'
+	kOpGet             = 'g'
+	kOpBinGet          = 'h'
+	kOpLongBinGet      = 'j'
+	kOpList            = 'l'
+	kOpPut             = 'p'
+	kOpBinPut          = 'q'
+	kOpLongBinPut      = 'r'
+	kOpTuple           = 't'
+	kOpEmptyList       = ']'
+	kOpEmptyTuple      = ')'
+	kOpBinFloat        = 'G'
+	kOpProto           = 0x80
+	kOpTuple1          = 0x85
+	kOpTuple2          = 0x86
+	kOpTuple3          = 0x87
+	kOpNewTrue         = 0x88
+	kOpNewFalse        = 0x89
+	kOpLong1           = 0x8a
+	kOpShortBinUnicode = 0x8c
+	kOpMemoize         = 0x94
+	kOpFrame           = 0x95
+)
+
+var (
+	errPickleTooBig  = errors.New("graphite: Pickle message too big")
+	errPickleStack   = errors.New("graphite: Bad pickle stack")
+	errPickleFormat  = errors.New("graphite: Expected list of (name, (timestamp, value))")
+	errPickleNoStop  = errors.New("graphite: Pickle missing STOP")
+	errPickleBadData = errors.New("graphite: Truncated pickle")
+)
+
+// markType marks the start of a tuple or a list of appended items on the
+// pickle stack.
+type markType struct{}
+
+// tupleType is a python tuple. A python list is a *[]interface{}.
+type tupleType []interface{}
+
+func readPickle(r io.Reader) ([]*Metric, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > kMaxPickleSize {
+		return nil, errPickleTooBig
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	value, err := unpickle(payload)
+	if err != nil {
+		return nil, err
+	}
+	return toMetrics(value)
+}
+
+// toMetrics converts an unpickled list of (name, (timestamp, value))
+// tuples to metrics.
+func toMetrics(value interface{}) ([]*Metric, error) {
+	list, ok := value.(*[]interface{})
+	if !ok {
+		return nil, errPickleFormat
+	}
+	result := make([]*Metric, 0, len(*list))
+	for _, item := range *list {
+		outer, ok := item.(tupleType)
+		if !ok || len(outer) != 2 {
+			return nil, errPickleFormat
+		}
+		name, ok := outer[0].(string)
+		if !ok {
+			return nil, errPickleFormat
+		}
+		inner, ok := outer[1].(tupleType)
+		if !ok || len(inner) != 2 {
+			return nil, errPickleFormat
+		}
+		ts, err := toFloat(inner[0])
+		if err != nil {
+			return nil, err
+		}
+		value, err := toFloat(inner[1])
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("graphite: Bad value for %s", name)
+		}
+		result = append(result, &Metric{
+			Name:      name,
+			Value:     value,
+			Timestamp: toTime(ts),
+		})
+	}
+	return result, nil
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	default:
+		return 0, errPickleFormat
+	}
+}
+
+// unpickleType decodes a single pickle.
+type unpickleType struct {
+	data  []byte
+	stack []interface{}
+	memo  map[int64]interface{}
+}
+
+func unpickle(data []byte) (interface{}, error) {
+	u := &unpickleType{data: data, memo: make(map[int64]interface{})}
+	return u.run()
+}
+
+func (u *unpickleType) next(n int) ([]byte, error) {
+	if n < 0 || len(u.data) < n {
+		return nil, errPickleBadData
+	}
+	result := u.data[:n]
+	u.data = u.data[n:]
+	return result, nil
+}
+
+// line returns the rest of the current line without the newline.
+func (u *unpickleType) line() (string, error) {
+	idx := bytes.IndexByte(u.data, '\n')
+	if idx == -1 {
+		return "", errPickleBadData
+	}
+	result := string(u.data[:idx])
+	u.data = u.data[idx+1:]
+	return result, nil
+}
+
+func (u *unpickleType) push(value interface{}) {
+	u.stack = append(u.stack, value)
+}
+
+func (u *unpickleType) pop() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, errPickleStack
+	}
+	result := u.stack[len(u.stack)-1]
+	u.stack = u.stack[:len(u.stack)-1]
+	if _, ok := result.(markType); ok {
+		return nil, errPickleStack
+	}
+	return result, nil
+}
+
+func (u *unpickleType) top() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, errPickleStack
+	}
+	return u.stack[len(u.stack)-1], nil
+}
+
+// popMark pops everything down to and including the topmost mark and
+// returns what was above the mark.
+func (u *unpickleType) popMark() ([]interface{}, error) {
+	for i := len(u.stack) - 1; i >= 0; i-- {
+		if _, ok := u.stack[i].(markType); ok {
+			result := append([]interface{}(nil), u.stack[i+1:]...)
+			u.stack = u.stack[:i]
+			return result, nil
+		}
+	}
+	return nil, errPickleStack
+}
+
+func (u *unpickleType) popTuple(n int) error {
+	if len(u.stack) < n {
+		return errPickleStack
+	}
+	items := u.stack[len(u.stack)-n:]
+	for _, item := range items {
+		if _, ok := item.(markType); ok {
+			return errPickleStack
+		}
+	}
+	tuple := append(tupleType(nil), items...)
+	u.stack = u.stack[:len(u.stack)-n]
+	u.push(tuple)
+	return nil
+}
+
+func (u *unpickleType) appendToList(items ...interface{}) error {
+	top, err := u.top()
+	if err != nil {
+		return err
+	}
+	list, ok := top.(*[]interface{})
+	if !ok {
+		return errPickleStack
+	}
+	*list = append(*list, items...)
+	return nil
+}
+
+func (u *unpickleType) memoize(key int64) error {
+	top, err := u.top()
+	if err != nil {
+		return err
+	}
+	u.memo[key] = top
+	return nil
+}
+
+func (u *unpickleType) recall(key int64) error {
+	value, ok := u.memo[key]
+	if !ok {
+		return fmt.Errorf("graphite: Pickle memo %d missing", key)
+	}
+	u.push(value)
+	return nil
+}
+
+func (u *unpickleType) run() (interface{}, error) {
+	for {
+		opBytes, err := u.next(1)
+		if err != nil {
+			return nil, errPickleNoStop
+		}
+		if err := u.step(opBytes[0]); err != nil {
+			if err == io.EOF {
+				if len(u.stack) != 1 {
+					return nil, errPickleStack
+				}
+				return u.pop()
+			}
+			return nil, err
+		}
+	}
+}
+
+// step runs a single opcode. step returns io.EOF on STOP.
+func (u *unpickleType) step(op byte) error {
+	switch op {
+	case kOpStop:
+		return io.EOF
+	case kOpProto:
+		_, err := u.next(1)
+		return err
+	case kOpFrame:
+		_, err := u.next(8)
+		return err
+	case kOpMark:
+		u.push(markType{})
+	case kOpPop:
+		_, err := u.pop()
+		return err
+	case kOpNone:
+		u.push(nil)
+	case kOpNewTrue:
+		u.push(int64(1))
+	case kOpNewFalse:
+		u.push(int64(0))
+	case kOpInt:
+		s, err := u.line()
+		if err != nil {
+			return err
+		}
+		// Protocol 0 encodes True and False as INT too.
+		switch s {
+		case "01":
+			u.push(int64(1))
+		case "00":
+			u.push(int64(0))
+		default:
+			x, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			u.push(x)
+		}
+	case kOpLong:
+		s, err := u.line()
+		if err != nil {
+			return err
+		}
+		x, ok := new(big.Int).SetString(strings.TrimSuffix(s, "L"), 10)
+		if !ok {
+			return errPickleBadData
+		}
+		u.push(x)
+	case kOpBinInt:
+		b, err := u.next(4)
+		if err != nil {
+			return err
+		}
+		u.push(int64(int32(binary.LittleEndian.Uint32(b))))
+	case kOpBinInt1:
+		b, err := u.next(1)
+		if err != nil {
+			return err
+		}
+		u.push(int64(b[0]))
+	case kOpBinInt2:
+		b, err := u.next(2)
+		if err != nil {
+			return err
+		}
+		u.push(int64(binary.LittleEndian.Uint16(b)))
+	case kOpLong1:
+		n, err := u.next(1)
+		if err != nil {
+			return err
+		}
+		b, err := u.next(int(n[0]))
+		if err != nil {
+			return err
+		}
+		u.push(decodeLong(b))
+	case kOpFloat:
+		s, err := u.line()
+		if err != nil {
+			return err
+		}
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		u.push(x)
+	case kOpBinFloat:
+		b, err := u.next(8)
+		if err != nil {
+			return err
+		}
+		u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case kOpString:
+		s, err := u.line()
+		if err != nil {
+			return err
+		}
+		unquoted, err := unquotePython(s)
+		if err != nil {
+			return err
+		}
+		u.push(unquoted)
+	case kOpUnicode:
+		s, err := u.line()
+		if err != nil {
+			return err
+		}
+		u.push(s)
+	case kOpBinString, kOpBinUnicode:
+		b, err := u.next(4)
+		if err != nil {
+			return err
+		}
+		s, err := u.next(int(binary.LittleEndian.Uint32(b)))
+		if err != nil {
+			return err
+		}
+		u.push(string(s))
+	case kOpShortBinString, kOpShortBinUnicode:
+		b, err := u.next(1)
+		if err != nil {
+			return err
+		}
+		s, err := u.next(int(b[0]))
+		if err != nil {
+			return err
+		}
+		u.push(string(s))
+	case kOpEmptyList:
+		u.push(&[]interface{}{})
+	case kOpList:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(&items)
+	case kOpAppend:
+		item, err := u.pop()
+		if err != nil {
+			return err
+		}
+		return u.appendToList(item)
+	case kOpAppends:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		return u.appendToList(items...)
+	case kOpEmptyTuple:
+		u.push(tupleType{})
+	case kOpTuple:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(tupleType(items))
+	case kOpTuple1:
+		return u.popTuple(1)
+	case kOpTuple2:
+		return u.popTuple(2)
+	case kOpTuple3:
+		return u.popTuple(3)
+	case kOpPut:
+		s, err := u.line()
+		if err != nil {
+			return err
+		}
+		key, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		return u.memoize(key)
+	case kOpBinPut:
+		b, err := u.next(1)
+		if err != nil {
+			return err
+		}
+		return u.memoize(int64(b[0]))
+	case kOpLongBinPut:
+		b, err := u.next(4)
+		if err != nil {
+			return err
+		}
+		return u.memoize(int64(binary.LittleEndian.Uint32(b)))
+	case kOpMemoize:
+		return u.memoize(int64(len(u.memo)))
+	case kOpGet:
+		s, err := u.line()
+		if err != nil {
+			return err
+		}
+		key, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		return u.recall(key)
+	case kOpBinGet:
+		b, err := u.next(1)
+		if err != nil {
+			return err
+		}
+		return u.recall(int64(b[0]))
+	case kOpLongBinGet:
+		b, err := u.next(4)
+		if err != nil {
+			return err
+		}
+		return u.recall(int64(binary.LittleEndian.Uint32(b)))
+	default:
+		return fmt.Errorf("graphite: Unsupported pickle opcode 0x%x", op)
+	}
+	return nil
+}
+
+// decodeLong decodes a little endian two's complement integer.
+func decodeLong(b []byte) interface{} {
+	if len(b) == 0 {
+		return int64(0)
+	}
+	bigEndian := make([]byte, len(b))
+	for i := range b {
+		bigEndian[len(b)-1-i] = b[i]
+	}
+	result := new(big.Int).SetBytes(bigEndian)
+	if b[len(b)-1]&0x80 != 0 {
+		result.Sub(result, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	if result.IsInt64() {
+		return result.Int64()
+	}
+	return result
+}
+
+// unquotePython unquotes a python 2 string literal in single or double
+// quotes.
+func unquotePython(s string) (string, error) {
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return "", errPickleBadData
+	}
+	body := s[1 : len(s)-1]
+	if s[0] == '\'' {
+		// strconv only understands double quoted strings.
+		body = strings.Replace(body, `\'`, `'`, -1)
+		body = strings.Replace(body, `"`, `\"`, -1)
+	}
+	return strconv.Unquote(`"` + body + `"`)
+}
@@ -0,0 +1,51 @@
+// Package hostlabels extracts host labels from mdb.
+//
+// Host labels such as owner or cluster describe a machine. Scotty attaches
+// them as tags to the metrics of every application on the machine.
+package hostlabels
+
+import (
+	"github.com/Symantec/Dominator/lib/mdb"
+)
+
+// Config says which mdb fields become which host labels. The key is the
+// label name; the value is the mdb field. The mdb fields are:
+//
+//	RequiredImage
+//	PlannedImage
+//	Tags.<key>
+//	AwsMetadata.AccountId
+//	AwsMetadata.AccountName
+//	AwsMetadata.InstanceId
+//	AwsMetadata.Tags.<key>
+//
+// A nil Config extracts no labels.
+type Config map[string]string
+
+// ParseConfig parses a comma separated list of label=field pairs such as
+// "owner=Tags.Owner,env=AwsMetadata.Tags.Environment". A label by itself
+// such as "cluster" is short for "cluster=Tags.cluster". Label names
+// may have only letters, digits, '-', '_', '.', and '/'. Label names may
+// not be reserved; see IsReserved.
+// An empty string yields a nil Config.
+func ParseConfig(s string) (Config, error) {
+	return parseConfig(s)
+}
+
+// Labels returns the host labels for machine omitting labels whose field
+// is empty. If there are no labels, Labels returns nil.
+func (c Config) Labels(machine *mdb.Machine) map[string]string {
+	return c.labels(machine)
+}
+
+// Names returns the label names of this Config in sorted order.
+func (c Config) Names() []string {
+	return c.names()
+}
+
+// IsReserved returns true if name is the name of a built-in tag such as
+// "appname" or a key that a persistent store writer adds to its records
+// such as "value" and therefore cannot be a label name.
+func IsReserved(name string) bool {
+	return kReserved[name]
+}
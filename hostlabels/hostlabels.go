@@ -0,0 +1,148 @@
+package hostlabels
+
+import (
+	"fmt"
+	"github.com/Symantec/Dominator/lib/mdb"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	kTagsPrefix    = "Tags."
+	kAwsTagsPrefix = "AwsMetadata.Tags."
+)
+
+var (
+	// Names of built-in tags in storage and queries and the keys that
+	// the persistent store writers put in each record along with the
+	// tags. "host" is the influx and kafka name of "HostName".
+	kReserved = map[string]bool{
+		"HostName":  true,
+		"host":      true,
+		"appname":   true,
+		"region":    true,
+		"ipaddress": true,
+		// kafka and lmm
+		"@version":    true,
+		"@timestamp":  true,
+		"value":       true,
+		"stringValue": true,
+		"name":        true,
+		"tenant_id":   true,
+		"apikey":      true,
+		// influx
+		"time": true,
+	}
+)
+
+func parseConfig(s string) (Config, error) {
+	if s == "" {
+		return nil, nil
+	}
+	result := make(Config)
+	for _, pair := range strings.Split(s, ",") {
+		var name, field string
+		if pos := strings.Index(pair, "="); pos != -1 {
+			name, field = pair[:pos], pair[pos+1:]
+		} else {
+			name, field = pair, kTagsPrefix+pair
+		}
+		if name == "" {
+			return nil, fmt.Errorf("hostlabels: Missing label name in %q", pair)
+		}
+		if !isValidName(name) {
+			return nil, fmt.Errorf("hostlabels: Bad label name %q", name)
+		}
+		if IsReserved(name) {
+			return nil, fmt.Errorf("hostlabels: %s is a built-in tag", name)
+		}
+		if _, ok := result[name]; ok {
+			return nil, fmt.Errorf("hostlabels: Duplicate label %s", name)
+		}
+		if !isValidField(field) {
+			return nil, fmt.Errorf("hostlabels: Unknown mdb field %q", field)
+		}
+		result[name] = field
+	}
+	return result, nil
+}
+
+// isValidName returns true if name has only the characters that tag keys
+// may have: letters, digits, '-', '_', '.', and '/'.
+func isValidName(name string) bool {
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) &&
+			!strings.ContainsRune("-_./", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isValidField(field string) bool {
+	switch field {
+	case "RequiredImage", "PlannedImage", "AwsMetadata.AccountId",
+		"AwsMetadata.AccountName", "AwsMetadata.InstanceId":
+		return true
+	}
+	if strings.HasPrefix(field, kAwsTagsPrefix) {
+		return len(field) > len(kAwsTagsPrefix)
+	}
+	if strings.HasPrefix(field, kTagsPrefix) {
+		return len(field) > len(kTagsPrefix)
+	}
+	return false
+}
+
+func fieldValue(machine *mdb.Machine, field string) string {
+	switch field {
+	case "RequiredImage":
+		return machine.RequiredImage
+	case "PlannedImage":
+		return machine.PlannedImage
+	}
+	if strings.HasPrefix(field, kTagsPrefix) {
+		return machine.Tags[field[len(kTagsPrefix):]]
+	}
+	aws := machine.AwsMetadata
+	if aws == nil {
+		return ""
+	}
+	switch field {
+	case "AwsMetadata.AccountId":
+		return aws.AccountId
+	case "AwsMetadata.AccountName":
+		return aws.AccountName
+	case "AwsMetadata.InstanceId":
+		return aws.InstanceId
+	}
+	if strings.HasPrefix(field, kAwsTagsPrefix) {
+		return aws.Tags[field[len(kAwsTagsPrefix):]]
+	}
+	return ""
+}
+
+func (c Config) labels(machine *mdb.Machine) map[string]string {
+	var result map[string]string
+	for name, field := range c {
+		value := fieldValue(machine, field)
+		if value == "" {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[name] = value
+	}
+	return result
+}
+
+func (c Config) names() []string {
+	result := make([]string, 0, len(c))
+	for name := range c {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,79 @@
+package hostlabels_test
+
+import (
+	"github.com/Symantec/Dominator/lib/mdb"
+	"github.com/Symantec/scotty/hostlabels"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestHostLabels(t *testing.T) {
+	Convey("With config", t, func() {
+		config, err := hostlabels.ParseConfig(
+			"owner,env=AwsMetadata.Tags.Environment,image=RequiredImage,account=AwsMetadata.AccountName")
+		So(err, ShouldBeNil)
+		So(config, ShouldResemble, hostlabels.Config{
+			"owner":   "Tags.owner",
+			"env":     "AwsMetadata.Tags.Environment",
+			"image":   "RequiredImage",
+			"account": "AwsMetadata.AccountName",
+		})
+		So(config.Names(), ShouldResemble, []string{
+			"account", "env", "image", "owner"})
+		Convey("aws machine", func() {
+			machine := &mdb.Machine{
+				Hostname:      "web01",
+				RequiredImage: "web.0",
+				Tags:          map[string]string{"owner": "alice"},
+				AwsMetadata: &mdb.AwsMetadata{
+					AccountName: "prod-account",
+					Tags:        map[string]string{"Environment": "prod"},
+				},
+			}
+			So(config.Labels(machine), ShouldResemble, map[string]string{
+				"owner":   "alice",
+				"env":     "prod",
+				"image":   "web.0",
+				"account": "prod-account",
+			})
+		})
+		Convey("empty fields are omitted", func() {
+			machine := &mdb.Machine{
+				Hostname: "web02",
+				Tags:     map[string]string{"owner": "bob"},
+			}
+			So(config.Labels(machine), ShouldResemble, map[string]string{
+				"owner": "bob",
+			})
+			So(config.Labels(&mdb.Machine{Hostname: "web03"}), ShouldBeNil)
+		})
+	})
+	Convey("Empty config", t, func() {
+		config, err := hostlabels.ParseConfig("")
+		So(err, ShouldBeNil)
+		So(config, ShouldBeNil)
+		So(config.Names(), ShouldBeEmpty)
+		machine := &mdb.Machine{Tags: map[string]string{"owner": "alice"}}
+		So(config.Labels(machine), ShouldBeNil)
+	})
+	Convey("Bad configs", t, func() {
+		for _, bad := range []string{
+			"=Tags.owner",
+			"appname",
+			"host=Tags.host",
+			"value=Tags.value",
+			"tenant_id",
+			"@timestamp=Tags.ts",
+			"time",
+			"own er=Tags.owner",
+			"own\x00er=Tags.owner",
+			"owner,owner=Tags.Owner",
+			"owner=Tags.",
+			"owner=Hostname",
+			"owner,",
+		} {
+			_, err := hostlabels.ParseConfig(bad)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
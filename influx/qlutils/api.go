@@ -61,10 +61,14 @@ func QuerySetTimeRange(
 // ParseQuery also returns the column names for each result set. The number
 // of column name sets returned always equals the number of ParseQuery
 // instances returned.
+// labelNames are the names of the host labels that queries may use as tags
+// in addition to host, appname, region, and ipaddress. ParseQuery returns
+// ErrUnsupported if the WHERE clause uses any other tag.
 //
-func ParseQuery(query *influxql.Query, now time.Time) (
+func ParseQuery(
+	query *influxql.Query, now time.Time, labelNames []string) (
 	parsedQueries []tsdbjson.ParsedQuery,
 	columnNameSets [][]string,
 	err error) {
-	return parseQuery(query, now)
+	return parseQuery(query, now, labelNames)
 }
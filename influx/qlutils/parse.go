@@ -9,10 +9,9 @@ import (
 )
 
 const (
-	kInfluxHost      = "host"
-	kInfluxAppName   = "appname"
-	kInfluxRegion    = "region"
-	kInfluxIpAddress = "ipaddress"
+	// influx name of the tsdbjson.HostName tag. All other tags have the
+	// same name in influx.
+	kInfluxHost = "host"
 )
 
 type tsdbAggSpecType struct {
@@ -30,13 +29,15 @@ var (
 	}
 )
 
-func parseQuery(query *influxql.Query, now time.Time) (
+func parseQuery(
+	query *influxql.Query, now time.Time, labelNames []string) (
 	[]tsdbjson.ParsedQuery, [][]string, error) {
 	result := make([]tsdbjson.ParsedQuery, len(query.Statements))
 	colNames := make([][]string, len(query.Statements))
 	for i := range result {
 		var err error
-		result[i], colNames[i], err = parseStatement(query.Statements[i], now)
+		result[i], colNames[i], err = parseStatement(
+			query.Statements[i], now, labelNames)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -100,7 +101,8 @@ func aggregationType(stmt influxql.Statement) (lowerCase string, err error) {
 	return strings.ToLower(call.Name), nil
 }
 
-func parseStatement(stmt influxql.Statement, currentTime time.Time) (
+func parseStatement(
+	stmt influxql.Statement, currentTime time.Time, labelNames []string) (
 	result tsdbjson.ParsedQuery, colNames []string, err error) {
 	sel, ok := stmt.(*influxql.SelectStatement)
 	if !ok {
@@ -199,13 +201,14 @@ func parseStatement(stmt influxql.Statement, currentTime time.Time) (
 		return
 	}
 
-	err = parseWhereClause(sel.Condition, &result.Options)
+	err = parseWhereClause(sel.Condition, labelNames, &result.Options)
 	if err != nil {
 		return
 	}
 
 	var dur time.Duration
-	dur, err = parseGroupByClause(sel.Dimensions, &result.Options)
+	dur, err = parseGroupByClause(
+		sel.Dimensions, labelNames, &result.Options)
 	if err != nil {
 		return
 	}
@@ -223,40 +226,58 @@ func parseStatement(stmt influxql.Statement, currentTime time.Time) (
 	return
 }
 
+// tagName returns the tsdbjson tag name of an influx tag.
+func tagName(influxName string) string {
+	if influxName == kInfluxHost {
+		return tsdbjson.HostName
+	}
+	return influxName
+}
+
 func parseGroupByClause(
 	dimensions influxql.Dimensions,
+	labelNames []string,
 	options *tsdbjson.ParsedQueryOptions) (time.Duration, error) {
 	dur, tags := dimensions.Normalize()
 	for _, tag := range tags {
-		switch tag {
-		case kInfluxHost:
-			options.GroupByHostName = true
-		case kInfluxAppName:
-			options.GroupByAppName = true
-		case kInfluxRegion:
-			options.GroupByRegion = true
-		case kInfluxIpAddress:
-			options.GroupByIpAddress = true
+		name := tagName(tag)
+		// Like influx, ignore grouping by tags that don't exist
+		if !tsdbjson.IsTagk(name, labelNames) {
+			continue
 		}
+		options.GroupBy = appendIfMissing(options.GroupBy, name)
 	}
 	return dur, nil
 }
 
+func appendIfMissing(names []string, name string) []string {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
 func parseWhereClause(
-	whereClause influxql.Expr, options *tsdbjson.ParsedQueryOptions) error {
+	whereClause influxql.Expr,
+	labelNames []string,
+	options *tsdbjson.ParsedQueryOptions) error {
 	switch expr := whereClause.(type) {
 	case *influxql.BinaryExpr:
 		switch expr.Op {
 		case influxql.AND:
-			if err := parseWhereClause(expr.LHS, options); err != nil {
+			if err := parseWhereClause(
+				expr.LHS, labelNames, options); err != nil {
 				return err
 			}
-			if err := parseWhereClause(expr.RHS, options); err != nil {
+			if err := parseWhereClause(
+				expr.RHS, labelNames, options); err != nil {
 				return err
 			}
 			return nil
 		default:
-			return parseWCSingle(expr, options)
+			return parseWCSingle(expr, labelNames, options)
 		}
 	default:
 		return ErrUnsupported
@@ -264,17 +285,21 @@ func parseWhereClause(
 }
 
 func parseWCSingle(
-	single *influxql.BinaryExpr, options *tsdbjson.ParsedQueryOptions) error {
+	single *influxql.BinaryExpr,
+	labelNames []string,
+	options *tsdbjson.ParsedQueryOptions) error {
 	switch single.Op {
 	case influxql.EQ:
-		return parseWCEqual(single, options)
+		return parseWCEqual(single, labelNames, options)
 	default:
 		return parseWCOther(single)
 	}
 }
 
 func parseWCEqual(
-	single *influxql.BinaryExpr, options *tsdbjson.ParsedQueryOptions) error {
+	single *influxql.BinaryExpr,
+	labelNames []string,
+	options *tsdbjson.ParsedQueryOptions) error {
 	vref, ok := single.LHS.(*influxql.VarRef)
 	if !ok {
 		return ErrUnsupported
@@ -283,47 +308,18 @@ func parseWCEqual(
 	if !ok {
 		return ErrUnsupported
 	}
-	switch vref.Val {
-	case kInfluxHost:
-		if options.HostNameFilter != nil {
-			return ErrUnsupported
-		}
-		options.HostNameFilter = &tsdbjson.FilterSpec{
-			Type:  "literal_or",
-			Value: lit.Val,
-		}
-		return nil
-	case kInfluxAppName:
-		if options.AppNameFilter != nil {
-			return ErrUnsupported
-		}
-		options.AppNameFilter = &tsdbjson.FilterSpec{
-			Type:  "literal_or",
-			Value: lit.Val,
-		}
-		return nil
-	case kInfluxRegion:
-		if options.RegionFilter != nil {
-			return ErrUnsupported
-		}
-		options.RegionFilter = &tsdbjson.FilterSpec{
-			Type:  "literal_or",
-			Value: lit.Val,
-		}
-		return nil
-	case kInfluxIpAddress:
-		if options.IpAddressFilter != nil {
-			return ErrUnsupported
-		}
-		options.IpAddressFilter = &tsdbjson.FilterSpec{
-			Type:  "literal_or",
-			Value: lit.Val,
-		}
-		return nil
-	default:
+	name := tagName(vref.Val)
+	if !tsdbjson.IsTagk(name, labelNames) || options.Filters[name] != nil {
 		return ErrUnsupported
-
 	}
+	if options.Filters == nil {
+		options.Filters = make(map[string]*tsdbjson.FilterSpec)
+	}
+	options.Filters[name] = &tsdbjson.FilterSpec{
+		Type:  "literal_or",
+		Value: lit.Val,
+	}
+	return nil
 }
 
 func parseWCOther(single *influxql.BinaryExpr) error {
@@ -10,7 +10,8 @@ import (
 )
 
 var (
-	kNow = time.Date(2016, 12, 1, 0, 1, 0, 0, time.UTC)
+	kNow        = time.Date(2016, 12, 1, 0, 1, 0, 0, time.UTC)
+	kLabelNames = []string{"env", "owner"}
 )
 
 func TestAggregationType(t *testing.T) {
@@ -48,7 +49,7 @@ func TestConvert(t *testing.T) {
 		origQueryString := query.String()
 
 		Convey("Conversion should succeed", func() {
-			pq, colNames, err := qlutils.ParseQuery(query, now)
+			pq, colNames, err := qlutils.ParseQuery(query, now, kLabelNames)
 			So(err, ShouldBeNil)
 			So(pq, ShouldHaveLength, 1)
 			So(
@@ -83,7 +84,7 @@ func TestConvert(t *testing.T) {
 		origQueryString := query.String()
 
 		Convey("Conversion should succeed", func() {
-			pq, colNames, err := qlutils.ParseQuery(query, now)
+			pq, colNames, err := qlutils.ParseQuery(query, now, kLabelNames)
 			So(err, ShouldBeNil)
 			So(pq, ShouldHaveLength, 1)
 			So(
@@ -101,21 +102,23 @@ func TestConvert(t *testing.T) {
 					Start: duration.TimeToFloat(now) - 3600.0,
 					End:   duration.TimeToFloat(now),
 					Options: tsdbjson.ParsedQueryOptions{
-						HostNameFilter: &tsdbjson.FilterSpec{
-							Type:  "literal_or",
-							Value: "some-host",
-						},
-						AppNameFilter: &tsdbjson.FilterSpec{
-							Type:  "literal_or",
-							Value: "some-app",
-						},
-						RegionFilter: &tsdbjson.FilterSpec{
-							Type:  "literal_or",
-							Value: "some-region",
-						},
-						IpAddressFilter: &tsdbjson.FilterSpec{
-							Type:  "literal_or",
-							Value: "some-ip",
+						Filters: map[string]*tsdbjson.FilterSpec{
+							tsdbjson.HostName: {
+								Type:  "literal_or",
+								Value: "some-host",
+							},
+							tsdbjson.AppName: {
+								Type:  "literal_or",
+								Value: "some-app",
+							},
+							tsdbjson.Region: {
+								Type:  "literal_or",
+								Value: "some-region",
+							},
+							tsdbjson.IpAddress: {
+								Type:  "literal_or",
+								Value: "some-ip",
+							},
 						},
 					},
 				},
@@ -136,7 +139,7 @@ func TestConvert(t *testing.T) {
 		origQueryString := query.String()
 
 		Convey("Conversion should succeed", func() {
-			pq, colNames, err := qlutils.ParseQuery(query, now)
+			pq, colNames, err := qlutils.ParseQuery(query, now, kLabelNames)
 			So(err, ShouldBeNil)
 			So(pq, ShouldHaveLength, 1)
 			So(
@@ -154,10 +157,12 @@ func TestConvert(t *testing.T) {
 					Start: duration.TimeToFloat(now) - 1800.0,
 					End:   duration.TimeToFloat(now),
 					Options: tsdbjson.ParsedQueryOptions{
-						GroupByHostName:  true,
-						GroupByAppName:   true,
-						GroupByRegion:    true,
-						GroupByIpAddress: true,
+						GroupBy: []string{
+							tsdbjson.AppName,
+							tsdbjson.HostName,
+							tsdbjson.IpAddress,
+							tsdbjson.Region,
+						},
 					},
 				},
 			)
@@ -169,6 +174,28 @@ func TestConvert(t *testing.T) {
 
 	})
 
+	Convey("With host label query", t, func() {
+
+		ql := "select max(value) from \"/d/metric\" WHERE owner = 'alice' and time > now() - 1h group by env, appname, owner, bogus, time(10m)"
+		query, err := qlutils.NewQuery(ql, now)
+		So(err, ShouldBeNil)
+
+		Convey("Labels become label filters and groupings; unknown tags are ignored", func() {
+			pq, _, err := qlutils.ParseQuery(query, now, kLabelNames)
+			So(err, ShouldBeNil)
+			So(pq, ShouldHaveLength, 1)
+			So(pq[0].Options, ShouldResemble, tsdbjson.ParsedQueryOptions{
+				Filters: map[string]*tsdbjson.FilterSpec{
+					"owner": {
+						Type:  "literal_or",
+						Value: "alice",
+					},
+				},
+				GroupBy: []string{tsdbjson.AppName, "env", "owner"},
+			})
+		})
+	})
+
 	Convey("With all caps aggregator query", t, func() {
 
 		ql := "SELECT SUM(value) FROM \"/c/metric\" WHERE time > now() - 30m group by host, appname, region, ipaddress, time(6m); SELECT MEAN(value) FROM \"/c/metric\" WHERE time > now() - 45m group by host, time(9m)"
@@ -176,7 +203,7 @@ func TestConvert(t *testing.T) {
 		So(err, ShouldBeNil)
 
 		Convey("aggregators not case sensitive", func() {
-			pq, _, err := qlutils.ParseQuery(query, now)
+			pq, _, err := qlutils.ParseQuery(query, now, kLabelNames)
 			So(err, ShouldBeNil)
 			So(pq, ShouldHaveLength, 2)
 			So(pq[0].Aggregator.Type, ShouldEqual, "sum")
@@ -192,6 +219,9 @@ func TestConvert(t *testing.T) {
 		checkUnsupported("select mean(value) from \"a/metric\" WHERE time > now() - 2h group by time(10m) limit 5")
 		checkUnsupported("select mean(value) from \"a/metric\" WHERE time > now() - 2h group by time(10m) order by time asc")
 		checkUnsupported("select mean(value) from \"a/metric\" WHERE time > '1968-08-28T23:00:01.232000000Z' group by time(10m)")
+		checkUnsupported("select mean(value) from \"a/metric\" WHERE time = '2016-11-30T23:01:00Z' group by time(10m)")
+		checkUnsupported("select mean(value) from \"a/metric\" WHERE owner = 'a' and owner = 'b' and time > now() - 1h group by time(10m)")
+		checkUnsupported("select mean(value) from \"a/metric\" WHERE bogus = 'a' and time > now() - 1h group by time(10m)")
 	})
 }
 
@@ -199,7 +229,7 @@ func checkUnsupported(q string) {
 	Convey(q, func() {
 		query, err := qlutils.NewQuery(q, kNow)
 		So(err, ShouldBeNil)
-		_, _, err = qlutils.ParseQuery(query, kNow)
+		_, _, err = qlutils.ParseQuery(query, kNow, kLabelNames)
 		So(err, ShouldEqual, qlutils.ErrUnsupported)
 	})
 }
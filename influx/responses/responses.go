@@ -17,10 +17,9 @@ import (
 )
 
 const (
-	kInfluxHostName  = "host"
-	kInfluxAppName   = "appname"
-	kInfluxRegion    = "region"
-	kInfluxIpAddress = "ipaddress"
+	// influx name of the tsdb.HostName tag. All other tags have the same
+	// name in influx.
+	kInfluxHostName = "host"
 )
 
 var (
@@ -97,17 +96,12 @@ func fromTaggedTimeSeriesSet(
 	result.Series = make([]models.Row, len(seriesSet.Data))
 	for i, series := range seriesSet.Data {
 		tags := make(map[string]string)
-		if seriesSet.GroupedByHostName {
-			tags[kInfluxHostName] = series.Tags.HostName
-		}
-		if seriesSet.GroupedByAppName {
-			tags[kInfluxAppName] = series.Tags.AppName
-		}
-		if seriesSet.GroupedByRegion {
-			tags[kInfluxRegion] = series.Tags.Region
-		}
-		if seriesSet.GroupedByIpAddress {
-			tags[kInfluxIpAddress] = series.Tags.IpAddress
+		for _, name := range seriesSet.GroupedBy {
+			if name == tsdb.HostName {
+				tags[kInfluxHostName] = series.Tags[name]
+			} else {
+				tags[name] = series.Tags[name]
+			}
 		}
 		downSample := pq.Aggregator.DownSample
 		var values [][]interface{}
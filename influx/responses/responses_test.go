@@ -232,31 +232,30 @@ func TestFromTaggedTimeSeriesSets(t *testing.T) {
 				Data: []tsdb.TaggedTimeSeries{
 					{
 						Tags: tsdb.TagSet{
-							HostName: "host1",
-							AppName:  "app1",
+							tsdb.HostName: "host1",
+							tsdb.AppName:  "app1",
 						},
 					},
 					{
 						Tags: tsdb.TagSet{
-							HostName: "host2",
-							AppName:  "app1",
+							tsdb.HostName: "host2",
+							tsdb.AppName:  "app1",
 						},
 					},
 					{
 						Tags: tsdb.TagSet{
-							HostName: "host1",
-							AppName:  "app2",
+							tsdb.HostName: "host1",
+							tsdb.AppName:  "app2",
 						},
 					},
 					{
 						Tags: tsdb.TagSet{
-							HostName: "host2",
-							AppName:  "app2",
+							tsdb.HostName: "host2",
+							tsdb.AppName:  "app2",
 						},
 					},
 				},
-				GroupedByHostName: true,
-				GroupedByAppName:  true,
+				GroupedBy: []string{tsdb.HostName, tsdb.AppName},
 			}
 			result := client.Result{
 				Series: []models.Row{
@@ -335,6 +334,39 @@ func TestFromTaggedTimeSeriesSets(t *testing.T) {
 				So(response, ShouldResemble, expected)
 			})
 		})
+		Convey("Given a series set grouped by host label", func() {
+			taggedTimeSeriesSet := &tsdb.TaggedTimeSeriesSet{
+				MetricName: "/my/labelMetric",
+				Data: []tsdb.TaggedTimeSeries{
+					{
+						Tags: tsdb.TagSet{
+							tsdb.Region: "us-east-1",
+							"owner":     "bob",
+						},
+						Values: tsdb.TimeSeries{{3000.0, 30.0}},
+					},
+					{
+						Tags: tsdb.TagSet{
+							tsdb.Region: "us-east-1",
+							"owner":     "alice",
+						},
+						Values: tsdb.TimeSeries{{3500.0, 35.0}},
+					},
+				},
+				GroupedBy: []string{tsdb.Region, "owner"},
+			}
+			response := responses.FromTaggedTimeSeriesSets(
+				[]*tsdb.TaggedTimeSeriesSet{taggedTimeSeriesSet},
+				[][]string{{"time", "max"}},
+				[]tsdbjson.ParsedQuery{pq},
+				epochConverter)
+			series := response.Results[0].Series
+			So(series, ShouldHaveLength, 2)
+			So(series[0].Tags, ShouldResemble, map[string]string{
+				"region": "us-east-1", "owner": "alice"})
+			So(series[1].Tags, ShouldResemble, map[string]string{
+				"region": "us-east-1", "owner": "bob"})
+		})
 	})
 }
 
@@ -0,0 +1,72 @@
+// Package pollsched keeps track of when each endpoint is next due to be
+// polled when endpoints are polled at different intervals.
+package pollsched
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Scheduler tracks the next due time of each key. Keys are typically
+// endpoints. Scheduler instances are not safe to use with multiple
+// goroutines.
+type Scheduler struct {
+	byKey      map[interface{}]*entryType
+	generation uint64
+	// nil if not staggered
+	name   func(key interface{}) string
+	jitter float64
+	rand   *rand.Rand
+}
+
+// New returns a new Scheduler with no keys.
+func New() *Scheduler {
+	return &Scheduler{byKey: make(map[interface{}]*entryType)}
+}
+
+// NewStaggered returns a new Scheduler that spreads keys evenly over their
+// intervals instead of making new keys due right away. The due times of
+// each key are a stable offset from the multiples of its interval since
+// the Unix epoch so that a key is due at regular times even across
+// restarts. The offset comes from hashing what name returns for the key.
+// jitter, between 0 and 1, delays each due time by a random amount up to
+// jitter times the interval. The delay does not carry over to later due
+// times.
+func NewStaggered(
+	name func(key interface{}) string, jitter float64) *Scheduler {
+	return &Scheduler{
+		byKey:  make(map[interface{}]*entryType),
+		name:   name,
+		jitter: jitter,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// IsDue returns true if key is due at time now. If key is due, IsDue
+// schedules the next due time of key interval later. A key that
+// IsDue has not seen before is due right away unless this instance is
+// staggered. If interval changes for a key, the new interval takes effect
+// after key is next due.
+// Keys must support equality.
+func (s *Scheduler) IsDue(
+	key interface{}, now time.Time, interval time.Duration) bool {
+	return s.isDue(key, now, interval)
+}
+
+// Prune forgets the keys that IsDue has not seen since the previous call
+// to Prune. Call Prune after calling IsDue on every current key so that
+// keys that go away don't accumulate.
+func (s *Scheduler) Prune() {
+	s.prune()
+}
+
+// NextDue returns the earliest time that any key is due. NextDue returns
+// false if there are no keys.
+func (s *Scheduler) NextDue() (time.Time, bool) {
+	return s.nextDue()
+}
+
+// Len returns the number of keys.
+func (s *Scheduler) Len() int {
+	return len(s.byKey)
+}
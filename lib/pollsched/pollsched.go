@@ -0,0 +1,117 @@
+package pollsched
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+type entryType struct {
+	// When the key is due without jitter
+	slot time.Time
+	// When the key is due
+	due time.Time
+	// The generation when IsDue last saw this entry
+	seen uint64
+	// The interval that offset is for
+	interval time.Duration
+	// The offset of the due times within interval if staggered
+	offset time.Duration
+}
+
+// offset returns the stable offset of key within interval.
+func (s *Scheduler) offset(
+	key interface{}, interval time.Duration) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(s.name(key)))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// slotAfter returns the first time after t that is offset from a multiple
+// of interval since the Unix epoch.
+func slotAfter(t time.Time, interval, offset time.Duration) time.Time {
+	sinceEpoch := time.Duration(t.UnixNano()) - offset
+	next := sinceEpoch - sinceEpoch%interval + interval
+	return time.Unix(0, int64(next+offset)).In(t.Location())
+}
+
+func (s *Scheduler) withJitter(
+	slot time.Time, interval time.Duration) time.Time {
+	if s.jitter <= 0 {
+		return slot
+	}
+	return slot.Add(
+		time.Duration(s.rand.Float64() * s.jitter * float64(interval)))
+}
+
+// setInterval sets the interval of entry for key hashing the name of key
+// only if the interval changed.
+func (s *Scheduler) setInterval(
+	entry *entryType, key interface{}, interval time.Duration) {
+	if entry.interval == interval {
+		return
+	}
+	entry.interval = interval
+	if s.name != nil {
+		entry.offset = s.offset(key, interval)
+	}
+}
+
+// nextSlot returns the slot for entry that comes after its current slot.
+// If that slot is not after now, nextSlot returns the first slot after now.
+func (s *Scheduler) nextSlot(entry *entryType, now time.Time) time.Time {
+	if s.name == nil {
+		// Keep a regular cadence unless we have fallen behind by more
+		// than an entire interval.
+		if next := entry.slot.Add(entry.interval); next.After(now) {
+			return next
+		}
+		return now.Add(entry.interval)
+	}
+	if next := slotAfter(
+		entry.slot, entry.interval, entry.offset); next.After(now) {
+		return next
+	}
+	return slotAfter(now, entry.interval, entry.offset)
+}
+
+func (s *Scheduler) isDue(
+	key interface{}, now time.Time, interval time.Duration) bool {
+	entry := s.byKey[key]
+	if entry == nil {
+		entry = &entryType{}
+		s.setInterval(entry, key, interval)
+		entry.slot = now
+		if s.name != nil {
+			entry.slot = slotAfter(now.Add(-1), interval, entry.offset)
+		}
+		entry.due = s.withJitter(entry.slot, interval)
+		s.byKey[key] = entry
+	}
+	entry.seen = s.generation
+	if now.Before(entry.due) {
+		return false
+	}
+	s.setInterval(entry, key, interval)
+	entry.slot = s.nextSlot(entry, now)
+	entry.due = s.withJitter(entry.slot, interval)
+	return true
+}
+
+func (s *Scheduler) prune() {
+	for key, entry := range s.byKey {
+		if entry.seen != s.generation {
+			delete(s.byKey, key)
+		}
+	}
+	s.generation++
+}
+
+func (s *Scheduler) nextDue() (result time.Time, ok bool) {
+	for _, entry := range s.byKey {
+		if !ok || entry.due.Before(result) {
+			result = entry.due
+			ok = true
+		}
+	}
+	return
+}
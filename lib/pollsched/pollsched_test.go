@@ -0,0 +1,137 @@
+package pollsched_test
+
+import (
+	"fmt"
+	"github.com/Symantec/scotty/lib/pollsched"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	Convey("With new scheduler", t, func() {
+		sched := pollsched.New()
+		start := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+
+		Convey("New keys are due right away", func() {
+			So(sched.IsDue("fast", start, 30*time.Second), ShouldBeTrue)
+			So(sched.IsDue("slow", start, 5*time.Minute), ShouldBeTrue)
+			next, ok := sched.NextDue()
+			So(ok, ShouldBeTrue)
+			So(next, ShouldResemble, start.Add(30*time.Second))
+
+			Convey("Keys are due at their own intervals", func() {
+				now := start.Add(30 * time.Second)
+				So(sched.IsDue("fast", now, 30*time.Second), ShouldBeTrue)
+				So(sched.IsDue("slow", now, 5*time.Minute), ShouldBeFalse)
+				now = start.Add(5 * time.Minute)
+				So(sched.IsDue("slow", now, 5*time.Minute), ShouldBeTrue)
+				next, _ := sched.NextDue()
+				So(next, ShouldResemble, start.Add(time.Minute))
+			})
+
+			Convey("Polling a little late keeps the cadence", func() {
+				now := start.Add(31 * time.Second)
+				So(sched.IsDue("fast", now, 30*time.Second), ShouldBeTrue)
+				So(sched.IsDue("fast", start.Add(59*time.Second), 30*time.Second), ShouldBeFalse)
+				So(sched.IsDue("fast", start.Add(60*time.Second), 30*time.Second), ShouldBeTrue)
+			})
+
+			Convey("Polling very late starts a new cadence", func() {
+				now := start.Add(100 * time.Second)
+				So(sched.IsDue("fast", now, 30*time.Second), ShouldBeTrue)
+				So(sched.IsDue("fast", start.Add(120*time.Second), 30*time.Second), ShouldBeFalse)
+				So(sched.IsDue("fast", start.Add(130*time.Second), 30*time.Second), ShouldBeTrue)
+			})
+
+			Convey("Prune forgets keys not seen", func() {
+				sched.Prune()
+				sched.IsDue("fast", start.Add(time.Second), 30*time.Second)
+				sched.Prune()
+				So(sched.Len(), ShouldEqual, 1)
+				next, _ := sched.NextDue()
+				So(next, ShouldResemble, start.Add(30*time.Second))
+			})
+		})
+
+		Convey("NextDue returns false with no keys", func() {
+			_, ok := sched.NextDue()
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func keyName(key interface{}) string {
+	return key.(string)
+}
+
+// firstDue returns when key is first due in sched.
+func firstDue(
+	sched *pollsched.Scheduler,
+	key string,
+	now time.Time,
+	interval time.Duration) time.Time {
+	sched.IsDue(key, now, interval)
+	result, _ := sched.NextDue()
+	return result
+}
+
+func TestStaggeredScheduler(t *testing.T) {
+	Convey("With staggered scheduler", t, func() {
+		sched := pollsched.NewStaggered(keyName, 0.0)
+		start := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+		interval := time.Minute
+
+		Convey("New keys are due within one interval", func() {
+			due := firstDue(sched, "host1:app", start, interval)
+			So(due.Before(start), ShouldBeFalse)
+			So(due.Before(start.Add(interval)), ShouldBeTrue)
+			So(sched.IsDue("host1:app", due.Add(-time.Millisecond), interval), ShouldBeFalse)
+			So(sched.IsDue("host1:app", due, interval), ShouldBeTrue)
+
+			Convey("Due times are regular even if polled late", func() {
+				next, _ := sched.NextDue()
+				So(next, ShouldResemble, due.Add(interval))
+				So(sched.IsDue("host1:app", next.Add(5*time.Second), interval), ShouldBeTrue)
+				next, _ = sched.NextDue()
+				So(next, ShouldResemble, due.Add(2*interval))
+				So(sched.IsDue("host1:app", due.Add(5*interval+time.Second), interval), ShouldBeTrue)
+				next, _ = sched.NextDue()
+				So(next, ShouldResemble, due.Add(6*interval))
+			})
+
+			Convey("Due times are stable across instances", func() {
+				another := pollsched.NewStaggered(keyName, 0.0)
+				So(
+					firstDue(another, "host1:app", start.Add(30*time.Minute), interval),
+					ShouldResemble,
+					due.Add(30*time.Minute))
+			})
+		})
+
+		Convey("Keys are spread over the interval", func() {
+			var buckets [6]int
+			for i := 0; i < 120; i++ {
+				single := pollsched.NewStaggered(keyName, 0.0)
+				due := firstDue(single, fmt.Sprintf("host%d:app", i), start, interval)
+				buckets[due.Sub(start)/(10*time.Second)]++
+			}
+			for i := range buckets {
+				So(buckets[i], ShouldBeGreaterThan, 0)
+			}
+		})
+
+		Convey("Jitter delays due times without shifting later ones", func() {
+			jittered := pollsched.NewStaggered(keyName, 0.1)
+			slot := firstDue(sched, "host2:app", start, interval)
+			for i := 0; i < 20; i++ {
+				due := firstDue(jittered, "host2:app", start, interval)
+				So(due.Before(slot), ShouldBeFalse)
+				So(due.Before(slot.Add(6*time.Second)), ShouldBeTrue)
+				So(jittered.IsDue("host2:app", due, interval), ShouldBeTrue)
+				slot = slot.Add(interval)
+				start = due
+			}
+		})
+	})
+}
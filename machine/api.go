@@ -6,8 +6,10 @@ import (
 	"github.com/Symantec/scotty"
 	"github.com/Symantec/scotty/application"
 	"github.com/Symantec/scotty/awsinfo"
+	"github.com/Symantec/scotty/hostlabels"
 	"github.com/Symantec/scotty/namesandports"
 	"github.com/Symantec/scotty/store"
+	"io"
 	"sort"
 	"sync"
 	"time"
@@ -55,6 +57,19 @@ func (e *EndpointObservations) MaybeAddApp(hostName, appName string, port uint)
 	e.maybeAddApp(hostName, appName, port)
 }
 
+// MergeEndpointObservations merges endpoint observations from several
+// sources such as the health agents and a static inventory. For each host,
+// the merged observation includes the applications from every source.
+// If more than one source reports an application with the same name, the
+// source listed last wins. The sequence number of the merged observation
+// is the sum of the sequence numbers from each source so that it increases
+// whenever any source observes the host again. Therefore, each source
+// must keep reporting every host it has ever reported.
+func MergeEndpointObservations(
+	observations ...map[string]EndpointObservation) map[string]EndpointObservation {
+	return mergeEndpointObservations(observations)
+}
+
 // Machine represents a single machine
 type Machine struct {
 
@@ -72,6 +87,10 @@ type Machine struct {
 
 	// Aws information for machine. nil if no aws information available.
 	Aws *awsinfo.AwsInfo
+
+	// Host labels from mdb such as {"owner": "alice"}. nil if none.
+	// Clients are to treat Labels as immutable.
+	Labels map[string]string
 }
 
 // AccountId is a convenience routine that returns the aws account id.
@@ -133,6 +152,7 @@ func (e *Endpoint) Active() bool {
 // that stores the metrics for each endpoint.
 type EndpointStore struct {
 	config            awsinfo.Config
+	hostLabels        hostlabels.Config
 	countToInactivate int
 
 	// grab this lock when changing machines or applications.
@@ -141,6 +161,10 @@ type EndpointStore struct {
 	mu               sync.Mutex
 	astore           *store.Store
 	byHost           map[string]*machineDataType
+	hostByIpAddress  map[string]string
+	checkpoint       *store.Checkpoint
+	walReplay        *store.WALReplay
+	connectors       application.Connectors
 }
 
 // NewEndpointStore returns a new EndpointStore.
@@ -180,11 +204,68 @@ func (e *EndpointStore) LogChangedMetricCount(
 	e.logChangedMetricCount(ep, metricCount)
 }
 
+// LogPush logs that an application pushed metrics with given timestamp
+// to scotty.
+func (e *EndpointStore) LogPush(ep *scotty.Endpoint, timestamp time.Time) {
+	e.logPush(ep, timestamp)
+}
+
+// SetCheckpoint tells this instance to restore the metrics of each endpoint
+// from checkpoint as that endpoint becomes known. Caller should call
+// SetCheckpoint before the first call to UpdateMachines.
+func (e *EndpointStore) SetCheckpoint(checkpoint *store.Checkpoint) {
+	e.setCheckpoint(checkpoint)
+}
+
+// SetHostLabels tells this instance which mdb fields become the Labels of
+// each machine. Caller should call SetHostLabels before the first call to
+// UpdateMachines.
+func (e *EndpointStore) SetHostLabels(config hostlabels.Config) {
+	e.setHostLabels(config)
+}
+
+// SetConnectors tells this instance which connectors read the metrics of
+// applications by protocol. See application.Connectors. Applications
+// already known keep their connector; only applications reported after
+// SetConnectors returns use the new connectors.
+func (e *EndpointStore) SetConnectors(connectors application.Connectors) {
+	e.setConnectors(connectors)
+}
+
+// SetWAL tells this instance to log each batch of metrics to wal and to
+// replay the batches in replay onto each endpoint as that endpoint becomes
+// known. replay may be nil. Batches are replayed after the checkpoint from
+// SetCheckpoint is restored. Caller should call SetWAL before the first
+// call to UpdateMachines.
+func (e *EndpointStore) SetWAL(wal *store.WAL, replay *store.WALReplay) {
+	e.setWAL(wal, replay)
+}
+
+// WriteCheckpoint writes a checkpoint of the metrics of all endpoints to w.
+// The checkpoint can later be read with store.ReadCheckpoint and passed to
+// SetCheckpoint.
+func (e *EndpointStore) WriteCheckpoint(w io.Writer) error {
+	return e.writeCheckpoint(w)
+}
+
 // UpdateMachines updates the available machines.
 func (e *EndpointStore) UpdateMachines(
 	timestamp float64,
 	activeHosts []mdb.Machine) {
-	e.updateMachines(timestamp, activeHosts)
+	e.updateMachines(timestamp, activeHosts, nil)
+}
+
+// UpdateMachinesWithoutHealthAgents works like UpdateMachines except that
+// scotty does not poll the health agent on hosts whose names are in
+// withoutHealthAgent. Applications on those hosts are known only from
+// UpdateEndpoints. withoutHealthAgent affects only hosts that don't
+// already have a health agent; UpdateMachinesWithoutHealthAgents never
+// removes the health agent from a host.
+func (e *EndpointStore) UpdateMachinesWithoutHealthAgents(
+	timestamp float64,
+	activeHosts []mdb.Machine,
+	withoutHealthAgent map[string]bool) {
+	e.updateMachines(timestamp, activeHosts, withoutHealthAgent)
 }
 
 // AllWithStore returns all endpoints along with the metric store
@@ -211,6 +292,56 @@ func (e *EndpointStore) ByHostAndName(host, name string) (
 	return e.byHostAndName(host, name)
 }
 
+// PushEndpoint returns the endpoint for the application with given name on
+// given host that pushes its metrics to scotty along with the current
+// metric store. If the endpoint doesn't exist yet, PushEndpoint creates
+// it and registers it with the metric store. If scotty doesn't know about
+// the host yet, PushEndpoint adds it without a health agent. A host added
+// this way stays active even though it is missing from mdb until
+// RemoveStalePushMachines removes it.
+//
+// If the endpoint is inactive because its application stopped pushing
+// metrics, PushEndpoint marks it active again.
+//
+// If scotty polls an application with the same name on the same host,
+// PushEndpoint returns nil for the endpoint.
+func (e *EndpointStore) PushEndpoint(hostName, appName string) (
+	*Endpoint, *store.Store) {
+	return e.pushEndpoint(hostName, appName)
+}
+
+// MarkStalePushEndpoints marks the endpoints of applications that push
+// their metrics inactive if those applications last pushed metrics before
+// lastPushedBefore. timestamp is the time in seconds since the epoch of
+// marking the endpoints inactive. Applications report pushing metrics
+// with LogPush.
+func (e *EndpointStore) MarkStalePushEndpoints(
+	timestamp float64, lastPushedBefore time.Time) {
+	e.markStalePushEndpoints(timestamp, lastPushedBefore)
+}
+
+// RemoveStalePushMachines removes the machines that scotty knows about
+// only because applications on them push their metrics if none of those
+// applications pushed metrics since lastPushedBefore.
+// RemoveStalePushMachines marks the endpoints of removed machines inactive
+// and unregisters them from the metric store. timestamp is the time in
+// seconds since the epoch of marking the endpoints inactive.
+func (e *EndpointStore) RemoveStalePushMachines(
+	timestamp float64, lastPushedBefore time.Time) {
+	e.removeStalePushMachines(timestamp, lastPushedBefore)
+}
+
+// HasMdbHost returns true if mdb lists a host with given name.
+func (e *EndpointStore) HasMdbHost(hostName string) bool {
+	return e.hasMdbHost(hostName)
+}
+
+// HostNameByIpAddress returns the name of the host in mdb with given IP
+// address or the empty string if there is no such host.
+func (e *EndpointStore) HostNameByIpAddress(ipAddress string) string {
+	return e.hostNameByIpAddress(ipAddress)
+}
+
 // UpdateEndpints tells this instance of all the applications running on
 // al the hosts. endpoints are all the applications running keyed by hostname.
 // If the sequence number for a given host hasn't changed since the last call
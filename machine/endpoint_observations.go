@@ -39,3 +39,31 @@ func (e *EndpointObservations) maybeAddApp(
 		e.data[hostName] = eo
 	}
 }
+
+func mergeEndpointObservations(
+	observations []map[string]EndpointObservation) map[string]EndpointObservation {
+	if len(observations) == 1 {
+		return observations[0]
+	}
+	result := make(map[string]EndpointObservation)
+	for _, observation := range observations {
+		for hostName, eo := range observation {
+			merged, ok := result[hostName]
+			if !ok {
+				result[hostName] = eo
+				continue
+			}
+			endpoints := merged.Endpoints.Copy()
+			for name, record := range eo.Endpoints {
+				if endpoints == nil {
+					endpoints = make(namesandports.NamesAndPorts)
+				}
+				endpoints[name] = record
+			}
+			merged.SeqNo += eo.SeqNo
+			merged.Endpoints = endpoints
+			result[hostName] = merged
+		}
+	}
+	return result
+}
@@ -5,7 +5,9 @@ import (
 	"github.com/Symantec/scotty"
 	"github.com/Symantec/scotty/application"
 	"github.com/Symantec/scotty/hostid"
+	"github.com/Symantec/scotty/hostlabels"
 	"github.com/Symantec/scotty/store"
+	"io"
 	"time"
 )
 
@@ -13,14 +15,20 @@ type machineDataType struct {
 	M     Machine
 	Group *application.Group
 	SeqNo uint64
+	// True if scotty knows about this machine only because applications
+	// on it push their metrics.
+	PushOnly bool
 }
 
 func (e *EndpointStore) updateMachines(
 	timestamp float64,
-	activeHosts []mdb.Machine) {
+	activeHosts []mdb.Machine,
+	withoutHealthAgent map[string]bool) {
 	e.statusChangeLock.Lock()
 	defer e.statusChangeLock.Unlock()
-	active, inactive, astore := e._updateMachines(activeHosts)
+	active, inactive, registered, astore := e._updateMachines(
+		activeHosts, withoutHealthAgent)
+	e.restore(astore, registered)
 	for _, ep := range active {
 		astore.MarkEndpointActive(ep)
 	}
@@ -33,7 +41,8 @@ func (e *EndpointStore) updateEndpoints(
 	timestamp float64, endpoints map[string]EndpointObservation) {
 	e.statusChangeLock.Lock()
 	defer e.statusChangeLock.Unlock()
-	active, inactive, astore := e._updateEndpoints(endpoints)
+	active, inactive, registered, astore := e._updateEndpoints(endpoints)
+	e.restore(astore, registered)
 	for _, ep := range active {
 		astore.MarkEndpointActive(ep)
 	}
@@ -43,14 +52,15 @@ func (e *EndpointStore) updateEndpoints(
 }
 
 func (e *EndpointStore) _updateMachines(
-	activeHosts []mdb.Machine) (
-	active, inactive []*scotty.Endpoint, astore *store.Store) {
+	activeHosts []mdb.Machine, withoutHealthAgent map[string]bool) (
+	active, inactive, registered []*scotty.Endpoint,
+	astore *store.Store) {
 	activeHostSet := newStringSet(activeHosts)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	// Mark everything that is not part of the new list as inactive
 	for _, md := range e.byHost {
-		if !activeHostSet[md.M.Host] {
+		if !md.PushOnly && !activeHostSet[md.M.Host] {
 			md.M.Active = false
 			// Mark active apps as inactive
 			for _, app := range md.Group.Applications() {
@@ -61,7 +71,11 @@ func (e *EndpointStore) _updateMachines(
 		}
 	}
 	storeCopy := e.astore
+	hostByIpAddress := make(map[string]string, len(activeHosts))
 	for _, ahost := range activeHosts {
+		if ahost.IpAddress != "" {
+			hostByIpAddress[ahost.IpAddress] = ahost.Hostname
+		}
 		lookedUpHost := e.byHost[ahost.Hostname]
 		if lookedUpHost == nil {
 			// A new machine
@@ -73,22 +87,46 @@ func (e *EndpointStore) _updateMachines(
 				m.M.Region = ahost.AwsMetadata.Region
 			}
 			m.M.IpAddress = ahost.IpAddress
-			var ep *scotty.Endpoint
-			m.Group, ep = application.NewGroup(
-				&hostid.HostID{
-					HostName:  ahost.Hostname,
-					IPAddress: ahost.IpAddress},
-				e.countToInactivate)
-			if storeCopy == e.astore {
-				storeCopy = e.astore.ShallowCopy()
+			m.M.Labels = e.hostLabels.Labels(&ahost)
+			hostId := &hostid.HostID{
+				HostName:  ahost.Hostname,
+				IPAddress: ahost.IpAddress}
+			if withoutHealthAgent[ahost.Hostname] {
+				m.Group = application.NewPushGroup(
+					hostId, e.countToInactivate)
+			} else {
+				var ep *scotty.Endpoint
+				m.Group, ep = application.NewGroup(
+					hostId, e.countToInactivate)
+				if storeCopy == e.astore {
+					storeCopy = e.astore.ShallowCopy()
+				}
+				storeCopy.RegisterEndpoint(ep)
+				registered = append(registered, ep)
 			}
-			storeCopy.RegisterEndpoint(ep)
+			m.Group.SetConnectors(e.connectors)
 			e.byHost[ahost.Hostname] = &m
 		} else {
 			lookedUpHost.M.Aws = e.config.GetAwsInfo(ahost.AwsMetadata)
 			if ahost.AwsMetadata != nil {
 				lookedUpHost.M.Region = ahost.AwsMetadata.Region
 			}
+			lookedUpHost.M.Labels = e.hostLabels.Labels(&ahost)
+			if lookedUpHost.PushOnly {
+				// mdb now knows about this machine.
+				lookedUpHost.PushOnly = false
+				lookedUpHost.M.IpAddress = ahost.IpAddress
+			}
+			if !withoutHealthAgent[ahost.Hostname] {
+				// Start polling the health agent if we aren't already.
+				if ep := lookedUpHost.Group.AddHealthAgent(); ep != nil {
+					if storeCopy == e.astore {
+						storeCopy = e.astore.ShallowCopy()
+					}
+					storeCopy.RegisterEndpoint(ep)
+					registered = append(registered, ep)
+				}
+			}
 			if !lookedUpHost.M.Active {
 				lookedUpHost.M.Active = true
 				for _, app := range lookedUpHost.Group.Applications() {
@@ -100,13 +138,137 @@ func (e *EndpointStore) _updateMachines(
 		}
 	}
 	e.astore = storeCopy
+	e.hostByIpAddress = hostByIpAddress
+	astore = e.astore
+	return
+}
+
+func (e *EndpointStore) pushEndpoint(hostName, appName string) (
+	*Endpoint, *store.Store) {
+	e.statusChangeLock.Lock()
+	defer e.statusChangeLock.Unlock()
+	result, registered, activated, astore := e._pushEndpoint(
+		hostName, appName)
+	e.restore(astore, registered)
+	if activated != nil {
+		astore.MarkEndpointActive(activated)
+	}
+	return result, astore
+}
+
+func (e *EndpointStore) _pushEndpoint(hostName, appName string) (
+	result *Endpoint,
+	registered []*scotty.Endpoint,
+	activated *scotty.Endpoint,
+	astore *store.Store) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	md := e.byHost[hostName]
+	if md == nil {
+		md = &machineDataType{PushOnly: true}
+		md.M.Host = hostName
+		md.M.Active = true
+		md.Group = application.NewPushGroup(
+			&hostid.HostID{HostName: hostName}, e.countToInactivate)
+		md.Group.SetConnectors(e.connectors)
+		e.byHost[hostName] = md
+	}
+	ep, isNew, isActivated := md.Group.AddPushApplication(appName)
+	if ep == nil {
+		return nil, nil, nil, e.astore
+	}
+	if isNew {
+		storeCopy := e.astore.ShallowCopy()
+		storeCopy.RegisterEndpoint(ep)
+		e.astore = storeCopy
+		registered = append(registered, ep)
+	}
+	if isActivated {
+		activated = ep
+	}
+	machineCopy := md.M
+	result = &Endpoint{M: &machineCopy, App: md.Group.ByName(appName)}
+	return result, registered, activated, e.astore
+}
+
+func (e *EndpointStore) markStalePushEndpoints(
+	timestamp float64, lastPushedBefore time.Time) {
+	e.statusChangeLock.Lock()
+	defer e.statusChangeLock.Unlock()
+	inactive, astore := e._markStalePushEndpoints(lastPushedBefore)
+	for _, ep := range inactive {
+		astore.MarkEndpointInactive(timestamp, ep)
+	}
+}
+
+func (e *EndpointStore) _markStalePushEndpoints(
+	lastPushedBefore time.Time) (
+	inactive []*scotty.Endpoint, astore *store.Store) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, md := range e.byHost {
+		inactive = append(
+			inactive,
+			md.Group.InactivatePushApplications(lastPushedBefore)...)
+	}
+	return inactive, e.astore
+}
+
+func (e *EndpointStore) removeStalePushMachines(
+	timestamp float64, lastPushedBefore time.Time) {
+	e.statusChangeLock.Lock()
+	defer e.statusChangeLock.Unlock()
+	inactive, astore := e._removeStalePushMachines(lastPushedBefore)
+	// astore is the store from before the removal so it still has the
+	// removed endpoints.
+	for _, ep := range inactive {
+		astore.MarkEndpointInactive(timestamp, ep)
+	}
+}
+
+func (e *EndpointStore) _removeStalePushMachines(
+	lastPushedBefore time.Time) (
+	inactive []*scotty.Endpoint, astore *store.Store) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	astore = e.astore
+	storeCopy := e.astore
+	for hostName, md := range e.byHost {
+		if !md.PushOnly || md.Group.PushedSince(lastPushedBefore) {
+			continue
+		}
+		if storeCopy == e.astore {
+			storeCopy = e.astore.ShallowCopy()
+		}
+		for _, app := range md.Group.Applications() {
+			if app.Active {
+				inactive = append(inactive, app.EP)
+			}
+			storeCopy.UnregisterEndpoint(app.EP)
+		}
+		delete(e.byHost, hostName)
+	}
+	e.astore = storeCopy
 	return
 }
 
+func (e *EndpointStore) hasMdbHost(hostName string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	md := e.byHost[hostName]
+	return md != nil && !md.PushOnly
+}
+
+func (e *EndpointStore) hostNameByIpAddress(ipAddress string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.hostByIpAddress[ipAddress]
+}
+
 func (e *EndpointStore) _updateEndpoints(
 	endpoints map[string]EndpointObservation) (
-	active, inactive []*scotty.Endpoint, astore *store.Store) {
+	active, inactive, registered []*scotty.Endpoint,
+	astore *store.Store) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	storeCopy := e.astore
@@ -125,6 +287,7 @@ func (e *EndpointStore) _updateEndpoints(
 			for _, ep := range newep {
 				storeCopy.RegisterEndpoint(ep)
 			}
+			registered = append(registered, newep...)
 		}
 		active = append(active, activeep...)
 		inactive = append(inactive, inactiveep...)
@@ -134,6 +297,77 @@ func (e *EndpointStore) _updateEndpoints(
 	return
 }
 
+// checkpointKey returns the key that identifies an endpoint in a
+// checkpoint.
+func checkpointKey(endpointId interface{}) string {
+	ep := endpointId.(*scotty.Endpoint)
+	return ep.HostName() + "/" + ep.AppName()
+}
+
+func (e *EndpointStore) setCheckpoint(checkpoint *store.Checkpoint) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.checkpoint = checkpoint
+}
+
+func (e *EndpointStore) setConnectors(connectors application.Connectors) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.connectors = connectors
+	for _, md := range e.byHost {
+		md.Group.SetConnectors(connectors)
+	}
+}
+
+func (e *EndpointStore) setHostLabels(config hostlabels.Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.hostLabels = config
+}
+
+func (e *EndpointStore) writeCheckpoint(w io.Writer) error {
+	return e.store().WriteCheckpoint(w, checkpointKey)
+}
+
+func (e *EndpointStore) setWAL(wal *store.WAL, replay *store.WALReplay) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.astore.SetWAL(wal, checkpointKey)
+	e.walReplay = replay
+}
+
+// restore restores newly registered endpoints from the checkpoint and
+// then from the write-ahead log if there are any. Caller must hold the
+// status change lock.
+func (e *EndpointStore) restore(
+	astore *store.Store, registered []*scotty.Endpoint) {
+	e.mu.Lock()
+	checkpoint := e.checkpoint
+	walReplay := e.walReplay
+	e.mu.Unlock()
+	if checkpoint != nil {
+		for _, ep := range registered {
+			astore.RestoreEndpoint(ep, checkpointKey(ep), checkpoint)
+		}
+	}
+	if walReplay != nil {
+		for _, ep := range registered {
+			// Batches that the store rejects are lost.
+			astore.ReplayEndpoint(ep, checkpointKey(ep), walReplay)
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Let the checkpoint and write-ahead log be GCed once everything in
+	// them is restored.
+	if checkpoint != nil && checkpoint.Len() == 0 {
+		e.checkpoint = nil
+	}
+	if walReplay != nil && walReplay.Len() == 0 {
+		e.walReplay = nil
+	}
+}
+
 func (e *EndpointStore) byHostAndName(
 	host, name string) (*Endpoint, *store.Store) {
 	e.mu.Lock()
@@ -225,6 +459,8 @@ func (e *EndpointStore) updateState(
 		ep,
 		func(es *application.EndpointStats) {
 			es.Status = newState.Status()
+			es.ConsecutiveFailures = newState.ConsecutiveFailures()
+			es.RetryTime = newState.RetryTime()
 			if es.Status == scotty.Synced {
 				es.PollTime = newState.TimeSpentPolling()
 				es.LastReadTime = newState.Timestamp()
@@ -232,6 +468,15 @@ func (e *EndpointStore) updateState(
 		})
 }
 
+func (e *EndpointStore) logPush(ep *scotty.Endpoint, ts time.Time) {
+	e.update(
+		ep,
+		func(es *application.EndpointStats) {
+			es.Status = scotty.Synced
+			es.LastReadTime = ts
+		})
+}
+
 func (e *EndpointStore) reportError(
 	ep *scotty.Endpoint, err error, ts time.Time) {
 	e.update(
@@ -1,11 +1,14 @@
 package machine_test
 
 import (
+	"errors"
 	"github.com/Symantec/Dominator/lib/mdb"
 	"github.com/Symantec/scotty/application"
 	"github.com/Symantec/scotty/awsinfo"
+	"github.com/Symantec/scotty/hostlabels"
 	"github.com/Symantec/scotty/machine"
 	"github.com/Symantec/scotty/namesandports"
+	"github.com/Symantec/scotty/sources"
 	"github.com/Symantec/scotty/store"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
@@ -276,3 +279,360 @@ func TestAPI(t *testing.T) {
 
 	})
 }
+
+func TestPushEndpoint(t *testing.T) {
+	Convey("Test PushEndpoint", t, func() {
+		aStore := store.NewStore(10, 100, 1.0, 10)
+		endpointStore := machine.NewEndpointStore(
+			aStore,
+			awsinfo.Config{CloudWatchRefresh: 5 * time.Minute},
+			0)
+		endpointStore.UpdateMachines(
+			100.0,
+			[]mdb.Machine{{Hostname: "host1", IpAddress: "10.1.1.1"}})
+		So(endpointStore.HostNameByIpAddress("10.1.1.1"), ShouldEqual, "host1")
+		So(endpointStore.HostNameByIpAddress("10.1.1.2"), ShouldEqual, "")
+
+		// Push to a known host
+		job1, store := endpointStore.PushEndpoint("host1", "job")
+		So(job1.M.Host, ShouldEqual, "host1")
+		So(job1.App.EP.AppName(), ShouldEqual, "job")
+		So(job1.App.Push, ShouldBeTrue)
+		So(job1.Active(), ShouldBeTrue)
+		So(store.IsRegistered(job1.App.EP), ShouldBeTrue)
+		again, _ := endpointStore.PushEndpoint("host1", "job")
+		So(again.App.EP, ShouldEqual, job1.App.EP)
+
+		// Can't push to a polled application
+		healthAgent1, _ := endpointStore.PushEndpoint(
+			"host1", application.HealthAgentName)
+		So(healthAgent1, ShouldBeNil)
+
+		// Health agent never reports pushed applications so they
+		// stay active.
+		endpointStore.UpdateEndpoints(
+			100.0,
+			map[string]machine.EndpointObservation{
+				"host1": {
+					SeqNo: 1,
+					Endpoints: namesandports.NamesAndPorts{
+						"scotty": {Port: 6980},
+					},
+				},
+			})
+		job1, store = endpointStore.ByHostAndName("host1", "job")
+		So(job1.Active(), ShouldBeTrue)
+		So(store.IsEndpointActive(job1.App.EP), ShouldBeTrue)
+
+		// Push to an unknown host
+		job2, store := endpointStore.PushEndpoint("host2", "job")
+		So(job2.M.Host, ShouldEqual, "host2")
+		So(job2.Active(), ShouldBeTrue)
+		So(store.IsRegistered(job2.App.EP), ShouldBeTrue)
+		healthAgent2, _ := endpointStore.ByHostAndName(
+			"host2", application.HealthAgentName)
+		So(healthAgent2, ShouldBeNil)
+
+		// host2 stays active even though it isn't in mdb
+		endpointStore.UpdateMachines(
+			100.0,
+			[]mdb.Machine{{Hostname: "host1", IpAddress: "10.1.1.1"}})
+		job2, store = endpointStore.ByHostAndName("host2", "job")
+		So(job2.Active(), ShouldBeTrue)
+		So(store.IsEndpointActive(job2.App.EP), ShouldBeTrue)
+
+		// Once host2 shows up in mdb, scotty polls its health agent
+		endpointStore.UpdateMachines(
+			100.0,
+			[]mdb.Machine{
+				{Hostname: "host1", IpAddress: "10.1.1.1"},
+				{Hostname: "host2", IpAddress: "10.1.1.2"},
+			})
+		healthAgent2, store = endpointStore.ByHostAndName(
+			"host2", application.HealthAgentName)
+		So(healthAgent2.Active(), ShouldBeTrue)
+		So(store.IsRegistered(healthAgent2.App.EP), ShouldBeTrue)
+		So(healthAgent2.M.IpAddress, ShouldEqual, "10.1.1.2")
+		So(endpointStore.HostNameByIpAddress("10.1.1.2"), ShouldEqual, "host2")
+
+		// Now host2 goes inactive when it leaves mdb
+		endpointStore.UpdateMachines(
+			100.0,
+			[]mdb.Machine{{Hostname: "host1", IpAddress: "10.1.1.1"}})
+		job2, store = endpointStore.ByHostAndName("host2", "job")
+		So(job2.Active(), ShouldBeFalse)
+		So(store.IsEndpointActive(job2.App.EP), ShouldBeFalse)
+
+		// Applications that stop pushing go inactive
+		now := time.Now()
+		endpointStore.LogPush(job1.App.EP, now.Add(-time.Hour))
+		endpointStore.MarkStalePushEndpoints(200.0, now.Add(-2*time.Hour))
+		job1, store = endpointStore.ByHostAndName("host1", "job")
+		So(job1.Active(), ShouldBeTrue)
+		So(job1.App.LastReadTime, ShouldResemble, now.Add(-time.Hour))
+		endpointStore.MarkStalePushEndpoints(200.0, now)
+		job1, store = endpointStore.ByHostAndName("host1", "job")
+		So(job1.Active(), ShouldBeFalse)
+		So(store.IsEndpointActive(job1.App.EP), ShouldBeFalse)
+
+		// and become active again when they push
+		job1, store = endpointStore.PushEndpoint("host1", "job")
+		So(job1.Active(), ShouldBeTrue)
+		So(store.IsEndpointActive(job1.App.EP), ShouldBeTrue)
+	})
+}
+
+func TestRemoveStalePushMachines(t *testing.T) {
+	Convey("Stale push only machines", t, func() {
+		aStore := store.NewStore(10, 100, 1.0, 10)
+		endpointStore := machine.NewEndpointStore(
+			aStore,
+			awsinfo.Config{CloudWatchRefresh: 5 * time.Minute},
+			0)
+		endpointStore.UpdateMachines(
+			100.0,
+			[]mdb.Machine{{Hostname: "host1", IpAddress: "10.1.1.1"}})
+		job1, _ := endpointStore.PushEndpoint("host1", "job")
+		job2, _ := endpointStore.PushEndpoint("host2", "job")
+		job3, _ := endpointStore.PushEndpoint("host3", "job")
+		So(endpointStore.HasMdbHost("host1"), ShouldBeTrue)
+		So(endpointStore.HasMdbHost("host2"), ShouldBeFalse)
+		So(endpointStore.HasMdbHost("host4"), ShouldBeFalse)
+
+		now := time.Now()
+		endpointStore.LogPush(job1.App.EP, now.Add(-time.Hour))
+		endpointStore.LogPush(job2.App.EP, now.Add(-time.Hour))
+		endpointStore.LogPush(job3.App.EP, now)
+		_, oldStore := endpointStore.AllActiveWithStore()
+		endpointStore.RemoveStalePushMachines(200.0, now.Add(-time.Minute))
+
+		// host1 is in mdb so it stays.
+		job1, astore := endpointStore.ByHostAndName("host1", "job")
+		So(job1, ShouldNotBeNil)
+		So(astore.IsRegistered(job1.App.EP), ShouldBeTrue)
+
+		// host2 is gone
+		gone, _ := endpointStore.ByHostAndName("host2", "job")
+		So(gone, ShouldBeNil)
+		So(astore.IsRegistered(job2.App.EP), ShouldBeFalse)
+		So(oldStore.IsEndpointActive(job2.App.EP), ShouldBeFalse)
+
+		// host3 pushed recently
+		job3, _ = endpointStore.ByHostAndName("host3", "job")
+		So(job3, ShouldNotBeNil)
+		So(astore.IsRegistered(job3.App.EP), ShouldBeTrue)
+
+		// host2 comes back when it pushes again
+		job2, astore = endpointStore.PushEndpoint("host2", "job")
+		So(job2.Active(), ShouldBeTrue)
+		So(astore.IsRegistered(job2.App.EP), ShouldBeTrue)
+	})
+}
+
+func TestUpdateMachinesWithoutHealthAgents(t *testing.T) {
+	Convey("Hosts without health agents", t, func() {
+		aStore := store.NewStore(10, 100, 1.0, 10)
+		endpointStore := machine.NewEndpointStore(
+			aStore,
+			awsinfo.Config{CloudWatchRefresh: 5 * time.Minute},
+			0)
+		hosts := []mdb.Machine{
+			{Hostname: "host1", IpAddress: "10.1.1.1"},
+			{Hostname: "host2", IpAddress: "10.1.1.2"},
+		}
+		endpointStore.UpdateMachinesWithoutHealthAgents(
+			100.0, hosts, map[string]bool{"host2": true})
+		healthAgent1, _ := endpointStore.ByHostAndName(
+			"host1", application.HealthAgentName)
+		So(healthAgent1, ShouldNotBeNil)
+		healthAgent2, _ := endpointStore.ByHostAndName(
+			"host2", application.HealthAgentName)
+		So(healthAgent2, ShouldBeNil)
+
+		endpointStore.UpdateEndpoints(
+			100.0,
+			map[string]machine.EndpointObservation{
+				"host2": {
+					SeqNo: 1,
+					Endpoints: namesandports.NamesAndPorts{
+						"lb": {Port: 8404},
+					},
+				},
+			})
+		lb, store := endpointStore.ByHostAndName("host2", "lb")
+		So(lb.App.Port, ShouldEqual, 8404)
+		So(lb.Active(), ShouldBeTrue)
+		So(store.IsRegistered(lb.App.EP), ShouldBeTrue)
+
+		// Health agent gets added once host2 may have one
+		endpointStore.UpdateMachines(100.0, hosts)
+		healthAgent2, store = endpointStore.ByHostAndName(
+			"host2", application.HealthAgentName)
+		So(healthAgent2, ShouldNotBeNil)
+		So(store.IsRegistered(healthAgent2.App.EP), ShouldBeTrue)
+
+		// But never gets removed
+		endpointStore.UpdateMachinesWithoutHealthAgents(
+			100.0, hosts, map[string]bool{"host2": true})
+		healthAgent2, _ = endpointStore.ByHostAndName(
+			"host2", application.HealthAgentName)
+		So(healthAgent2, ShouldNotBeNil)
+	})
+}
+
+func TestMergeEndpointObservations(t *testing.T) {
+	Convey("Merging endpoint observations", t, func() {
+		healthAgents := map[string]machine.EndpointObservation{
+			"host1": {
+				SeqNo: 3,
+				Endpoints: namesandports.NamesAndPorts{
+					"scotty": {Port: 6980},
+					"lb":     {Port: 8000},
+				},
+			},
+			"host2": {
+				SeqNo: 5,
+			},
+		}
+		inventory := map[string]machine.EndpointObservation{
+			"host1": {
+				SeqNo: 2,
+				Endpoints: namesandports.NamesAndPorts{
+					"lb": {Port: 8404, Protocol: "haproxy"},
+				},
+			},
+			"host3": {
+				SeqNo: 1,
+				Endpoints: namesandports.NamesAndPorts{
+					"db": {Port: 5432, IsTLS: true},
+				},
+			},
+		}
+		merged := machine.MergeEndpointObservations(healthAgents, inventory)
+		So(merged, ShouldResemble, map[string]machine.EndpointObservation{
+			"host1": {
+				SeqNo: 5,
+				Endpoints: namesandports.NamesAndPorts{
+					"scotty": {Port: 6980},
+					"lb":     {Port: 8404, Protocol: "haproxy"},
+				},
+			},
+			"host2": {
+				SeqNo: 5,
+			},
+			"host3": {
+				SeqNo: 1,
+				Endpoints: namesandports.NamesAndPorts{
+					"db": {Port: 5432, IsTLS: true},
+				},
+			},
+		})
+		// Inputs unchanged
+		So(healthAgents["host1"].Endpoints["lb"].Port, ShouldEqual, 8000)
+	})
+}
+
+func TestHostLabels(t *testing.T) {
+	Convey("Test host labels", t, func() {
+		aStore := store.NewStore(10, 100, 1.0, 10)
+		endpointStore := machine.NewEndpointStore(
+			aStore,
+			awsinfo.Config{CloudWatchRefresh: 5 * time.Minute},
+			0)
+		endpointStore.SetHostLabels(hostlabels.Config{
+			"owner": "Tags.owner",
+			"env":   "AwsMetadata.Tags.Environment",
+		})
+		endpointStore.UpdateMachines(
+			100.0,
+			[]mdb.Machine{
+				{
+					Hostname: "host1",
+					Tags:     map[string]string{"owner": "alice"},
+					AwsMetadata: &mdb.AwsMetadata{
+						Tags: map[string]string{"Environment": "prod"},
+					},
+				},
+				{Hostname: "host2"},
+			})
+		host1, _ := endpointStore.ByHostAndName(
+			"host1", application.HealthAgentName)
+		So(host1.M.Labels, ShouldResemble, map[string]string{
+			"owner": "alice",
+			"env":   "prod",
+		})
+		host2, _ := endpointStore.ByHostAndName(
+			"host2", application.HealthAgentName)
+		So(host2.M.Labels, ShouldBeNil)
+
+		// Labels follow changes in mdb
+		endpointStore.UpdateMachines(
+			200.0,
+			[]mdb.Machine{
+				{
+					Hostname: "host1",
+					Tags:     map[string]string{"owner": "bob"},
+				},
+				{Hostname: "host2"},
+			})
+		So(host1.M.Labels, ShouldResemble, map[string]string{
+			"owner": "alice",
+			"env":   "prod",
+		})
+		host1, _ = endpointStore.ByHostAndName(
+			"host1", application.HealthAgentName)
+		So(host1.M.Labels, ShouldResemble, map[string]string{
+			"owner": "bob",
+		})
+	})
+}
+
+type fakeConnectorType string
+
+func (f fakeConnectorType) Connect(
+	host string, port uint, config sources.Config) (sources.Poller, error) {
+	return nil, errors.New("fake connector")
+}
+
+func (f fakeConnectorType) Name() string {
+	return string(f)
+}
+
+func TestConnectors(t *testing.T) {
+	Convey("Test connectors", t, func() {
+		aStore := store.NewStore(10, 100, 1.0, 10)
+		endpointStore := machine.NewEndpointStore(
+			aStore,
+			awsinfo.Config{CloudWatchRefresh: 5 * time.Minute},
+			0)
+		endpointStore.UpdateMachines(
+			100.0, []mdb.Machine{{Hostname: "host1"}})
+		connectors := make(application.Connectors)
+		So(connectors.Add("fake", fakeConnectorType("fake")), ShouldBeNil)
+		// Both known machines and machines added later use connectors
+		endpointStore.SetConnectors(connectors)
+		endpointStore.UpdateMachines(
+			100.0,
+			[]mdb.Machine{{Hostname: "host1"}, {Hostname: "host2"}})
+		endpointStore.UpdateEndpoints(
+			100.0,
+			map[string]machine.EndpointObservation{
+				"host1": {
+					SeqNo: 1,
+					Endpoints: namesandports.NamesAndPorts{
+						"lb": {Port: 8404, Protocol: "fake"},
+					},
+				},
+				"host2": {
+					SeqNo: 1,
+					Endpoints: namesandports.NamesAndPorts{
+						"lb": {Port: 8404, Protocol: "fake"},
+					},
+				},
+			})
+		lb1, _ := endpointStore.ByHostAndName("host1", "lb")
+		So(lb1.App.EP.ConnectorName(), ShouldEqual, "fake")
+		lb2, _ := endpointStore.ByHostAndName("host2", "lb")
+		So(lb2.App.EP.ConnectorName(), ShouldEqual, "fake")
+	})
+}
@@ -107,12 +107,62 @@ type Error struct {
 	HostName  string `json:"hostName"`
 	Timestamp string `json:"timestamp"`
 	Error     string `json:"error"`
+	// True if the error is scotty giving up because connecting or polling
+	// took too long.
+	Timeout bool `json:"timeout,omitempty"`
+	// Number of times in a row scotty failed to connect to the endpoint.
+	ConsecutiveFailures uint `json:"consecutiveFailures,omitempty"`
+	// When scotty will next try to connect to the endpoint in seconds
+	// since Jan 1 1970. Empty if scotty is not backing off.
+	RetryTime string `json:"retryTime,omitempty"`
 }
 
 // ErrorList represents a list of Error instances. Clients should treat
 // ErrorList instances as immutable.
 type ErrorList []*Error
 
+// PageUsage represents how many pages scotty uses to store the metrics
+// of a particular endpoint.
+type PageUsage struct {
+	HostName          string `json:"hostName"`
+	AppName           string `json:"appName"`
+	PageCount         uint   `json:"pageCount"`
+	InactivePageCount uint   `json:"inactivePageCount"`
+	// 0 means no quota
+	Quota uint `json:"quota"`
+}
+
+// EndpointCardinality represents how many time series scotty stores for
+// a particular endpoint.
+type EndpointCardinality struct {
+	HostName    string `json:"hostName"`
+	AppName     string `json:"appName"`
+	SeriesCount uint   `json:"seriesCount"`
+	// Time series in the latest poll of the endpoint
+	ActiveSeriesCount uint `json:"activeSeriesCount"`
+	// Time series first seen in the latest poll of the endpoint
+	NewSeriesCount      uint   `json:"newSeriesCount"`
+	TotalNewSeriesCount uint64 `json:"totalNewSeriesCount"`
+}
+
+// AppCardinality represents how many time series scotty stores for all
+// the endpoints of a particular application.
+type AppCardinality struct {
+	AppName             string `json:"appName"`
+	EndpointCount       uint   `json:"endpointCount"`
+	SeriesCount         uint   `json:"seriesCount"`
+	ActiveSeriesCount   uint   `json:"activeSeriesCount"`
+	NewSeriesCount      uint   `json:"newSeriesCount"`
+	TotalNewSeriesCount uint64 `json:"totalNewSeriesCount"`
+}
+
+// Cardinality lists the endpoints and the applications with the most
+// time series, most first.
+type Cardinality struct {
+	Endpoints []*EndpointCardinality `json:"endpoints"`
+	Apps      []*AppCardinality      `json:"apps"`
+}
+
 func init() {
 	var dist *Distribution
 	gob.RegisterName("*scotty.messages.Distribution", dist)
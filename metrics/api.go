@@ -62,6 +62,10 @@ func FileSystems(list List) []string {
 
 // Endpoints returns the name and port of each reported application.
 // The applications are listed under /health-checks metrics tree.
+// Endpoints includes only applications reporting has-tricorder-metrics,
+// has-prometheus-metrics or a non empty metrics-protocol. metrics-protocol
+// names the protocol directly e.g "prometheus" or the protocol of a
+// scraper that scotty is configured with.
 func Endpoints(list List) namesandports.NamesAndPorts {
 	return endpoints(list)
 }
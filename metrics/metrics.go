@@ -88,11 +88,12 @@ func endpoints(list List) (result namesandports.NamesAndPorts) {
 		}
 		name := current[healthChecksLen]
 		base := current.Truncate(healthChecksLen + 1)
-		if istri, _ := getBool(list, base.String()+"/has-tricorder-metrics"); istri {
+		protocol, hasMetrics := endpointProtocol(list, base)
+		if hasMetrics {
 			isTls, _ := getBool(list, base.String()+"/use-tls")
 			port, ok := getAsUint(list, base.String()+"/port-number")
 			if ok {
-				result.Add(name, port, isTls)
+				result.AddWithProtocol(name, port, isTls, protocol)
 			}
 		}
 		beginning = findNext(list, base)
@@ -100,6 +101,23 @@ func endpoints(list List) (result namesandports.NamesAndPorts) {
 	return
 }
 
+// endpointProtocol returns how the application whose health check
+// metrics are under base exposes its metrics. hasMetrics is false if the
+// application exposes no metrics that scotty can read.
+func endpointProtocol(list List, base pathType) (
+	protocol string, hasMetrics bool) {
+	if protocol, _ = getString(list, base.String()+"/metrics-protocol"); protocol != "" {
+		return protocol, true
+	}
+	if istri, _ := getBool(list, base.String()+"/has-tricorder-metrics"); istri {
+		return namesandports.Tricorder, true
+	}
+	if isprom, _ := getBool(list, base.String()+"/has-prometheus-metrics"); isprom {
+		return namesandports.Prometheus, true
+	}
+	return "", false
+}
+
 func fileSystems(list List) (result []string) {
 	sysFsLen := len(kSysFs)
 	beginning := find(list, kSysFs)
@@ -158,6 +176,15 @@ func getBool(list List, path string) (bool, bool) {
 	return v, k
 }
 
+func getString(list List, path string) (string, bool) {
+	val, ok := get(list, path)
+	if !ok {
+		return "", false
+	}
+	v, k := val.(string)
+	return v, k
+}
+
 func getFloat64(list List, path string) (float64, bool) {
 	val, ok := get(list, path)
 	if !ok {
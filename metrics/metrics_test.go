@@ -268,6 +268,22 @@ func TestEndpoints(t *testing.T) {
 			Path:  "/health-checks/foo/port-number",
 			Value: int32(6974),
 		},
+		{
+			Path:  "/health-checks/lb/metrics-protocol",
+			Value: "haproxy",
+		},
+		{
+			Path:  "/health-checks/lb/port-number",
+			Value: int64(8404),
+		},
+		{
+			Path:  "/health-checks/prom/has-prometheus-metrics",
+			Value: true,
+		},
+		{
+			Path:  "/health-checks/prom/port-number",
+			Value: int64(9100),
+		},
 		{
 			Path:  "/health-checks/scotty/has-tricorder-metrics",
 			Value: true,
@@ -285,6 +301,8 @@ func TestEndpoints(t *testing.T) {
 	expected := namesandports.NamesAndPorts{
 		"bar":    {Port: 6990, IsTLS: false},
 		"foo":    {Port: 6974, IsTLS: false},
+		"lb":     {Port: 8404, IsTLS: false, Protocol: "haproxy"},
+		"prom":   {Port: 9100, IsTLS: false, Protocol: namesandports.Prometheus},
 		"scotty": {Port: 6980, IsTLS: false},
 	}
 	if !reflect.DeepEqual(actual, expected) {
@@ -1,9 +1,17 @@
 // Package namesandports contains the NamesAndPorts datastructure.
 package namesandports
 
+// Protocols for Record.Protocol
+const (
+	Tricorder  = ""
+	Prometheus = "prometheus"
+)
+
 type Record struct {
 	Port  uint
 	IsTLS bool
+	// How the application exposes its metrics
+	Protocol string
 }
 
 // NamesAndPorts represents a set of application names and corresponding
@@ -21,6 +29,13 @@ func (n *NamesAndPorts) Add(name string, port uint, isTLS bool) {
 	n.add(name, port, isTLS)
 }
 
+// AddWithProtocol adds a name and port to this instance in place for an
+// application that exposes its metrics using given protocol.
+func (n *NamesAndPorts) AddWithProtocol(
+	name string, port uint, isTLS bool, protocol string) {
+	n.addWithProtocol(name, port, isTLS, protocol)
+}
+
 // HasPort returns true if port is included in set
 func (n NamesAndPorts) HasPort(port uint) bool {
 	return n.hasPort(port)
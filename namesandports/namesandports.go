@@ -12,10 +12,15 @@ func (n NamesAndPorts) _copy() NamesAndPorts {
 }
 
 func (n *NamesAndPorts) add(name string, port uint, isTLS bool) {
+	n.addWithProtocol(name, port, isTLS, Tricorder)
+}
+
+func (n *NamesAndPorts) addWithProtocol(
+	name string, port uint, isTLS bool, protocol string) {
 	if *n == nil {
 		*n = make(NamesAndPorts)
 	}
-	(*n)[name] = Record{Port: port, IsTLS: isTLS}
+	(*n)[name] = Record{Port: port, IsTLS: isTLS, Protocol: protocol}
 }
 
 func (n NamesAndPorts) hasPort(port uint) bool {
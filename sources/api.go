@@ -2,7 +2,10 @@
 package sources
 
 import (
+	"context"
 	"github.com/Symantec/scotty/metrics"
+	"io"
+	"net/http"
 )
 
 // Config controls how a Connector connects.
@@ -17,6 +20,14 @@ type Connector interface {
 	Name() string
 }
 
+// ContextConnector is implemented by Connector instances that can give up
+// connecting when a context is done.
+type ContextConnector interface {
+	Connector
+	ConnectContext(ctx context.Context, host string, port uint, config Config) (
+		Poller, error)
+}
+
 // ConnectorList is a list of connectors.
 // First element of list is most prefered; last element is least preferred.
 // Instances of this type must be treated as immutable.
@@ -45,6 +56,25 @@ type ResourceConnector interface {
 	ResourceConnect(resource Resource) (Poller, error)
 }
 
+// ContextResourceConnector is implemented by ResourceConnector instances
+// that can give up connecting when a context is done. The ResourceConnector
+// instances that MultiResourceConnector and AsResourceConnector return
+// implement this interface.
+type ContextResourceConnector interface {
+	ResourceConnector
+	ResourceConnectContext(ctx context.Context, resource Resource) (
+		Poller, error)
+}
+
+// ResourceConnectContext connects to resource using conn giving up when
+// ctx is done. If conn does not implement ContextResourceConnector,
+// ResourceConnectContext ignores ctx.
+func ResourceConnectContext(
+	ctx context.Context, conn ResourceConnector, resource Resource) (
+	Poller, error) {
+	return resourceConnectContext(ctx, conn, resource)
+}
+
 // MultiResourceConnector makes a ResourceConnector out of a ConnectorList.
 // consecutiveCallsForReset is how many times an alternate Connector must
 // be used through a Resource created from this instance before the first
@@ -66,3 +96,31 @@ type Poller interface {
 	Poll() (metrics.List, error)
 	Close() error
 }
+
+// ContextPoller is implemented by Poller instances that can give up
+// polling when a context is done. A ContextPoller that gives up polling
+// must still be closed.
+type ContextPoller interface {
+	Poller
+	PollContext(ctx context.Context) (metrics.List, error)
+}
+
+// ConnectHTTP sends request for a connector that polls metrics over HTTP
+// giving up when ctx is done. If isTls is true, ConnectHTTP doesn't verify
+// the certificate of the server. The returned Poller implements
+// ContextPoller. Polling it fails if the response status isn't 200;
+// otherwise polling it reads the metrics from the response body with
+// decode.
+func ConnectHTTP(
+	ctx context.Context,
+	request *http.Request,
+	isTls bool,
+	decode func(body io.Reader) (metrics.List, error)) (Poller, error) {
+	return connectHTTP(ctx, request, isTls, decode)
+}
+
+// PollContext polls poller giving up when ctx is done. If poller does not
+// implement ContextPoller, PollContext ignores ctx.
+func PollContext(ctx context.Context, poller Poller) (metrics.List, error) {
+	return pollContext(ctx, poller)
+}
@@ -0,0 +1,92 @@
+package sources
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
+	"io"
+	"net/http"
+)
+
+var (
+	kTlsClient = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+)
+
+func connectHTTP(
+	ctx context.Context,
+	request *http.Request,
+	isTls bool,
+	decode func(body io.Reader) (metrics.List, error)) (Poller, error) {
+	client := http.DefaultClient
+	if isTls {
+		client = kTlsClient
+	}
+	// The response body outlives ctx, so the request gets its own
+	// context which we cancel only while ctx is in charge.
+	requestCtx, cancel := context.WithCancel(context.Background())
+	stopWatching := cancelWhenDone(ctx, cancel)
+	response, err := client.Do(request.WithContext(requestCtx))
+	stopWatching()
+	if err != nil {
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return &httpPollerType{
+		response: response, cancel: cancel, decode: decode}, nil
+}
+
+// cancelWhenDone calls cancel if ctx is done before the returned function
+// is called.
+func cancelWhenDone(ctx context.Context, cancel func()) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
+
+type httpPollerType struct {
+	response *http.Response
+	cancel   func()
+	decode   func(body io.Reader) (metrics.List, error)
+}
+
+func (p *httpPollerType) Poll() (metrics.List, error) {
+	return p.PollContext(context.Background())
+}
+
+func (p *httpPollerType) PollContext(ctx context.Context) (
+	result metrics.List, err error) {
+	stopWatching := cancelWhenDone(ctx, p.cancel)
+	defer stopWatching()
+	result, err = p.poll()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return
+}
+
+func (p *httpPollerType) poll() (metrics.List, error) {
+	if p.response.StatusCode != 200 {
+		return nil, fmt.Errorf(
+			"%s: %s", p.response.Request.URL, p.response.Status)
+	}
+	return p.decode(p.response.Body)
+}
+
+func (p *httpPollerType) Close() error {
+	defer p.cancel()
+	return p.response.Body.Close()
+}
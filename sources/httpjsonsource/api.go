@@ -0,0 +1,69 @@
+// Package httpjsonsource connects to sources that serve arbitrary JSON
+// over HTTP such as the status pages of load balancers and the admin APIs
+// of queues.
+//
+// A connector fetches one URL from each endpoint and turns fields of the
+// returned JSON document into metrics according to a list of rules.
+package httpjsonsource
+
+import (
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/scotty/sources"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io"
+)
+
+// Rule maps fields of a JSON document to metrics.
+type Rule struct {
+	// The fields to read e.g "$.queues[*].depth". Field is a simplified
+	// JSONPath: an optional leading "$" followed by ".name" or ["name"]
+	// to select a member of an object, [n] to select the nth element of
+	// an array, and .* or [*] to select every member of an object or
+	// every element of an array.
+	Field string `yaml:"field"`
+	// The metric path e.g "/queues/{1}/depth". {n} is replaced by the
+	// member name or array index that the nth wildcard in Field matched.
+	Path string `yaml:"path"`
+	// Optional. The kind of the metric: bool, int64, uint64, float64 or
+	// string. Numbers and strings are converted to the kind if possible.
+	// Empty means float64 for JSON numbers, bool for JSON booleans, and
+	// string for JSON strings.
+	Kind types.Type `yaml:"kind"`
+	// Optional. The unit of the metric.
+	Unit units.Unit `yaml:"unit"`
+	// Optional. The description of the metric.
+	Description string `yaml:"description"`
+}
+
+// Config describes how to scrape an endpoint.
+type Config struct {
+	// Template for the URL to fetch e.g
+	// "{{.Scheme}}://{{.Host}}:{{.Port}}/stats". The template sees the
+	// Scheme, "http" or "https", and the Host and Port of the endpoint.
+	URL string `yaml:"url"`
+	// Optional. Extra headers for each request.
+	Headers map[string]string `yaml:"headers"`
+	// Optional. If set, each request carries this bearer token.
+	BearerToken string `yaml:"bearerToken"`
+	// Optional. If set, each request uses basic authentication.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// The metrics to read.
+	Rules []Rule `yaml:"rules"`
+}
+
+// NewConnector returns a connector named name that scrapes endpoints
+// according to config. NewConnector returns an error if config has a
+// malformed URL template or rule.
+func NewConnector(name string, config *Config) (sources.Connector, error) {
+	return newConnector(name, config)
+}
+
+// Decode reads a JSON document from r and returns the metrics that rules
+// select. Fields that are missing from the document, are null, or cannot
+// be converted to the kind of their rule are skipped. Decode returns an
+// error if a rule is malformed or if two fields map to the same path.
+func Decode(r io.Reader, rules []Rule) (metrics.List, error) {
+	return decode(r, rules)
+}
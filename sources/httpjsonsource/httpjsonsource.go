@@ -0,0 +1,105 @@
+package httpjsonsource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/scotty/sources"
+	"io"
+	"net/http"
+	"text/template"
+)
+
+var (
+	errNoURL = errors.New("httpjsonsource: URL required")
+)
+
+// urlArgsType is what URL templates see.
+type urlArgsType struct {
+	Scheme string
+	Host   string
+	Port   uint
+}
+
+type connectorType struct {
+	name        string
+	url         *template.Template
+	headers     map[string]string
+	bearerToken string
+	username    string
+	password    string
+	rules       []*ruleType
+}
+
+func newConnector(name string, config *Config) (sources.Connector, error) {
+	if config.URL == "" {
+		return nil, errNoURL
+	}
+	url, err := template.New(name).Option("missingkey=error").Parse(
+		config.URL)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := compileRules(config.Rules)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string, len(config.Headers))
+	for key, value := range config.Headers {
+		headers[key] = value
+	}
+	return &connectorType{
+		name:        name,
+		url:         url,
+		headers:     headers,
+		bearerToken: config.BearerToken,
+		username:    config.Username,
+		password:    config.Password,
+		rules:       rules,
+	}, nil
+}
+
+func (c *connectorType) Connect(
+	host string, port uint, config sources.Config) (sources.Poller, error) {
+	return c.ConnectContext(context.Background(), host, port, config)
+}
+
+func (c *connectorType) ConnectContext(
+	ctx context.Context, host string, port uint, config sources.Config) (
+	sources.Poller, error) {
+	args := urlArgsType{Scheme: "http", Host: host, Port: port}
+	if config.IsTls {
+		args.Scheme = "https"
+	}
+	var url bytes.Buffer
+	if err := c.url.Execute(&url, &args); err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	for key, value := range c.headers {
+		request.Header.Set(key, value)
+	}
+	if c.bearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	}
+	if c.username != "" {
+		request.SetBasicAuth(c.username, c.password)
+	}
+	rules := c.rules
+	return sources.ConnectHTTP(
+		ctx,
+		request,
+		config.IsTls,
+		func(body io.Reader) (metrics.List, error) {
+			return decodeWithRules(body, rules)
+		})
+}
+
+func (c *connectorType) Name() string {
+	return c.name
+}
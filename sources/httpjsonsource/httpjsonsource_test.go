@@ -0,0 +1,264 @@
+package httpjsonsource_test
+
+import (
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/scotty/sources"
+	"github.com/Symantec/scotty/sources/httpjsonsource"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	kDocument = `
+{
+	"version": "1.4.2",
+	"up": true,
+	"uptime": 3600.5,
+	"connections": {"active": 12, "total": "18446744073709551615"},
+	"queues": {
+		"orders": {"depth": 7, "consumers": 2},
+		"billing/eu": {"depth": 0, "consumers": null}
+	},
+	"backends": [
+		{"name": "web01", "weight": 1.0},
+		{"name": "web02", "weight": 2.5}
+	]
+}`
+)
+
+var (
+	kRules = []httpjsonsource.Rule{
+		{Field: "$.version", Path: "/version"},
+		{Field: "up", Path: "/up"},
+		{
+			Field: "$.uptime",
+			Path:  "/uptime",
+			Unit:  units.Second,
+		},
+		{
+			Field:       "$.connections.active",
+			Path:        "/connections/active",
+			Kind:        types.Int64,
+			Description: "Active connections",
+		},
+		{
+			Field: `$["connections"]['total']`,
+			Path:  "/connections/total",
+			Kind:  types.Uint64,
+		},
+		{
+			Field: "$.queues.*.depth",
+			Path:  "/queues/{1}/depth",
+			Kind:  types.Uint64,
+		},
+		{
+			Field: "$.queues[*].consumers",
+			Path:  "/queues/{1}/consumers",
+			Kind:  types.Uint64,
+		},
+		{
+			Field: "$.backends[*].weight",
+			Path:  "/backends/{1}/weight",
+			Kind:  types.Int64,
+		},
+		{
+			Field: "$.backends[1].name",
+			Path:  "/backends/last/name",
+		},
+		{
+			Field: "$.missing.field",
+			Path:  "/missing",
+		},
+	}
+)
+
+func TestDecode(t *testing.T) {
+	list, err := httpjsonsource.Decode(strings.NewReader(kDocument), kRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := metrics.SimpleList{
+		{
+			Path:  "/backends/0/weight",
+			Unit:  units.None,
+			Value: int64(1),
+		},
+		{
+			Path:  "/backends/last/name",
+			Unit:  units.None,
+			Value: "web02",
+		},
+		{
+			Path:        "/connections/active",
+			Description: "Active connections",
+			Unit:        units.None,
+			Value:       int64(12),
+		},
+		{
+			Path:  "/connections/total",
+			Unit:  units.None,
+			Value: uint64(18446744073709551615),
+		},
+		{
+			Path:  "/queues/billing_eu/depth",
+			Unit:  units.None,
+			Value: uint64(0),
+		},
+		{
+			Path:  "/queues/orders/consumers",
+			Unit:  units.None,
+			Value: uint64(2),
+		},
+		{
+			Path:  "/queues/orders/depth",
+			Unit:  units.None,
+			Value: uint64(7),
+		},
+		{
+			Path:  "/up",
+			Unit:  units.None,
+			Value: true,
+		},
+		{
+			Path:  "/uptime",
+			Unit:  units.Second,
+			Value: 3600.5,
+		},
+		{
+			Path:  "/version",
+			Unit:  units.None,
+			Value: "1.4.2",
+		},
+	}
+	if !reflect.DeepEqual(expected, list) {
+		t.Errorf("Expected %v, got %v", expected, list)
+	}
+	if err := metrics.VerifyList(list); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, rule := range []httpjsonsource.Rule{
+		{Field: "$.a..b", Path: "/a"},
+		{Field: "$.a[", Path: "/a"},
+		{Field: "$.a[-1]", Path: "/a"},
+		{Field: `$["a]`, Path: "/a"},
+		{Field: "$.a", Path: "a"},
+		{Field: "$.a", Path: "/a/{1}"},
+		{Field: "$.*", Path: "/a/{1"},
+		{Field: "$.a", Path: "/a", Kind: types.Dist},
+	} {
+		_, err := httpjsonsource.Decode(
+			strings.NewReader(kDocument), []httpjsonsource.Rule{rule})
+		if err == nil {
+			t.Errorf("Expected error for %v", rule)
+		}
+	}
+	// Two fields map to the same path
+	_, err := httpjsonsource.Decode(
+		strings.NewReader(kDocument),
+		[]httpjsonsource.Rule{
+			{Field: "$.queues.*.depth", Path: "/depth"},
+		})
+	if err == nil {
+		t.Error("Expected duplicate path error")
+	}
+	_, err = httpjsonsource.Decode(strings.NewReader("{"), kRules)
+	if err == nil {
+		t.Error("Expected error for malformed JSON")
+	}
+}
+
+func TestConnector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/admin/stats" {
+				http.NotFound(w, r)
+				return
+			}
+			username, password, ok := r.BasicAuth()
+			if !ok || username != "scotty" || password != "secret" ||
+				r.Header.Get("X-Api-Version") != "2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, kDocument)
+		}))
+	defer server.Close()
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &httpjsonsource.Config{
+		URL:      "{{.Scheme}}://{{.Host}}:{{.Port}}/admin/stats",
+		Headers:  map[string]string{"X-Api-Version": "2"},
+		Username: "scotty",
+		Password: "secret",
+		Rules: []httpjsonsource.Rule{
+			{Field: "$.up", Path: "/up"},
+		},
+	}
+	conn, err := httpjsonsource.NewConnector("queueadmin", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Name() != "queueadmin" {
+		t.Errorf("Expected queueadmin, got %s", conn.Name())
+	}
+	expected := metrics.SimpleList{{Path: "/up", Unit: units.None, Value: true}}
+	if actual := poll(t, conn, host, uint(port)); !reflect.DeepEqual(
+		expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+
+	config.Password = "wrong"
+	conn, err = httpjsonsource.NewConnector("queueadmin", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poller, err := conn.Connect(host, uint(port), sources.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer poller.Close()
+	if _, err := poller.Poll(); err == nil {
+		t.Error("Expected error for bad password")
+	}
+
+	for _, bad := range []string{"", "{{.Host", "{{.Bogus}}"} {
+		conn, err := httpjsonsource.NewConnector(
+			"bad", &httpjsonsource.Config{URL: bad})
+		if err == nil {
+			_, err = conn.Connect(host, uint(port), sources.Config{})
+		}
+		if err == nil {
+			t.Errorf("Expected error for URL %q", bad)
+		}
+	}
+}
+
+func poll(t *testing.T, conn sources.Connector, host string, port uint) (
+	result metrics.List) {
+	poller, err := conn.Connect(host, port, sources.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer poller.Close()
+	if result, err = poller.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
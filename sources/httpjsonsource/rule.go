@@ -0,0 +1,363 @@
+package httpjsonsource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This file contains the code for compiling rules and applying them to
+// JSON documents.
+
+type stepKindType int
+
+const (
+	kMember stepKindType = iota
+	kIndex
+	kWildcard
+)
+
+// stepType is one step of a field e.g ".queues", "[0]" or "[*]"
+type stepType struct {
+	kind  stepKindType
+	name  string
+	index int
+}
+
+// pathPartType is a part of a metric path template: either literal text
+// or the capture of a wildcard.
+type pathPartType struct {
+	literal string
+	// 0-based index of the wildcard capture or -1 for literal text.
+	capture int
+}
+
+type ruleType struct {
+	steps       []stepType
+	path        []pathPartType
+	kind        types.Type
+	unit        units.Unit
+	description string
+}
+
+func compileRules(rules []Rule) ([]*ruleType, error) {
+	result := make([]*ruleType, len(rules))
+	for i := range rules {
+		var err error
+		if result[i], err = compileRule(&rules[i]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func compileRule(rule *Rule) (*ruleType, error) {
+	switch rule.Kind {
+	case types.Unknown, types.Bool, types.Int64, types.Uint64,
+		types.Float64, types.String:
+	default:
+		return nil, fmt.Errorf(
+			"httpjsonsource: Unsupported kind %q for %s",
+			rule.Kind, rule.Field)
+	}
+	steps, err := parseField(rule.Field)
+	if err != nil {
+		return nil, err
+	}
+	wildcardCount := 0
+	for _, step := range steps {
+		if step.kind == kWildcard {
+			wildcardCount++
+		}
+	}
+	path, err := parsePath(rule.Path, wildcardCount)
+	if err != nil {
+		return nil, err
+	}
+	unit := rule.Unit
+	if unit == units.Unknown {
+		unit = units.None
+	}
+	return &ruleType{
+		steps:       steps,
+		path:        path,
+		kind:        rule.Kind,
+		unit:        unit,
+		description: rule.Description,
+	}, nil
+}
+
+func parseField(field string) (result []stepType, err error) {
+	s := field
+	if strings.HasPrefix(s, "$") {
+		s = s[1:]
+	} else if s != "" && s[0] != '.' && s[0] != '[' {
+		s = "." + s
+	}
+	for s != "" {
+		var step stepType
+		var ok bool
+		switch s[0] {
+		case '.':
+			step, s, ok = parseDotStep(s[1:])
+		case '[':
+			step, s, ok = parseBracketStep(s[1:])
+		}
+		if !ok {
+			return nil, fmt.Errorf("httpjsonsource: Malformed field %q", field)
+		}
+		result = append(result, step)
+	}
+	return
+}
+
+func parseDotStep(s string) (step stepType, rest string, ok bool) {
+	end := strings.IndexAny(s, ".[")
+	if end == -1 {
+		end = len(s)
+	}
+	name := s[:end]
+	if name == "" {
+		return
+	}
+	if name == "*" {
+		return stepType{kind: kWildcard}, s[end:], true
+	}
+	return stepType{kind: kMember, name: name}, s[end:], true
+}
+
+func parseBracketStep(s string) (step stepType, rest string, ok bool) {
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		end := strings.IndexByte(s[1:], s[0])
+		if end == -1 || !strings.HasPrefix(s[end+2:], "]") {
+			return
+		}
+		return stepType{kind: kMember, name: s[1 : end+1]}, s[end+3:], true
+	}
+	end := strings.IndexByte(s, ']')
+	if end == -1 {
+		return
+	}
+	if s[:end] == "*" {
+		return stepType{kind: kWildcard}, s[end+1:], true
+	}
+	index, err := strconv.Atoi(s[:end])
+	if err != nil || index < 0 {
+		return
+	}
+	return stepType{kind: kIndex, index: index}, s[end+1:], true
+}
+
+func parsePath(path string, wildcardCount int) (
+	result []pathPartType, err error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf(
+			"httpjsonsource: Path %q must start with /", path)
+	}
+	s := path
+	for s != "" {
+		start := strings.IndexByte(s, '{')
+		if start == -1 {
+			result = append(result, pathPartType{literal: s, capture: -1})
+			break
+		}
+		if start > 0 {
+			result = append(
+				result, pathPartType{literal: s[:start], capture: -1})
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf(
+				"httpjsonsource: Unclosed { in path %q", path)
+		}
+		n, err := strconv.Atoi(s[start+1 : start+end])
+		if err != nil || n < 1 || n > wildcardCount {
+			return nil, fmt.Errorf(
+				"httpjsonsource: Bad wildcard reference %s in path %q",
+				s[start:start+end+1], path)
+		}
+		result = append(result, pathPartType{capture: n - 1})
+		s = s[start+end+1:]
+	}
+	return
+}
+
+// apply calls emit with the metric path and value of each field in
+// document that r selects.
+func (r *ruleType) apply(
+	document interface{}, emit func(path string, value interface{})) {
+	r.walk(document, r.steps, nil, emit)
+}
+
+func (r *ruleType) walk(
+	value interface{},
+	steps []stepType,
+	captures []string,
+	emit func(path string, value interface{})) {
+	if len(steps) == 0 {
+		if converted, ok := convert(r.kind, value); ok {
+			emit(r.metricPath(captures), converted)
+		}
+		return
+	}
+	step := steps[0]
+	switch v := value.(type) {
+	case map[string]interface{}:
+		switch step.kind {
+		case kMember:
+			if member, ok := v[step.name]; ok {
+				r.walk(member, steps[1:], captures, emit)
+			}
+		case kWildcard:
+			names := make([]string, 0, len(v))
+			for name := range v {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				r.walk(v[name], steps[1:], append(captures, name), emit)
+			}
+		}
+	case []interface{}:
+		switch step.kind {
+		case kIndex:
+			if step.index < len(v) {
+				r.walk(v[step.index], steps[1:], captures, emit)
+			}
+		case kWildcard:
+			for i := range v {
+				r.walk(
+					v[i], steps[1:], append(captures, strconv.Itoa(i)), emit)
+			}
+		}
+	}
+}
+
+func (r *ruleType) metricPath(captures []string) string {
+	parts := make([]string, len(r.path))
+	for i, part := range r.path {
+		if part.capture == -1 {
+			parts[i] = part.literal
+			continue
+		}
+		capture := strings.Replace(captures[part.capture], "/", "_", -1)
+		if capture == "" {
+			capture = "_"
+		}
+		parts[i] = capture
+	}
+	return strings.Join(parts, "")
+}
+
+// convert converts a decoded JSON value to given kind. ok is false if
+// value cannot be converted.
+func convert(kind types.Type, value interface{}) (
+	result interface{}, ok bool) {
+	var text string
+	switch v := value.(type) {
+	case json.Number:
+		text = v.String()
+	case string:
+		text = v
+	case bool:
+		switch kind {
+		case types.Unknown, types.Bool:
+			return v, true
+		case types.String:
+			return strconv.FormatBool(v), true
+		}
+		return nil, false
+	default:
+		return nil, false
+	}
+	_, isNumber := value.(json.Number)
+	switch kind {
+	case types.Unknown:
+		if !isNumber {
+			return text, true
+		}
+		return parseFloat(text)
+	case types.String:
+		return text, true
+	case types.Bool:
+		if isNumber {
+			return nil, false
+		}
+		b, err := strconv.ParseBool(text)
+		return b, err == nil
+	case types.Float64:
+		return parseFloat(text)
+	case types.Int64:
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i, true
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil || f != math.Trunc(f) ||
+			f < math.MinInt64 || f >= math.MaxInt64 {
+			return nil, false
+		}
+		return int64(f), true
+	case types.Uint64:
+		if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return u, true
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil || f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return nil, false
+		}
+		return uint64(f), true
+	}
+	return nil, false
+}
+
+func parseFloat(text string) (interface{}, bool) {
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, false
+	}
+	return f, true
+}
+
+func decode(r io.Reader, rules []Rule) (metrics.List, error) {
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	return decodeWithRules(r, compiled)
+}
+
+func decodeWithRules(r io.Reader, rules []*ruleType) (
+	metrics.List, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	var result metrics.SimpleList
+	for _, rule := range rules {
+		rule.apply(document, func(path string, value interface{}) {
+			result = append(result, metrics.Value{
+				Path:        path,
+				Description: rule.description,
+				Unit:        rule.unit,
+				Value:       value,
+			})
+		})
+	}
+	result = result.Sorted()
+	for i := 1; i < len(result); i++ {
+		if result[i].Path == result[i-1].Path {
+			return nil, fmt.Errorf(
+				"httpjsonsource: Duplicate metric path %s", result[i].Path)
+		}
+	}
+	return result, nil
+}
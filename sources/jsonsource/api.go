@@ -2,9 +2,17 @@
 package jsonsource
 
 import (
+	"github.com/Symantec/scotty/metrics"
 	"github.com/Symantec/scotty/sources"
+	"io"
 )
 
 func GetConnector() sources.Connector {
 	return kConnector
 }
+
+// Decode reads metrics from r encoded as a JSON messages.MetricList,
+// the same format that applications serve at /metricsapi.
+func Decode(r io.Reader) (metrics.List, error) {
+	return decode(r)
+}
@@ -1,7 +1,7 @@
 package jsonsource
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +9,7 @@ import (
 	"github.com/Symantec/scotty/sources"
 	"github.com/Symantec/tricorder/go/tricorder/messages"
 	"github.com/Symantec/tricorder/go/tricorder/types"
+	"io"
 	"net/http"
 	"reflect"
 	"time"
@@ -20,38 +21,35 @@ var (
 	kConnector = connectorType(0)
 )
 
+var (
+	errNullMetric = errors.New("jsonsource: null metric")
+)
+
 func (c connectorType) Connect(
 	host string, port uint, config sources.Config) (sources.Poller, error) {
+	return c.ConnectContext(context.Background(), host, port, config)
+}
+
+func (c connectorType) ConnectContext(
+	ctx context.Context, host string, port uint, config sources.Config) (
+	sources.Poller, error) {
 	// TODO: Use TLS.
+	scheme := "http"
 	if config.IsTls {
-		url := fmt.Sprintf("https://%s:%d/metricsapi", host, port)
-		client := &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
-		response, err := client.Get(url)
-		if err != nil {
-			return nil, err
-		}
-		return &pollerType{response: response}, nil
-	} else {
-		url := fmt.Sprintf("http://%s:%d/metricsapi", host, port)
-		var client http.Client
-		response, err := client.Get(url)
-		if err != nil {
-			return nil, err
-		}
-		return &pollerType{response: response}, nil
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s:%d/metricsapi", scheme, host, port)
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
 	}
+	return sources.ConnectHTTP(ctx, request, config.IsTls, decode)
 }
 
 func (c connectorType) Name() string {
 	return "json"
 }
 
-type pollerType struct {
-	response *http.Response
-}
-
 type genericMetric struct {
 	Value json.RawMessage `json:"value"`
 	messages.Metric
@@ -95,16 +93,16 @@ func (g *genericMetric) ConvertToGoRPC() (err error) {
 	return
 }
 
-func (p *pollerType) Poll() (result metrics.List, err error) {
-	if p.response.StatusCode != 200 {
-		return nil, errors.New(p.response.Status)
-	}
-	decoder := json.NewDecoder(p.response.Body)
+func decode(r io.Reader) (result metrics.List, err error) {
+	decoder := json.NewDecoder(r)
 	var values genericMetricList
 	if err = decoder.Decode(&values); err != nil {
 		return
 	}
 	for _, v := range values {
+		if v == nil {
+			return nil, errNullMetric
+		}
 		if err = v.ConvertToGoRPC(); err != nil {
 			return
 		}
@@ -113,10 +111,6 @@ func (p *pollerType) Poll() (result metrics.List, err error) {
 	return
 }
 
-func (p *pollerType) Close() error {
-	return p.response.Body.Close()
-}
-
 type genericMetricList []*genericMetric
 
 func (l genericMetricList) Len() int {
@@ -0,0 +1,75 @@
+package jsonsource_test
+
+import (
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/scotty/sources/jsonsource"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	kMetricList = `[
+	{
+		"path": "/proc/args",
+		"description": "Program args",
+		"unit": "None",
+		"kind": "string",
+		"value": "-port=6910"
+	},
+	{
+		"path": "/proc/load",
+		"description": "Load average",
+		"unit": "None",
+		"kind": "float64",
+		"value": 1.5,
+		"groupId": 1
+	},
+	{
+		"path": "/proc/threads",
+		"description": "Thread count",
+		"unit": "None",
+		"kind": "uint32",
+		"value": 7
+	}
+]`
+)
+
+func TestDecode(t *testing.T) {
+	list, err := jsonsource.Decode(strings.NewReader(kMetricList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := metrics.VerifyList(list); err != nil {
+		t.Error(err)
+	}
+	actual := make(metrics.SimpleList, list.Len())
+	for i := range actual {
+		list.Index(i, &actual[i])
+	}
+	if len(actual) != 3 {
+		t.Fatalf("Expected 3 metrics, got %v", actual)
+	}
+	expected := metrics.Value{
+		Path:        "/proc/args",
+		Description: "Program args",
+		Unit:        units.None,
+		Value:       "-port=6910",
+	}
+	if !reflect.DeepEqual(expected, actual[0]) {
+		t.Errorf("Expected %v, got %v", expected, actual[0])
+	}
+	if actual[1].GroupId != 1 || actual[1].Value != 1.5 {
+		t.Errorf("Unexpected %v", actual[1])
+	}
+	if actual[2].Value != uint32(7) {
+		t.Errorf("Expected uint32 7, got %#v", actual[2].Value)
+	}
+	if _, err := jsonsource.Decode(strings.NewReader(`[{"path": `)); err == nil {
+		t.Error("Expected error")
+	}
+	if _, err := jsonsource.Decode(strings.NewReader(`[null]`)); err == nil {
+		t.Error("Expected error for null metric")
+	}
+}
@@ -1,6 +1,7 @@
 package loadsource
 
 import (
+	"context"
 	"fmt"
 	"github.com/Symantec/scotty/metrics"
 	"github.com/Symantec/scotty/sources"
@@ -18,6 +19,15 @@ func (c connectorType) Connect(
 	return &pollerType{c.config}, nil
 }
 
+func (c connectorType) ConnectContext(
+	ctx context.Context, host string, port uint, config sources.Config) (
+	sources.Poller, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Connect(host, port, config)
+}
+
 func (c connectorType) Name() string {
 	return "load test"
 }
@@ -41,6 +51,13 @@ func (p *pollerType) Poll() (metrics.List, error) {
 	return result, nil
 }
 
+func (p *pollerType) PollContext(ctx context.Context) (metrics.List, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.Poll()
+}
+
 func (p *pollerType) Close() error {
 	return nil
 }
@@ -0,0 +1,22 @@
+// Package promsource connects to sources using the Prometheus text
+// exposition format.
+//
+// Each sample becomes a metric whose path is the metric name followed by
+// the name and value of each label sorted by label name. For instance,
+// http_requests_total{method="get",code="200"} becomes
+// /http_requests_total/code/200/method/get. Histograms become
+// distributions; the _bucket, _sum and _count samples of a histogram
+// make up one distribution whose path omits the le label. Summary
+// quantiles, _sum and _count samples become separate metrics like any
+// other sample. Timestamps in the exposition are ignored as are NaN
+// values.
+package promsource
+
+import (
+	"github.com/Symantec/scotty/sources"
+)
+
+// GetConnector returns the connector that reads /metrics.
+func GetConnector() sources.Connector {
+	return kConnector
+}
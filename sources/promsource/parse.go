@@ -0,0 +1,446 @@
+package promsource
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This file contains the code for parsing the Prometheus text exposition
+// format. It also accepts the OpenMetrics text format.
+
+const (
+	kMaxLineLength = 1024 * 1024
+)
+
+var (
+	// Suffixes that samples add to the name of their metric family.
+	kFamilySuffixes = []string{"_bucket", "_count", "_sum", "_total", "_created"}
+)
+
+// familyType describes a metric family from its HELP and TYPE lines.
+type familyType struct {
+	Help string
+	Type string
+}
+
+type labelType struct {
+	Name  string
+	Value string
+}
+
+type sampleType struct {
+	Name   string
+	Labels []labelType
+	Value  float64
+}
+
+type bucketType struct {
+	Upper float64
+	// Cumulative count
+	Count float64
+}
+
+// histogramType collects the samples of a single histogram.
+type histogramType struct {
+	Path        string
+	Description string
+	Unit        units.Unit
+	Buckets     []bucketType
+	Sum         float64
+	Count       float64
+	HasCount    bool
+	// The _sum and _count samples as plain metrics for when there are
+	// no buckets.
+	Plain []metrics.Value
+}
+
+// Distribution converts this instance to a distribution. ok is false if
+// this instance has no buckets or its bucket counts decrease.
+// The first range of the distribution has no lower bound. So that its
+// Lower never exceeds its Upper, Lower is 0 or Upper whichever is less.
+func (h *histogramType) Distribution() (
+	result *messages.Distribution, ok bool) {
+	if len(h.Buckets) == 0 {
+		return
+	}
+	sort.Slice(h.Buckets, func(i, j int) bool {
+		return h.Buckets[i].Upper < h.Buckets[j].Upper
+	})
+	ranges := make([]*messages.RangeWithCount, 0, len(h.Buckets)+1)
+	lower := math.Min(0, h.Buckets[0].Upper)
+	var previous float64
+	for _, bucket := range h.Buckets {
+		if bucket.Count < previous {
+			return
+		}
+		if math.IsInf(bucket.Upper, 1) {
+			break
+		}
+		ranges = append(ranges, &messages.RangeWithCount{
+			Lower: lower,
+			Upper: bucket.Upper,
+			Count: uint64(bucket.Count - previous),
+		})
+		lower = bucket.Upper
+		previous = bucket.Count
+	}
+	// The +Inf bucket and _count agree if both are present.
+	total := h.Buckets[len(h.Buckets)-1].Count
+	if h.HasCount {
+		total = h.Count
+	}
+	if total < previous {
+		return
+	}
+	// Last bucket has no upper bound
+	ranges = append(ranges, &messages.RangeWithCount{
+		Lower: lower,
+		Count: uint64(total - previous),
+	})
+	result = &messages.Distribution{
+		Sum:    h.Sum,
+		Count:  uint64(total),
+		Ranges: ranges,
+	}
+	if total > 0 {
+		result.Average = h.Sum / total
+	}
+	return result, true
+}
+
+// parserType builds a metrics.List from the lines of an exposition.
+type parserType struct {
+	families   map[string]*familyType
+	histograms map[string]*histogramType
+	paths      map[string]bool
+	result     metrics.SimpleList
+}
+
+func newParser() *parserType {
+	return &parserType{
+		families:   make(map[string]*familyType),
+		histograms: make(map[string]*histogramType),
+		paths:      make(map[string]bool),
+	}
+}
+
+// Comment handles a line starting with '#'. Comment returns false if the
+// line marks the end of the exposition.
+func (p *parserType) Comment(line string) bool {
+	line = strings.TrimSpace(line[1:])
+	if line == "EOF" {
+		return false
+	}
+	keyword, rest := splitField(line)
+	if keyword != "HELP" && keyword != "TYPE" {
+		return true
+	}
+	name, text := splitField(rest)
+	family := p.families[name]
+	if family == nil {
+		family = &familyType{}
+		p.families[name] = family
+	}
+	if keyword == "HELP" {
+		family.Help = unescapeHelp(text)
+	} else {
+		family.Type = text
+	}
+	return true
+}
+
+// Add adds a sample.
+func (p *parserType) Add(sample *sampleType) {
+	familyName, family := p.family(sample.Name)
+	if family == nil {
+		family = &familyType{}
+	}
+	if family.Type == "histogram" {
+		p.addToHistogram(familyName, family, sample)
+		return
+	}
+	// OpenMetrics creation times are not metric values
+	if familyName != sample.Name && strings.HasSuffix(sample.Name, "_created") {
+		return
+	}
+	if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+		return
+	}
+	p.addValue(&metrics.Value{
+		Path:        metricPath(sample.Name, sample.Labels),
+		Description: family.Help,
+		Unit:        unitOf(familyName),
+		Value:       sample.Value,
+	})
+}
+
+// List returns the metrics added so far sorted by path. Histograms
+// without buckets become their _sum and _count metrics.
+func (p *parserType) List() metrics.List {
+	for _, histogram := range p.histograms {
+		if len(histogram.Buckets) == 0 {
+			for i := range histogram.Plain {
+				p.addValue(&histogram.Plain[i])
+			}
+			continue
+		}
+		if dist, ok := histogram.Distribution(); ok {
+			p.addValue(&metrics.Value{
+				Path:        histogram.Path,
+				Description: histogram.Description,
+				Unit:        histogram.Unit,
+				Value:       dist,
+			})
+		}
+	}
+	return p.result.Sorted()
+}
+
+// family returns the metric family of the sample with given name.
+// family is nil if the sample belongs to no family with a HELP or TYPE
+// line.
+func (p *parserType) family(name string) (
+	familyName string, family *familyType) {
+	if family = p.families[name]; family != nil {
+		return name, family
+	}
+	for _, suffix := range kFamilySuffixes {
+		if strings.HasSuffix(name, suffix) {
+			familyName = strings.TrimSuffix(name, suffix)
+			if family = p.families[familyName]; family != nil {
+				return
+			}
+		}
+	}
+	return name, nil
+}
+
+func (p *parserType) addToHistogram(
+	familyName string, family *familyType, sample *sampleType) {
+	var le string
+	labels := make([]labelType, 0, len(sample.Labels))
+	for _, label := range sample.Labels {
+		if label.Name == "le" {
+			le = label.Value
+		} else {
+			labels = append(labels, label)
+		}
+	}
+	path := metricPath(familyName, labels)
+	histogram := p.histograms[path]
+	if histogram == nil {
+		histogram = &histogramType{
+			Path:        path,
+			Description: family.Help,
+			Unit:        unitOf(familyName),
+		}
+		p.histograms[path] = histogram
+	}
+	switch strings.TrimPrefix(sample.Name, familyName) {
+	case "_bucket":
+		if upper, err := strconv.ParseFloat(le, 64); err == nil {
+			histogram.Buckets = append(
+				histogram.Buckets,
+				bucketType{Upper: upper, Count: sample.Value})
+		}
+	case "_sum":
+		histogram.Sum = sample.Value
+		histogram.addPlain(sample)
+	case "_count":
+		histogram.Count = sample.Value
+		histogram.HasCount = true
+		histogram.addPlain(sample)
+	}
+}
+
+// addPlain adds the _sum or _count sample of this histogram as a plain
+// metric.
+func (h *histogramType) addPlain(sample *sampleType) {
+	if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+		return
+	}
+	h.Plain = append(h.Plain, metrics.Value{
+		Path:        metricPath(sample.Name, sample.Labels),
+		Description: h.Description,
+		Unit:        h.Unit,
+		Value:       sample.Value,
+	})
+}
+
+// addValue adds value unless a value with the same path was already added.
+func (p *parserType) addValue(value *metrics.Value) {
+	if p.paths[value.Path] {
+		return
+	}
+	p.paths[value.Path] = true
+	p.result = append(p.result, *value)
+}
+
+// parse reads the exposition in r.
+func parse(r io.Reader) (metrics.List, error) {
+	parser := newParser()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, kMaxLineLength)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if !parser.Comment(line) {
+				break
+			}
+			continue
+		}
+		sample, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		parser.Add(sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parser.List(), nil
+}
+
+// parseSample parses a line such as
+// http_requests_total{method="get",code="200"} 1027 1395066363000
+// ignoring the timestamp and any exemplar.
+func parseSample(line string) (*sampleType, error) {
+	nameEnd := strings.IndexAny(line, "{ \t")
+	if nameEnd <= 0 {
+		return nil, errors.New("Missing metric name or value")
+	}
+	result := &sampleType{Name: line[:nameEnd]}
+	rest := line[nameEnd:]
+	if strings.HasPrefix(rest, "{") {
+		var err error
+		if result.Labels, rest, err = parseLabels(rest[1:]); err != nil {
+			return nil, err
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return nil, errors.New("Missing metric value")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, err
+	}
+	result.Value = value
+	return result, nil
+}
+
+// parseLabels parses the labels in s which starts just after the opening
+// brace. parseLabels returns what follows the closing brace.
+func parseLabels(s string) (labels []labelType, rest string, err error) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			return labels, s[1:], nil
+		}
+		eqIdx := strings.IndexByte(s, '=')
+		if eqIdx <= 0 {
+			return nil, "", errors.New("Malformed label")
+		}
+		name := strings.TrimSpace(s[:eqIdx])
+		s = strings.TrimLeft(s[eqIdx+1:], " \t")
+		if !strings.HasPrefix(s, `"`) {
+			return nil, "", errors.New("Label value must be quoted")
+		}
+		value, length, err := unquote(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		labels = append(labels, labelType{Name: name, Value: value})
+		s = strings.TrimLeft(s[1+length:], " \t")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "}") {
+			return nil, "", errors.New("Expected , or } after label")
+		}
+	}
+}
+
+// unquote returns the label value at the start of s up to the closing
+// quote and how many bytes of s it used including the closing quote.
+func unquote(s string) (value string, length int, err error) {
+	var buffer []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return string(buffer), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", 0, errors.New("Unterminated label value")
+			}
+			if s[i] == 'n' {
+				buffer = append(buffer, '\n')
+			} else {
+				buffer = append(buffer, s[i])
+			}
+		default:
+			buffer = append(buffer, s[i])
+		}
+	}
+	return "", 0, errors.New("Unterminated label value")
+}
+
+func unescapeHelp(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(s)
+}
+
+// splitField splits s into its first space separated field and the rest.
+func splitField(s string) (field, rest string) {
+	idx := strings.IndexAny(s, " \t")
+	if idx == -1 {
+		return s, ""
+	}
+	return s[:idx], strings.TrimSpace(s[idx+1:])
+}
+
+// metricPath returns the path for the metric with given name and labels.
+// Labels with empty values are the same as no label at all.
+func metricPath(name string, labels []labelType) string {
+	sorted := make([]labelType, len(labels))
+	copy(sorted, labels)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	parts := []string{"", name}
+	for _, label := range sorted {
+		if label.Value == "" {
+			continue
+		}
+		parts = append(
+			parts,
+			label.Name,
+			strings.Replace(label.Value, "/", "_", -1))
+	}
+	return strings.Join(parts, "/")
+}
+
+// unitOf guesses the unit of a metric family from its name following
+// Prometheus naming conventions.
+func unitOf(familyName string) units.Unit {
+	name := strings.TrimSuffix(familyName, "_total")
+	switch {
+	case strings.HasSuffix(name, "_seconds"):
+		return units.Second
+	case strings.HasSuffix(name, "_bytes"):
+		return units.Byte
+	default:
+		return units.None
+	}
+}
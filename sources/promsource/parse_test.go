@@ -0,0 +1,183 @@
+package promsource
+
+import (
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	kExposition = `
+# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{code="400",method="post"} 3 1395066363000
+
+# A comment
+go_goroutines 42
+# HELP temperature Current temperature with an escaped\\backslash.
+# TYPE temperature gauge
+temperature{path="/var/lib",empty=""} -3.5
+temperature{path="/tmp"} NaN
+temperature{path="/sys"} +Inf
+temperature{path="/proc"} -Inf
+
+# HELP http_request_duration_seconds A histogram of the request duration.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="0.1"} 33444
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+
+# HELP queue_depth A histogram without buckets.
+# TYPE queue_depth histogram
+queue_depth_sum{queue="a"} 12
+queue_depth_count{queue="a"} 3
+
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+# EOF
+ignored 1
+`
+)
+
+func parseString(t *testing.T, s string) metrics.SimpleList {
+	list, err := parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(metrics.SimpleList, list.Len())
+	for i := range result {
+		list.Index(i, &result[i])
+	}
+	return result
+}
+
+func TestParse(t *testing.T) {
+	actual := parseString(t, kExposition)
+	expected := metrics.SimpleList{
+		{
+			Path:  "/go_goroutines",
+			Unit:  units.None,
+			Value: 42.0,
+		},
+		{
+			Path:        "/http_request_duration_seconds",
+			Description: "A histogram of the request duration.",
+			Unit:        units.Second,
+			Value: &messages.Distribution{
+				Sum:     53423,
+				Count:   144320,
+				Average: 53423.0 / 144320.0,
+				Ranges: []*messages.RangeWithCount{
+					{Lower: 0, Upper: 0.05, Count: 24054},
+					{Lower: 0.05, Upper: 0.1, Count: 9390},
+					{Lower: 0.1, Count: 110876},
+				},
+			},
+		},
+		{
+			Path:        "/http_requests_total/code/200/method/post",
+			Description: "The total number of HTTP requests.",
+			Unit:        units.None,
+			Value:       1027.0,
+		},
+		{
+			Path:        "/http_requests_total/code/400/method/post",
+			Description: "The total number of HTTP requests.",
+			Unit:        units.None,
+			Value:       3.0,
+		},
+		{
+			Path:        "/queue_depth_count/queue/a",
+			Description: "A histogram without buckets.",
+			Unit:        units.None,
+			Value:       3.0,
+		},
+		{
+			Path:        "/queue_depth_sum/queue/a",
+			Description: "A histogram without buckets.",
+			Unit:        units.None,
+			Value:       12.0,
+		},
+		{
+			Path:  "/rpc_duration_seconds/quantile/0.5",
+			Unit:  units.Second,
+			Value: 4773.0,
+		},
+		{
+			Path:  "/rpc_duration_seconds_count",
+			Unit:  units.Second,
+			Value: 2693.0,
+		},
+		{
+			Path:  "/rpc_duration_seconds_sum",
+			Unit:  units.Second,
+			Value: 1.7560473e+07,
+		},
+		{
+			Path:        "/temperature/path/_var_lib",
+			Description: "Current temperature with an escaped\\backslash.",
+			Unit:        units.None,
+			Value:       -3.5,
+		},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if err := metrics.VerifyList(actual); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	badLines := []string{
+		`foo`,
+		`foo{bar="baz"}`,
+		`foo{bar="baz} 1`,
+		`foo{bar=baz} 1`,
+		`foo{bar="baz" qux="x"} 1`,
+		`foo abc`,
+	}
+	for _, line := range badLines {
+		if _, err := parse(strings.NewReader(line)); err == nil {
+			t.Errorf("Expected error parsing %q", line)
+		}
+	}
+}
+
+func TestParseNegativeBuckets(t *testing.T) {
+	actual := parseString(t, `
+# TYPE temperature_celsius histogram
+temperature_celsius_bucket{le="-10"} 2
+temperature_celsius_bucket{le="0"} 5
+temperature_celsius_bucket{le="+Inf"} 9
+temperature_celsius_sum 30
+temperature_celsius_count 9
+`)
+	expected := metrics.SimpleList{
+		{
+			Path: "/temperature_celsius",
+			Unit: units.None,
+			Value: &messages.Distribution{
+				Sum:     30,
+				Count:   9,
+				Average: 30.0 / 9.0,
+				Ranges: []*messages.RangeWithCount{
+					{Lower: -10, Upper: -10, Count: 2},
+					{Lower: -10, Upper: 0, Count: 3},
+					{Lower: 0, Count: 4},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
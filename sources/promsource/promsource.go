@@ -0,0 +1,43 @@
+package promsource
+
+import (
+	"context"
+	"fmt"
+	"github.com/Symantec/scotty/sources"
+	"net/http"
+)
+
+const (
+	kAcceptHeader = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+)
+
+type connectorType int
+
+var (
+	kConnector = connectorType(0)
+)
+
+func (c connectorType) Connect(
+	host string, port uint, config sources.Config) (sources.Poller, error) {
+	return c.ConnectContext(context.Background(), host, port, config)
+}
+
+func (c connectorType) ConnectContext(
+	ctx context.Context, host string, port uint, config sources.Config) (
+	sources.Poller, error) {
+	scheme := "http"
+	if config.IsTls {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s:%d/metrics", scheme, host, port)
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", kAcceptHeader)
+	return sources.ConnectHTTP(ctx, request, config.IsTls, parse)
+}
+
+func (c connectorType) Name() string {
+	return "prometheus"
+}
@@ -1,6 +1,7 @@
 package selfsource
 
 import (
+	"context"
 	"github.com/Symantec/scotty/metrics"
 	"github.com/Symantec/scotty/sources"
 	"github.com/Symantec/tricorder/go/tricorder"
@@ -23,6 +24,15 @@ func (c connectorType) Connect(
 	return kPoller, nil
 }
 
+func (c connectorType) ConnectContext(
+	ctx context.Context, host string, port uint, config sources.Config) (
+	sources.Poller, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Connect(host, port, config)
+}
+
 func (c connectorType) Name() string {
 	return "self"
 }
@@ -34,6 +44,13 @@ func (p pollerType) Poll() (result metrics.List, err error) {
 	return listType(values), nil
 }
 
+func (p pollerType) PollContext(ctx context.Context) (metrics.List, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.Poll()
+}
+
 func (p pollerType) Close() error {
 	return nil
 }
@@ -3,9 +3,97 @@ package snmpsource
 
 import (
 	"github.com/Symantec/scotty/sources"
+	"github.com/Symantec/tricorder/go/tricorder/units"
 )
 
+// Metric describes an OID to read with SNMP v2c and the metric it becomes.
+//
+// Values become metrics according to their SNMP type: INTEGER becomes
+// int64; Counter32 and Gauge32 become uint32; Counter64 becomes uint64;
+// TimeTicks becomes time.Duration; OCTET STRING, OBJECT IDENTIFIER and
+// IpAddress become string.
+type Metric struct {
+	// The OID e.g "1.3.6.1.2.1.1.3.0" for sysUpTime.0
+	OID string `yaml:"oid"`
+	// The metric path e.g "/system/uptime"
+	Path string `yaml:"path"`
+	// Optional. The description of the metric
+	Description string `yaml:"description"`
+	// Optional. The unit of the metric. TimeTicks default to seconds;
+	// everything else defaults to none.
+	Unit units.Unit `yaml:"unit"`
+	// If true, read every OID in the subtree at OID using GETBULK.
+	// The path of each resulting metric is Path followed by the
+	// remaining part of its OID. For instance, if OID is
+	// "1.3.6.1.2.1.2.2.1.10" and Path is "/interfaces/in-octets",
+	// 1.3.6.1.2.1.2.2.1.10.2 becomes "/interfaces/in-octets/2".
+	// If false, read the OID itself using GET.
+	Walk bool `yaml:"walk"`
+}
+
+// DefaultMetrics are the metrics that connectors from NewConnector read:
+// the system uptime and the status and counters of each interface.
+var DefaultMetrics = []Metric{
+	{
+		OID:         "1.3.6.1.2.1.1.3.0",
+		Path:        "/system/uptime",
+		Description: "Time since the network management system started",
+	},
+	{
+		OID:         "1.3.6.1.2.1.1.5.0",
+		Path:        "/system/name",
+		Description: "Administratively assigned name",
+	},
+	{
+		OID:         "1.3.6.1.2.1.2.2.1.2",
+		Path:        "/interfaces/description",
+		Description: "Interface description",
+		Walk:        true,
+	},
+	{
+		OID:         "1.3.6.1.2.1.2.2.1.8",
+		Path:        "/interfaces/oper-status",
+		Description: "Interface status: 1=up, 2=down, 3=testing",
+		Walk:        true,
+	},
+	{
+		OID:         "1.3.6.1.2.1.31.1.1.1.6",
+		Path:        "/interfaces/in-octets",
+		Description: "Bytes received",
+		Unit:        units.Byte,
+		Walk:        true,
+	},
+	{
+		OID:         "1.3.6.1.2.1.31.1.1.1.10",
+		Path:        "/interfaces/out-octets",
+		Description: "Bytes sent",
+		Unit:        units.Byte,
+		Walk:        true,
+	},
+	{
+		OID:         "1.3.6.1.2.1.2.2.1.14",
+		Path:        "/interfaces/in-errors",
+		Description: "Inbound packets with errors",
+		Walk:        true,
+	},
+	{
+		OID:         "1.3.6.1.2.1.2.2.1.20",
+		Path:        "/interfaces/out-errors",
+		Description: "Outbound packets with errors",
+		Walk:        true,
+	},
+}
+
 // NewConnector returns a new connector for the given community.
+// The returned connector reads DefaultMetrics.
 func NewConnector(community string) sources.Connector {
-	return newConnector(community)
+	return newConnector(community, DefaultMetrics)
+}
+
+// NewConnectorWithMetrics returns a new connector for the given community
+// that reads the given metrics. NewConnectorWithMetrics returns an error
+// if an OID is malformed.
+func NewConnectorWithMetrics(community string, metrics []Metric) (
+	sources.Connector, error) {
+	return newConnectorWithMetrics(community, metrics)
 }
@@ -0,0 +1,420 @@
+package snmpsource
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file contains the BER encoding and decoding of SNMP v2c messages.
+
+const (
+	kTagInteger        = 0x02
+	kTagOctetString    = 0x04
+	kTagNull           = 0x05
+	kTagOID            = 0x06
+	kTagSequence       = 0x30
+	kTagIpAddress      = 0x40
+	kTagCounter32      = 0x41
+	kTagGauge32        = 0x42
+	kTagTimeTicks      = 0x43
+	kTagOpaque         = 0x44
+	kTagCounter64      = 0x46
+	kTagNoSuchObject   = 0x80
+	kTagNoSuchInstance = 0x81
+	kTagEndOfMibView   = 0x82
+
+	kPduGet      = 0xa0
+	kPduGetNext  = 0xa1
+	kPduResponse = 0xa2
+	kPduGetBulk  = 0xa5
+
+	kVersion2c = 1
+)
+
+var (
+	errTruncated = errors.New("Truncated SNMP message")
+)
+
+// oidType is an SNMP object identifier.
+type oidType []uint32
+
+// parseOID parses a dotted OID such as "1.3.6.1.2.1.1.3.0". A leading dot
+// is optional.
+func parseOID(s string) (oidType, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("Bad OID: %s", s)
+	}
+	result := make(oidType, len(parts))
+	for i := range parts {
+		x, err := strconv.ParseUint(parts[i], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Bad OID: %s", s)
+		}
+		result[i] = uint32(x)
+	}
+	return result, nil
+}
+
+func (o oidType) String() string {
+	parts := make([]string, len(o))
+	for i := range o {
+		parts[i] = strconv.FormatUint(uint64(o[i]), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// HasPrefix returns true if o is within the subtree at prefix.
+func (o oidType) HasPrefix(prefix oidType) bool {
+	if len(o) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if o[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare returns -1, 0, or 1 if o comes before, is the same as, or comes
+// after other in lexicographic OID order.
+func (o oidType) Compare(other oidType) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		if o[i] < other[i] {
+			return -1
+		}
+		if o[i] > other[i] {
+			return 1
+		}
+	}
+	if len(o) < len(other) {
+		return -1
+	}
+	if len(o) > len(other) {
+		return 1
+	}
+	return 0
+}
+
+// varBindType is a single OID and value in an SNMP PDU.
+type varBindType struct {
+	OID oidType
+	Tag byte
+	// The encoded value without its tag and length
+	Value []byte
+}
+
+// GoValue converts the value in this instance to a value that scotty can
+// store. ok is false if this instance has no value such as when the
+// agent has no such object.
+func (v *varBindType) GoValue() (value interface{}, ok bool, err error) {
+	switch v.Tag {
+	case kTagInteger:
+		value, err = decodeInteger(v.Value)
+	case kTagOctetString:
+		value = string(v.Value)
+	case kTagOID:
+		var oid oidType
+		oid, err = decodeOID(v.Value)
+		value = oid.String()
+	case kTagIpAddress:
+		if len(v.Value) != 4 {
+			return nil, false, errors.New("Bad IP address")
+		}
+		value = fmt.Sprintf(
+			"%d.%d.%d.%d", v.Value[0], v.Value[1], v.Value[2], v.Value[3])
+	case kTagCounter32, kTagGauge32:
+		var x uint64
+		x, err = decodeUnsigned(v.Value, 32)
+		value = uint32(x)
+	case kTagTimeTicks:
+		var x uint64
+		// Hundredths of a second
+		x, err = decodeUnsigned(v.Value, 32)
+		value = time.Duration(x) * 10 * time.Millisecond
+	case kTagCounter64:
+		value, err = decodeUnsigned(v.Value, 64)
+	default:
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// pduType represents an SNMP v2c PDU.
+type pduType struct {
+	Type      byte
+	RequestId int32
+	// Non-repeaters for GetBulk requests
+	ErrorStatus int
+	// Max-repetitions for GetBulk requests
+	ErrorIndex int
+	VarBinds   []varBindType
+}
+
+// messageType represents an SNMP v2c message.
+type messageType struct {
+	Community string
+	PDU       pduType
+}
+
+// Marshal encodes this instance.
+func (m *messageType) Marshal() []byte {
+	var varBinds []byte
+	for _, varBind := range m.PDU.VarBinds {
+		var encoded []byte
+		encoded = appendTLV(encoded, kTagOID, encodeOID(varBind.OID))
+		encoded = appendTLV(encoded, varBind.Tag, varBind.Value)
+		varBinds = appendTLV(varBinds, kTagSequence, encoded)
+	}
+	var pdu []byte
+	pdu = appendTLV(pdu, kTagInteger, encodeInteger(int64(m.PDU.RequestId)))
+	pdu = appendTLV(pdu, kTagInteger, encodeInteger(int64(m.PDU.ErrorStatus)))
+	pdu = appendTLV(pdu, kTagInteger, encodeInteger(int64(m.PDU.ErrorIndex)))
+	pdu = appendTLV(pdu, kTagSequence, varBinds)
+	var message []byte
+	message = appendTLV(message, kTagInteger, encodeInteger(kVersion2c))
+	message = appendTLV(message, kTagOctetString, []byte(m.Community))
+	message = appendTLV(message, m.PDU.Type, pdu)
+	return appendTLV(nil, kTagSequence, message)
+}
+
+// unmarshalMessage decodes an SNMP v2c message.
+func unmarshalMessage(data []byte) (*messageType, error) {
+	message, err := expect(&data, kTagSequence)
+	if err != nil {
+		return nil, err
+	}
+	version, err := expectInteger(&message)
+	if err != nil {
+		return nil, err
+	}
+	if version != kVersion2c {
+		return nil, fmt.Errorf("Unsupported SNMP version: %d", version)
+	}
+	community, err := expect(&message, kTagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	result := &messageType{Community: string(community)}
+	var pdu []byte
+	if result.PDU.Type, pdu, message, err = readTLV(message); err != nil {
+		return nil, err
+	}
+	requestId, err := expectInteger(&pdu)
+	if err != nil {
+		return nil, err
+	}
+	result.PDU.RequestId = int32(requestId)
+	errorStatus, err := expectInteger(&pdu)
+	if err != nil {
+		return nil, err
+	}
+	result.PDU.ErrorStatus = int(errorStatus)
+	errorIndex, err := expectInteger(&pdu)
+	if err != nil {
+		return nil, err
+	}
+	result.PDU.ErrorIndex = int(errorIndex)
+	varBinds, err := expect(&pdu, kTagSequence)
+	if err != nil {
+		return nil, err
+	}
+	for len(varBinds) > 0 {
+		encoded, err := expect(&varBinds, kTagSequence)
+		if err != nil {
+			return nil, err
+		}
+		oidBytes, err := expect(&encoded, kTagOID)
+		if err != nil {
+			return nil, err
+		}
+		var varBind varBindType
+		if varBind.OID, err = decodeOID(oidBytes); err != nil {
+			return nil, err
+		}
+		if varBind.Tag, varBind.Value, _, err = readTLV(encoded); err != nil {
+			return nil, err
+		}
+		result.PDU.VarBinds = append(result.PDU.VarBinds, varBind)
+	}
+	return result, nil
+}
+
+func appendTLV(buffer []byte, tag byte, value []byte) []byte {
+	buffer = append(buffer, tag)
+	length := len(value)
+	if length < 0x80 {
+		buffer = append(buffer, byte(length))
+	} else {
+		var lengthBytes []byte
+		for ; length > 0; length >>= 8 {
+			lengthBytes = append([]byte{byte(length)}, lengthBytes...)
+		}
+		buffer = append(buffer, 0x80|byte(len(lengthBytes)))
+		buffer = append(buffer, lengthBytes...)
+	}
+	return append(buffer, value...)
+}
+
+// readTLV reads the tag, length and value at the start of data. rest is
+// what follows the value.
+func readTLV(data []byte) (tag byte, value, rest []byte, err error) {
+	if len(data) < 2 {
+		err = errTruncated
+		return
+	}
+	tag = data[0]
+	length := int(data[1])
+	data = data[2:]
+	if length&0x80 != 0 {
+		lengthSize := length & 0x7f
+		if lengthSize == 0 || lengthSize > 4 || len(data) < lengthSize {
+			err = errTruncated
+			return
+		}
+		length = 0
+		for _, b := range data[:lengthSize] {
+			length = length<<8 | int(b)
+		}
+		data = data[lengthSize:]
+	}
+	if length < 0 || len(data) < length {
+		err = errTruncated
+		return
+	}
+	return tag, data[:length], data[length:], nil
+}
+
+// expect reads the value with given tag at the start of *data and
+// advances *data past it.
+func expect(data *[]byte, tag byte) ([]byte, error) {
+	actualTag, value, rest, err := readTLV(*data)
+	if err != nil {
+		return nil, err
+	}
+	if actualTag != tag {
+		return nil, fmt.Errorf("Expected tag 0x%x, got 0x%x", tag, actualTag)
+	}
+	*data = rest
+	return value, nil
+}
+
+func expectInteger(data *[]byte) (int64, error) {
+	value, err := expect(data, kTagInteger)
+	if err != nil {
+		return 0, err
+	}
+	return decodeInteger(value)
+}
+
+// encodeInteger encodes x in as few bytes of two's complement as possible.
+func encodeInteger(x int64) []byte {
+	result := []byte{byte(x)}
+	for (x > 0x7f || x < -0x80) && len(result) < 8 {
+		x >>= 8
+		result = append([]byte{byte(x)}, result...)
+	}
+	return result
+}
+
+func decodeInteger(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errors.New("Bad integer")
+	}
+	// Sign extend
+	result := int64(int8(b[0]))
+	for _, x := range b[1:] {
+		result = result<<8 | int64(x)
+	}
+	return result, nil
+}
+
+// encodeUnsigned encodes an unsigned value such as a Counter64.
+func encodeUnsigned(x uint64) []byte {
+	var result []byte
+	for {
+		result = append([]byte{byte(x)}, result...)
+		x >>= 8
+		if x == 0 {
+			break
+		}
+	}
+	// Keep the value from looking negative
+	if result[0]&0x80 != 0 {
+		result = append([]byte{0}, result...)
+	}
+	return result
+}
+
+func decodeUnsigned(b []byte, bits uint) (uint64, error) {
+	if len(b) == 0 || len(b) > int(bits/8)+1 {
+		return 0, errors.New("Bad unsigned integer")
+	}
+	var result uint64
+	for _, x := range b {
+		result = result<<8 | uint64(x)
+	}
+	if bits < 64 && result>>bits != 0 {
+		return 0, errors.New("Unsigned integer too big")
+	}
+	return result, nil
+}
+
+func encodeOID(oid oidType) []byte {
+	var result []byte
+	if len(oid) < 2 {
+		return result
+	}
+	result = appendBase128(result, oid[0]*40+oid[1])
+	for _, x := range oid[2:] {
+		result = appendBase128(result, x)
+	}
+	return result
+}
+
+func appendBase128(buffer []byte, x uint32) []byte {
+	var encoded []byte
+	encoded = append(encoded, byte(x&0x7f))
+	for x >>= 7; x > 0; x >>= 7 {
+		encoded = append([]byte{byte(x&0x7f) | 0x80}, encoded...)
+	}
+	return append(buffer, encoded...)
+}
+
+func decodeOID(b []byte) (oidType, error) {
+	var result oidType
+	var x uint32
+	for i, octet := range b {
+		if x > 0x1ffffff {
+			return nil, errors.New("Bad OID")
+		}
+		x = x<<7 | uint32(octet&0x7f)
+		if octet&0x80 != 0 {
+			if i == len(b)-1 {
+				return nil, errors.New("Bad OID")
+			}
+			continue
+		}
+		if result == nil {
+			if x < 80 {
+				result = oidType{x / 40, x % 40}
+			} else {
+				result = oidType{2, x - 80}
+			}
+		} else {
+			result = append(result, x)
+		}
+		x = 0
+	}
+	if result == nil {
+		return nil, errors.New("Empty OID")
+	}
+	return result, nil
+}
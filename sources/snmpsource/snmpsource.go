@@ -1,21 +1,278 @@
 package snmpsource
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
 	"github.com/Symantec/scotty/sources"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net"
+	"strconv"
+	"time"
 )
 
-type connectorType string
+const (
+	kDefaultPort = 161
+	// Most OIDs in a single GET request
+	kMaxOIDsPerGet = 20
+	// How many OIDs to ask for in each GETBULK request
+	kMaxRepetitions = 25
+	kMaxPacketSize  = 65535
+	kRetries        = 2
+	// How long to wait for each response
+	kRequestTimeout = 5 * time.Second
+)
+
+// metricType is a Metric with its OID parsed.
+type metricType struct {
+	Metric
+	oid oidType
+}
+
+// unit returns the unit of the metric with given value.
+func (m *metricType) unit(value interface{}) units.Unit {
+	if m.Unit != "" {
+		return m.Unit
+	}
+	if _, ok := value.(time.Duration); ok {
+		return units.Second
+	}
+	return units.None
+}
+
+type connectorType struct {
+	community string
+	metrics   []metricType
+}
 
-func newConnector(community string) sources.Connector {
-	return connectorType(community)
+func newConnector(community string, metrics []Metric) sources.Connector {
+	result, err := newConnectorWithMetrics(community, metrics)
+	if err != nil {
+		panic(err)
+	}
+	return result
 }
 
-func (c connectorType) Connect(host string, port uint, config sources.Config) (
+func newConnectorWithMetrics(community string, metrics []Metric) (
+	sources.Connector, error) {
+	parsed := make([]metricType, len(metrics))
+	for i := range metrics {
+		oid, err := parseOID(metrics[i].OID)
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = metricType{Metric: metrics[i], oid: oid}
+	}
+	return &connectorType{community: community, metrics: parsed}, nil
+}
+
+func (c *connectorType) Connect(host string, port uint, config sources.Config) (
+	sources.Poller, error) {
+	return c.ConnectContext(context.Background(), host, port, config)
+}
+
+// ConnectContext resolves the address of the agent. Since SNMP uses UDP,
+// nothing goes over the network until polling.
+func (c *connectorType) ConnectContext(
+	ctx context.Context, host string, port uint, config sources.Config) (
 	sources.Poller, error) {
-	return nil, errors.New("SNMP not supported.")
+	if port == 0 {
+		port = kDefaultPort
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(
+		ctx,
+		"udp",
+		net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10)))
+	if err != nil {
+		return nil, err
+	}
+	return &pollerType{
+		conn:      conn,
+		community: c.community,
+		metrics:   c.metrics,
+	}, nil
 }
 
-func (c connectorType) Name() string {
+func (c *connectorType) Name() string {
 	return "snmp"
 }
+
+type pollerType struct {
+	conn      net.Conn
+	community string
+	metrics   []metricType
+	requestId int32
+	result    metrics.SimpleList
+	paths     map[string]bool
+}
+
+func (p *pollerType) Poll() (metrics.List, error) {
+	return p.PollContext(context.Background())
+}
+
+func (p *pollerType) PollContext(ctx context.Context) (metrics.List, error) {
+	p.result = nil
+	p.paths = make(map[string]bool)
+	var toGet []*metricType
+	for i := range p.metrics {
+		if p.metrics[i].Walk {
+			if err := p.walk(ctx, &p.metrics[i]); err != nil {
+				return nil, err
+			}
+		} else {
+			toGet = append(toGet, &p.metrics[i])
+		}
+	}
+	for len(toGet) > 0 {
+		batch := toGet
+		if len(batch) > kMaxOIDsPerGet {
+			batch = batch[:kMaxOIDsPerGet]
+		}
+		if err := p.get(ctx, batch); err != nil {
+			return nil, err
+		}
+		toGet = toGet[len(batch):]
+	}
+	return p.result.Sorted(), nil
+}
+
+func (p *pollerType) Close() error {
+	return p.conn.Close()
+}
+
+// get reads the OIDs of the given metrics with one GET request.
+func (p *pollerType) get(ctx context.Context, batch []*metricType) error {
+	request := &pduType{Type: kPduGet}
+	for _, m := range batch {
+		request.VarBinds = append(
+			request.VarBinds, varBindType{OID: m.oid, Tag: kTagNull})
+	}
+	response, err := p.request(ctx, request)
+	if err != nil {
+		return err
+	}
+	for i := range response.VarBinds {
+		if i < len(batch) {
+			if err := p.add(batch[i], batch[i].Path, &response.VarBinds[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walk reads the subtree at the OID of m with GETBULK requests.
+func (p *pollerType) walk(ctx context.Context, m *metricType) error {
+	current := m.oid
+	for {
+		response, err := p.request(ctx, &pduType{
+			Type:       kPduGetBulk,
+			ErrorIndex: kMaxRepetitions,
+			VarBinds:   []varBindType{{OID: current, Tag: kTagNull}},
+		})
+		if err != nil {
+			return err
+		}
+		if len(response.VarBinds) == 0 {
+			return nil
+		}
+		for i := range response.VarBinds {
+			varBind := &response.VarBinds[i]
+			// Stop at the end of the subtree. Also stop if the agent
+			// doesn't move forward so that we don't loop forever.
+			if varBind.Tag == kTagEndOfMibView ||
+				!varBind.OID.HasPrefix(m.oid) ||
+				varBind.OID.Compare(current) <= 0 {
+				return nil
+			}
+			current = varBind.OID
+			path := m.Path + "/" + varBind.OID[len(m.oid):].String()
+			if err := p.add(m, path, varBind); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// add adds the value in varBind as the metric with given path.
+func (p *pollerType) add(m *metricType, path string, varBind *varBindType) error {
+	value, ok, err := varBind.GoValue()
+	if err != nil {
+		return fmt.Errorf("%s: %v", varBind.OID, err)
+	}
+	if !ok || p.paths[path] {
+		return nil
+	}
+	p.paths[path] = true
+	p.result = append(p.result, metrics.Value{
+		Path:        path,
+		Description: m.Description,
+		Unit:        m.unit(value),
+		Value:       value,
+	})
+	return nil
+}
+
+// request sends request to the agent and returns the response retrying
+// if the agent doesn't answer in time.
+func (p *pollerType) request(ctx context.Context, request *pduType) (
+	*pduType, error) {
+	p.requestId++
+	request.RequestId = p.requestId
+	message := &messageType{Community: p.community, PDU: *request}
+	packet := message.Marshal()
+	buffer := make([]byte, kMaxPacketSize)
+	for attempt := 0; attempt <= kRetries; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := p.conn.Write(packet); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(kRequestTimeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		if err := p.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		response, err := p.readResponse(buffer, request.RequestId)
+		if err == nil {
+			return response, nil
+		}
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			return nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("No response from SNMP agent")
+}
+
+// readResponse reads packets until it gets the response to the request
+// with given id. readResponse ignores stray and malformed packets.
+func (p *pollerType) readResponse(buffer []byte, requestId int32) (
+	*pduType, error) {
+	for {
+		n, err := p.conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		response, err := unmarshalMessage(buffer[:n])
+		if err != nil ||
+			response.PDU.Type != kPduResponse ||
+			response.PDU.RequestId != requestId {
+			continue
+		}
+		if response.PDU.ErrorStatus != 0 {
+			return nil, fmt.Errorf(
+				"SNMP error status %d at index %d",
+				response.PDU.ErrorStatus,
+				response.PDU.ErrorIndex)
+		}
+		return &response.PDU, nil
+	}
+}
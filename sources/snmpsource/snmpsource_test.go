@@ -0,0 +1,306 @@
+package snmpsource
+
+import (
+	"context"
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/scotty/sources"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// agentType is an in-process stand-in for an SNMP v2c agent.
+type agentType struct {
+	conn      *net.UDPConn
+	community string
+	// Sorted by OID
+	varBinds []varBindType
+}
+
+func newAgent(t *testing.T, community string, varBinds []varBindType) *agentType {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(varBinds, func(i, j int) bool {
+		return varBinds[i].OID.Compare(varBinds[j].OID) < 0
+	})
+	result := &agentType{
+		conn: conn, community: community, varBinds: varBinds}
+	go result.serve()
+	return result
+}
+
+func (a *agentType) Port() uint {
+	return uint(a.conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func (a *agentType) Close() {
+	a.conn.Close()
+}
+
+func (a *agentType) serve() {
+	buffer := make([]byte, kMaxPacketSize)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		request, err := unmarshalMessage(buffer[:n])
+		// Real agents ignore requests with the wrong community
+		if err != nil || request.Community != a.community {
+			continue
+		}
+		response := &messageType{
+			Community: request.Community,
+			PDU: pduType{
+				Type:      kPduResponse,
+				RequestId: request.PDU.RequestId,
+			},
+		}
+		switch request.PDU.Type {
+		case kPduGet:
+			for _, varBind := range request.PDU.VarBinds {
+				response.PDU.VarBinds = append(
+					response.PDU.VarBinds, a.get(varBind.OID))
+			}
+		case kPduGetBulk:
+			for _, varBind := range request.PDU.VarBinds {
+				response.PDU.VarBinds = append(
+					response.PDU.VarBinds,
+					a.getBulk(varBind.OID, request.PDU.ErrorIndex)...)
+			}
+		default:
+			continue
+		}
+		a.conn.WriteToUDP(response.Marshal(), addr)
+	}
+}
+
+func (a *agentType) get(oid oidType) varBindType {
+	for _, varBind := range a.varBinds {
+		if varBind.OID.Compare(oid) == 0 {
+			return varBind
+		}
+	}
+	return varBindType{OID: oid, Tag: kTagNoSuchObject}
+}
+
+func (a *agentType) getBulk(oid oidType, maxRepetitions int) (
+	result []varBindType) {
+	idx := sort.Search(len(a.varBinds), func(i int) bool {
+		return a.varBinds[i].OID.Compare(oid) > 0
+	})
+	for ; idx < len(a.varBinds) && len(result) < maxRepetitions; idx++ {
+		result = append(result, a.varBinds[idx])
+	}
+	if len(result) < maxRepetitions {
+		result = append(result, varBindType{OID: oid, Tag: kTagEndOfMibView})
+	}
+	return
+}
+
+func mustParseOID(s string) oidType {
+	result, err := parseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func poll(t *testing.T, conn sources.Connector, port uint) (
+	result metrics.SimpleList) {
+	poller, err := conn.Connect("127.0.0.1", port, sources.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer poller.Close()
+	list, err := poller.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = make(metrics.SimpleList, list.Len())
+	for i := range result {
+		list.Index(i, &result[i])
+	}
+	return
+}
+
+func TestPoll(t *testing.T) {
+	varBinds := []varBindType{
+		{
+			OID:   mustParseOID("1.3.6.1.2.1.1.3.0"),
+			Tag:   kTagTimeTicks,
+			Value: encodeUnsigned(123456),
+		},
+		{
+			OID:   mustParseOID("1.3.6.1.2.1.1.5.0"),
+			Tag:   kTagOctetString,
+			Value: []byte("switch-1"),
+		},
+		{
+			OID:   mustParseOID("1.3.6.1.2.1.4.20.1.1.10.0.0.1"),
+			Tag:   kTagIpAddress,
+			Value: []byte{10, 0, 0, 1},
+		},
+		{
+			OID:   mustParseOID("1.3.6.1.2.1.9.1"),
+			Tag:   kTagInteger,
+			Value: encodeInteger(-300),
+		},
+	}
+	// Enough rows to need more than one GETBULK
+	rowCount := 2*kMaxRepetitions + 3
+	for i := 1; i <= rowCount; i++ {
+		varBinds = append(varBinds, varBindType{
+			OID:   mustParseOID(fmt.Sprintf("1.3.6.1.2.1.31.1.1.1.6.%d", i)),
+			Tag:   kTagCounter64,
+			Value: encodeUnsigned(uint64(1<<40 + i)),
+		})
+	}
+	agent := newAgent(t, "public", varBinds)
+	defer agent.Close()
+
+	conn, err := NewConnectorWithMetrics(
+		"public",
+		[]Metric{
+			{
+				OID:         "1.3.6.1.2.1.1.3.0",
+				Path:        "/system/uptime",
+				Description: "Uptime",
+			},
+			{OID: ".1.3.6.1.2.1.1.5.0", Path: "/system/name"},
+			{OID: "1.3.6.1.2.1.1.6.0", Path: "/system/missing"},
+			{OID: "1.3.6.1.2.1.9.1", Path: "/negative"},
+			{
+				OID:  "1.3.6.1.2.1.4.20.1.1",
+				Path: "/addresses",
+				Walk: true,
+			},
+			{
+				OID:  "1.3.6.1.2.1.31.1.1.1.6",
+				Path: "/interfaces/in-octets",
+				Unit: units.Byte,
+				Walk: true,
+			},
+			{
+				OID:  "1.3.6.1.2.1.99",
+				Path: "/nothing",
+				Walk: true,
+			},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := poll(t, conn, agent.Port())
+	if err := metrics.VerifyList(actual); err != nil {
+		t.Error(err)
+	}
+	expected := metrics.SimpleList{
+		{
+			Path:  "/addresses/10.0.0.1",
+			Unit:  units.None,
+			Value: "10.0.0.1",
+		},
+		{
+			Path:  "/negative",
+			Unit:  units.None,
+			Value: int64(-300),
+		},
+		{
+			Path:        "/system/name",
+			Unit:        units.None,
+			Value:       "switch-1",
+			Description: "",
+		},
+		{
+			Path:        "/system/uptime",
+			Unit:        units.Second,
+			Value:       1234560 * time.Millisecond,
+			Description: "Uptime",
+		},
+	}
+	for i := 1; i <= rowCount; i++ {
+		expected = append(expected, metrics.Value{
+			Path:  "/interfaces/in-octets/" + strconv.Itoa(i),
+			Unit:  units.Byte,
+			Value: uint64(1<<40 + i),
+		})
+	}
+	expected = expected.Sorted()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestWrongCommunity(t *testing.T) {
+	agent := newAgent(t, "secret", nil)
+	defer agent.Close()
+	conn := NewConnector("public")
+	poller, err := conn.Connect("127.0.0.1", agent.Port(), sources.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer poller.Close()
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = poller.(sources.ContextPoller).PollContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestBadOID(t *testing.T) {
+	if _, err := NewConnectorWithMetrics(
+		"public", []Metric{{OID: "1.3.x", Path: "/foo"}}); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	for _, x := range []int64{
+		0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40, -1 << 40} {
+		decoded, err := decodeInteger(encodeInteger(x))
+		if err != nil || decoded != x {
+			t.Errorf("Expected %d, got %d %v", x, decoded, err)
+		}
+	}
+	for _, x := range []uint64{0, 127, 128, 1<<32 - 1, 1<<64 - 1} {
+		decoded, err := decodeUnsigned(encodeUnsigned(x), 64)
+		if err != nil || decoded != x {
+			t.Errorf("Expected %d, got %d %v", x, decoded, err)
+		}
+	}
+	oid := mustParseOID("1.3.6.1.4.1.2021.4294967295.0")
+	decoded, err := decodeOID(encodeOID(oid))
+	if err != nil || decoded.Compare(oid) != 0 {
+		t.Errorf("Expected %v, got %v %v", oid, decoded, err)
+	}
+	message := &messageType{
+		Community: "public",
+		PDU: pduType{
+			Type:      kPduResponse,
+			RequestId: 12345,
+			VarBinds: []varBindType{
+				{
+					OID:   oid,
+					Tag:   kTagOctetString,
+					Value: make([]byte, 300),
+				},
+			},
+		},
+	}
+	decodedMessage, err := unmarshalMessage(message.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(message, decodedMessage) {
+		t.Errorf("Expected %v, got %v", message, decodedMessage)
+	}
+}
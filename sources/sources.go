@@ -1,7 +1,9 @@
 package sources
 
 import (
+	"context"
 	"github.com/Symantec/scotty/lib/preference"
+	"github.com/Symantec/scotty/metrics"
 	"strings"
 	"sync"
 )
@@ -42,14 +44,24 @@ func (m *multiResourceConnectorType) NewResource(
 }
 
 func (m *multiResourceConnectorType) ResourceConnect(r Resource) (
-	poll Poller, err error) {
+	Poller, error) {
+	return m.ResourceConnectContext(context.Background(), r)
+}
+
+func (m *multiResourceConnectorType) ResourceConnectContext(
+	ctx context.Context, r Resource) (poll Poller, err error) {
 	resource := r.(*preferenceResourceType)
 	for _, index := range resource.Indexes() {
-		poll, err = m.conns[index].ResourceConnect(resource.Resources[index])
+		poll, err = resourceConnectContext(
+			ctx, m.conns[index], resource.Resources[index])
 		if err == nil {
 			resource.SetFirstIndex(index)
 			return
 		}
+		// No time left to try the other connectors
+		if ctx.Err() != nil {
+			return
+		}
 	}
 	return
 }
@@ -60,6 +72,12 @@ func (m *multiResourceConnectorType) Connect(
 	return m.ResourceConnect(m.NewResource(host, port, config))
 }
 
+func (m *multiResourceConnectorType) ConnectContext(
+	ctx context.Context, host string, port uint, config Config) (
+	Poller, error) {
+	return m.ResourceConnectContext(ctx, m.NewResource(host, port, config))
+}
+
 func (m *multiResourceConnectorType) Name() string {
 	names := make([]string, len(m.conns))
 	for i := range names {
@@ -101,6 +119,15 @@ func (c *simpleResourceConnectorType) ResourceConnect(r Resource) (
 	return c.Connect(hAndP.Host, hAndP.Port, hAndP.Config)
 }
 
+func (c *simpleResourceConnectorType) ResourceConnectContext(
+	ctx context.Context, r Resource) (Poller, error) {
+	hAndP := r.(*hostAndPort)
+	if cc, ok := c.Connector.(ContextConnector); ok {
+		return cc.ConnectContext(ctx, hAndP.Host, hAndP.Port, hAndP.Config)
+	}
+	return c.Connect(hAndP.Host, hAndP.Port, hAndP.Config)
+}
+
 func simpleResourceConnector(conn Connector) ResourceConnector {
 	rc, ok := conn.(ResourceConnector)
 	if ok {
@@ -108,3 +135,19 @@ func simpleResourceConnector(conn Connector) ResourceConnector {
 	}
 	return &simpleResourceConnectorType{conn}
 }
+
+func resourceConnectContext(
+	ctx context.Context, conn ResourceConnector, resource Resource) (
+	Poller, error) {
+	if cc, ok := conn.(ContextResourceConnector); ok {
+		return cc.ResourceConnectContext(ctx, resource)
+	}
+	return conn.ResourceConnect(resource)
+}
+
+func pollContext(ctx context.Context, poller Poller) (metrics.List, error) {
+	if cp, ok := poller.(ContextPoller); ok {
+		return cp.PollContext(ctx)
+	}
+	return poller.Poll()
+}
@@ -1,6 +1,7 @@
 package trisource
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"time"
@@ -45,10 +46,34 @@ func (c connectorType) Connect(host string, port uint, config sources.Config) (
 	return c.ResourceConnect(c.NewResource(host, port, config))
 }
 
+func (c connectorType) ConnectContext(
+	ctx context.Context, host string, port uint, config sources.Config) (
+	sources.Poller, error) {
+	return c.ResourceConnectContext(ctx, c.NewResource(host, port, config))
+}
+
 func (c connectorType) ResourceConnect(resource sources.Resource) (
+	sources.Poller, error) {
+	return c.resourceConnect(nil, resource)
+}
+
+func (c connectorType) ResourceConnectContext(
+	ctx context.Context, resource sources.Resource) (sources.Poller, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	poller, err := c.resourceConnect(ctx.Done(), resource)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return poller, err
+}
+
+func (c connectorType) resourceConnect(
+	cancelChannel <-chan struct{}, resource sources.Resource) (
 	sources.Poller, error) {
 	clientResource := resource.(*rpcclientpool.ClientResource)
-	conn, err := clientResource.Get(nil)
+	conn, err := clientResource.Get(cancelChannel)
 	if err != nil {
 		return nil, err
 	}
@@ -64,8 +89,21 @@ type pollerType struct {
 }
 
 func (p pollerType) Poll() (result metrics.List, err error) {
+	return p.PollContext(context.Background())
+}
+
+func (p pollerType) PollContext(ctx context.Context) (
+	result metrics.List, err error) {
 	var values messages.MetricList
-	err = p.client.Call("MetricsServer.ListMetrics", "", &values)
+	call := p.client.Go("MetricsServer.ListMetrics", "", &values, nil)
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-ctx.Done():
+		// Closing the client ends the call and keeps the hung connection
+		// from going back into the pool.
+		err = ctx.Err()
+	}
 	if err != nil {
 		p.client.Close()
 		return
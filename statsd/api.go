@@ -0,0 +1,105 @@
+// Package statsd parses and aggregates metrics in the StatsD format.
+package statsd
+
+import (
+	"github.com/Symantec/scotty/metrics"
+	"sync"
+)
+
+// Type is the type of a StatsD metric.
+type Type string
+
+const (
+	Counter   Type = "c"
+	Gauge     Type = "g"
+	Timer     Type = "ms"
+	Histogram Type = "h"
+	Set       Type = "s"
+)
+
+// Metric represents a single StatsD line such as "requests:1|c|@0.5".
+type Metric struct {
+	// The name e.g "api.requests"
+	Name string
+	Type Type
+	// The value. Unused for sets.
+	Value float64
+	// True if Value is a change to a gauge such as "temp:-3|g" rather
+	// than its new value.
+	IsDelta bool
+	// The member being added to a set. Unused for everything else.
+	Member string
+	// The fraction of values the application sent e.g 0.5 means that
+	// it sent every other value. 1.0 means it sent every value.
+	SampleRate float64
+	// DogStatsD tags e.g "|#app:web,region:us-east-1". Tags without
+	// a value map to the empty string. nil if there are no tags.
+	Tags map[string]string
+}
+
+// Parse parses a single StatsD line.
+func Parse(line string) (*Metric, error) {
+	return parse(line)
+}
+
+// Path returns the metric path for a StatsD name. Dots in name separate
+// path components so that "api.requests" becomes "/api/requests".
+// Path returns the empty string if name has no usable components.
+func Path(name string) string {
+	return path(name)
+}
+
+// Aggregator aggregates StatsD metrics from one application between
+// flushes. Aggregator instances are safe to use with multiple goroutines.
+//
+// Aggregator reports each kind of StatsD metric as follows.
+//
+// Counters report the total count since the counter was first seen
+// adjusting for the sample rate. Like other counters in scotty, they
+// only go up.
+//
+// Gauges report their last value. They keep reporting it in later
+// flushes until it changes.
+//
+// Timers and histograms report a distribution of the values seen since
+// the last flush. Timers are in milliseconds. A timer or histogram
+// without any values since the last flush is left out. To bound memory,
+// the median comes from a random sample of at most 1000 values; the rest
+// of the distribution is exact.
+//
+// Sets report the number of unique members seen since the last flush.
+type Aggregator struct {
+	lock     sync.Mutex
+	types    map[string]Type
+	counters map[string]float64
+	gauges   map[string]float64
+	timers   map[string]*timerType
+	sets     map[string]map[string]bool
+	// The generation of the last add for each metric
+	lastAdds   map[string]uint64
+	generation uint64
+}
+
+// NewAggregator returns a new, empty aggregator.
+func NewAggregator() *Aggregator {
+	return newAggregator()
+}
+
+// Add adds m to this instance. Add returns an error if m has a name
+// that this instance already has with a different type.
+func (a *Aggregator) Add(m *Metric) error {
+	return a.add(m)
+}
+
+// Flush returns the aggregated metrics sorted by path and clears the
+// values that are only reported once.
+func (a *Aggregator) Flush() metrics.SimpleList {
+	return a.flush()
+}
+
+// Expire forgets the metrics that have not been added to during the last
+// flushCount flushes so that metrics an application stops sending don't
+// stay around forever. flushCount must be at least 1.
+func (a *Aggregator) Expire(flushCount uint64) {
+	a.expire(flushCount)
+}
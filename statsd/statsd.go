@@ -0,0 +1,333 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Upper limits of the buckets of timer and histogram distributions
+	kUpperLimits = []float64{
+		1, 2, 5, 10, 20, 50, 100, 200, 500,
+		1000, 2000, 5000, 10000, 20000, 50000, 100000}
+)
+
+const (
+	// Most values a timer or histogram keeps between flushes for
+	// computing its median
+	kMaxTimerSamples = 1000
+)
+
+var (
+	errNoName = errors.New("statsd: Missing metric name")
+)
+
+// sampleType is a single timer or histogram value.
+type sampleType struct {
+	Value float64
+	// How many values this one stands for given the sample rate
+	Count uint64
+}
+
+// timerType aggregates the values of a timer or histogram since the last
+// flush. All but the median are exact. The median comes from at most
+// kMaxTimerSamples values chosen uniformly at random so that a busy timer
+// uses bounded memory.
+type timerType struct {
+	Min, Max, Sum float64
+	Count         uint64
+	// Count of values in each bucket. See kUpperLimits.
+	BucketCounts []uint64
+	Samples      []sampleType
+	// Number of values added since last flush ignoring sample rate
+	Seen uint64
+}
+
+func (t *timerType) Add(sample sampleType) {
+	if t.Seen == 0 || sample.Value < t.Min {
+		t.Min = sample.Value
+	}
+	if t.Seen == 0 || sample.Value > t.Max {
+		t.Max = sample.Value
+	}
+	t.Sum += sample.Value * float64(sample.Count)
+	t.Count += sample.Count
+	if t.BucketCounts == nil {
+		t.BucketCounts = make([]uint64, len(kUpperLimits)+1)
+	}
+	idx := sort.SearchFloat64s(kUpperLimits, sample.Value)
+	// A value equal to an upper limit belongs to the next bucket.
+	if idx < len(kUpperLimits) && kUpperLimits[idx] == sample.Value {
+		idx++
+	}
+	t.BucketCounts[idx] += sample.Count
+	t.Seen++
+	// Reservoir sampling
+	if len(t.Samples) < kMaxTimerSamples {
+		t.Samples = append(t.Samples, sample)
+	} else if idx := rand.Int63n(int64(t.Seen)); idx < kMaxTimerSamples {
+		t.Samples[idx] = sample
+	}
+}
+
+// Reset clears this instance for the next flush reusing its memory.
+func (t *timerType) Reset() {
+	t.Min, t.Max, t.Sum, t.Count, t.Seen = 0, 0, 0, 0, 0
+	for i := range t.BucketCounts {
+		t.BucketCounts[i] = 0
+	}
+	t.Samples = t.Samples[:0]
+}
+
+func parse(line string) (*Metric, error) {
+	colon := strings.IndexByte(line, ':')
+	if colon == -1 {
+		return nil, fmt.Errorf("statsd: Missing ':' in %q", line)
+	}
+	result := &Metric{Name: line[:colon], SampleRate: 1.0}
+	if path(result.Name) == "" {
+		return nil, errNoName
+	}
+	fields := strings.Split(line[colon+1:], "|")
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("statsd: Missing type in %q", line)
+	}
+	valueStr := fields[0]
+	result.Type = Type(fields[1])
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0.0 || rate > 1.0 {
+				return nil, fmt.Errorf("statsd: Bad sample rate in %q", line)
+			}
+			result.SampleRate = rate
+		case strings.HasPrefix(field, "#"):
+			result.Tags = parseTags(field[1:])
+		default:
+			return nil, fmt.Errorf("statsd: Unknown field in %q", line)
+		}
+	}
+	switch result.Type {
+	case Set:
+		result.Member = valueStr
+		return result, nil
+	case Gauge:
+		result.IsDelta = strings.HasPrefix(valueStr, "+") ||
+			strings.HasPrefix(valueStr, "-")
+	case Counter, Timer, Histogram:
+	default:
+		return nil, fmt.Errorf("statsd: Unknown type in %q", line)
+	}
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("statsd: Bad value in %q", line)
+	}
+	result.Value = value
+	return result, nil
+}
+
+func parseTags(s string) map[string]string {
+	result := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		colon := strings.IndexByte(tag, ':')
+		if colon == -1 {
+			result[tag] = ""
+		} else {
+			result[tag[:colon]] = tag[colon+1:]
+		}
+	}
+	return result
+}
+
+func path(name string) string {
+	var components []string
+	for _, component := range strings.Split(name, ".") {
+		component = strings.Map(
+			func(r rune) rune {
+				if r == '/' || r <= ' ' {
+					return '_'
+				}
+				return r
+			},
+			component)
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+	if len(components) == 0 {
+		return ""
+	}
+	return "/" + strings.Join(components, "/")
+}
+
+func newAggregator() *Aggregator {
+	return &Aggregator{
+		types:    make(map[string]Type),
+		counters: make(map[string]float64),
+		gauges:   make(map[string]float64),
+		timers:   make(map[string]*timerType),
+		lastAdds: make(map[string]uint64),
+		sets:     make(map[string]map[string]bool),
+	}
+}
+
+func (a *Aggregator) add(m *Metric) error {
+	p := path(m.Name)
+	if p == "" {
+		return errNoName
+	}
+	kind := m.Type
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if existing, ok := a.types[p]; ok && existing != kind {
+		return fmt.Errorf(
+			"statsd: %s already has type %s, not %s", m.Name, existing, kind)
+	}
+	a.types[p] = kind
+	a.lastAdds[p] = a.generation
+	switch kind {
+	case Counter:
+		a.counters[p] += m.Value / m.SampleRate
+	case Gauge:
+		if m.IsDelta {
+			a.gauges[p] += m.Value
+		} else {
+			a.gauges[p] = m.Value
+		}
+	case Timer, Histogram:
+		count := uint64(math.Floor(1.0/m.SampleRate + 0.5))
+		if count == 0 {
+			count = 1
+		}
+		timer := a.timers[p]
+		if timer == nil {
+			timer = &timerType{}
+			a.timers[p] = timer
+		}
+		timer.Add(sampleType{Value: m.Value, Count: count})
+	case Set:
+		members := a.sets[p]
+		if members == nil {
+			members = make(map[string]bool)
+			a.sets[p] = members
+		}
+		members[m.Member] = true
+	default:
+		return fmt.Errorf("statsd: Unknown type %s", kind)
+	}
+	return nil
+}
+
+func (a *Aggregator) flush() metrics.SimpleList {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.generation++
+	var result metrics.SimpleList
+	for p, value := range a.counters {
+		result = append(result, metrics.Value{
+			Path:  p,
+			Unit:  units.None,
+			Value: value,
+		})
+	}
+	for p, value := range a.gauges {
+		result = append(result, metrics.Value{
+			Path:  p,
+			Unit:  units.None,
+			Value: value,
+		})
+	}
+	for p, timer := range a.timers {
+		if timer.Seen == 0 {
+			continue
+		}
+		unit := units.None
+		if a.types[p] == Timer {
+			unit = units.Millisecond
+		}
+		result = append(result, metrics.Value{
+			Path:  p,
+			Unit:  unit,
+			Value: distribution(timer, a.generation),
+		})
+		timer.Reset()
+	}
+	for p, members := range a.sets {
+		result = append(result, metrics.Value{
+			Path:  p,
+			Unit:  units.None,
+			Value: uint64(len(members)),
+		})
+		a.sets[p] = make(map[string]bool)
+	}
+	return result.Sorted()
+}
+
+func (a *Aggregator) expire(flushCount uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for p, lastAdd := range a.lastAdds {
+		if lastAdd+flushCount >= a.generation {
+			continue
+		}
+		delete(a.lastAdds, p)
+		delete(a.types, p)
+		delete(a.counters, p)
+		delete(a.gauges, p)
+		delete(a.timers, p)
+		delete(a.sets, p)
+	}
+}
+
+// distribution returns the distribution of the values in timer.
+// distribution sorts the samples of timer in place.
+func distribution(
+	timer *timerType, generation uint64) *messages.Distribution {
+	samples := timer.Samples
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Value < samples[j].Value
+	})
+	result := &messages.Distribution{
+		Min:             timer.Min,
+		Max:             timer.Max,
+		Average:         timer.Sum / float64(timer.Count),
+		Sum:             timer.Sum,
+		Count:           timer.Count,
+		Generation:      generation,
+		IsNotCumulative: true,
+		Ranges:          make([]*messages.RangeWithCount, len(kUpperLimits)+1),
+	}
+	lower := 0.0
+	for i, upper := range kUpperLimits {
+		result.Ranges[i] = &messages.RangeWithCount{
+			Lower: lower, Upper: upper, Count: timer.BucketCounts[i]}
+		lower = upper
+	}
+	result.Ranges[len(kUpperLimits)] = &messages.RangeWithCount{
+		Lower: lower, Count: timer.BucketCounts[len(kUpperLimits)]}
+	var sampleCount uint64
+	for _, sample := range samples {
+		sampleCount += sample.Count
+	}
+	var seen uint64
+	for _, sample := range samples {
+		seen += sample.Count
+		if 2*seen >= sampleCount {
+			result.Median = sample.Value
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,207 @@
+package statsd_test
+
+import (
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/scotty/statsd"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"reflect"
+	"testing"
+)
+
+func mustAdd(t *testing.T, a *statsd.Aggregator, lines ...string) {
+	for _, line := range lines {
+		m, err := statsd.Parse(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Add(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	m, err := statsd.Parse("api.latency:320|ms|@0.1|#app:web,canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &statsd.Metric{
+		Name:       "api.latency",
+		Type:       statsd.Timer,
+		Value:      320,
+		SampleRate: 0.1,
+		Tags:       map[string]string{"app": "web", "canary": ""},
+	}
+	if !reflect.DeepEqual(expected, m) {
+		t.Errorf("Expected %v, got %v", expected, m)
+	}
+	m, err = statsd.Parse("temp:-3|g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsDelta || m.Value != -3 {
+		t.Errorf("Expected delta of -3, got %v", m)
+	}
+	badLines := []string{
+		"foo",
+		"foo:1",
+		":1|c",
+		"..:1|c",
+		"foo:abc|c",
+		"foo:1|x",
+		"foo:1|c|@0",
+		"foo:1|c|@2",
+		"foo:1|c|bar",
+		"foo:NaN|g",
+	}
+	for _, line := range badLines {
+		if _, err := statsd.Parse(line); err == nil {
+			t.Errorf("Expected error parsing %q", line)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	for name, expected := range map[string]string{
+		"api.requests":   "/api/requests",
+		"a..b.":          "/a/b",
+		"disk./var.free": "/disk/_var/free",
+		"with space":     "/with_space",
+		"...":            "",
+	} {
+		if actual := statsd.Path(name); actual != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, name, actual)
+		}
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	a := statsd.NewAggregator()
+	mustAdd(
+		t,
+		a,
+		"requests:1|c",
+		"requests:2|c|@0.5",
+		"temp:20|g",
+		"temp:+5|g",
+		"latency:3|ms",
+		"latency:1|ms",
+		"latency:10|ms|@0.5",
+		"users:alice|s",
+		"users:bob|s",
+		"users:alice|s")
+	m, _ := statsd.Parse("temp:1|c")
+	if err := a.Add(m); err == nil {
+		t.Error("Expected error adding counter with gauge's name")
+	}
+	expected := metrics.SimpleList{
+		{
+			Path: "/latency",
+			Unit: units.Millisecond,
+			Value: &messages.Distribution{
+				Min:             1,
+				Max:             10,
+				Average:         24.0 / 4.0,
+				Median:          3,
+				Sum:             24,
+				Count:           4,
+				Generation:      1,
+				IsNotCumulative: true,
+				Ranges: []*messages.RangeWithCount{
+					{Lower: 0, Upper: 1},
+					{Lower: 1, Upper: 2, Count: 1},
+					{Lower: 2, Upper: 5, Count: 1},
+					{Lower: 5, Upper: 10},
+					{Lower: 10, Upper: 20, Count: 2},
+					{Lower: 20, Upper: 50},
+					{Lower: 50, Upper: 100},
+					{Lower: 100, Upper: 200},
+					{Lower: 200, Upper: 500},
+					{Lower: 500, Upper: 1000},
+					{Lower: 1000, Upper: 2000},
+					{Lower: 2000, Upper: 5000},
+					{Lower: 5000, Upper: 10000},
+					{Lower: 10000, Upper: 20000},
+					{Lower: 20000, Upper: 50000},
+					{Lower: 50000, Upper: 100000},
+					{Lower: 100000},
+				},
+			},
+		},
+		{Path: "/requests", Unit: units.None, Value: 5.0},
+		{Path: "/temp", Unit: units.None, Value: 25.0},
+		{Path: "/users", Unit: units.None, Value: uint64(2)},
+	}
+	actual := a.Flush()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if err := metrics.VerifyList(actual); err != nil {
+		t.Error(err)
+	}
+
+	// Counters and gauges carry over; timers don't; sets start over.
+	mustAdd(t, a, "requests:1|c")
+	expected = metrics.SimpleList{
+		{Path: "/requests", Unit: units.None, Value: 6.0},
+		{Path: "/temp", Unit: units.None, Value: 25.0},
+		{Path: "/users", Unit: units.None, Value: uint64(0)},
+	}
+	actual = a.Flush()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestAggregatorManyTimerValues(t *testing.T) {
+	a := statsd.NewAggregator()
+	for i := 1; i <= 10000; i++ {
+		mustAdd(t, a, fmt.Sprintf("latency:%d|ms", i%100+1))
+	}
+	actual := a.Flush()
+	if len(actual) != 1 {
+		t.Fatalf("Expected 1 value, got %v", actual)
+	}
+	dist := actual[0].Value.(*messages.Distribution)
+	if dist.Min != 1 || dist.Max != 100 {
+		t.Errorf("Expected min 1 max 100, got %v %v", dist.Min, dist.Max)
+	}
+	if dist.Count != 10000 || dist.Sum != 505000 {
+		t.Errorf(
+			"Expected count 10000 sum 505000, got %v %v",
+			dist.Count, dist.Sum)
+	}
+	// Median comes from a sample so only check that it is in range.
+	if dist.Median < 1 || dist.Median > 100 {
+		t.Errorf("Median %v out of range", dist.Median)
+	}
+	var bucketTotal uint64
+	for _, r := range dist.Ranges {
+		bucketTotal += r.Count
+	}
+	if bucketTotal != 10000 {
+		t.Errorf("Expected 10000 in buckets, got %d", bucketTotal)
+	}
+}
+
+func TestAggregatorExpire(t *testing.T) {
+	a := statsd.NewAggregator()
+	mustAdd(t, a, "requests:1|c", "temp:20|g")
+	a.Flush()
+	// Both were added during the last flush.
+	a.Expire(1)
+	mustAdd(t, a, "requests:1|c")
+	a.Flush()
+	a.Expire(1)
+	expected := metrics.SimpleList{
+		{Path: "/requests", Unit: units.None, Value: 2.0},
+	}
+	actual := a.Flush()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	// temp is gone so it may change type.
+	mustAdd(t, a, "temp:1|c")
+}
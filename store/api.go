@@ -8,6 +8,7 @@ import (
 	"github.com/Symantec/tricorder/go/tricorder"
 	"github.com/Symantec/tricorder/go/tricorder/types"
 	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io"
 	"time"
 )
 
@@ -57,6 +58,9 @@ type MetricInfo struct {
 	ranges          *Ranges
 	isNotCumulative bool
 	groupId         int
+	// Not part of the identity of the metric. Set when the store first
+	// sees the metric and again after the retention policy changes.
+	retention RetentionClass
 }
 
 // Path returns the path of the metric
@@ -217,6 +221,139 @@ type Record struct {
 	Active bool
 }
 
+// RollUpTier describes a tier of rolled up values. Each rolled up value
+// covers Duration of time. The store keeps at most Count rolled up values
+// per metric in the tier.
+type RollUpTier struct {
+	Duration time.Duration
+	Count    uint
+}
+
+// RollUpValue represents a single rolled up value for one metric.
+type RollUpValue struct {
+	// Start of rolled up time period in seconds since Jan 1, 1970.
+	Start float64
+	// Smallest value in time period
+	Min float64
+	// Largest value in time period
+	Max float64
+	// Average value in time period weighted by how long each value was
+	// in effect
+	Avg float64
+	// Number of distinct values that started in time period
+	Count uint64
+}
+
+// RollUpField selects which rolled up value stands in for the values of
+// a rolled up time period.
+type RollUpField int
+
+const (
+	// Average value in time period
+	RollUpAvg RollUpField = iota
+	// Smallest value in time period
+	RollUpMin
+	// Largest value in time period
+	RollUpMax
+	// Sum of the distinct values that started in time period
+	RollUpSum
+)
+
+// RetentionClass determines how long the store keeps the values of a
+// metric relative to other metrics. When the store needs a page, it takes
+// full pages of low retention metrics first and full pages of high
+// retention metrics last.
+type RetentionClass int
+
+const (
+	LowRetention    RetentionClass = -1
+	NormalRetention RetentionClass = 0
+	HighRetention   RetentionClass = 1
+)
+
+// RetentionPolicy assigns retention classes to metrics. The store asks
+// for the retention class of a metric when it first sees the metric and
+// again when the policy changes. Implementations must be safe to use with
+// multiple goroutines.
+type RetentionPolicy interface {
+	// RetentionClass returns the retention class of the metric with
+	// given path.
+	RetentionClass(path string) RetentionClass
+}
+
+// ChangingRetentionPolicy is a RetentionPolicy that changes over time
+// such as one that follows a configuration file.
+type ChangingRetentionPolicy interface {
+	RetentionPolicy
+	// Current returns the policy in effect now. Current must keep
+	// returning the same pointer until the policy changes.
+	Current() RetentionPolicy
+}
+
+// RetentionRule assigns a retention class to each metric whose path matches
+// a regular expression.
+type RetentionRule struct {
+	PathRegex string
+	Class     RetentionClass
+}
+
+// RetentionClasses is a RetentionPolicy made from a list of RetentionRule.
+// RetentionClasses instances are safe to use with multiple goroutines.
+type RetentionClasses struct {
+	rules []retentionRuleType
+}
+
+// NewRetentionClasses returns a new RetentionClasses from rules. The
+// first rule whose regular expression matches a metric path determines
+// the retention class of that metric. Metrics matching no rule get
+// NormalRetention.
+func NewRetentionClasses(rules []RetentionRule) (*RetentionClasses, error) {
+	return newRetentionClasses(rules)
+}
+
+// RetentionClass returns the retention class for given metric path.
+func (r *RetentionClasses) RetentionClass(path string) RetentionClass {
+	return r.retentionClass(path)
+}
+
+// PageUsage represents the pages that a single endpoint uses.
+type PageUsage struct {
+	EndpointId interface{}
+	// Total pages the endpoint uses
+	PageCount uint
+	// Pages the endpoint uses for inactive metrics. The store reclaims
+	// these first.
+	InactivePageCount uint
+	// Most pages the endpoint may use. 0 means no quota.
+	Quota uint
+}
+
+// Cardinality represents how many time series a single endpoint has.
+type Cardinality struct {
+	EndpointId interface{}
+	// Total time series the endpoint has including inactive ones
+	SeriesCount uint
+	// Time series in the endpoint's latest batch of values
+	ActiveSeriesCount uint
+	// Time series that were new in the endpoint's latest batch of values
+	NewSeriesCount uint
+	// Time series that were new in any batch of values
+	TotalNewSeriesCount uint64
+}
+
+// AppCardinality represents how many time series all the endpoints of
+// a single application have.
+type AppCardinality struct {
+	AppName string
+	// Number of endpoints running the application
+	EndpointCount uint
+	// The remaining fields are sums of the same fields in Cardinality
+	SeriesCount         uint
+	ActiveSeriesCount   uint
+	NewSeriesCount      uint
+	TotalNewSeriesCount uint64
+}
+
 // Filterer filters metric values.
 type Filterer interface {
 	// Filter returns true to include passed metric value false otherwise.
@@ -433,6 +570,16 @@ type Store struct {
 	byApplication map[interface{}]*timeSeriesCollectionType
 	supplier      *pageQueueType
 	metrics       *storeMetricsType
+	rollUpTiers   []RollUpTier
+	wal           *WAL
+	walKeyFunc    func(endpointId interface{}) string
+}
+
+// DistributionDerivedNames returns the names of the commonly used
+// time series that TsdbTimeSeries derives from the distribution with
+// given name.
+func DistributionDerivedNames(name string) []string {
+	return distDerivedNames(name)
 }
 
 // NewStore returns a new Store instance.
@@ -506,6 +653,87 @@ func (s *Store) IsExpanding() bool {
 	return s.supplier.IsExpanding()
 }
 
+// SetCompressed controls whether or not the store compresses newly granted
+// pages. The store compresses only pages holding timestamps or numeric
+// values. Pages already granted keep their current format, and the store
+// reads both formats transparently.
+func (s *Store) SetCompressed(compressed bool) {
+	s.supplier.SetCompressed(compressed)
+}
+
+// IsCompressed returns true if the store compresses newly granted pages.
+func (s *Store) IsCompressed() bool {
+	return s.supplier.IsCompressed()
+}
+
+// SetRetentionPolicy sets the policy that assigns retention classes to
+// metrics. nil, the default, means all metrics get NormalRetention.
+// When the policy changes, either through SetRetentionPolicy or because a
+// ChangingRetentionPolicy returns a different current policy, the metrics
+// of each endpoint and the pages they already filled move to their new
+// retention class the next time the endpoint gets new values.
+func (s *Store) SetRetentionPolicy(policy RetentionPolicy) {
+	s.supplier.SetRetentionPolicy(policy)
+}
+
+// SetPageQuota limits the pages each endpoint may use to ratio times the
+// total number of pages. Once an endpoint reaches its quota, it reuses its
+// own oldest pages instead of taking pages from other endpoints.
+// A ratio of 0 means no quota which is the default.
+func (s *Store) SetPageQuota(ratio float64) {
+	s.supplier.SetPageQuota(ratio)
+}
+
+// PageQuota returns the ratio of all pages that each endpoint may use.
+func (s *Store) PageQuota() float64 {
+	return s.supplier.PageQuota()
+}
+
+// SetAppPageQuota works like SetPageQuota but only for endpoints of the
+// named application. It overrides the ratio from SetPageQuota.
+// SetAppPageQuota applies only to endpoint ids that have an
+// AppName() string method.
+func (s *Store) SetAppPageQuota(appName string, ratio float64) {
+	s.supplier.SetAppPageQuota(appName, ratio)
+}
+
+// PageUsageByEndpoint returns how many pages each endpoint uses. Endpoints
+// using the most pages come first. Endpoints using no pages are omitted.
+func (s *Store) PageUsageByEndpoint() []PageUsage {
+	return s.supplier.PageUsageByEndpoint()
+}
+
+// CardinalityByEndpoint returns how many time series each endpoint has.
+// Endpoints with the most time series come first. Endpoints that have
+// never received a batch of values are omitted.
+func (s *Store) CardinalityByEndpoint() []Cardinality {
+	return s.metrics.Cardinality.ByEndpoint()
+}
+
+// CardinalityByApp returns how many time series each application has
+// across all its endpoints. Applications with the most time series come
+// first. Endpoint ids without an AppName method are omitted.
+func (s *Store) CardinalityByApp() []AppCardinality {
+	return s.metrics.Cardinality.ByApp()
+}
+
+// SetRollUpTiers sets the tiers into which this store rolls up the values
+// of numeric metrics as it evicts their pages. Rolled up tiers let this
+// store answer queries for times whose values it has already evicted.
+// Rolled up values come out of the same memory budget as pages: as they
+// grow, this store removes pages to make room for them.
+// SetRollUpTiers sorts the tiers from finest to coarsest.
+// Caller must call SetRollUpTiers before registering any endpoints.
+func (s *Store) SetRollUpTiers(tiers []RollUpTier) {
+	s.setRollUpTiers(tiers)
+}
+
+// RollUpTiers returns the tiers into which this store rolls up values
+// from finest to coarsest.
+func (s *Store) RollUpTiers() []RollUpTier {
+	return s.rollUpTiersCopy()
+}
+
 // ShallowCopy returns a shallow copy of this store. In an environment with
 // multiple goroutines, a client can create a shallow copy to safely register
 // more endpoints without creating data races.
@@ -518,6 +746,15 @@ func (s *Store) RegisterEndpoint(endpointId interface{}) {
 	s.registerEndpoint(endpointId)
 }
 
+// UnregisterEndpoint unregisters an endpoint dropping all its time series.
+// Callers should first mark the endpoint inactive so that the store reuses
+// its pages before other pages. Like RegisterEndpoint, callers should
+// call UnregisterEndpoint on a shallow copy in an environment with
+// multiple goroutines.
+func (s *Store) UnregisterEndpoint(endpointId interface{}) {
+	s.unregisterEndpoint(endpointId)
+}
+
 // IsRegistered returns true if given endpoint is registered.
 func (s *Store) IsRegistered(endpointId interface{}) bool {
 	return s.isRegistered(endpointId)
@@ -679,6 +916,14 @@ func (s *Store) Earliest(name string, endpointId interface{}) float64 {
 // If multiple time series with different numeric types exist for the
 // same name, TsdbTimeSeries merges them together in the result as floats.
 // Any inactive flags found are not reflected in returned value.
+//
+// If no time series with name exists but a distribution time series
+// named name minus its suffix does, TsdbTimeSeries derives the returned
+// time series from that distribution. The suffixes are ".count" and ".sum"
+// for the running count and sum of values, ".avg" for the average, and
+// ".p" followed by 1 to 99 for a percentile such as ".p99". Averages and
+// percentiles of cumulative distributions cover only the values added since
+// the previous timestamp.
 func (s *Store) TsdbTimeSeries(
 	name string,
 	endpointId interface{},
@@ -686,6 +931,57 @@ func (s *Store) TsdbTimeSeries(
 	return s.tsdbTimeSeries(name, endpointId, start, end)
 }
 
+// TsdbDistTimeSeries returns the distribution time series with given name
+// from given endpoint. Each value in the returned time series holds the
+// bucket counts of the values the distribution gained since the previous
+// timestamp, or of all its values if the distribution is not cumulative.
+// For cumulative distributions, the first timestamp in the time range
+// yields no value.
+//
+// If no distribution with name exists for given endpoint,
+// TsdbDistTimeSeries returns false for ok.
+//
+// start and end are seconds since Jan 1, 1970 and denote the start time
+// inclusive and the end time exclusive.
+func (s *Store) TsdbDistTimeSeries(
+	name string,
+	endpointId interface{},
+	start, end float64) (
+	result tsdb.DistTimeSeries, earliest float64, ok bool) {
+	return s.tsdbDistTimeSeries(name, endpointId, start, end)
+}
+
+// TsdbTimeSeriesRollUp works like TsdbTimeSeries except that for times
+// whose values this store has evicted, it returns the rolled up values
+// that field selects from the given roll up tier. Callers should choose
+// field to match how they down sample the result, for instance RollUpMax
+// when taking the maximum. tier is an index into the slice that RollUpTiers
+// returns. The returned earliest is the earliest time for which the tier
+// has values or 0.0 if the tier has not dropped any values.
+// If the time series has no such tier, TsdbTimeSeriesRollUp returns
+// (nil, 0.0, false).
+func (s *Store) TsdbTimeSeriesRollUp(
+	name string,
+	endpointId interface{},
+	tier int,
+	field RollUpField,
+	start, end float64) (result tsdb.TimeSeries, earliest float64, ok bool) {
+	return s.tsdbTimeSeriesRollUp(name, endpointId, tier, field, start, end)
+}
+
+// RollUpsByNameAndEndpoint returns the rolled up values in the given tier
+// of the numeric metric with given name and endpoint in ascending order
+// by time. RollUpsByNameAndEndpoint returns only rolled up values that
+// overlap start inclusive to end exclusive. If the metric has no such
+// tier, RollUpsByNameAndEndpoint returns nil, false.
+func (s *Store) RollUpsByNameAndEndpoint(
+	name string,
+	endpointId interface{},
+	tier int,
+	start, end float64) ([]RollUpValue, bool) {
+	return s.rollUpsByNameAndEndpoint(name, endpointId, tier, start, end)
+}
+
 // FloatVar represents a floating point random variable
 type FloatVar struct {
 	Sum   float64
@@ -878,6 +1174,67 @@ func (s *Store) IsEndpointActive(endpointId interface{}) bool {
 	return s.isEndpointActive(endpointId)
 }
 
+// WriteCheckpoint writes the pages, metric meta data, timestamps, and
+// named iterator progress of each endpoint in this store to w.
+// keyFunc returns the key that identifies an endpoint across restarts.
+// WriteCheckpoint skips endpoints for which keyFunc returns the empty string.
+// It is safe to call WriteCheckpoint while values are being added to
+// this store.
+func (s *Store) WriteCheckpoint(
+	w io.Writer, keyFunc func(endpointId interface{}) string) error {
+	return s.writeCheckpoint(w, keyFunc)
+}
+
+// ReadCheckpoint reads a checkpoint that WriteCheckpoint wrote.
+func ReadCheckpoint(r io.Reader) (*Checkpoint, error) {
+	return readCheckpoint(r)
+}
+
+// RestoreEndpoint restores the data saved under key in checkpoint to the
+// given registered endpoint and removes that data from checkpoint.
+// RestoreEndpoint returns true if it restored data. If checkpoint has no
+// data for key or if the endpoint already has values, RestoreEndpoint
+// returns false.
+func (s *Store) RestoreEndpoint(
+	endpointId interface{}, key string, checkpoint *Checkpoint) bool {
+	return s.restoreEndpoint(endpointId, key, checkpoint)
+}
+
+// OpenWAL opens the write-ahead log in dir creating dir if necessary.
+// The returned WAL writes to a new segment after any segments already in
+// dir. maxSegmentBytes is the size at which WAL starts a new segment;
+// 0 means no limit.
+func OpenWAL(dir string, maxSegmentBytes int64) (*WAL, error) {
+	return openWAL(dir, maxSegmentBytes)
+}
+
+// ReadWAL reads all the segments of the write-ahead log in dir.
+// Call ReadWAL before OpenWAL. ReadWAL skips torn or corrupt records along
+// with the rest of the segment containing them.
+func ReadWAL(dir string) (*WALReplay, error) {
+	return readWAL(dir)
+}
+
+// SetWAL makes this store log each batch passed to AddBatch to wal.
+// keyFunc returns the key that identifies an endpoint across restarts.
+// Batches for endpoints for which keyFunc returns the empty string are not
+// logged. Caller must call SetWAL before registering any endpoints.
+func (s *Store) SetWAL(
+	wal *WAL, keyFunc func(endpointId interface{}) string) {
+	s.wal = wal
+	s.walKeyFunc = keyFunc
+}
+
+// ReplayEndpoint adds the batches logged under key in replay to the given
+// registered endpoint and removes them from replay. Call ReplayEndpoint
+// after RestoreEndpoint. ReplayEndpoint skips batches no newer than the
+// latest batch the endpoint already has. ReplayEndpoint returns the number
+// of batches it added.
+func (s *Store) ReplayEndpoint(
+	endpointId interface{}, key string, replay *WALReplay) (int, error) {
+	return s.replayEndpoint(endpointId, key, replay)
+}
+
 // Coordinator coordinates writes to persistent stores across multiple scotty
 // processes. Each scotty process should have only one coordinator shared
 // among the goroutines writing to persistent store. This one coordinator
@@ -3,11 +3,17 @@ package btreepq
 
 import (
 	"github.com/google/btree"
+	"math"
 )
 
 // IsPageLessThanThat returns true if page should be evicted / reused before
-// that.
+// that. When both pages have finite timestamps, the page in the lower
+// class comes first regardless of timestamp.
 func IsPageLessThanThat(page, that Page) bool {
+	if !math.IsInf(page.TS(), 0) && !math.IsInf(that.TS(), 0) &&
+		page.Class() != that.Class() {
+		return page.Class() < that.Class()
+	}
 	if page.TS() < that.TS() {
 		return true
 	}
@@ -39,6 +45,16 @@ type Page interface {
 	SetTS(ts float64)
 	// Returns the timestamp.
 	TS() float64
+	// Sets the group of this page. Only PageQueue uses this.
+	// Clients must not call this directly.
+	SetGroup(group interface{})
+	// Returns the group of this page. nil means no group.
+	Group() interface{}
+	// Sets the class of this page. Only PageQueue uses this.
+	// Clients must not call this directly.
+	SetClass(class int)
+	// Returns the class of this page.
+	Class() int
 }
 
 // PageQueueStats represents statistics for a PageQueue
@@ -58,6 +74,18 @@ func (s *PageQueueStats) HighPriorityRatio() float64 {
 	return float64(s.HighPriorityCount) / float64(s.TotalCount())
 }
 
+// GroupStats represents statistics for a single group of pages in a
+// PageQueue
+type GroupStats struct {
+	Group             interface{}
+	LowPriorityCount  uint
+	HighPriorityCount uint
+}
+
+func (s *GroupStats) TotalCount() uint {
+	return s.LowPriorityCount + s.HighPriorityCount
+}
+
 // PageQueue represents the page queue for scotty.
 // Instances have two internal queues: A low priority and a high priority queue.
 // Generally, the high priority queue contain the pages that are less valuable
@@ -69,10 +97,18 @@ func (s *PageQueueStats) HighPriorityRatio() float64 {
 // data valuable to scotty.
 // NextPage always adds the page it returns back to the end of the low
 // priority queue. The low and high priority queues are mutually exclusive.
+//
+// Pages may also belong to a group. Capping the number of pages in a group
+// with NextPageForGroup keeps one group from taking pages away from all
+// the other groups.
 type PageQueue struct {
 	high *btree.BTree
 	low  *btree.BTree
 
+	// The high and low priority queues of each group
+	groups map[interface{}]*pageGroupType
+	degree uint
+
 	// Number of pages that must be in high before the page queue ignores low
 	// when finding the next page.
 	threshold uint
@@ -125,6 +161,32 @@ func (p *PageQueue) NewPage() Page {
 	return p.newPage()
 }
 
+// NextPageForGroup works like NextPage except that the returned page
+// belongs to group. If group already has quota or more pages,
+// NextPageForGroup returns the next page from group itself rather than from
+// the whole queue. A quota of 0 means no quota.
+// NextPage and NewPage remove the page they return from its group.
+func (p *PageQueue) NextPageForGroup(group interface{}, quota uint) Page {
+	return p.nextPageForGroup(group, quota)
+}
+
+// NewPageForGroup works like NewPage except that the returned page belongs
+// to group. If group already has quota or more pages, NewPageForGroup
+// works like NextPageForGroup and leaves the total page count unchanged.
+func (p *PageQueue) NewPageForGroup(group interface{}, quota uint) Page {
+	return p.newPageForGroup(group, quota)
+}
+
+// GroupLen returns the number of pages in group.
+func (p *PageQueue) GroupLen(group interface{}) uint {
+	return p.groupLen(group)
+}
+
+// GroupStats returns the statistics of each group in no particular order.
+func (p *PageQueue) GroupStats() []GroupStats {
+	return p.groupStats()
+}
+
 // RemovePage works like NextPage except that it removes returned page from
 // this queue entirely. If this instance already has the minimum number
 // of pages, RemovePages returns nil, false
@@ -135,19 +197,29 @@ func (p *PageQueue) RemovePage() (removed Page, ok bool) {
 // ReclaimHigh moves pg from the low priority queue to the high priority queue
 // If pg is already in the high priority queue, ReclaimHigh is a no-op.
 func (p *PageQueue) ReclaimHigh(pg Page) {
-	moveFromTo(pg, p.low, p.high)
+	p.moveFromTo(pg, p.low, p.high)
 }
 
 // ReclaimLow moves pg from the high priority queue to the low priority queue
 // If pg is already in the low priority queue, ReclaimLow is a no-op.
 func (p *PageQueue) ReclaimLow(pg Page) {
-	moveFromTo(pg, p.high, p.low)
+	p.moveFromTo(pg, p.high, p.low)
 }
 
 // Prioritise prioritises pg according to ts. Pages with lower ts values come
 // off the queue first. By default, the ts for a page is +Inf.
 func (p *PageQueue) Prioritise(pg Page, ts float64) {
-	p.prioritise(pg, ts)
+	p.prioritise(pg, ts, pg.Class())
+}
+
+// PrioritiseInClass works like Prioritise except that it also puts pg in
+// given class. If ts is finite, pg comes off the queue before all pages
+// in higher classes and after all pages in lower classes that also have
+// finite timestamps. By default, the class for a page is 0.
+// NextPage, NewPage, and their group variants put the page they return
+// back in class 0.
+func (p *PageQueue) PrioritiseInClass(pg Page, ts float64, class int) {
+	p.prioritise(pg, ts, class)
 }
 
 // Stats gets the statistics for this instance
@@ -33,12 +33,81 @@ func _new(
 	return &PageQueue{
 		high:      high,
 		low:       low,
+		groups:    make(map[interface{}]*pageGroupType),
+		degree:    degree,
 		threshold: threshold,
 		nextSeqNo: uint64(pageCount),
 		creater:   creater}
 }
 
+// pageGroupType holds the pages of a single group. Each page in a group's
+// high queue is also in the page queue's high queue. Likewise for the low
+// queue.
+type pageGroupType struct {
+	high *btree.BTree
+	low  *btree.BTree
+}
+
+func (g *pageGroupType) Len() uint {
+	return uint(g.high.Len()) + uint(g.low.Len())
+}
+
+// queueFor returns the queue in this group that corresponds to the
+// page queue's queue.
+func (p *PageQueue) queueFor(g *pageGroupType, queue *btree.BTree) *btree.BTree {
+	if queue == p.high {
+		return g.high
+	}
+	return g.low
+}
+
+func (p *PageQueue) groupOf(pg Page) *pageGroupType {
+	if pg.Group() == nil {
+		return nil
+	}
+	return p.groups[pg.Group()]
+}
+
+// leaveGroup removes pg from its group. pg must not be in either
+// queue of the page queue.
+func (p *PageQueue) leaveGroup(pg Page) {
+	group := p.groupOf(pg)
+	if group == nil {
+		return
+	}
+	if group.high.Delete(pg) == nil {
+		group.low.Delete(pg)
+	}
+	if group.Len() == 0 {
+		delete(p.groups, pg.Group())
+	}
+	pg.SetGroup(nil)
+}
+
+// joinGroup adds pg to the low priority queue of given group.
+func (p *PageQueue) joinGroup(pg Page, group interface{}) {
+	if group == nil {
+		return
+	}
+	pageGroup := p.groups[group]
+	if pageGroup == nil {
+		pageGroup = &pageGroupType{
+			high: btree.New(int(p.degree)),
+			low:  btree.New(int(p.degree)),
+		}
+		p.groups[group] = pageGroup
+	}
+	pg.SetGroup(group)
+	insert(pageGroup.low, pg)
+}
+
 func (p *PageQueue) popPage() Page {
+	result := p._popPage()
+	p.leaveGroup(result)
+	return result
+}
+
+func (p *PageQueue) _popPage() Page {
 	if uint(p.high.Len()) >= p.threshold {
 		return p.high.DeleteMin().(Page)
 	}
@@ -52,6 +121,67 @@ func (p *PageQueue) popPage() Page {
 	panic("Two pages with same sequence number found")
 }
 
+// popGroupPage pops the next page from group preferring its high
+// priority pages.
+func (p *PageQueue) popGroupPage(group *pageGroupType) Page {
+	var result Page
+	if group.high.Len() > 0 {
+		result = group.high.Min().(Page)
+		p.high.Delete(result)
+	} else {
+		result = group.low.Min().(Page)
+		p.low.Delete(result)
+	}
+	p.leaveGroup(result)
+	return result
+}
+
+func (p *PageQueue) isGroupFull(group interface{}, quota uint) bool {
+	return quota > 0 && p.groupLen(group) >= quota
+}
+
+func (p *PageQueue) nextPageForGroup(
+	group interface{}, quota uint) (next Page) {
+	if p.isGroupFull(group, quota) {
+		next = p.popGroupPage(p.groups[group])
+	} else {
+		next = p.popPage()
+	}
+	p.pushBack(next)
+	p.joinGroup(next, group)
+	return
+}
+
+func (p *PageQueue) newPageForGroup(
+	group interface{}, quota uint) (next Page) {
+	if p.isGroupFull(group, quota) {
+		return p.nextPageForGroup(group, quota)
+	}
+	next = p.newPage()
+	p.joinGroup(next, group)
+	return
+}
+
+func (p *PageQueue) groupLen(group interface{}) uint {
+	pageGroup := p.groups[group]
+	if pageGroup == nil {
+		return 0
+	}
+	return pageGroup.Len()
+}
+
+func (p *PageQueue) groupStats() []GroupStats {
+	result := make([]GroupStats, 0, len(p.groups))
+	for group, pageGroup := range p.groups {
+		result = append(result, GroupStats{
+			Group:             group,
+			LowPriorityCount:  uint(pageGroup.low.Len()),
+			HighPriorityCount: uint(pageGroup.high.Len()),
+		})
+	}
+	return result
+}
+
 func (p *PageQueue) removePage() (removed Page, ok bool) {
 	if p.high.Len()+p.low.Len() <= 1 {
 		return
@@ -62,6 +192,7 @@ func (p *PageQueue) removePage() (removed Page, ok bool) {
 func (p *PageQueue) pushBack(page Page) {
 	page.SetSeqNo(p.nextSeqNo)
 	page.SetTS(kInf)
+	page.SetClass(0)
 	p.nextSeqNo++
 	insert(p.low, page)
 }
@@ -78,7 +209,7 @@ func (p *PageQueue) nextPage() (next Page) {
 	return
 }
 
-func moveFromTo(pg Page, from, to *btree.BTree) {
+func (p *PageQueue) moveFromTo(pg Page, from, to *btree.BTree) {
 	aPage := from.Delete(pg)
 	if aPage == nil {
 		// If we can't find our page in the from list,
@@ -90,9 +221,14 @@ func moveFromTo(pg Page, from, to *btree.BTree) {
 		panic("Unrecongized page passed to ReclaimHigh or ReclaimLow")
 	}
 	insert(to, pageToMove)
+	if group := p.groupOf(pageToMove); group != nil {
+		p.queueFor(group, from).Delete(pageToMove)
+		insert(p.queueFor(group, to), pageToMove)
+	}
 }
 
-func prioritiseWith(pg Page, ts float64, dest *btree.BTree) bool {
+func (p *PageQueue) prioritiseWith(
+	pg Page, ts float64, class int, dest *btree.BTree) bool {
 	aPage := dest.Delete(pg)
 	if aPage == nil {
 		// Fail
@@ -102,16 +238,26 @@ func prioritiseWith(pg Page, ts float64, dest *btree.BTree) bool {
 	if pageToPrioritise != pg {
 		panic("Unrecongized page passed to Prioritise")
 	}
+	// Changing the timestamp or class changes the page's place in its
+	// group too.
+	group := p.groupOf(pageToPrioritise)
+	if group != nil {
+		p.queueFor(group, dest).Delete(pageToPrioritise)
+	}
 	pageToPrioritise.SetTS(ts)
+	pageToPrioritise.SetClass(class)
 	insert(dest, pageToPrioritise)
+	if group != nil {
+		insert(p.queueFor(group, dest), pageToPrioritise)
+	}
 	return true
 }
 
-func (p *PageQueue) prioritise(pg Page, ts float64) {
-	if prioritiseWith(pg, ts, p.low) {
+func (p *PageQueue) prioritise(pg Page, ts float64, class int) {
+	if p.prioritiseWith(pg, ts, class, p.low) {
 		return
 	}
-	if prioritiseWith(pg, ts, p.high) {
+	if p.prioritiseWith(pg, ts, class, p.high) {
 		return
 	}
 }
@@ -7,8 +7,10 @@ import (
 )
 
 type pageForTesting struct {
-	seq uint64
-	ts  float64
+	seq   uint64
+	ts    float64
+	group interface{}
+	class int
 }
 
 func (p *pageForTesting) SetSeqNo(i uint64) {
@@ -27,6 +29,22 @@ func (p *pageForTesting) TS() float64 {
 	return p.ts
 }
 
+func (p *pageForTesting) SetGroup(group interface{}) {
+	p.group = group
+}
+
+func (p *pageForTesting) Group() interface{} {
+	return p.group
+}
+
+func (p *pageForTesting) SetClass(class int) {
+	p.class = class
+}
+
+func (p *pageForTesting) Class() int {
+	return p.class
+}
+
 func (p *pageForTesting) Less(than btree.Item) bool {
 	pthan := than.(btreepq.Page)
 	return btreepq.IsPageLessThanThat(p, pthan)
@@ -81,6 +99,42 @@ func TestPrioritise(t *testing.T) {
 	assertValueEquals(t, uint(8), queue.Len())
 }
 
+func TestClasses(t *testing.T) {
+	var pages [6]btreepq.Page
+	queue := btreepq.New(
+		uint(len(pages)),
+		6,
+		10,
+		func() btreepq.Page {
+			return &pageForTesting{}
+		})
+	for i := range pages {
+		pages[i] = queue.NextPage()
+	}
+	queue.PrioritiseInClass(pages[0], 10.0, 1)
+	queue.PrioritiseInClass(pages[1], 20.0, -1)
+	queue.Prioritise(pages[2], 5.0)
+	queue.Prioritise(pages[3], 30.0)
+	queue.PrioritiseInClass(pages[4], 40.0, -1)
+	// pages[5] isn't full yet so it comes after all full pages
+
+	// Class -1, then class 0, then class 1
+	assertNextValues(t, queue, pages[:], 1, 4, 2, 3, 0, 5)
+
+	// Reused pages go back to class 0
+	assertValueEquals(t, 0, pages[1].Class())
+
+	// A full page in a high class still comes before pages that aren't
+	// full.
+	queue.PrioritiseInClass(pages[3], 50.0, 1)
+	assertNextValues(t, queue, pages[:], 3, 1, 4, 2, 0, 5)
+
+	queue.PrioritiseInClass(pages[0], 50.0, 1)
+	queue.PrioritiseInClass(pages[2], 60.0, -1)
+	removed, _ := queue.RemovePage()
+	assertValueEquals(t, pages[2], removed)
+}
+
 func TestRemovePages(t *testing.T) {
 	var pages [8]btreepq.Page
 	queue := btreepq.New(
@@ -188,6 +242,76 @@ func TestAPI(t *testing.T) {
 	assertNextValues(t, queue, pages[:], 0, 4, 1, 5, 6, 2, 3, 7)
 }
 
+func TestGroups(t *testing.T) {
+	var pages [8]btreepq.Page
+	queue := btreepq.New(
+		uint(len(pages)),
+		8,
+		10,
+		func() btreepq.Page {
+			return &pageForTesting{}
+		})
+	// Pages 0-1 go to group a, pages 2-7 go to group b.
+	for i := range pages {
+		group := "b"
+		if i < 2 {
+			group = "a"
+		}
+		pages[i] = queue.NextPageForGroup(group, 0)
+	}
+	verifyPagesAreUnique(t, pages[:])
+	assertValueEquals(t, uint(2), queue.GroupLen("a"))
+	assertValueEquals(t, uint(6), queue.GroupLen("b"))
+	assertValueEquals(t, uint(0), queue.GroupLen("c"))
+
+	// Group b is at its quota so it reuses its own pages.
+	assertValueEquals(t, pages[2], queue.NextPageForGroup("b", 6))
+	assertValueEquals(t, pages[3], queue.NextPageForGroup("b", 6))
+	assertValueEquals(t, uint(6), queue.GroupLen("b"))
+
+	// Group b's high priority pages go first
+	queue.ReclaimHigh(pages[6])
+	assertValueEquals(t, pages[6], queue.NextPageForGroup("b", 6))
+
+	// Prioritising a page changes its order within its group.
+	queue.Prioritise(pages[5], 1.0)
+	assertValueEquals(t, pages[5], queue.NextPageForGroup("b", 6))
+
+	// Group a is under quota, so it takes the oldest page in the queue.
+	assertValueEquals(t, pages[0], queue.NextPageForGroup("a", 6))
+	assertValueEquals(t, pages[1], queue.NextPageForGroup("a", 6))
+	assertValueEquals(t, pages[4], queue.NextPageForGroup("a", 6))
+	assertValueEquals(t, uint(3), queue.GroupLen("a"))
+	assertValueEquals(t, uint(5), queue.GroupLen("b"))
+
+	// NextPage takes pages out of their group
+	assertValueEquals(t, pages[7], queue.NextPage())
+	assertValueEquals(t, uint(4), queue.GroupLen("b"))
+
+	// At quota, NewPageForGroup doesn't grow the queue.
+	assertValueEquals(t, pages[0], queue.NewPageForGroup("a", 3))
+	assertValueEquals(t, uint(8), queue.Len())
+	queue.NewPageForGroup("a", 4)
+	assertValueEquals(t, uint(9), queue.Len())
+	assertValueEquals(t, uint(4), queue.GroupLen("a"))
+
+	stats := queue.GroupStats()
+	assertValueEquals(t, 2, len(stats))
+	var total uint
+	for i := range stats {
+		total += stats[i].TotalCount()
+	}
+	assertValueEquals(t, uint(8), total)
+
+	// Removing pages takes them out of their groups
+	for i := 0; i < 8; i++ {
+		queue.RemovePage()
+	}
+	assertValueEquals(t, uint(1), queue.Len())
+	queue.NextPage()
+	assertValueEquals(t, 0, len(queue.GroupStats()))
+}
+
 func assertValueEquals(t *testing.T, expected, actual interface{}) {
 	if expected != actual {
 		t.Errorf("Expected %v, got %v", expected, actual)
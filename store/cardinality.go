@@ -0,0 +1,190 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+// This file contains all the code for tracking how many time series each
+// endpoint has.
+
+// cardinalityStatsType contains time series counts across all endpoints.
+type cardinalityStatsType struct {
+	// Number of time series across all endpoints
+	SeriesCount uint64
+	// Most time series any one endpoint has
+	MaxSeriesCount uint
+	// The endpoint with the most time series
+	LargestEndpoint string
+	// Most time series any one application has across its endpoints
+	MaxAppSeriesCount uint
+	// The application with the most time series
+	LargestApp string
+	// New time series in the latest batch of each endpoint
+	NewSeriesCount uint64
+	// New time series since scotty started
+	TotalNewSeriesCount uint64
+}
+
+// cardinalityTrackerType tracks the time series count of each endpoint.
+// It is shared by all copies of a Store so that it sees every endpoint.
+type cardinalityTrackerType struct {
+	lock       sync.Mutex
+	byEndpoint map[interface{}]*Cardinality
+}
+
+func (t *cardinalityTrackerType) get(endpointId interface{}) *Cardinality {
+	if t.byEndpoint == nil {
+		t.byEndpoint = make(map[interface{}]*Cardinality)
+	}
+	result := t.byEndpoint[endpointId]
+	if result == nil {
+		result = &Cardinality{EndpointId: endpointId}
+		t.byEndpoint[endpointId] = result
+	}
+	return result
+}
+
+// LogBatch records the time series counts of an endpoint after it
+// receives a batch of values.
+// seriesCount is the total number of time series the endpoint has;
+// activeCount is how many of them were in the batch; newCount is how many
+// of them are new.
+func (t *cardinalityTrackerType) LogBatch(
+	endpointId interface{}, seriesCount, activeCount, newCount uint) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	c := t.get(endpointId)
+	c.SeriesCount = seriesCount
+	c.ActiveSeriesCount = activeCount
+	c.NewSeriesCount = newCount
+	c.TotalNewSeriesCount += uint64(newCount)
+}
+
+// LogRestore records the time series counts of an endpoint restored
+// from a checkpoint. seriesCount is the total number of time series the
+// endpoint has; activeCount is how many of them are active. Restored time
+// series don't count as new.
+func (t *cardinalityTrackerType) LogRestore(
+	endpointId interface{}, seriesCount, activeCount uint) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	c := t.get(endpointId)
+	c.SeriesCount = seriesCount
+	c.ActiveSeriesCount = activeCount
+	c.NewSeriesCount = 0
+}
+
+// LogInactive records that all the time series of an endpoint went
+// inactive.
+func (t *cardinalityTrackerType) LogInactive(endpointId interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if c := t.byEndpoint[endpointId]; c != nil {
+		c.ActiveSeriesCount = 0
+		c.NewSeriesCount = 0
+	}
+}
+
+// Forget drops the time series counts of an endpoint that is no longer
+// registered.
+func (t *cardinalityTrackerType) Forget(endpointId interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.byEndpoint, endpointId)
+}
+
+// ByEndpoint returns the time series counts of each endpoint. Endpoints
+// with the most time series come first.
+func (t *cardinalityTrackerType) ByEndpoint() []Cardinality {
+	t.lock.Lock()
+	result := make([]Cardinality, 0, len(t.byEndpoint))
+	for _, c := range t.byEndpoint {
+		result = append(result, *c)
+	}
+	t.lock.Unlock()
+	sort.Sort(cardinalityBySeriesCountType(result))
+	return result
+}
+
+// ByApp returns the time series counts of each application. Applications
+// with the most time series come first.
+func (t *cardinalityTrackerType) ByApp() []AppCardinality {
+	return cardinalityByApp(t.ByEndpoint())
+}
+
+func (t *cardinalityTrackerType) Stats(stats *cardinalityStatsType) {
+	byEndpoint := t.ByEndpoint()
+	byApp := cardinalityByApp(byEndpoint)
+	*stats = cardinalityStatsType{}
+	if len(byEndpoint) > 0 {
+		stats.MaxSeriesCount = byEndpoint[0].SeriesCount
+		stats.LargestEndpoint = endpointName(byEndpoint[0].EndpointId)
+	}
+	if len(byApp) > 0 {
+		stats.MaxAppSeriesCount = byApp[0].SeriesCount
+		stats.LargestApp = byApp[0].AppName
+	}
+	for i := range byEndpoint {
+		stats.SeriesCount += uint64(byEndpoint[i].SeriesCount)
+		stats.NewSeriesCount += uint64(byEndpoint[i].NewSeriesCount)
+		stats.TotalNewSeriesCount += byEndpoint[i].TotalNewSeriesCount
+	}
+}
+
+// cardinalityByApp sums the time series counts of endpoints by
+// application. It skips endpoints whose ids don't know their application.
+func cardinalityByApp(byEndpoint []Cardinality) (result []AppCardinality) {
+	indexByApp := make(map[string]int)
+	for i := range byEndpoint {
+		namer, ok := byEndpoint[i].EndpointId.(appNamerType)
+		if !ok {
+			continue
+		}
+		appName := namer.AppName()
+		idx, ok := indexByApp[appName]
+		if !ok {
+			idx = len(result)
+			indexByApp[appName] = idx
+			result = append(result, AppCardinality{AppName: appName})
+		}
+		app := &result[idx]
+		app.EndpointCount++
+		app.SeriesCount += byEndpoint[i].SeriesCount
+		app.ActiveSeriesCount += byEndpoint[i].ActiveSeriesCount
+		app.NewSeriesCount += byEndpoint[i].NewSeriesCount
+		app.TotalNewSeriesCount += byEndpoint[i].TotalNewSeriesCount
+	}
+	sort.Sort(appCardinalityBySeriesCountType(result))
+	return
+}
+
+type cardinalityBySeriesCountType []Cardinality
+
+func (c cardinalityBySeriesCountType) Len() int { return len(c) }
+
+func (c cardinalityBySeriesCountType) Less(i, j int) bool {
+	if c[i].SeriesCount != c[j].SeriesCount {
+		return c[i].SeriesCount > c[j].SeriesCount
+	}
+	return endpointName(c[i].EndpointId) < endpointName(c[j].EndpointId)
+}
+
+func (c cardinalityBySeriesCountType) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+}
+
+type appCardinalityBySeriesCountType []AppCardinality
+
+func (a appCardinalityBySeriesCountType) Len() int { return len(a) }
+
+func (a appCardinalityBySeriesCountType) Less(i, j int) bool {
+	if a[i].SeriesCount != a[j].SeriesCount {
+		return a[i].SeriesCount > a[j].SeriesCount
+	}
+	return a[i].AppName < a[j].AppName
+}
+
+func (a appCardinalityBySeriesCountType) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
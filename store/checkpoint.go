@@ -0,0 +1,585 @@
+package store
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"io"
+	"sync"
+	"time"
+)
+
+// This file contains the code for checkpointing the store to disk and
+// restoring it again.
+
+const (
+	kCheckpointVersion = 1
+)
+
+var (
+	errBadCheckpoint = errors.New("store: corrupt checkpoint")
+)
+
+func init() {
+	// Metric values are stored as interface{} so gob must know about
+	// every non basic type a value could be.
+	gob.Register(time.Time{})
+	gob.Register(time.Duration(0))
+	gob.Register([]time.Time(nil))
+	gob.Register([]time.Duration(nil))
+	gob.Register(&DistributionTotals{})
+}
+
+// checkpointHeaderType is the first thing in a checkpoint.
+type checkpointHeaderType struct {
+	Version       int
+	EndpointCount int
+}
+
+// metricInfoCheckpointType is a MetricInfo in checkpoint form.
+type metricInfoCheckpointType struct {
+	Path            string
+	Description     string
+	Unit            units.Unit
+	Kind            types.Type
+	SubType         types.Type
+	HasRanges       bool
+	UpperLimits     []float64
+	IsNotCumulative bool
+	GroupId         int
+}
+
+// tsValueCheckpointType is a tsValueType in checkpoint form.
+type tsValueCheckpointType struct {
+	TimeStamp float64
+	Inactive  bool
+	Value     interface{}
+}
+
+// timeSeriesCheckpointType is a timeSeriesType in checkpoint form.
+// Values are in ascending order by timestamp. The last value is the
+// latest value of the time series.
+type timeSeriesCheckpointType struct {
+	InfoIndex int
+	Values    []tsValueCheckpointType
+	RollUps   *rollUpsCheckpointType
+}
+
+// rollUpsCheckpointType is a rollUpsType in checkpoint form.
+type rollUpsCheckpointType struct {
+	RolledUpTo float64
+	Tiers      []rollUpTierCheckpointType
+}
+
+// rollUpTierCheckpointType is a rollUpTierType in checkpoint form.
+// Buckets are in ascending order by time.
+type rollUpTierCheckpointType struct {
+	Width   float64
+	Dropped bool
+	Buckets []rollUpBucketType
+}
+
+// timestampSeriesCheckpointType is a timestampSeriesType in checkpoint form.
+// TimeStamps are in ascending order. The last timestamp is the latest
+// timestamp of the series.
+type timestampSeriesCheckpointType struct {
+	GroupId    int
+	Active     bool
+	TimeStamps []float64
+}
+
+// iteratorCheckpointType is the saved progress of a named iterator.
+// Completed is keyed by index into the metric infos of the endpoint.
+type iteratorCheckpointType struct {
+	Name            string
+	StartTimeStamps map[int]float64
+	Completed       map[int]float64
+}
+
+// rollOverCheckpointType is a distributionRollOverType in checkpoint form.
+type rollOverCheckpointType struct {
+	Path          string
+	HasRanges     bool
+	UpperLimits   []float64
+	Generation    uint64
+	RollOverCount uint64
+}
+
+// endpointCheckpointType is everything stored for a single endpoint.
+type endpointCheckpointType struct {
+	Key             string
+	Infos           []metricInfoCheckpointType
+	TimeSeries      []timeSeriesCheckpointType
+	TimestampSeries []timestampSeriesCheckpointType
+	Iterators       []iteratorCheckpointType
+	RollOvers       []rollOverCheckpointType
+	// scotty timestamp of the last batch added to the endpoint
+	LastBatchTimeStamp float64
+}
+
+func toMetricInfoCheckpoint(info *MetricInfo) metricInfoCheckpointType {
+	result := metricInfoCheckpointType{
+		Path:            info.path,
+		Description:     info.description,
+		Unit:            info.unit,
+		Kind:            info.kind,
+		SubType:         info.subType,
+		IsNotCumulative: info.isNotCumulative,
+		GroupId:         info.groupId,
+	}
+	if info.ranges != nil {
+		result.HasRanges = true
+		result.UpperLimits = info.ranges.UpperLimits
+	}
+	return result
+}
+
+func toTsValueCheckpoint(value tsValueType) tsValueCheckpointType {
+	if value.Value == gInactive {
+		return tsValueCheckpointType{TimeStamp: value.TimeStamp, Inactive: true}
+	}
+	return tsValueCheckpointType{TimeStamp: value.TimeStamp, Value: value.Value}
+}
+
+func (v *tsValueCheckpointType) value() interface{} {
+	if v.Inactive {
+		return gInactive
+	}
+	return v.Value
+}
+
+// Checkpoint returns the values in this time series in checkpoint form.
+// Checkpoint skips inactive markers that come before the first real value
+// as they mark nothing, and restore would make them the first value of the
+// restored series.
+func (t *timeSeriesType) Checkpoint(infoIndex int) timeSeriesCheckpointType {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var values []tsValueCheckpointType
+	for e := t.pages.pages.Front(); e != nil; e = e.Next() {
+		page := *e.Value.(*pageWithMetaDataType).Values()
+		for i := range page {
+			if len(values) == 0 && page[i].Value == gInactive {
+				continue
+			}
+			values = append(values, toTsValueCheckpoint(page[i]))
+		}
+	}
+	if len(values) != 0 || t.lastValue.Value != gInactive {
+		values = append(values, toTsValueCheckpoint(t.lastValue))
+	}
+	result := timeSeriesCheckpointType{InfoIndex: infoIndex, Values: values}
+	if t.rollUps != nil {
+		result.RollUps = t.rollUps.Checkpoint()
+	}
+	return result
+}
+
+// Checkpoint returns these roll ups in checkpoint form.
+func (r *rollUpsType) Checkpoint() *rollUpsCheckpointType {
+	result := &rollUpsCheckpointType{
+		RolledUpTo: r.rolledUpTo,
+		Tiers:      make([]rollUpTierCheckpointType, len(r.tiers)),
+	}
+	for i := range r.tiers {
+		tier := &r.tiers[i]
+		buckets := make([]rollUpBucketType, tier.Len())
+		for j := range buckets {
+			buckets[j] = *tier.At(j)
+		}
+		result.Tiers[i] = rollUpTierCheckpointType{
+			Width:   tier.width,
+			Dropped: tier.dropped,
+			Buckets: buckets,
+		}
+	}
+	return result
+}
+
+// RestoreRollUps restores the roll ups of this time series from checkpoint.
+// RestoreRollUps returns how many more bytes the roll ups use as a result.
+func (t *timeSeriesType) RestoreRollUps(
+	checkpoint *rollUpsCheckpointType) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.rollUps == nil {
+		return 0
+	}
+	oldBytes := t.rollUps.Bytes()
+	t.rollUps.Restore(checkpoint)
+	return t.rollUps.Bytes() - oldBytes
+}
+
+// Restore restores these roll ups from checkpoint. Restore skips tiers
+// in checkpoint that these roll ups don't have.
+func (r *rollUpsType) Restore(checkpoint *rollUpsCheckpointType) {
+	r.rolledUpTo = checkpoint.RolledUpTo
+	for i := range r.tiers {
+		tier := &r.tiers[i]
+		for j := range checkpoint.Tiers {
+			tierCheckpoint := &checkpoint.Tiers[j]
+			if tierCheckpoint.Width != tier.width {
+				continue
+			}
+			buckets := tierCheckpoint.Buckets
+			tier.dropped = tierCheckpoint.Dropped
+			if len(buckets) > tier.capacity {
+				buckets = buckets[len(buckets)-tier.capacity:]
+				tier.dropped = true
+			}
+			tier.buckets = append(
+				make([]rollUpBucketType, 0, len(buckets)), buckets...)
+			tier.first = 0
+		}
+	}
+}
+
+// Checkpoint returns the timestamps in this series in checkpoint form.
+func (t *timestampSeriesType) Checkpoint() timestampSeriesCheckpointType {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var timestamps []float64
+	for e := t.pages.pages.Front(); e != nil; e = e.Next() {
+		page := *e.Value.(*pageWithMetaDataType).Times()
+		for i := range page {
+			timestamps = append(timestamps, page[i].TimeStamp)
+		}
+	}
+	timestamps = append(timestamps, t.lastTs)
+	return timestampSeriesCheckpointType{
+		GroupId:    t.groupId,
+		Active:     t.active,
+		TimeStamps: timestamps,
+	}
+}
+
+// SetActive sets whether or not this series is active without adding
+// any timestamps. Only used when restoring from a checkpoint.
+func (t *timestampSeriesType) SetActive(active bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.active = active
+}
+
+// IsEmpty returns true if no time series have been added to this instance.
+func (c *timeSeriesCollectionType) IsEmpty() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timeSeries) == 0 && len(c.timestampSeries) == 0
+}
+
+// Checkpoint returns everything in this collection in checkpoint form.
+func (c *timeSeriesCollectionType) Checkpoint(
+	key string) *endpointCheckpointType {
+	// Prevent values from being added while we work so that timestamps
+	// and values stay consistent with each other.
+	c.statusChangeLock.Lock()
+	defer c.statusChangeLock.Unlock()
+	result := &endpointCheckpointType{
+		Key:                key,
+		LastBatchTimeStamp: c.lastBatchTimeStamp,
+	}
+	infoIndexes := make(map[*MetricInfo]int)
+	c.lock.Lock()
+	for _, info := range c.metricInfoStore.ByInfo {
+		infoIndexes[info] = len(result.Infos)
+		result.Infos = append(result.Infos, toMetricInfoCheckpoint(info))
+	}
+	for name, progress := range c.iterators {
+		completed := make(map[int]float64, len(progress.completed))
+		for info, ts := range progress.completed {
+			completed[infoIndexes[info]] = ts
+		}
+		result.Iterators = append(
+			result.Iterators,
+			iteratorCheckpointType{
+				Name:            name,
+				StartTimeStamps: progress.startTimeStamps,
+				Completed:       completed,
+			})
+	}
+	for path, rollOver := range c.distributionRollOversByPath {
+		rollOverCheckpoint := rollOverCheckpointType{
+			Path:          path,
+			Generation:    rollOver.generation,
+			RollOverCount: rollOver.rollOverCount,
+		}
+		if rollOver.ranges != nil {
+			rollOverCheckpoint.HasRanges = true
+			rollOverCheckpoint.UpperLimits = rollOver.ranges.UpperLimits
+		}
+		result.RollOvers = append(result.RollOvers, rollOverCheckpoint)
+	}
+	timeSeries := c.tsAll()
+	timestampSeries := c.tsAllTimeStamps()
+	c.lock.Unlock()
+	for _, ts := range timeSeries {
+		result.TimeSeries = append(
+			result.TimeSeries, ts.Checkpoint(infoIndexes[ts.id]))
+	}
+	for _, ts := range timestampSeries {
+		result.TimestampSeries = append(
+			result.TimestampSeries, ts.Checkpoint())
+	}
+	return result
+}
+
+// registerInfo returns the MetricInfo instance from the pool for a
+// metric info in checkpoint form.
+func (m *metricInfoStoreType) registerInfo(
+	info *metricInfoCheckpointType, policy RetentionPolicy) *MetricInfo {
+	var ranges *Ranges
+	if info.HasRanges {
+		ranges = m.rangesCache.Get(info.Path, info.UpperLimits)
+	}
+	infoStruct := MetricInfo{
+		path:            info.Path,
+		description:     info.Description,
+		unit:            info.Unit,
+		kind:            info.Kind,
+		subType:         info.SubType,
+		ranges:          ranges,
+		isNotCumulative: info.IsNotCumulative,
+		groupId:         info.GroupId}
+	result, alreadyExists := m.ByInfo[infoStruct]
+	if alreadyExists {
+		return result
+	}
+	return m.add(infoStruct, policy)
+}
+
+// restoreMetadata restores metric infos, iterator progress, and
+// distribution roll overs from checkpoint. Returns the restored metric infos
+// in the same order as they appear in checkpoint. generation is the
+// generation of policy.
+func (c *timeSeriesCollectionType) restoreMetadata(
+	checkpoint *endpointCheckpointType,
+	policy RetentionPolicy,
+	generation uint64) []*MetricInfo {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.retentionGeneration = generation
+	infos := make([]*MetricInfo, len(checkpoint.Infos))
+	for i := range checkpoint.Infos {
+		infos[i] = c.metricInfoStore.registerInfo(
+			&checkpoint.Infos[i], policy)
+	}
+	for _, iter := range checkpoint.Iterators {
+		var completed map[*MetricInfo]float64
+		if iter.Completed != nil {
+			completed = make(map[*MetricInfo]float64, len(iter.Completed))
+			for infoIndex, ts := range iter.Completed {
+				completed[infos[infoIndex]] = ts
+			}
+		}
+		c.iterators[iter.Name] = &namedIteratorDataType{
+			startTimeStamps: iter.StartTimeStamps,
+			completed:       completed,
+		}
+	}
+	for _, rollOver := range checkpoint.RollOvers {
+		var ranges *Ranges
+		if rollOver.HasRanges {
+			ranges = c.metricInfoStore.rangesCache.Get(
+				rollOver.Path, rollOver.UpperLimits)
+		}
+		c.distributionRollOversByPath[rollOver.Path] = &distributionRollOverType{
+			ranges:        ranges,
+			generation:    rollOver.Generation,
+			rollOverCount: rollOver.RollOverCount,
+		}
+	}
+	return infos
+}
+
+// Restore restores this collection from checkpoint. Restore is a no-op
+// returning false if this collection already has time series.
+func (c *timeSeriesCollectionType) Restore(
+	checkpoint *endpointCheckpointType, supplier *pageQueueType) bool {
+	c.statusChangeLock.Lock()
+	defer c.statusChangeLock.Unlock()
+	if !c.IsEmpty() {
+		return false
+	}
+	policy, generation := supplier.RetentionPolicy()
+	infos := c.restoreMetadata(checkpoint, policy, generation)
+	c.lastBatchTimeStamp = checkpoint.LastBatchTimeStamp
+	var reclaimHighList []pageListType
+	var addedCount int
+	var activeCount uint
+	var rollUpBytes int
+	classByGroupId := make(groupRetentionClassesType)
+	for _, tsCheckpoint := range checkpoint.TimeSeries {
+		if len(tsCheckpoint.Values) == 0 {
+			continue
+		}
+		first := &tsCheckpoint.Values[0]
+		timeSeries := newTimeSeriesType(
+			c.applicationId,
+			infos[tsCheckpoint.InfoIndex],
+			first.TimeStamp,
+			first.value(),
+			c.metrics,
+			c.rollUpTiers)
+		addedCount++
+		for i := 1; i < len(tsCheckpoint.Values); i++ {
+			value := &tsCheckpoint.Values[i]
+			if neededToAdd, _ := addToTimeSeries(
+				timeSeries,
+				value.TimeStamp,
+				value.value(),
+				supplier); neededToAdd {
+				addedCount++
+			}
+		}
+		if tsCheckpoint.RollUps != nil {
+			rollUpBytes += timeSeries.RestoreRollUps(tsCheckpoint.RollUps)
+		}
+		if tsCheckpoint.Values[len(tsCheckpoint.Values)-1].Inactive {
+			reclaimHighList = append(reclaimHighList, timeSeries.PageList())
+		} else {
+			activeCount++
+		}
+		classByGroupId.Add(timeSeries)
+		c.lock.Lock()
+		c.timeSeries[timeSeries.id] = timeSeries
+		c.lock.Unlock()
+	}
+	for _, tsCheckpoint := range checkpoint.TimestampSeries {
+		if len(tsCheckpoint.TimeStamps) == 0 {
+			continue
+		}
+		timestampSeries := newTimeStampSeriesType(
+			c.applicationId,
+			tsCheckpoint.GroupId,
+			tsCheckpoint.TimeStamps[0],
+			c.metrics)
+		classByGroupId.Set(timestampSeries)
+		for _, ts := range tsCheckpoint.TimeStamps[1:] {
+			addToTimeStampSeries(timestampSeries, ts, supplier)
+		}
+		if !tsCheckpoint.Active {
+			timestampSeries.SetActive(false)
+			reclaimHighList = append(
+				reclaimHighList, timestampSeries.PageList())
+		}
+		c.lock.Lock()
+		c.timestampSeries[timestampSeries.GroupId()] = timestampSeries
+		c.lock.Unlock()
+	}
+	supplier.ReclaimHigh(reclaimHighList)
+	supplier.AddRollUpBytes(rollUpBytes)
+	c.metrics.AddUniqueValues(addedCount)
+	c.lock.Lock()
+	seriesCount := uint(len(c.timeSeries))
+	c.lock.Unlock()
+	c.metrics.Cardinality.LogRestore(
+		c.applicationId, seriesCount, activeCount)
+	return true
+}
+
+// Checkpoint holds the saved state of a Store for restoring endpoints.
+// Checkpoint instances may be safely used with multiple goroutines.
+type Checkpoint struct {
+	lock  sync.Mutex
+	byKey map[string]*endpointCheckpointType
+}
+
+// Len returns the number of endpoints in this instance not yet restored.
+func (c *Checkpoint) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.byKey)
+}
+
+// take removes and returns the endpoint checkpoint for key or returns nil
+// if there is none.
+func (c *Checkpoint) take(key string) *endpointCheckpointType {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	result := c.byKey[key]
+	delete(c.byKey, key)
+	return result
+}
+
+func readCheckpoint(r io.Reader) (*Checkpoint, error) {
+	decoder := gob.NewDecoder(r)
+	var header checkpointHeaderType
+	if err := decoder.Decode(&header); err != nil {
+		return nil, err
+	}
+	if header.Version != kCheckpointVersion {
+		return nil, fmt.Errorf(
+			"store: unsupported checkpoint version %d", header.Version)
+	}
+	result := &Checkpoint{
+		byKey: make(map[string]*endpointCheckpointType, header.EndpointCount),
+	}
+	for i := 0; i < header.EndpointCount; i++ {
+		var endpoint endpointCheckpointType
+		if err := decoder.Decode(&endpoint); err != nil {
+			return nil, err
+		}
+		if err := endpoint.verify(); err != nil {
+			return nil, err
+		}
+		result.byKey[endpoint.Key] = &endpoint
+	}
+	return result, nil
+}
+
+// verify ensures that all the metric info indexes in this instance are
+// valid.
+func (e *endpointCheckpointType) verify() error {
+	infoLen := len(e.Infos)
+	for i := range e.TimeSeries {
+		if idx := e.TimeSeries[i].InfoIndex; idx < 0 || idx >= infoLen {
+			return errBadCheckpoint
+		}
+	}
+	for i := range e.Iterators {
+		for idx := range e.Iterators[i].Completed {
+			if idx < 0 || idx >= infoLen {
+				return errBadCheckpoint
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) writeCheckpoint(
+	w io.Writer, keyFunc func(endpointId interface{}) string) error {
+	keys := make(map[interface{}]string, len(s.byApplication))
+	for endpointId := range s.byApplication {
+		if key := keyFunc(endpointId); key != "" {
+			keys[endpointId] = key
+		}
+	}
+	encoder := gob.NewEncoder(w)
+	header := checkpointHeaderType{
+		Version:       kCheckpointVersion,
+		EndpointCount: len(keys),
+	}
+	if err := encoder.Encode(&header); err != nil {
+		return err
+	}
+	for endpointId, key := range keys {
+		if err := encoder.Encode(
+			s.byApplication[endpointId].Checkpoint(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) restoreEndpoint(
+	endpointId interface{}, key string, checkpoint *Checkpoint) bool {
+	endpointCheckpoint := checkpoint.take(key)
+	if endpointCheckpoint == nil {
+		return false
+	}
+	return s.byApplication[endpointId].Restore(
+		endpointCheckpoint, s.supplier)
+}
@@ -0,0 +1,181 @@
+package store_test
+
+import (
+	"bytes"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/scotty/store"
+	"testing"
+)
+
+func checkpointKey(endpointId interface{}) string {
+	if endpointId == kEndpoint0 {
+		return "endpoint0"
+	}
+	return ""
+}
+
+func TestCheckpointAndRestore(t *testing.T) {
+	aStore := newStore(t, "TestCheckpointAndRestore", 2, 100, 1.0, 10)
+	aStore.RegisterEndpoint(kEndpoint0)
+	aStore.RegisterEndpoint(kEndpoint1)
+	aMetric := metrics.SimpleList{
+		{
+			Path:        "/foo/bar",
+			Description: "A description",
+		},
+		{
+			Path:        "/foo/baz",
+			Description: "A description",
+		},
+	}
+	aMetric[0].Value = int64(6)
+	aMetric[1].Value = "hello"
+	addBatch(t, aStore, kEndpoint0, 900.0, aMetric[:].Sorted(), 2)
+	aMetric[0].Value = int64(16)
+	addBatch(t, aStore, kEndpoint0, 910.0, aMetric[:].Sorted(), 1)
+	aMetric[0].Value = int64(26)
+	addBatch(t, aStore, kEndpoint0, 920.0, aMetric[:].Sorted(), 1)
+	// /foo/baz goes inactive
+	aMetric[0].Value = int64(36)
+	addBatch(t, aStore, kEndpoint0, 930.0, aMetric[:1].Sorted(), 2)
+	addBatch(t, aStore, kEndpoint1, 930.0, aMetric[:1].Sorted(), 1)
+
+	// Consume the first two timestamps with a named iterator.
+	iterator, _ := aStore.NamedIteratorForEndpoint("aname", kEndpoint0, 2)
+	countItems(iterator)
+	iterator.Commit()
+
+	var buffer bytes.Buffer
+	if err := aStore.WriteCheckpoint(&buffer, checkpointKey); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := store.ReadCheckpoint(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, 1, checkpoint.Len())
+
+	restoredStore := newStore(
+		t, "TestCheckpointAndRestoreRestored", 2, 100, 1.0, 10)
+	restoredStore.RegisterEndpoint(kEndpoint0)
+	restoredStore.RegisterEndpoint(kEndpoint1)
+	assertValueEquals(
+		t,
+		false,
+		restoredStore.RestoreEndpoint(kEndpoint1, "endpoint1", checkpoint))
+	assertValueEquals(
+		t,
+		true,
+		restoredStore.RestoreEndpoint(kEndpoint0, "endpoint0", checkpoint))
+	assertValueEquals(t, 0, checkpoint.Len())
+
+	// Restored series count towards cardinality but aren't new.
+	byEndpoint := restoredStore.CardinalityByEndpoint()
+	if assertValueEquals(t, 1, len(byEndpoint)) {
+		assertValueEquals(
+			t,
+			store.Cardinality{
+				EndpointId:        kEndpoint0,
+				SeriesCount:       2,
+				ActiveSeriesCount: 1,
+			},
+			byEndpoint[0])
+	}
+	byApp := restoredStore.CardinalityByApp()
+	if assertValueEquals(t, 1, len(byApp)) {
+		assertValueEquals(t, uint(2), byApp[0].SeriesCount)
+		assertValueEquals(t, uint(1), byApp[0].ActiveSeriesCount)
+	}
+
+	for _, name := range []string{"/foo/bar", "/foo/baz"} {
+		var expected, actual []store.Record
+		aStore.ByNameAndEndpoint(
+			name, kEndpoint0, 0.0, 1000.0, store.AppendTo(&expected))
+		restoredStore.ByNameAndEndpoint(
+			name, kEndpoint0, 0.0, 1000.0, store.AppendTo(&actual))
+		if assertValueEquals(t, len(expected), len(actual)) {
+			for i := range expected {
+				assertValueEquals(t, expected[i].Info.Path(), actual[i].Info.Path())
+				assertValueEquals(t, expected[i].TimeStamp, actual[i].TimeStamp)
+				assertValueEquals(t, expected[i].Value, actual[i].Value)
+				assertValueEquals(t, expected[i].Active, actual[i].Active)
+			}
+		}
+	}
+
+	timeSeries, _, ok := restoredStore.TsdbTimeSeries(
+		"/foo/bar", kEndpoint0, 0.0, 1000.0)
+	if !ok {
+		t.Fatal("Expected to find /foo/bar")
+	}
+	assertValueEquals(t, 4, len(timeSeries))
+
+	// The restored iterator should continue where the original left off.
+	expectedTsValues := newExpectedTsValues()
+	expectedTsValues.Add("/foo/bar", 920.0, int64(26))
+	expectedTsValues.Add("/foo/baz", 920.0, "hello")
+	expectedTsValues.Add("/foo/bar", 930.0, int64(36))
+	expectedTsValues.AddInactive("/foo/baz", 930.0, "")
+	iterator, _ = restoredStore.NamedIteratorForEndpoint(
+		"aname", kEndpoint0, 0)
+	expectedTsValues.Iterate(t, iterator)
+	expectedTsValues.VerifyDone(t)
+
+	// Restored store accepts new values
+	aMetric[0].Value = int64(46)
+	addBatch(t, restoredStore, kEndpoint0, 940.0, aMetric[:1].Sorted(), 1)
+}
+
+func TestCheckpointSkipsLeadingInactive(t *testing.T) {
+	aStore := newStore(t, "TestCheckpointSkipsLeadingInactive", 2, 4, 1.0, 10)
+	aStore.RegisterEndpoint(kEndpoint0)
+	aMetric := metrics.SimpleList{
+		{
+			Path:        "/foo/bar",
+			Description: "A description",
+		},
+		{
+			Path:        "/foo/baz",
+			Description: "A description",
+		},
+	}
+	aMetric[0].Value = int64(6)
+	aMetric[1].Value = int64(7)
+	addBatch(t, aStore, kEndpoint0, 900.0, aMetric[:].Sorted(), 2)
+	aMetric[1].Value = int64(8)
+	addBatch(t, aStore, kEndpoint0, 910.0, aMetric[:].Sorted(), 1)
+	// /foo/baz goes inactive
+	addBatch(t, aStore, kEndpoint0, 920.0, aMetric[:1].Sorted(), 1)
+	// /foo/baz comes back evicting its page with 7 and 8 so that its
+	// oldest value is the inactive marker at 920.
+	for i := 0; i < 2; i++ {
+		aMetric[1].Value = int64(9 + i)
+		aStore.AddBatch(
+			kEndpoint0, 930.0+10.0*float64(i), aMetric[1:].Sorted())
+	}
+
+	var buffer bytes.Buffer
+	if err := aStore.WriteCheckpoint(&buffer, checkpointKey); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := store.ReadCheckpoint(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredStore := newStore(
+		t, "TestCheckpointSkipsLeadingInactiveRestored", 2, 100, 1.0, 10)
+	restoredStore.RegisterEndpoint(kEndpoint0)
+	assertValueEquals(
+		t,
+		true,
+		restoredStore.RestoreEndpoint(kEndpoint0, "endpoint0", checkpoint))
+	var actual []store.Record
+	restoredStore.ByNameAndEndpoint(
+		"/foo/baz", kEndpoint0, 0.0, 1000.0, store.AppendTo(&actual))
+	if assertValueEquals(t, 2, len(actual)) {
+		assertValueEquals(t, 940.0, actual[0].TimeStamp)
+		assertValueEquals(t, int64(10), actual[0].Value)
+		assertValueEquals(t, 930.0, actual[1].TimeStamp)
+		assertValueEquals(t, int64(9), actual[1].Value)
+	}
+}
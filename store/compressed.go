@@ -0,0 +1,355 @@
+package store
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// This file contains all the code for compressed pages.
+//
+// Compressed pages use an encoding similar to Facebook's Gorilla.
+// Timestamps are stored as delta of deltas of their float64 bit patterns.
+// Values are stored as the XOR of their 64 bit representation with that
+// of the previous value in the page. Each page can be decoded by itself.
+
+const (
+	// Compressed pages holding only timestamps use this kind.
+	kTimeStampsOnly = types.Unknown
+
+	// Worst case number of bits needed to encode one timestamp
+	kMaxTimeStampBits = 4 + 64
+	// Worst case number of bits needed to encode one value
+	kMaxValueBits = 1 + 2 + 5 + 6 + 64
+)
+
+// canCompress returns true if pages holding values of given kind can be
+// compressed. Timestamp only pages are always compressible.
+func canCompress(kind types.Type) bool {
+	switch kind {
+	case kTimeStampsOnly,
+		types.Bool,
+		types.Int8, types.Int16, types.Int32, types.Int64,
+		types.Uint8, types.Uint16, types.Uint32, types.Uint64,
+		types.Float32, types.Float64,
+		types.GoDuration:
+		return true
+	default:
+		return false
+	}
+}
+
+func compressedValueToBits(kind types.Type, value interface{}) uint64 {
+	switch kind {
+	case types.Bool:
+		if value.(bool) {
+			return 1
+		}
+		return 0
+	case types.Int8:
+		return uint64(value.(int8))
+	case types.Int16:
+		return uint64(value.(int16))
+	case types.Int32:
+		return uint64(value.(int32))
+	case types.Int64:
+		return uint64(value.(int64))
+	case types.Uint8:
+		return uint64(value.(uint8))
+	case types.Uint16:
+		return uint64(value.(uint16))
+	case types.Uint32:
+		return uint64(value.(uint32))
+	case types.Uint64:
+		return value.(uint64)
+	case types.Float32:
+		return uint64(math.Float32bits(value.(float32)))
+	case types.Float64:
+		return math.Float64bits(value.(float64))
+	case types.GoDuration:
+		return uint64(value.(time.Duration))
+	default:
+		panic("Kind not compressible")
+	}
+}
+
+func compressedBitsToValue(kind types.Type, x uint64) interface{} {
+	switch kind {
+	case types.Bool:
+		return x != 0
+	case types.Int8:
+		return int8(x)
+	case types.Int16:
+		return int16(x)
+	case types.Int32:
+		return int32(x)
+	case types.Int64:
+		return int64(x)
+	case types.Uint8:
+		return uint8(x)
+	case types.Uint16:
+		return uint16(x)
+	case types.Uint32:
+		return uint32(x)
+	case types.Uint64:
+		return x
+	case types.Float32:
+		return math.Float32frombits(uint32(x))
+	case types.Float64:
+		return math.Float64frombits(x)
+	case types.GoDuration:
+		return time.Duration(x)
+	default:
+		panic("Kind not compressible")
+	}
+}
+
+// fitsInBits returns true if x fits in a signed integer of nbits bits.
+func fitsInBits(x int64, nbits uint) bool {
+	limit := int64(1) << (nbits - 1)
+	return x >= -limit && x < limit
+}
+
+// signExtend sign extends the lower nbits of x.
+func signExtend(x uint64, nbits uint) int64 {
+	shift := 64 - nbits
+	return int64(x<<shift) >> shift
+}
+
+// Each bucket for a delta of deltas. A bucket's control bits are
+// a 1 for each preceding bucket followed by a 0. The last bucket has no
+// trailing 0.
+var kDeltaOfDeltaBucketBits = []uint{0, 14, 20, 32, 64}
+
+// compressedPageType is a page of timestamps or timestamp value pairs
+// stored in compressed form.
+type compressedPageType struct {
+	data     []byte
+	kind     types.Type
+	bitCount uint
+	count    int
+
+	// Encoder state
+	lastTsBits    uint64
+	lastDelta     int64
+	lastValueBits uint64
+	leading       uint
+	trailing      uint
+	hasWindow     bool
+}
+
+func newCompressedPageType(bytesPerPage uint) *compressedPageType {
+	return &compressedPageType{data: make([]byte, bytesPerPage)}
+}
+
+// Clear empties this page so that it stores values of given kind.
+// kind is kTimeStampsOnly if this page is to store only timestamps.
+func (c *compressedPageType) Clear(kind types.Type) {
+	usedBytes := c.data[:(c.bitCount+7)/8]
+	for i := range usedBytes {
+		usedBytes[i] = 0
+	}
+	*c = compressedPageType{data: c.data, kind: kind}
+}
+
+func (c *compressedPageType) Len() int {
+	return c.count
+}
+
+// UsedBytes returns the number of bytes this page is using.
+func (c *compressedPageType) UsedBytes() uint {
+	return (c.bitCount + 7) / 8
+}
+
+// IsFull returns true if this page may not have room for another entry.
+func (c *compressedPageType) IsFull() bool {
+	maxBits := uint(kMaxTimeStampBits)
+	if c.kind != kTimeStampsOnly {
+		maxBits += kMaxValueBits
+	}
+	return c.bitCount+maxBits > uint(len(c.data))*8
+}
+
+func (c *compressedPageType) Latest() (latest float64, ok bool) {
+	if c.count == 0 {
+		return
+	}
+	return math.Float64frombits(c.lastTsBits), true
+}
+
+// AddTime adds a timestamp to this page.
+func (c *compressedPageType) AddTime(ts float64) {
+	c.encodeTime(ts)
+	c.count++
+}
+
+// Add adds a timestamp value pair to this page.
+func (c *compressedPageType) Add(val tsValueType) {
+	c.encodeTime(val.TimeStamp)
+	c.encodeValue(val.Value)
+	c.count++
+}
+
+// Times decodes the timestamps in this page.
+func (c *compressedPageType) Times() tsPageType {
+	result := make(tsPageType, c.count)
+	decoder := compressedDecoderType{data: c.data}
+	for i := range result {
+		result[i].TimeStamp = decoder.DecodeTime()
+	}
+	return result
+}
+
+// Values decodes the timestamp value pairs in this page.
+func (c *compressedPageType) Values() pageType {
+	result := make(pageType, c.count)
+	decoder := compressedDecoderType{data: c.data}
+	for i := range result {
+		result[i].TimeStamp = decoder.DecodeTime()
+		result[i].Value = decoder.DecodeValue(c.kind)
+	}
+	return result
+}
+
+func (c *compressedPageType) writeBits(x uint64, nbits uint) {
+	for nbits > 0 {
+		free := 8 - c.bitCount%8
+		n := nbits
+		if n > free {
+			n = free
+		}
+		chunk := byte((x >> (nbits - n)) & (1<<n - 1))
+		c.data[c.bitCount/8] |= chunk << (free - n)
+		c.bitCount += n
+		nbits -= n
+	}
+}
+
+func (c *compressedPageType) encodeTime(ts float64) {
+	tsBits := math.Float64bits(ts)
+	if c.count == 0 {
+		c.writeBits(tsBits, 64)
+		c.lastTsBits = tsBits
+		return
+	}
+	delta := int64(tsBits - c.lastTsBits)
+	deltaOfDelta := delta - c.lastDelta
+	c.lastTsBits = tsBits
+	c.lastDelta = delta
+	lastBucket := len(kDeltaOfDeltaBucketBits) - 1
+	for i, nbits := range kDeltaOfDeltaBucketBits {
+		if i == lastBucket {
+			c.writeBits(1<<uint(i)-1, uint(i))
+			c.writeBits(uint64(deltaOfDelta), nbits)
+			return
+		}
+		if nbits == 0 && deltaOfDelta == 0 || nbits > 0 && fitsInBits(deltaOfDelta, nbits) {
+			// i ones followed by a zero
+			c.writeBits(1<<uint(i+1)-2, uint(i+1))
+			c.writeBits(uint64(deltaOfDelta), nbits)
+			return
+		}
+	}
+}
+
+func (c *compressedPageType) encodeValue(value interface{}) {
+	if value == gInactive {
+		c.writeBits(1, 1)
+		return
+	}
+	c.writeBits(0, 1)
+	valueBits := compressedValueToBits(c.kind, value)
+	xor := valueBits ^ c.lastValueBits
+	c.lastValueBits = valueBits
+	if xor == 0 {
+		c.writeBits(0, 1)
+		return
+	}
+	leading := uint(bits.LeadingZeros64(xor))
+	trailing := uint(bits.TrailingZeros64(xor))
+	// We store leading zeros in 5 bits.
+	if leading > 31 {
+		leading = 31
+	}
+	if c.hasWindow && leading >= c.leading && trailing >= c.trailing {
+		c.writeBits(2, 2)
+		c.writeBits(xor>>c.trailing, 64-c.leading-c.trailing)
+		return
+	}
+	meaningful := 64 - leading - trailing
+	c.writeBits(3, 2)
+	c.writeBits(uint64(leading), 5)
+	// meaningful is between 1 and 64 so we store meaningful - 1 in 6 bits
+	c.writeBits(uint64(meaningful-1), 6)
+	c.writeBits(xor>>trailing, meaningful)
+	c.leading = leading
+	c.trailing = trailing
+	c.hasWindow = true
+}
+
+// compressedDecoderType decodes the data in a compressed page.
+type compressedDecoderType struct {
+	data []byte
+	pos  uint
+
+	count         int
+	lastTsBits    uint64
+	lastDelta     int64
+	lastValueBits uint64
+	leading       uint
+	trailing      uint
+}
+
+func (d *compressedDecoderType) readBits(nbits uint) (result uint64) {
+	for nbits > 0 {
+		avail := 8 - d.pos%8
+		n := nbits
+		if n > avail {
+			n = avail
+		}
+		chunk := (d.data[d.pos/8] >> (avail - n)) & (1<<n - 1)
+		result = result<<n | uint64(chunk)
+		d.pos += n
+		nbits -= n
+	}
+	return
+}
+
+func (d *compressedDecoderType) DecodeTime() float64 {
+	if d.count == 0 {
+		d.count++
+		d.lastTsBits = d.readBits(64)
+		return math.Float64frombits(d.lastTsBits)
+	}
+	d.count++
+	lastBucket := len(kDeltaOfDeltaBucketBits) - 1
+	bucket := 0
+	for bucket < lastBucket && d.readBits(1) == 1 {
+		bucket++
+	}
+	var deltaOfDelta int64
+	if nbits := kDeltaOfDeltaBucketBits[bucket]; nbits > 0 {
+		deltaOfDelta = signExtend(d.readBits(nbits), nbits)
+	}
+	d.lastDelta += deltaOfDelta
+	d.lastTsBits += uint64(d.lastDelta)
+	return math.Float64frombits(d.lastTsBits)
+}
+
+func (d *compressedDecoderType) DecodeValue(kind types.Type) interface{} {
+	if d.readBits(1) == 1 {
+		return gInactive
+	}
+	if d.readBits(1) == 0 {
+		return compressedBitsToValue(kind, d.lastValueBits)
+	}
+	if d.readBits(1) == 1 {
+		d.leading = uint(d.readBits(5))
+		meaningful := uint(d.readBits(6)) + 1
+		d.trailing = 64 - d.leading - meaningful
+	}
+	meaningful := 64 - d.leading - d.trailing
+	d.lastValueBits ^= d.readBits(meaningful) << d.trailing
+	return compressedBitsToValue(kind, d.lastValueBits)
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompressedTimeStamps(t *testing.T) {
+	page := newCompressedPageType(64)
+	page.Clear(kTimeStampsOnly)
+	expected := tsPageType{
+		{TimeStamp: 1500000000.123},
+		{TimeStamp: 1500000060.123},
+		{TimeStamp: 1500000120.123},
+		{TimeStamp: 1500000180.124},
+		{TimeStamp: 1500000180.125},
+		{TimeStamp: 1800000000.0},
+	}
+	for i := range expected {
+		if page.IsFull() {
+			t.Fatalf("Page full after %d timestamps", i)
+		}
+		page.AddTime(expected[i].TimeStamp)
+	}
+	if actual := page.Times(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if latest, ok := page.Latest(); !ok || latest != 1800000000.0 {
+		t.Errorf("Expected 1800000000.0, got %v", latest)
+	}
+	for !page.IsFull() {
+		page.AddTime(1900000000.0 + float64(page.Len()))
+	}
+	if page.UsedBytes() > 64 {
+		t.Errorf("Page overflowed: %d bytes used", page.UsedBytes())
+	}
+
+	// Clearing page should make it reusable
+	page.Clear(kTimeStampsOnly)
+	page.AddTime(3.0)
+	page.AddTime(2.0)
+	expected = tsPageType{{TimeStamp: 3.0}, {TimeStamp: 2.0}}
+	if actual := page.Times(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestCompressedValues(t *testing.T) {
+	testCompressedValues(
+		t,
+		types.Float64,
+		12.5, 12.5, 12.75, gInactive, -3.0, math.Inf(1), 0.0, 1e300)
+	testCompressedValues(
+		t,
+		types.Int64,
+		int64(-1), int64(7), int64(math.MaxInt64), gInactive,
+		int64(math.MinInt64), int64(0))
+	testCompressedValues(
+		t,
+		types.Int8,
+		int8(-128), int8(127), int8(-1), int8(0))
+	testCompressedValues(
+		t,
+		types.Uint32,
+		uint32(0), uint32(math.MaxUint32), uint32(5))
+	testCompressedValues(
+		t, types.Bool, true, false, gInactive, true)
+	testCompressedValues(
+		t,
+		types.GoDuration,
+		time.Second, -time.Minute, gInactive, time.Hour)
+	testCompressedValues(
+		t,
+		types.Float32,
+		float32(1.5), float32(-2.25), float32(1.5))
+}
+
+func testCompressedValues(
+	t *testing.T, kind types.Type, values ...interface{}) {
+	page := newCompressedPageType(256)
+	page.Clear(kind)
+	expected := make(pageType, len(values))
+	for i := range values {
+		expected[i] = tsValueType{
+			TimeStamp: 1000.0 + 10.0*float64(i),
+			Value:     values[i],
+		}
+		if page.IsFull() {
+			t.Fatalf("%s: Page full after %d values", kind, i)
+		}
+		page.Add(expected[i])
+	}
+	if actual := page.Values(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("%s: Expected %v, got %v", kind, expected, actual)
+	}
+}
+
+func TestCanCompress(t *testing.T) {
+	if !canCompress(kTimeStampsOnly) || !canCompress(types.Float64) {
+		t.Error("Expected timestamps and floats to be compressible")
+	}
+	if canCompress(types.String) || canCompress(types.Dist) || canCompress(types.List) {
+		t.Error("Expected strings, distributions, and lists to be incompressible")
+	}
+}
+
+func TestPageSwitchesFormat(t *testing.T) {
+	page := newPageWithMetaDataType(240)
+	page.Clear(types.Int64, true)
+	page.AddValue(tsValueType{TimeStamp: 10.0, Value: int64(3)})
+	if !page.IsCompressed() {
+		t.Error("Expected compressed page")
+	}
+	expected := pageType{{TimeStamp: 10.0, Value: int64(3)}}
+	if actual := *page.Values(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	page.Clear(types.String, false)
+	page.AddValue(tsValueType{TimeStamp: 20.0, Value: "hello"})
+	if page.IsCompressed() {
+		t.Error("Expected uncompressed page")
+	}
+	if cap(page.values) != 10 {
+		t.Errorf("Expected capacity of 10, got %d", cap(page.values))
+	}
+	expected = pageType{{TimeStamp: 20.0, Value: "hello"}}
+	if actual := *page.Values(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,323 @@
+package store
+
+import (
+	"github.com/Symantec/scotty/tsdb"
+	"github.com/Symantec/tricorder/go/tricorder/types"
+	"strconv"
+	"strings"
+)
+
+// This file contains all the code for deriving numeric time series such as
+// percentiles from distribution time series.
+
+type distDerivedKindType int
+
+const (
+	kDistPercentile distDerivedKindType = iota
+	kDistCount
+	kDistSum
+	kDistAvg
+)
+
+var (
+	kDistDerivedKindsBySuffix = map[string]distDerivedKindType{
+		"count": kDistCount,
+		"sum":   kDistSum,
+		"avg":   kDistAvg,
+	}
+	kDistDerivedSuffixes = []string{"p50", "p99", "count", "sum", "avg"}
+)
+
+// distDerivedType describes a numeric time series derived from
+// a distribution time series.
+type distDerivedType struct {
+	Kind distDerivedKindType
+	// 0 - 100. Used only when Kind is kDistPercentile.
+	Percentile float64
+}
+
+// parseDistDerivedName splits name into the name of a distribution and
+// what to derive from it. For instance, "/foo/bar.p99" becomes "/foo/bar"
+// and the 99th percentile. ok is false if name does not end in a
+// recognised suffix.
+func parseDistDerivedName(name string) (
+	distName string, derived distDerivedType, ok bool) {
+	idx := strings.LastIndex(name, ".")
+	if idx == -1 {
+		return
+	}
+	distName, suffix := name[:idx], name[idx+1:]
+	if kind, isKnown := kDistDerivedKindsBySuffix[suffix]; isKnown {
+		return distName, distDerivedType{Kind: kind}, true
+	}
+	if !strings.HasPrefix(suffix, "p") {
+		return
+	}
+	percentile, err := strconv.ParseUint(suffix[1:], 10, 8)
+	if err != nil || percentile == 0 || percentile >= 100 {
+		return
+	}
+	return distName, distDerivedType{
+		Kind: kDistPercentile, Percentile: float64(percentile)}, true
+}
+
+// distPercentile estimates the given percentile (0-100) of the values
+// in counts by interpolating linearly within buckets. The first and last
+// buckets have no lower and upper bound respectively, so values in them
+// are estimated at their one bound. ok is false if there is only one
+// bucket.
+func distPercentile(
+	ranges *Ranges, counts []uint64, total uint64, percentile float64) (
+	result float64, ok bool) {
+	upperLimits := ranges.UpperLimits
+	lastIdx := len(counts) - 1
+	if lastIdx < 1 {
+		return
+	}
+	rank := percentile / 100.0 * float64(total)
+	var cumulative float64
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		next := cumulative + float64(count)
+		if next < rank && i < lastIdx {
+			cumulative = next
+			continue
+		}
+		if i == 0 {
+			return upperLimits[0], true
+		}
+		if i == lastIdx {
+			return upperLimits[lastIdx-1], true
+		}
+		lower, upper := upperLimits[i-1], upperLimits[i]
+		return lower + (rank-cumulative)/float64(count)*(upper-lower), true
+	}
+	return
+}
+
+// distIntervalType finds the values that a distribution gained between
+// consecutive records.
+type distIntervalType struct {
+	last *Record
+}
+
+// Next returns the bucket counts and sum of the values the distribution
+// in r gained since the record passed to the previous call. If the
+// distribution is not cumulative, Next returns them for r alone. ok is
+// false if the distribution is cumulative and there is no previous record.
+// The returned counts must be treated as immutable.
+func (d *distIntervalType) Next(r *Record) (
+	counts []uint64, sum float64, ok bool) {
+	totals := r.Value.(*DistributionTotals)
+	last := d.last
+	rcopy := *r
+	d.last = &rcopy
+	if r.Info.IsNotCumulative() {
+		return totals.Counts, totals.Sum, true
+	}
+	if last == nil {
+		return
+	}
+	// If the distribution rolled over, the current totals are
+	// all new values.
+	if distRolledOver(last, r) {
+		return totals.Counts, totals.Sum, true
+	}
+	lastTotals := last.Value.(*DistributionTotals)
+	counts = make([]uint64, len(totals.Counts))
+	for i := range counts {
+		counts[i] = totals.Counts[i] - lastTotals.Counts[i]
+	}
+	return counts, totals.Sum - lastTotals.Sum, true
+}
+
+// Reset makes this instance forget the previous record. Call when the
+// distribution goes inactive.
+func (d *distIntervalType) Reset() {
+	d.last = nil
+}
+
+// distRolledOver returns true if the cumulative distribution in record r
+// started over after record last.
+func distRolledOver(last, r *Record) bool {
+	if last.Info.Ranges() != r.Info.Ranges() {
+		return true
+	}
+	lastTotals := last.Value.(*DistributionTotals)
+	totals := r.Value.(*DistributionTotals)
+	if lastTotals.RollOverCount != totals.RollOverCount {
+		return true
+	}
+	for i := range totals.Counts {
+		if totals.Counts[i] < lastTotals.Counts[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func countTotal(counts []uint64) (result uint64) {
+	for _, count := range counts {
+		result += count
+	}
+	return
+}
+
+// distDerivedAppenderType appends the derived values of the distribution
+// records it gets to a tsdb.TimeSeries.
+//
+// For cumulative distributions, percentiles and averages cover only the
+// values added since the previous record so that they reflect recent
+// values. Such a distribution's first record in a time range yields no
+// percentile or average. Counts and sums are the running totals to be
+// used with rates like any other counter.
+type distDerivedAppenderType struct {
+	derived  distDerivedType
+	result   *tsdb.TimeSeries
+	interval distIntervalType
+}
+
+func (d *distDerivedAppenderType) Append(r *Record) bool {
+	if !r.Active {
+		d.interval.Reset()
+		return true
+	}
+	totals := r.Value.(*DistributionTotals)
+	counts, sum, ok := d.interval.Next(r)
+	if d.derived.Kind == kDistCount {
+		d.add(r.TimeStamp, float64(totals.Count()))
+		return true
+	}
+	if d.derived.Kind == kDistSum {
+		d.add(r.TimeStamp, totals.Sum)
+		return true
+	}
+	if !ok {
+		return true
+	}
+	total := countTotal(counts)
+	if total == 0 {
+		return true
+	}
+	if d.derived.Kind == kDistAvg {
+		d.add(r.TimeStamp, sum/float64(total))
+	} else if value, ok := distPercentile(
+		r.Info.Ranges(), counts, total, d.derived.Percentile); ok {
+		d.add(r.TimeStamp, value)
+	}
+	return true
+}
+
+func (d *distDerivedAppenderType) add(ts, value float64) {
+	*d.result = append(*d.result, tsdb.TsValue{Ts: ts, Value: value})
+}
+
+// distTsValueAppenderType appends the values that the distribution
+// records it gets gained to a tsdb.DistTimeSeries. Like
+// distDerivedAppenderType, it yields nothing for the first record of a
+// cumulative distribution.
+type distTsValueAppenderType struct {
+	result   *tsdb.DistTimeSeries
+	interval distIntervalType
+}
+
+func (d *distTsValueAppenderType) Append(r *Record) bool {
+	if !r.Active {
+		d.interval.Reset()
+		return true
+	}
+	counts, _, ok := d.interval.Next(r)
+	if !ok || countTotal(counts) == 0 {
+		return true
+	}
+	*d.result = append(*d.result, tsdb.DistTsValue{
+		Ts:          r.TimeStamp,
+		UpperLimits: r.Info.Ranges().UpperLimits,
+		Counts:      counts,
+	})
+	return true
+}
+
+// distTimeSeries returns the distribution time series in timeSeries.
+func distTimeSeries(timeSeries []*timeSeriesType) []*timeSeriesType {
+	partition := GroupMetricByPathAndNumeric.orderedPartition(timeSeries)
+	tslen := len(timeSeries)
+	for startIdx, endIdx := 0, 0; startIdx < tslen; startIdx = endIdx {
+		endIdx = nextSubset(partition, startIdx)
+		if timeSeries[startIdx].id.Kind() == types.Dist {
+			return timeSeries[startIdx:endIdx]
+		}
+	}
+	return nil
+}
+
+// fetchDist fetches the distribution records with given name between start
+// inclusive and end exclusive oldest first. fetchDist returns the earliest
+// time for which the records are valid and false if there is no such
+// distribution.
+func (c *timeSeriesCollectionType) fetchDist(
+	name string, start, end float64, result Appender) (
+	early float64, ok bool) {
+	timeSeries, timestampSeries := c.TsAndTimeStampsByName(name)
+	timeSeries = distTimeSeries(timeSeries)
+	if len(timeSeries) == 0 {
+		return 0.0, false
+	}
+	if start < end {
+		timestamps := make(map[int][]float64)
+		merger := newMerger()
+		merger.MergeOldestFirst(
+			timeSeries,
+			func(ts *timeSeriesType, appender Appender) {
+				groupId := ts.id.GroupId()
+				if timestamps[groupId] == nil {
+					timestamps[groupId] = timestampSeries[groupId].FindBetween(start, end)
+				}
+				ts.FetchForwardWithTimeStamps(
+					c.applicationId,
+					timestamps[groupId],
+					appender)
+			},
+			result)
+	}
+	return c.earliest(timeSeries, timestampSeries), true
+}
+
+// tsdbDistDerivedTimeSeries works like TsdbTimeSeries for names that
+// parseDistDerivedName accepts.
+func (c *timeSeriesCollectionType) tsdbDistDerivedTimeSeries(
+	name string, start, end float64) (
+	result tsdb.TimeSeries, early float64, ok bool) {
+	distName, derived, ok := parseDistDerivedName(name)
+	if !ok {
+		return
+	}
+	early, ok = c.fetchDist(
+		distName,
+		start,
+		end,
+		&distDerivedAppenderType{derived: derived, result: &result})
+	return
+}
+
+// TsdbDistTimeSeries returns the values that the distribution with given
+// name gained at each timestamp between start inclusive and end exclusive.
+func (c *timeSeriesCollectionType) TsdbDistTimeSeries(
+	name string, start, end float64) (
+	result tsdb.DistTimeSeries, early float64, ok bool) {
+	early, ok = c.fetchDist(
+		name, start, end, &distTsValueAppenderType{result: &result})
+	return
+}
+
+// distDerivedNames returns the names of the commonly used time series
+// derived from the distribution with given name.
+func distDerivedNames(name string) []string {
+	result := make([]string, len(kDistDerivedSuffixes))
+	for i := range result {
+		result[i] = name + "." + kDistDerivedSuffixes[i]
+	}
+	return result
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"testing"
+)
+
+func TestParseDistDerivedName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		distName string
+		derived  distDerivedType
+		ok       bool
+	}{
+		{"/foo/bar.p50", "/foo/bar", distDerivedType{kDistPercentile, 50.0}, true},
+		{"/foo/bar.p1", "/foo/bar", distDerivedType{kDistPercentile, 1.0}, true},
+		{"/foo.x/bar.count", "/foo.x/bar", distDerivedType{Kind: kDistCount}, true},
+		{"/foo/bar.sum", "/foo/bar", distDerivedType{Kind: kDistSum}, true},
+		{"/foo/bar.avg", "/foo/bar", distDerivedType{Kind: kDistAvg}, true},
+		{"/foo/bar.p0", "", distDerivedType{}, false},
+		{"/foo/bar.p100", "", distDerivedType{}, false},
+		{"/foo/bar.p", "", distDerivedType{}, false},
+		{"/foo/bar.max", "", distDerivedType{}, false},
+		{"/foo/bar", "", distDerivedType{}, false},
+	}
+	for _, tc := range testCases {
+		distName, derived, ok := parseDistDerivedName(tc.name)
+		if ok != tc.ok {
+			t.Errorf("%s: Expected ok=%v", tc.name, tc.ok)
+			continue
+		}
+		if ok && (distName != tc.distName || derived != tc.derived) {
+			t.Errorf(
+				"%s: Expected %s %v, got %s %v",
+				tc.name, tc.distName, tc.derived, distName, derived)
+		}
+	}
+}
+
+func TestDistPercentile(t *testing.T) {
+	ranges := &Ranges{UpperLimits: []float64{10.0, 20.0, 30.0}}
+	counts := []uint64{2, 4, 4, 0}
+	testCases := []struct {
+		percentile float64
+		expected   float64
+	}{
+		{10.0, 10.0},
+		{20.0, 10.0},
+		{50.0, 17.5},
+		{80.0, 25.0},
+		{99.0, 29.75},
+	}
+	for _, tc := range testCases {
+		actual, ok := distPercentile(ranges, counts, 10, tc.percentile)
+		if !ok || actual != tc.expected {
+			t.Errorf(
+				"p%v: Expected %v, got %v", tc.percentile, tc.expected, actual)
+		}
+	}
+	// Values in the unbounded last bucket are estimated at its lower bound
+	if actual, _ := distPercentile(
+		ranges, []uint64{0, 0, 1, 3}, 4, 90.0); actual != 30.0 {
+		t.Errorf("Expected 30.0, got %v", actual)
+	}
+	if _, ok := distPercentile(&Ranges{}, []uint64{5}, 5, 50.0); ok {
+		t.Error("Expected no percentile for a single bucket")
+	}
+}
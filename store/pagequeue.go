@@ -1,16 +1,54 @@
 package store
 
 import (
+	"fmt"
 	"github.com/Symantec/scotty/store/btreepq"
 	"github.com/Symantec/tricorder/go/tricorder"
 	"github.com/Symantec/tricorder/go/tricorder/duration"
 	"github.com/Symantec/tricorder/go/tricorder/units"
+	"math"
+	"sort"
 	"sync"
 	"time"
 )
 
 // This file contains all the code related to the page queue.
 
+// appNamerType is implemented by endpoint ids that know the name of their
+// application. Per application page quotas apply only to these.
+type appNamerType interface {
+	AppName() string
+}
+
+type hostNamerType interface {
+	HostName() string
+}
+
+// endpointName returns a human readable name for endpointId.
+func endpointName(endpointId interface{}) string {
+	hostNamer, hostOk := endpointId.(hostNamerType)
+	appNamer, appOk := endpointId.(appNamerType)
+	if hostOk && appOk {
+		return hostNamer.HostName() + ":" + appNamer.AppName()
+	}
+	return fmt.Sprintf("%v", endpointId)
+}
+
+// endpointPageStatsType contains page usage statistics across all endpoints
+type endpointPageStatsType struct {
+	// Number of endpoints using at least one page
+	EndpointCount uint
+	// Number of endpoints using at least their quota of pages
+	AtQuotaCount uint
+	// Most pages any one endpoint uses
+	MaxPageCount uint
+	// The endpoint using the most pages
+	NoisiestEndpoint string
+	// Number of times an endpoint reused its own page because it was at
+	// its quota
+	QuotaEvictionCount uint64
+}
+
 type pageQueueType struct {
 	valueCountPerPage  uint
 	inactiveThreshhold float64
@@ -18,7 +56,20 @@ type pageQueueType struct {
 	bytesPerPage       uint
 	lock               sync.Mutex
 	expanding          bool
-	pq                 *btreepq.PageQueue
+	compressed         bool
+	pageQuota          float64
+	appPageQuotas      map[string]float64
+	quotaEvictionCount uint64
+	retentionPolicy    RetentionPolicy
+	// The policy in effect the last time we checked
+	currentRetentionPolicy RetentionPolicy
+	// Goes up each time the policy in effect changes
+	retentionGeneration uint64
+	pq                  *btreepq.PageQueue
+	// Bytes that the roll ups of all time series use
+	rollUpBytes uint64
+	// Number of pages removed from pq to make room for roll ups
+	rollUpPageCount uint
 }
 
 func newPageQueueType(
@@ -53,18 +104,64 @@ func (s *pageQueueType) updateThreshold() {
 	s.pq.SetThreshold(newThreshold)
 }
 
-func (s *pageQueueType) maybeRemoveOnePage() {
+// giveUpPage forces the owner of page, if any, to give it up.
+// Caller must hold the lock.
+func (s *pageQueueType) giveUpPage(page *pageWithMetaDataType) {
+	if page.owner != nil {
+		s.rollUpBytes += uint64(page.owner.GiveUpPage(page))
+	}
+}
+
+func (s *pageQueueType) maybeRemoveOnePage() bool {
 	result, ok := s.pq.RemovePage()
 	if ok {
 		removedPage := result.(*pageWithMetaDataType)
-		// Force the owner of the page to give it up.
-		if removedPage.owner != nil {
-			removedPage.owner.GiveUpPage(removedPage)
-		}
+		s.giveUpPage(removedPage)
 		// Removed page no longer has an owner
 		removedPage.owner = nil
 		s.updateThreshold()
 	}
+	return ok
+}
+
+// payForRollUps removes pages until the removed pages make room for all
+// the memory that roll ups use so that roll ups stay within the same
+// memory budget as the pages. Caller must hold the lock.
+func (s *pageQueueType) payForRollUps() {
+	for uint64(s.rollUpPageCount)*uint64(s.bytesPerPage) < s.rollUpBytes {
+		if !s.maybeRemoveOnePage() {
+			return
+		}
+		s.rollUpPageCount++
+	}
+}
+
+// canRepayRollUps returns true if roll ups shrank enough to give back one
+// of the pages removed for them. Caller must hold the lock.
+func (s *pageQueueType) canRepayRollUps() bool {
+	return s.rollUpPageCount > 0 &&
+		uint64(s.rollUpPageCount-1)*uint64(s.bytesPerPage) >= s.rollUpBytes
+}
+
+// AddRollUpBytes tells this queue that roll ups use delta more bytes.
+// delta may be negative.
+func (s *pageQueueType) AddRollUpBytes(delta int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if delta < 0 && uint64(-delta) > s.rollUpBytes {
+		s.rollUpBytes = 0
+	} else {
+		s.rollUpBytes = uint64(int64(s.rollUpBytes) + int64(delta))
+	}
+	s.payForRollUps()
+}
+
+// RollUpStats returns the bytes that roll ups use and the number of
+// pages removed to make room for them.
+func (s *pageQueueType) RollUpStats() (bytes uint64, pageCount uint) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rollUpBytes, s.rollUpPageCount
 }
 
 func (s *pageQueueType) FreeUpBytes(bytesToFree uint64) {
@@ -75,6 +172,7 @@ func (s *pageQueueType) FreeUpBytes(bytesToFree uint64) {
 		s.maybeRemoveOnePage()
 		freedSoFar += uint64(s.bytesPerPage)
 	}
+	s.payForRollUps()
 }
 
 func (s *pageQueueType) LessenPageCount(ratio float64) {
@@ -86,6 +184,7 @@ func (s *pageQueueType) LessenPageCount(ratio float64) {
 	for i := 0; i < pageCount; i++ {
 		s.maybeRemoveOnePage()
 	}
+	s.payForRollUps()
 }
 
 func (s *pageQueueType) SetExpanding(b bool) {
@@ -100,6 +199,120 @@ func (s *pageQueueType) IsExpanding() bool {
 	return s.expanding
 }
 
+func (s *pageQueueType) SetCompressed(b bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.compressed = b
+}
+
+func (s *pageQueueType) IsCompressed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.compressed
+}
+
+func (s *pageQueueType) SetRetentionPolicy(policy RetentionPolicy) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.retentionPolicy = policy
+}
+
+// RetentionPolicy returns the retention policy in effect along with its
+// generation. The generation goes up each time the policy in effect
+// changes.
+func (s *pageQueueType) RetentionPolicy() (RetentionPolicy, uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	current := s.retentionPolicy
+	if changing, ok := current.(ChangingRetentionPolicy); ok {
+		current = changing.Current()
+	}
+	if current != s.currentRetentionPolicy {
+		s.currentRetentionPolicy = current
+		s.retentionGeneration++
+	}
+	return current, s.retentionGeneration
+}
+
+func (s *pageQueueType) SetPageQuota(ratio float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pageQuota = ratio
+}
+
+func (s *pageQueueType) PageQuota() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pageQuota
+}
+
+func (s *pageQueueType) SetAppPageQuota(appName string, ratio float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.appPageQuotas == nil {
+		s.appPageQuotas = make(map[string]float64)
+	}
+	s.appPageQuotas[appName] = ratio
+}
+
+// quotaFor returns the page quota for given endpoint or 0 if the endpoint
+// has no quota. Caller must hold the lock.
+func (s *pageQueueType) quotaFor(endpointId interface{}) uint {
+	ratio := s.pageQuota
+	if namer, ok := endpointId.(appNamerType); ok {
+		if appRatio, ok := s.appPageQuotas[namer.AppName()]; ok {
+			ratio = appRatio
+		}
+	}
+	if ratio <= 0.0 {
+		return 0
+	}
+	quota := uint(ratio * float64(s.pq.Len()))
+	if quota < 1 {
+		quota = 1
+	}
+	return quota
+}
+
+// PageUsageByEndpoint returns page usage for each endpoint using at least
+// one page. Endpoints using the most pages come first.
+func (s *pageQueueType) PageUsageByEndpoint() []PageUsage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	groupStats := s.pq.GroupStats()
+	result := make([]PageUsage, len(groupStats))
+	for i := range groupStats {
+		result[i] = PageUsage{
+			EndpointId:        groupStats[i].Group,
+			PageCount:         groupStats[i].TotalCount(),
+			InactivePageCount: groupStats[i].HighPriorityCount,
+			Quota:             s.quotaFor(groupStats[i].Group),
+		}
+	}
+	sort.Sort(pageUsageByCountType(result))
+	return result
+}
+
+func (s *pageQueueType) EndpointPageStats(stats *endpointPageStatsType) {
+	usages := s.PageUsageByEndpoint()
+	s.lock.Lock()
+	evictionCount := s.quotaEvictionCount
+	s.lock.Unlock()
+	*stats = endpointPageStatsType{
+		EndpointCount:      uint(len(usages)),
+		QuotaEvictionCount: evictionCount,
+	}
+	if len(usages) > 0 {
+		stats.MaxPageCount = usages[0].PageCount
+		stats.NoisiestEndpoint = endpointName(usages[0].EndpointId)
+	}
+	for i := range usages {
+		if usages[i].Quota > 0 && usages[i].PageCount >= usages[i].Quota {
+			stats.AtQuotaCount++
+		}
+	}
+}
+
 func (s *pageQueueType) PageQueueStats(stats *btreepq.PageQueueStats) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -175,6 +388,83 @@ func (s *pageQueueType) RegisterMetrics(d *tricorder.DirectorySpec) (
 		return
 	}
 
+	var endpointStats endpointPageStatsType
+	endpointGroup := tricorder.NewGroup()
+	endpointGroup.RegisterUpdateFunc(func() time.Time {
+		s.EndpointPageStats(&endpointStats)
+		return time.Now()
+	})
+	if err = d.RegisterMetricInGroup(
+		"/endpointsWithPages",
+		&endpointStats.EndpointCount,
+		endpointGroup,
+		units.None,
+		"Number of endpoints using pages"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/endpointsAtPageQuota",
+		&endpointStats.AtQuotaCount,
+		endpointGroup,
+		units.None,
+		"Number of endpoints using their full page quota"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/maxPagesPerEndpoint",
+		&endpointStats.MaxPageCount,
+		endpointGroup,
+		units.None,
+		"Most pages used by any one endpoint"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/noisiestEndpoint",
+		&endpointStats.NoisiestEndpoint,
+		endpointGroup,
+		units.None,
+		"Endpoint using the most pages"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/pageQuotaEvictions",
+		&endpointStats.QuotaEvictionCount,
+		endpointGroup,
+		units.None,
+		"Number of times an endpoint at its page quota reused its own page"); err != nil {
+		return
+	}
+	var rollUpBytes uint64
+	var rollUpPageCount uint
+	rollUpGroup := tricorder.NewGroup()
+	rollUpGroup.RegisterUpdateFunc(func() time.Time {
+		rollUpBytes, rollUpPageCount = s.RollUpStats()
+		return time.Now()
+	})
+	if err = d.RegisterMetricInGroup(
+		"/rollUpBytes",
+		&rollUpBytes,
+		rollUpGroup,
+		units.Byte,
+		"Memory that rolled up values use"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/rollUpPages",
+		&rollUpPageCount,
+		rollUpGroup,
+		units.None,
+		"Number of pages given up to make room for rolled up values"); err != nil {
+		return
+	}
+	if err = d.RegisterMetric(
+		"/endpointPageQuota",
+		s.PageQuota,
+		units.None,
+		"Ratio of all pages that one endpoint may use. 0 means no quota."); err != nil {
+		return
+	}
+
 	if err = d.RegisterMetric(
 		"/expanding",
 		s.IsExpanding,
@@ -182,6 +472,13 @@ func (s *pageQueueType) RegisterMetrics(d *tricorder.DirectorySpec) (
 		"Is page queue expanding."); err != nil {
 		return
 	}
+	if err = d.RegisterMetric(
+		"/compressed",
+		s.IsCompressed,
+		units.None,
+		"Are new pages compressed."); err != nil {
+		return
+	}
 	if err = d.RegisterMetric(
 		"/maxValuesPerPage",
 		&s.valueCountPerPage,
@@ -207,7 +504,11 @@ func (s *pageQueueType) RegisterMetrics(d *tricorder.DirectorySpec) (
 }
 
 // GivePageTo bestows a new page on t. ts is the timestamp of the value
-// being added to t.
+// being added to t. If the endpoint of t is at its page quota, t gets one
+// of its endpoint's own pages. The full page of t goes in the retention
+// class of t.
+// If giving up a page makes roll ups use more memory, GivePageTo removes
+// pages from the queue to make room for them.
 // This call may lock another pageOwnerType instance. To avoid deadlock,
 // caller must not hold a lock on any pageOwnerType instance.
 func (s *pageQueueType) GivePageTo(t pageOwnerType, ts float64) {
@@ -215,20 +516,54 @@ func (s *pageQueueType) GivePageTo(t pageOwnerType, ts float64) {
 	defer s.lock.Unlock()
 	fullPage := t.LatestPage()
 	if fullPage != nil {
-		s.pq.Prioritise(fullPage, ts)
+		s.pq.PrioritiseInClass(fullPage, ts, int(t.RetentionClass()))
+	}
+	endpointId := t.EndpointId()
+	quota := s.quotaFor(endpointId)
+	if quota > 0 && s.pq.GroupLen(endpointId) >= quota {
+		s.quotaEvictionCount++
 	}
 	var result *pageWithMetaDataType
 	if s.expanding {
-		result = s.pq.NewPage().(*pageWithMetaDataType)
+		result = s.pq.NewPageForGroup(
+			endpointId, quota).(*pageWithMetaDataType)
 		s.updateThreshold()
+	} else if s.canRepayRollUps() {
+		// Roll ups shrank so give back a page removed for them.
+		oldLen := s.pq.Len()
+		result = s.pq.NewPageForGroup(
+			endpointId, quota).(*pageWithMetaDataType)
+		if s.pq.Len() > oldLen {
+			s.rollUpPageCount--
+			s.updateThreshold()
+		}
 	} else {
-		result = s.pq.NextPage().(*pageWithMetaDataType)
-	}
-	if result.owner != nil {
-		result.owner.GiveUpPage(result)
+		result = s.pq.NextPageForGroup(
+			endpointId, quota).(*pageWithMetaDataType)
 	}
+	s.giveUpPage(result)
 	result.owner = t
-	result.owner.AcceptPage(result)
+	result.owner.AcceptPage(result, s.compressed)
+	s.payForRollUps()
+}
+
+// Reclassify moves the full pages in each page list to the current
+// retention class of their owner.
+// This call may lock another pageOwnerType instance. To avoid deadlock,
+// caller must not hold a lock on any pageOwnerType instance.
+func (s *pageQueueType) Reclassify(pageLists []pageListType) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, pageList := range pageLists {
+		class := int(pageList.Owner.RetentionClass())
+		for _, page := range pageList.Pages {
+			// Pages that aren't full yet get their class once they
+			// fill up.
+			if page.owner == pageList.Owner && !math.IsInf(page.TS(), 1) {
+				s.pq.PrioritiseInClass(page, page.TS(), class)
+			}
+		}
+	}
 }
 
 func (s *pageQueueType) ReclaimHigh(
@@ -256,3 +591,15 @@ func (s *pageQueueType) ReclaimLow(
 		}
 	}
 }
+
+type pageUsageByCountType []PageUsage
+
+func (p pageUsageByCountType) Len() int { return len(p) }
+
+func (p pageUsageByCountType) Less(i, j int) bool {
+	return p[i].PageCount > p[j].PageCount
+}
+
+func (p pageUsageByCountType) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"github.com/Symantec/scotty/store/btreepq"
+	"github.com/Symantec/tricorder/go/tricorder/types"
 	"github.com/google/btree"
 	"reflect"
 	"sort"
@@ -135,6 +136,8 @@ func (p pageType) FindGreater(ts float64) int {
 type pageMetaDataType struct {
 	seqNo uint64
 	ts    float64
+	group interface{}
+	class int
 	owner pageOwnerType
 }
 
@@ -154,28 +157,58 @@ func (m *pageMetaDataType) TS() float64 {
 	return m.ts
 }
 
+func (m *pageMetaDataType) SetGroup(group interface{}) {
+	m.group = group
+}
+
+func (m *pageMetaDataType) Group() interface{} {
+	return m.group
+}
+
+func (m *pageMetaDataType) SetClass(class int) {
+	m.class = class
+}
+
+func (m *pageMetaDataType) Class() int {
+	return m.class
+}
+
 // Represents an actual page in scotty. These pages can either hold timestmps
-// value pairs or just timestamps.
+// value pairs or just timestamps. Pages may be compressed or uncompressed.
 // These pages implement github.com/google/btree.Item
 type pageWithMetaDataType struct {
 	// page queue lock protects this.
 	pageMetaDataType
+	bytesPerPage uint
 	// Lock of current page owner protects these.
 	values []tsValueType
+	// nil if page is not compressed
+	compressed *compressedPageType
 }
 
 func newPageWithMetaDataType(bytesPerPage uint) *pageWithMetaDataType {
 	return &pageWithMetaDataType{
-		values: make(pageType, 0, bytesPerPage/kTsAndValueSize)}
+		bytesPerPage: bytesPerPage,
+		values:       make(pageType, 0, bytesPerPage/kTsAndValueSize)}
 }
 
-// As timestamp value pairs
+// As timestamp value pairs. If this page is compressed, the returned
+// values are a decoded copy.
 func (p *pageWithMetaDataType) Values() *pageType {
+	if p.compressed != nil {
+		result := p.compressed.Values()
+		return &result
+	}
 	return (*pageType)(&p.values)
 }
 
-// As timestamps
+// As timestamps. If this page is compressed, the returned timestamps are
+// a decoded copy.
 func (p *pageWithMetaDataType) Times() *tsPageType {
+	if p.compressed != nil {
+		result := p.compressed.Times()
+		return &result
+	}
 	return (*tsPageType)(&p.values)
 }
 
@@ -189,6 +222,80 @@ func (p *pageWithMetaDataType) TimePage() basicPageType {
 	return p.Times()
 }
 
+// Clear empties this page. kind is the kind of values this page is to
+// store or kTimeStampsOnly if this page is to store only timestamps.
+// compress is true if this page should store its data compressed.
+func (p *pageWithMetaDataType) Clear(kind types.Type, compress bool) {
+	if compress {
+		if p.compressed == nil {
+			p.compressed = newCompressedPageType(p.bytesPerPage)
+			p.values = nil
+		}
+		p.compressed.Clear(kind)
+		return
+	}
+	if p.compressed != nil {
+		p.compressed = nil
+		p.values = make(pageType, 0, p.bytesPerPage/kTsAndValueSize)
+	}
+	p.values = p.values[:0]
+}
+
+// IsCompressed returns true if this page is compressed.
+func (p *pageWithMetaDataType) IsCompressed() bool {
+	return p.compressed != nil
+}
+
+// UsedBytes returns how many bytes this compressed page is using and
+// how many bytes its data would use uncompressed.
+func (p *pageWithMetaDataType) UsedBytes() (
+	compressedBytes, uncompressedBytes uint) {
+	if p.compressed == nil {
+		return
+	}
+	return p.compressed.UsedBytes(),
+		uint(p.compressed.Len()) * kTsAndValueSize
+}
+
+func (p *pageWithMetaDataType) IsFull() bool {
+	if p.compressed != nil {
+		return p.compressed.IsFull()
+	}
+	return pageType(p.values).IsFull()
+}
+
+func (p *pageWithMetaDataType) Len() int {
+	if p.compressed != nil {
+		return p.compressed.Len()
+	}
+	return len(p.values)
+}
+
+func (p *pageWithMetaDataType) Latest() (latest float64, ok bool) {
+	if p.compressed != nil {
+		return p.compressed.Latest()
+	}
+	return pageType(p.values).Latest()
+}
+
+// AddValue adds a timestamp value pair to this page.
+func (p *pageWithMetaDataType) AddValue(val tsValueType) {
+	if p.compressed != nil {
+		p.compressed.Add(val)
+		return
+	}
+	(*pageType)(&p.values).Add(val)
+}
+
+// AddTime adds a timestamp to this page.
+func (p *pageWithMetaDataType) AddTime(ts float64) {
+	if p.compressed != nil {
+		p.compressed.AddTime(ts)
+		return
+	}
+	(*tsPageType)(&p.values).Add(ts)
+}
+
 // github.com/google/btree.Item
 func (p *pageWithMetaDataType) Less(than btree.Item) bool {
 	pthan := than.(btreepq.Page)
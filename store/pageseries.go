@@ -2,6 +2,7 @@ package store
 
 import (
 	"container/list"
+	"github.com/Symantec/tricorder/go/tricorder/types"
 	"math"
 	"reflect"
 	"sync"
@@ -29,12 +30,20 @@ func (m *MetricInfo) valuesAreEqual(lhs, rhs interface{}) bool {
 // pageOwnerType is the interface for any data structure that can own
 // pages.
 type pageOwnerType interface {
-	// GiveUpPage instructs this instance to give up given page
-	GiveUpPage(page *pageWithMetaDataType)
-	// AcceptPage instructs this instance to accept given page
-	AcceptPage(page *pageWithMetaDataType)
+	// GiveUpPage instructs this instance to give up given page.
+	// GiveUpPage returns how many more bytes this instance uses for
+	// rolled up values as a result.
+	GiveUpPage(page *pageWithMetaDataType) int
+	// AcceptPage instructs this instance to accept given page.
+	// compress is true if this instance should compress the page if it can.
+	AcceptPage(page *pageWithMetaDataType, compress bool)
 	// LatestPage returns the latest page or nil if no pages.
 	LatestPage() *pageWithMetaDataType
+	// EndpointId returns the endpoint to which this instance belongs.
+	// Page quotas are per endpoint.
+	EndpointId() interface{}
+	// RetentionClass returns the retention class of this instance.
+	RetentionClass() RetentionClass
 }
 
 // pageListType Represents a list of pages owned by the same page series.
@@ -54,14 +63,18 @@ type pageSeriesType struct {
 	pages         list.List
 	nextPageToUse *pageWithMetaDataType
 	toData        func(*pageWithMetaDataType) basicPageType
+	kind          types.Type
 	pagesRemoved  bool
 }
 
-// Init initializes this instance. toData gets the page data out of a page
+// Init initializes this instance. toData gets the page data out of a page.
+// kind is the kind of values stored or kTimeStampsOnly if this instance
+// stores only timestamps.
 func (p *pageSeriesType) Init(
-	toData func(*pageWithMetaDataType) basicPageType) {
+	toData func(*pageWithMetaDataType) basicPageType, kind types.Type) {
 	p.pages.Init()
 	p.toData = toData
+	p.kind = kind
 }
 
 // Len returns the number of pages
@@ -150,13 +163,15 @@ func (p *pageSeriesType) PageList() (pages []*pageWithMetaDataType) {
 	return
 }
 
-// AcceptPage accepts given page.
-func (p *pageSeriesType) AcceptPage(page *pageWithMetaDataType) {
+// AcceptPage accepts given page. AcceptPage compresses the page if compress
+// is true and the kind of data in this instance can be compressed.
+func (p *pageSeriesType) AcceptPage(
+	page *pageWithMetaDataType, compress bool) {
 	if p.nextPageToUse != nil {
 		panic("Oops, look like multiple goroutines are attempting to add pages to this series.")
 	}
 	p.nextPageToUse = page
-	p.toData(page).Clear()
+	page.Clear(p.kind, compress && canCompress(p.kind))
 }
 
 // GiveUpPage gives up given page. If non-nil, GiveUpPage calls
@@ -204,7 +219,20 @@ func (p *pageSeriesType) lastPage() (result *pageWithMetaDataType) {
 
 func (p *pageSeriesType) needPage() bool {
 	page := p.lastPage()
-	return page == nil || p.toData(page).IsFull()
+	return page == nil || page.IsFull()
+}
+
+// logFullPage logs the compression stats of the page just before the
+// last page once the last page is a new page.
+func (p *pageSeriesType) logFullPage(metrics *storeMetricsType) {
+	back := p.pages.Back()
+	if back == nil || back.Prev() == nil {
+		return
+	}
+	compressedBytes, uncompressedBytes := back.Prev().Value.(*pageWithMetaDataType).UsedBytes()
+	if compressedBytes > 0 {
+		metrics.AddFullCompressedPage(compressedBytes, uncompressedBytes)
+	}
 }
 
 func incTs(x float64) float64 {
@@ -214,28 +242,48 @@ func incTs(x float64) float64 {
 // timestampSeriesType represents a sorted list of timestamps for a particular
 // metric group.
 type timestampSeriesType struct {
-	groupId int
-	metrics *storeMetricsType
-	lock    sync.Mutex
-	lastTs  float64
-	pages   pageSeriesType
-	active  bool
+	endpointId interface{}
+	groupId    int
+	metrics    *storeMetricsType
+	lock       sync.Mutex
+	lastTs     float64
+	pages      pageSeriesType
+	active     bool
+	retention  RetentionClass
 }
 
 func newTimeStampSeriesType(
+	endpointId interface{},
 	groupId int,
 	ts float64,
 	metrics *storeMetricsType) *timestampSeriesType {
 	result := &timestampSeriesType{
-		groupId: groupId,
-		metrics: metrics,
-		lastTs:  ts,
-		active:  true}
-	result.pages.Init((*pageWithMetaDataType).TimePage)
+		endpointId: endpointId,
+		groupId:    groupId,
+		metrics:    metrics,
+		lastTs:     ts,
+		active:     true}
+	result.pages.Init((*pageWithMetaDataType).TimePage, kTimeStampsOnly)
 	result.metrics.NewTimeStampSeries()
 	return result
 }
 
+func (t *timestampSeriesType) EndpointId() interface{} {
+	return t.endpointId
+}
+
+func (t *timestampSeriesType) RetentionClass() RetentionClass {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.retention
+}
+
+func (t *timestampSeriesType) SetRetentionClass(class RetentionClass) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.retention = class
+}
+
 func (t *timestampSeriesType) GroupId() int {
 	return t.groupId
 }
@@ -309,9 +357,9 @@ func (t *timestampSeriesType) Add(ts float64) (
 	t.active = true
 	if isNew {
 		t.metrics.AddEmptyTimeStampPage()
+		t.pages.logFullPage(t.metrics)
 	}
-	latestPage := lastPage.Times()
-	latestPage.Add(t.lastTs)
+	lastPage.AddTime(t.lastTs)
 	t.lastTs = ts
 	return
 }
@@ -353,9 +401,9 @@ func (t *timestampSeriesType) Inactivate() (
 	t.active = false
 	if isNew {
 		t.metrics.AddEmptyTimeStampPage()
+		t.pages.logFullPage(t.metrics)
 	}
-	latestPage := lastPage.Times()
-	latestPage.Add(t.lastTs)
+	lastPage.AddTime(t.lastTs)
 	t.lastTs = incTs(t.lastTs)
 	return
 }
@@ -364,19 +412,20 @@ func (t *timestampSeriesType) Inactivate() (
 // Only the page queue calls this. This method must not be called directly.
 // The page queue will always take pages away in the same
 // order it bestowed them.
-func (t *timestampSeriesType) GiveUpPage(page *pageWithMetaDataType) {
+func (t *timestampSeriesType) GiveUpPage(page *pageWithMetaDataType) int {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	if t.pages.GiveUpPage(page, nil) {
 		// Only update latest evicted timestamp for active timestamp series
 		if t.active {
-			latest, ok := page.Times().Latest()
+			latest, ok := page.Latest()
 			if ok {
 				t.metrics.UpdateLatestEvictedTimeStamp(latest)
 			}
 		}
 		t.metrics.RemoveTimeStampPage()
 	}
+	return 0
 }
 
 func (t *timestampSeriesType) GivenUpPages() bool {
@@ -388,10 +437,11 @@ func (t *timestampSeriesType) GivenUpPages() bool {
 // AcceptPage grants given page to this series. The page queue calls
 // this to bestow a page to this series. This method must not be called
 // directly.
-func (t *timestampSeriesType) AcceptPage(page *pageWithMetaDataType) {
+func (t *timestampSeriesType) AcceptPage(
+	page *pageWithMetaDataType, compress bool) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	t.pages.AcceptPage(page)
+	t.pages.AcceptPage(page, compress)
 }
 
 // FindBetween returns timestamps falling between start inclusive and
@@ -443,25 +493,45 @@ func (t *timestampSeriesType) FindAfter(
 // timeSeriesType represents a list of timestamped values in ascending order
 // by timestamp.
 type timeSeriesType struct {
-	id        *MetricInfo
-	metrics   *storeMetricsType
-	lock      sync.Mutex
-	lastValue tsValueType
-	pages     pageSeriesType
-}
-
+	endpointId interface{}
+	id         *MetricInfo
+	metrics    *storeMetricsType
+	lock       sync.Mutex
+	lastValue  tsValueType
+	pages      pageSeriesType
+	// nil if this series does not roll up values
+	rollUps *rollUpsType
+}
+
+// newTimeSeriesType creates a new time series. If rollUpTiers is non-empty
+// and the metric is numeric, the new time series rolls up values into
+// rollUpTiers as it gives up pages.
 func newTimeSeriesType(
+	endpointId interface{},
 	id *MetricInfo,
 	ts float64, value interface{},
-	metrics *storeMetricsType) *timeSeriesType {
-	result := &timeSeriesType{id: id, metrics: metrics}
+	metrics *storeMetricsType,
+	rollUpTiers []RollUpTier) *timeSeriesType {
+	result := &timeSeriesType{
+		endpointId: endpointId, id: id, metrics: metrics}
+	if len(rollUpTiers) > 0 && id.Kind().CanToFromFloat() {
+		result.rollUps = newRollUpsType(rollUpTiers)
+	}
 	result.lastValue.TimeStamp = ts
 	result.lastValue.Value = value
-	result.pages.Init((*pageWithMetaDataType).ValuePage)
+	result.pages.Init((*pageWithMetaDataType).ValuePage, id.Kind())
 	result.metrics.NewValueSeries()
 	return result
 }
 
+func (t *timeSeriesType) EndpointId() interface{} {
+	return t.endpointId
+}
+
+func (t *timeSeriesType) RetentionClass() RetentionClass {
+	return t.id.retention
+}
+
 func (t *timeSeriesType) GroupId() int {
 	return t.id.GroupId()
 }
@@ -469,30 +539,34 @@ func (t *timeSeriesType) GroupId() int {
 // AcceptPage grants given page to this series. The page queue calls
 // this to bestow a page to this series. This method must not be called
 // directly.
-func (t *timeSeriesType) AcceptPage(page *pageWithMetaDataType) {
+func (t *timeSeriesType) AcceptPage(
+	page *pageWithMetaDataType, compress bool) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	t.pages.AcceptPage(page)
+	t.pages.AcceptPage(page, compress)
 }
 
 // GiveUpPage instructs this series to give up the given page.
 // Only the page queue calls this. This method must not be called directly.
 // The page queue will always take pages away in the same
 // order it bestowed them.
-func (t *timeSeriesType) GiveUpPage(page *pageWithMetaDataType) {
+func (t *timeSeriesType) GiveUpPage(
+	page *pageWithMetaDataType) (rollUpBytes int) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	oldLen := t.pages.Len()
 	if t.pages.GiveUpPage(page, nil) {
+		rollUpBytes = t.rollUp(page)
 		// Only update latest evicted timestamp for active time series
 		if t.lastValue.Value != gInactive {
-			latest, ok := page.Values().Latest()
+			latest, ok := page.Latest()
 			if ok {
 				t.metrics.UpdateLatestEvictedTimeStamp(latest)
 			}
 		}
-		t.metrics.RemoveValuePage(oldLen, page.Values().Len())
+		t.metrics.RemoveValuePage(oldLen, page.Len())
 	}
+	return
 }
 
 func (t *timeSeriesType) LatestPage() *pageWithMetaDataType {
@@ -563,9 +637,9 @@ func (t *timeSeriesType) Add(
 	justActivated = t.lastValue.Value == gInactive
 	if newPage {
 		t.metrics.AddEmptyValuePage(oldLen)
+		t.pages.logFullPage(t.metrics)
 	}
-	latestPage := lastPage.Values()
-	latestPage.Add(t.lastValue)
+	lastPage.AddValue(t.lastValue)
 	// Ensure that timestamps are monotone increasing. We could get an
 	// earlier timestamp if we are getting an inactive marker.
 	if timestamp <= t.lastValue.TimeStamp {
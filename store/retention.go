@@ -0,0 +1,137 @@
+package store
+
+import (
+	"regexp"
+)
+
+// This file contains the code for retention classes.
+
+type retentionRuleType struct {
+	regex *regexp.Regexp
+	class RetentionClass
+}
+
+func newRetentionClasses(rules []RetentionRule) (*RetentionClasses, error) {
+	result := &RetentionClasses{rules: make([]retentionRuleType, len(rules))}
+	for i := range rules {
+		regex, err := regexp.Compile(rules[i].PathRegex)
+		if err != nil {
+			return nil, err
+		}
+		result.rules[i] = retentionRuleType{
+			regex: regex, class: rules[i].Class}
+	}
+	return result, nil
+}
+
+func (r *RetentionClasses) retentionClass(path string) RetentionClass {
+	for i := range r.rules {
+		if r.rules[i].regex.MatchString(path) {
+			return r.rules[i].class
+		}
+	}
+	return NormalRetention
+}
+
+// retentionClassOf returns the retention class that policy assigns to
+// path. A nil policy assigns NormalRetention to everything.
+func retentionClassOf(policy RetentionPolicy, path string) RetentionClass {
+	if policy == nil {
+		return NormalRetention
+	}
+	return policy.RetentionClass(path)
+}
+
+// groupRetentionClassesType tracks the highest retention class of the time
+// series in each group so that the timestamps of a group last as long as
+// the values that need them.
+type groupRetentionClassesType map[int]RetentionClass
+
+// Add notes that the group of timeSeries has a time series with the
+// retention class of timeSeries.
+func (g groupRetentionClassesType) Add(timeSeries *timeSeriesType) {
+	class := timeSeries.RetentionClass()
+	groupClass, ok := g[timeSeries.GroupId()]
+	if !ok || class > groupClass {
+		g[timeSeries.GroupId()] = class
+	}
+}
+
+// Set sets the retention class of timestampSeries, which is new, to the
+// highest retention class of the time series in its group.
+func (g groupRetentionClassesType) Set(timestampSeries *timestampSeriesType) {
+	if class, ok := g[timestampSeries.GroupId()]; ok {
+		timestampSeries.SetRetentionClass(class)
+	}
+}
+
+// Raise raises the retention class of timestampSeries, which already has
+// time series, if a new time series in its group has a higher retention
+// class.
+func (g groupRetentionClassesType) Raise(
+	timestampSeries *timestampSeriesType) {
+	class, ok := g[timestampSeries.GroupId()]
+	if ok && class > timestampSeries.RetentionClass() {
+		timestampSeries.SetRetentionClass(class)
+	}
+}
+
+// reclassify gives each metric in c the retention class that policy
+// assigns and updates the retention classes of the timestamp series to
+// match. Since a series must give up its pages oldest first, reclassify
+// moves the full pages of each series whose class changed to its new
+// class. Caller must hold both the normal and status change locks of c.
+func (c *timeSeriesCollectionType) reclassify(
+	policy RetentionPolicy, supplier *pageQueueType) {
+	oldClasses := make(map[*MetricInfo]RetentionClass, len(c.timeSeries))
+	for id := range c.timeSeries {
+		oldClasses[id] = id.retention
+	}
+	oldTsClasses := make(
+		map[*timestampSeriesType]RetentionClass, len(c.timestampSeries))
+	for _, timestampSeries := range c.timestampSeries {
+		oldTsClasses[timestampSeries] = timestampSeries.RetentionClass()
+	}
+	c.metricInfoStore.Reclassify(policy)
+	classByGroupId := make(groupRetentionClassesType)
+	for _, timeSeries := range c.timeSeries {
+		classByGroupId.Add(timeSeries)
+	}
+	for _, timestampSeries := range c.timestampSeries {
+		classByGroupId.Set(timestampSeries)
+	}
+	var pageLists []pageListType
+	for id, timeSeries := range c.timeSeries {
+		if id.retention != oldClasses[id] {
+			pageLists = append(pageLists, timeSeries.PageList())
+		}
+	}
+	for timestampSeries, oldClass := range oldTsClasses {
+		if timestampSeries.RetentionClass() != oldClass {
+			pageLists = append(pageLists, timestampSeries.PageList())
+		}
+	}
+	supplier.Reclassify(pageLists)
+}
+
+// updateRetentionClasses sets the retention class of the timestamp series
+// of each group having new time series. The time series themselves get
+// their retention class from their MetricInfo.
+func updateRetentionClasses(
+	newOnes []*timeSeriesType,
+	newTs []*timestampSeriesType,
+	tsFetched map[*timestampSeriesType]float64) {
+	if len(newOnes) == 0 {
+		return
+	}
+	classByGroupId := make(groupRetentionClassesType)
+	for _, timeSeries := range newOnes {
+		classByGroupId.Add(timeSeries)
+	}
+	for _, timestampSeries := range newTs {
+		classByGroupId.Set(timestampSeries)
+	}
+	for timestampSeries := range tsFetched {
+		classByGroupId.Raise(timestampSeries)
+	}
+}
@@ -0,0 +1,446 @@
+package store
+
+import (
+	"github.com/Symantec/scotty/tsdb"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// This file contains all the code for rolling up values of numeric time
+// series into coarser tiers as their pages are evicted.
+
+var (
+	kRollUpBucketSize = rollUpBucketSize()
+)
+
+func rollUpBucketSize() int {
+	var b rollUpBucketType
+	return int(reflect.TypeOf(b).Size())
+}
+
+// rollUpBucketType is a single rolled up value.
+type rollUpBucketType struct {
+	// Start of time period in seconds since Jan 1, 1970
+	Start float64
+	Min   float64
+	Max   float64
+	// Sum of each value times the seconds it was in effect
+	Sum float64
+	// Total seconds of values in this bucket
+	Seconds float64
+	// Number of values that started in this bucket
+	Count uint64
+	// Sum of the values that started in this bucket
+	ValueSum float64
+}
+
+func (b *rollUpBucketType) Add(value, seconds float64, isNew bool) {
+	if value < b.Min {
+		b.Min = value
+	}
+	if value > b.Max {
+		b.Max = value
+	}
+	b.Sum += value * seconds
+	b.Seconds += seconds
+	if isNew {
+		b.Count++
+		b.ValueSum += value
+	}
+}
+
+func (b *rollUpBucketType) Avg() float64 {
+	return b.Sum / b.Seconds
+}
+
+// Value returns the rolled up value that field selects.
+func (b *rollUpBucketType) Value(field RollUpField) float64 {
+	switch field {
+	case RollUpMin:
+		return b.Min
+	case RollUpMax:
+		return b.Max
+	case RollUpSum:
+		return b.ValueSum
+	default:
+		return b.Avg()
+	}
+}
+
+func (b *rollUpBucketType) RollUpValue() RollUpValue {
+	return RollUpValue{
+		Start: b.Start,
+		Min:   b.Min,
+		Max:   b.Max,
+		Avg:   b.Avg(),
+		Count: b.Count,
+	}
+}
+
+// rollUpTierType holds the rolled up values of one tier of a time series
+// in a ring buffer.
+type rollUpTierType struct {
+	width    float64
+	capacity int
+	// Buckets in the ring buffer. Grows until it reaches capacity.
+	buckets []rollUpBucketType
+	// Index of the oldest bucket
+	first int
+	// True if oldest buckets have been dropped.
+	dropped bool
+}
+
+func newRollUpTierType(tier RollUpTier) rollUpTierType {
+	return rollUpTierType{
+		width:    tier.Duration.Seconds(),
+		capacity: int(tier.Count),
+	}
+}
+
+// Earliest returns the earliest time for which this tier has values or
+// 0.0 if this tier has dropped no values.
+func (r *rollUpTierType) Earliest() float64 {
+	if !r.dropped || len(r.buckets) == 0 {
+		return 0.0
+	}
+	return r.buckets[r.first].Start
+}
+
+// Len returns the number of buckets
+func (r *rollUpTierType) Len() int {
+	return len(r.buckets)
+}
+
+// Bytes returns the memory that the buckets of this tier use.
+func (r *rollUpTierType) Bytes() int {
+	return cap(r.buckets) * kRollUpBucketSize
+}
+
+// At returns the bucket at idx. 0 is the oldest bucket.
+func (r *rollUpTierType) At(idx int) *rollUpBucketType {
+	return &r.buckets[(r.first+idx)%len(r.buckets)]
+}
+
+func (r *rollUpTierType) last() *rollUpBucketType {
+	if len(r.buckets) == 0 {
+		return nil
+	}
+	return r.At(len(r.buckets) - 1)
+}
+
+// grow doubles the room for buckets without going over capacity so that
+// Bytes stays within what the capacity of this tier calls for.
+func (r *rollUpTierType) grow() {
+	newCap := 2 * cap(r.buckets)
+	if newCap == 0 {
+		newCap = 1
+	}
+	if newCap > r.capacity {
+		newCap = r.capacity
+	}
+	buckets := make([]rollUpBucketType, len(r.buckets), newCap)
+	copy(buckets, r.buckets)
+	r.buckets = buckets
+}
+
+// bucketFor returns the bucket starting at start adding it if needed.
+// bucketFor returns nil if start comes before the latest bucket.
+func (r *rollUpTierType) bucketFor(start float64) *rollUpBucketType {
+	last := r.last()
+	if last != nil && last.Start == start {
+		return last
+	}
+	if last != nil && last.Start > start {
+		return nil
+	}
+	newBucket := rollUpBucketType{
+		Start: start, Min: math.Inf(1), Max: math.Inf(-1)}
+	if len(r.buckets) < r.capacity {
+		if len(r.buckets) == cap(r.buckets) {
+			r.grow()
+		}
+		r.buckets = append(r.buckets, newBucket)
+		return r.last()
+	}
+	r.buckets[r.first] = newBucket
+	r.first = (r.first + 1) % len(r.buckets)
+	r.dropped = true
+	return r.last()
+}
+
+// Add adds value which was in effect from start inclusive to end exclusive.
+func (r *rollUpTierType) Add(value, start, end float64) {
+	if r.capacity == 0 {
+		return
+	}
+	isNew := true
+	// Don't bother with buckets that would be dropped anyway
+	if earliest := math.Floor(end/r.width-float64(r.capacity)) * r.width; start < earliest {
+		start = earliest
+		isNew = false
+	}
+	for start < end {
+		bucketStart := math.Floor(start/r.width) * r.width
+		segmentEnd := math.Min(end, bucketStart+r.width)
+		if bucket := r.bucketFor(bucketStart); bucket != nil {
+			bucket.Add(value, segmentEnd-start, isNew)
+		}
+		isNew = false
+		start = segmentEnd
+	}
+}
+
+// rollUpsType holds all the roll up tiers of a time series.
+type rollUpsType struct {
+	// Values before this time are rolled up.
+	rolledUpTo float64
+	tiers      []rollUpTierType
+}
+
+func newRollUpsType(tiers []RollUpTier) *rollUpsType {
+	result := &rollUpsType{tiers: make([]rollUpTierType, len(tiers))}
+	for i := range tiers {
+		result.tiers[i] = newRollUpTierType(tiers[i])
+	}
+	return result
+}
+
+// Bytes returns the memory that the buckets of all the tiers use.
+func (r *rollUpsType) Bytes() (result int) {
+	for i := range r.tiers {
+		result += r.tiers[i].Bytes()
+	}
+	return
+}
+
+// Add rolls up values. Each value is in effect until the timestamp of the
+// next value. next is the value after the last value in values.
+// info is the metric info of the values.
+func (r *rollUpsType) Add(
+	values []tsValueType, next tsValueType, info *MetricInfo) {
+	kind := info.Kind()
+	for i := range values {
+		end := next.TimeStamp
+		if i+1 < len(values) {
+			end = values[i+1].TimeStamp
+		}
+		if values[i].Value != gInactive {
+			value := kind.ToFloat(values[i].Value)
+			for j := range r.tiers {
+				r.tiers[j].Add(value, values[i].TimeStamp, end)
+			}
+		}
+		if end > r.rolledUpTo {
+			r.rolledUpTo = end
+		}
+	}
+}
+
+// Fetch appends the buckets of given tier that overlap start inclusive to
+// end exclusive and come before the values that are not rolled up.
+func (r *rollUpsType) Fetch(
+	tier int, start, end float64, result *[]rollUpBucketType) {
+	t := &r.tiers[tier]
+	if end > r.rolledUpTo {
+		end = r.rolledUpTo
+	}
+	length := t.Len()
+	for i := 0; i < length; i++ {
+		bucket := t.At(i)
+		if bucket.Start+t.width > start && bucket.Start < end {
+			*result = append(*result, *bucket)
+		}
+	}
+}
+
+// fetchRollUps appends the buckets of given tier to result. fetchRollUps
+// returns the time before which values are rolled up and the earliest time
+// of the tier. If this time series has no such tier, fetchRollUps returns
+// false.
+func (t *timeSeriesType) fetchRollUps(
+	tier int, start, end float64, result *[]rollUpBucketType) (
+	rolledUpTo, earliest float64, ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.rollUps == nil || tier >= len(t.rollUps.tiers) {
+		return
+	}
+	t.rollUps.Fetch(tier, start, end, result)
+	return t.rollUps.rolledUpTo, t.rollUps.tiers[tier].Earliest(), true
+}
+
+// rollUp rolls up the values in page that this time series just gave up.
+// rollUp returns how many more bytes the roll ups of this time series
+// use as a result. Caller must hold the lock of this time series.
+func (t *timeSeriesType) rollUp(page *pageWithMetaDataType) int {
+	if t.rollUps == nil {
+		return 0
+	}
+	next := t.lastValue
+	if front := t.pages.pages.Front(); front != nil {
+		nextPage := *front.Value.(*pageWithMetaDataType).Values()
+		if len(nextPage) > 0 {
+			next = nextPage[0]
+		}
+	}
+	oldBytes := t.rollUps.Bytes()
+	t.rollUps.Add(*page.Values(), next, t.id)
+	return t.rollUps.Bytes() - oldBytes
+}
+
+// dropRollUps drops the roll ups of this time series and returns the
+// bytes they used.
+func (t *timeSeriesType) dropRollUps() (result int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.rollUps != nil {
+		result = t.rollUps.Bytes()
+		t.rollUps = nil
+	}
+	return
+}
+
+type rollUpTiersByDurationType []RollUpTier
+
+func (r rollUpTiersByDurationType) Len() int { return len(r) }
+
+func (r rollUpTiersByDurationType) Less(i, j int) bool {
+	return r[i].Duration < r[j].Duration
+}
+
+func (r rollUpTiersByDurationType) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+type rollUpBucketsByStartType []rollUpBucketType
+
+func (b rollUpBucketsByStartType) Len() int { return len(b) }
+
+func (b rollUpBucketsByStartType) Less(i, j int) bool {
+	return b[i].Start < b[j].Start
+}
+
+func (b rollUpBucketsByStartType) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+// mergeRollUps merges buckets from multiple time series into one bucket
+// per start time.
+func mergeRollUps(buckets []rollUpBucketType) (result []rollUpBucketType) {
+	sort.Stable(rollUpBucketsByStartType(buckets))
+	for i := range buckets {
+		length := len(result)
+		if length > 0 && result[length-1].Start == buckets[i].Start {
+			last := &result[length-1]
+			last.Min = math.Min(last.Min, buckets[i].Min)
+			last.Max = math.Max(last.Max, buckets[i].Max)
+			last.Sum += buckets[i].Sum
+			last.Seconds += buckets[i].Seconds
+			last.Count += buckets[i].Count
+			last.ValueSum += buckets[i].ValueSum
+		} else {
+			result = append(result, buckets[i])
+		}
+	}
+	return
+}
+
+// rollUps fetches the merged buckets for the given numeric time series.
+// rollUps returns the time where raw values begin and the earliest time of
+// the tier.
+func rollUps(
+	timeSeries []*timeSeriesType,
+	tier int,
+	start, end float64) (
+	result []rollUpBucketType, rolledUpTo, earliest float64, ok bool) {
+	var buckets []rollUpBucketType
+	for _, ts := range timeSeries {
+		tsRolledUpTo, tsEarliest, tsOk := ts.fetchRollUps(
+			tier, start, end, &buckets)
+		if !tsOk {
+			continue
+		}
+		ok = true
+		if tsRolledUpTo > rolledUpTo {
+			rolledUpTo = tsRolledUpTo
+		}
+		if tsEarliest > earliest {
+			earliest = tsEarliest
+		}
+	}
+	return mergeRollUps(buckets), rolledUpTo, earliest, ok
+}
+
+// numericTimeSeries returns the time series for name that have numeric
+// values.
+func (c *timeSeriesCollectionType) numericTimeSeries(name string) (
+	result []*timeSeriesType,
+	timestampSeries map[int]*timestampSeriesType) {
+	timeSeries, timestampSeries := c.TsAndTimeStampsByName(name)
+	partition := GroupMetricByPathAndNumeric.orderedPartition(timeSeries)
+	tslen := len(timeSeries)
+	for startIdx, endIdx := 0, 0; startIdx < tslen; startIdx = endIdx {
+		endIdx = nextSubset(partition, startIdx)
+		if timeSeries[startIdx].id.Kind().CanToFromFloat() {
+			return timeSeries[startIdx:endIdx], timestampSeries
+		}
+	}
+	return nil, nil
+}
+
+// DropRollUps drops the roll ups of all the time series in this
+// collection and returns the bytes they used.
+func (c *timeSeriesCollectionType) DropRollUps() (result int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, ts := range c.timeSeries {
+		result += ts.dropRollUps()
+	}
+	return
+}
+
+// RollUps returns the rolled up values of the given tier for the numeric
+// metric with given name.
+func (c *timeSeriesCollectionType) RollUps(
+	name string, tier int, start, end float64) (
+	result []RollUpValue, ok bool) {
+	timeSeries, _ := c.numericTimeSeries(name)
+	buckets, _, _, ok := rollUps(timeSeries, tier, start, end)
+	if !ok {
+		return
+	}
+	result = make([]RollUpValue, len(buckets))
+	for i := range buckets {
+		result[i] = buckets[i].RollUpValue()
+	}
+	return
+}
+
+// TsdbTimeSeriesRollUp works like TsdbTimeSeries except that it uses the
+// rolled up values that field selects from given tier for times where
+// values are no longer available.
+func (c *timeSeriesCollectionType) TsdbTimeSeriesRollUp(
+	name string, tier int, field RollUpField, start, end float64) (
+	result tsdb.TimeSeries, earliest float64, ok bool) {
+	timeSeries, timestampSeries := c.numericTimeSeries(name)
+	buckets, rolledUpTo, earliest, ok := rollUps(
+		timeSeries, tier, start, end)
+	if !ok {
+		return
+	}
+	for i := range buckets {
+		result = append(
+			result,
+			tsdb.TsValue{
+				Ts: buckets[i].Start, Value: buckets[i].Value(field)})
+	}
+	result = append(
+		result,
+		c.tsdbTimeSeries(
+			timeSeries,
+			timestampSeries,
+			math.Max(start, rolledUpTo),
+			end)...)
+	return
+}
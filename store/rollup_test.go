@@ -0,0 +1,147 @@
+package store
+
+import (
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRollUpTier(t *testing.T) {
+	tier := newRollUpTierType(RollUpTier{Duration: time.Minute, Count: 3})
+	// 5 from 30 to 90 spans two buckets
+	tier.Add(5.0, 30.0, 90.0)
+	// 20 from 90 to 120
+	tier.Add(20.0, 90.0, 120.0)
+	expected := []rollUpBucketType{
+		{Start: 0.0, Min: 5.0, Max: 5.0, Sum: 150.0, Seconds: 30.0, Count: 1,
+			ValueSum: 5.0},
+		{Start: 60.0, Min: 5.0, Max: 20.0, Sum: 750.0, Seconds: 60.0, Count: 1,
+			ValueSum: 20.0},
+	}
+	assertBuckets(t, expected, &tier)
+	if earliest := tier.Earliest(); earliest != 0.0 {
+		t.Errorf("Expected 0.0, got %v", earliest)
+	}
+	if avg := tier.At(1).Avg(); avg != 12.5 {
+		t.Errorf("Expected 12.5, got %v", avg)
+	}
+
+	// Values before latest bucket are ignored
+	tier.Add(100.0, 0.0, 10.0)
+	assertBuckets(t, expected, &tier)
+
+	// Oldest buckets get dropped.
+	tier.Add(7.0, 120.0, 300.0)
+	expected = []rollUpBucketType{
+		{Start: 120.0, Min: 7.0, Max: 7.0, Sum: 420.0, Seconds: 60.0, Count: 1,
+			ValueSum: 7.0},
+		{Start: 180.0, Min: 7.0, Max: 7.0, Sum: 420.0, Seconds: 60.0, Count: 0},
+		{Start: 240.0, Min: 7.0, Max: 7.0, Sum: 420.0, Seconds: 60.0, Count: 0},
+	}
+	assertBuckets(t, expected, &tier)
+	if earliest := tier.Earliest(); earliest != 120.0 {
+		t.Errorf("Expected 120.0, got %v", earliest)
+	}
+
+	// Value that started long ago isn't counted again.
+	tier.Add(9.0, 0.0, 360.0)
+	if bucket := tier.At(2); bucket.Start != 300.0 || bucket.Count != 0 {
+		t.Errorf("Unexpected bucket %v", *bucket)
+	}
+}
+
+func TestRollUpsFetchAndMerge(t *testing.T) {
+	tiers := []RollUpTier{{Duration: time.Minute, Count: 10}}
+	first := newRollUpsType(tiers)
+	second := newRollUpsType(tiers)
+	first.tiers[0].Add(1.0, 0.0, 120.0)
+	first.rolledUpTo = 120.0
+	second.tiers[0].Add(3.0, 60.0, 180.0)
+	second.rolledUpTo = 180.0
+	var buckets []rollUpBucketType
+	first.Fetch(0, 30.0, 1000.0, &buckets)
+	second.Fetch(0, 30.0, 1000.0, &buckets)
+	merged := mergeRollUps(buckets)
+	if len(merged) != 3 {
+		t.Fatalf("Expected 3 buckets, got %d", len(merged))
+	}
+	actual := merged[1].RollUpValue()
+	expected := RollUpValue{Start: 60.0, Min: 1.0, Max: 3.0, Avg: 2.0, Count: 1}
+	if actual != expected {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if merged[2].Start != 120.0 || merged[2].Avg() != 3.0 {
+		t.Errorf("Unexpected last bucket %v", merged[2])
+	}
+}
+
+func assertBuckets(
+	t *testing.T, expected []rollUpBucketType, tier *rollUpTierType) {
+	actual := make([]rollUpBucketType, tier.Len())
+	for i := range actual {
+		actual[i] = *tier.At(i)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	for i := range actual {
+		if math.IsInf(actual[i].Min, 0) {
+			t.Errorf("Bucket %d has no values", i)
+		}
+	}
+}
+
+func TestRollUpsTakePages(t *testing.T) {
+	aStore := NewStore(2, 20, 1.0, 10)
+	aStore.SetRollUpTiers([]RollUpTier{{Duration: time.Minute, Count: 10}})
+	aStore.RegisterEndpoint("endpoint0")
+	aMetric := metrics.SimpleList{{Path: "/foo"}}
+	for i := 0; i < 40; i++ {
+		aMetric[0].Value = int64(i)
+		if _, err := aStore.AddBatch(
+			"endpoint0", 30.0*float64(i), aMetric.Sorted()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	supplier := aStore.supplier
+	rollUpBytes, rollUpPageCount := supplier.RollUpStats()
+	if rollUpBytes != uint64(10*kRollUpBucketSize) {
+		t.Errorf("Expected full tier, got %d bytes", rollUpBytes)
+	}
+	bytesPerPage := uint64(supplier.bytesPerPage)
+	expected := uint((rollUpBytes + bytesPerPage - 1) / bytesPerPage)
+	if rollUpPageCount != expected {
+		t.Errorf(
+			"Expected %d pages for roll ups, got %d",
+			expected,
+			rollUpPageCount)
+	}
+	if total := supplier.pq.Len() + rollUpPageCount; total != 20 {
+		t.Errorf("Expected 20 pages in all, got %d", total)
+	}
+
+	// Pages come back once the roll ups go away
+	aStore = aStore.ShallowCopy()
+	aStore.UnregisterEndpoint("endpoint0")
+	if rollUpBytes, _ = supplier.RollUpStats(); rollUpBytes != 0 {
+		t.Errorf("Expected no roll ups, got %d bytes", rollUpBytes)
+	}
+	aStore.RegisterEndpoint("endpoint1")
+	aMetric[0].Path = "/bar"
+	for i := 0; i < 40; i++ {
+		aMetric[0].Value = fmt.Sprintf("%d", i)
+		if _, err := aStore.AddBatch(
+			"endpoint1", 30.0*float64(i), aMetric.Sorted()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, rollUpPageCount = supplier.RollUpStats(); rollUpPageCount != 0 {
+		t.Errorf("Expected pages to come back, got %d", rollUpPageCount)
+	}
+	if pageCount := supplier.pq.Len(); pageCount != 20 {
+		t.Errorf("Expected 20 pages, got %d", pageCount)
+	}
+}
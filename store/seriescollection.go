@@ -28,9 +28,11 @@ func (m *metricInfoStoreType) Init() {
 
 // Register returns the correct MetricInfo instance from the pool for
 // passed in metric and type. Register will always return a non nil value.
+// If the MetricInfo instance is new, Register gives it the retention class
+// that policy assigns.
 func (m *metricInfoStoreType) Register(
-	metric *metrics.Value, kind, subType types.Type) (
-	result *MetricInfo) {
+	metric *metrics.Value, kind, subType types.Type,
+	policy RetentionPolicy) (result *MetricInfo) {
 	if kind == types.Unknown {
 		panic("Got Unknown type")
 	}
@@ -61,12 +63,31 @@ func (m *metricInfoStoreType) Register(
 	if alreadyExists {
 		return
 	}
-	result = &infoStruct
-	m.ByInfo[infoStruct] = result
-	m.ByName[infoStruct.path] = append(m.ByName[infoStruct.path], result)
+	result = m.add(infoStruct, policy)
 	return
 }
 
+// add adds info to the pool giving it the retention class that policy
+// assigns and returns the pooled instance.
+func (m *metricInfoStoreType) add(
+	info MetricInfo, policy RetentionPolicy) *MetricInfo {
+	// The retention class isn't part of the key.
+	result := new(MetricInfo)
+	*result = info
+	result.retention = retentionClassOf(policy, info.path)
+	m.ByInfo[info] = result
+	m.ByName[info.path] = append(m.ByName[info.path], result)
+	return result
+}
+
+// Reclassify gives each MetricInfo instance in the pool the retention class
+// that policy assigns.
+func (m *metricInfoStoreType) Reclassify(policy RetentionPolicy) {
+	for _, info := range m.ByInfo {
+		info.retention = retentionClassOf(policy, info.path)
+	}
+}
+
 // timeSeriesCollectionType represents all the values and timestamps for
 // a particular endpoint.
 type timeSeriesCollectionType struct {
@@ -87,14 +108,40 @@ type timeSeriesCollectionType struct {
 	active                      bool
 	iterators                   map[string]*namedIteratorDataType
 	distributionRollOversByPath map[string]*distributionRollOverType
+	rollUpTiers                 []RollUpTier
+	// scotty timestamp of the last batch added. Protected by
+	// statusChangeLock.
+	lastBatchTimeStamp float64
+	// The write-ahead log epoch of the last batch logged. Protected by
+	// statusChangeLock.
+	walEpoch uint64
+	// The generation of the retention policy that assigned the retention
+	// classes of the metrics in this instance.
+	retentionGeneration uint64
+}
+
+// batchChangesType records what a batch changed in a collection so that
+// the batch can be logged to the write-ahead log.
+type batchChangesType struct {
+	// The current epoch of the write-ahead log. Set by caller.
+	Epoch uint64
+	// True if the previous batch logged for the collection was logged in
+	// a different epoch and so this batch must be logged in full.
+	Full bool
+	// Paths of the values added to the collection
+	Added map[string]bool
+	// Paths of the time series that went inactive
+	Removed []string
 }
 
 func newTimeSeriesCollectionType(
 	app interface{},
-	metrics *storeMetricsType) *timeSeriesCollectionType {
+	metrics *storeMetricsType,
+	rollUpTiers []RollUpTier) *timeSeriesCollectionType {
 	result := &timeSeriesCollectionType{
 		applicationId:               app,
 		metrics:                     metrics,
+		rollUpTiers:                 rollUpTiers,
 		timeSeries:                  make(map[*MetricInfo]*timeSeriesType),
 		timestampSeries:             make(map[int]*timestampSeriesType),
 		active:                      true,
@@ -284,6 +331,7 @@ func (c *timeSeriesCollectionType) TsAllAndTimeStampsMarkingInactive() (
 		return
 	}
 	c.active = false
+	c.metrics.Cardinality.LogInactive(c.applicationId)
 	return c.tsAll(), c.tsAllTimeStamps(), true
 }
 
@@ -351,8 +399,10 @@ func (c *timeSeriesCollectionType) TsAndTimeStampsByPrefix(prefix string) (
 // are the time series that should be marked inactive.
 // ok is true if metrics can be added to this instance or false if this
 // instance is inactive and closed for new metrics.
+// If the retention policy of supplier changed since the last lookup,
+// LookupBatch first gives existing metrics their new retention classes.
 func (c *timeSeriesCollectionType) LookupBatch(
-	timestamp float64, mlist metrics.List) (
+	timestamp float64, mlist metrics.List, supplier *pageQueueType) (
 	fetched map[*timeSeriesType]interface{},
 	newOnes, notFetched []*timeSeriesType,
 	fetchedTimeStamps map[*timestampSeriesType]float64,
@@ -368,6 +418,11 @@ func (c *timeSeriesCollectionType) LookupBatch(
 		err = ErrInactive
 		return
 	}
+	policy, generation := supplier.RetentionPolicy()
+	if generation != c.retentionGeneration {
+		c.reclassify(policy, supplier)
+		c.retentionGeneration = generation
+	}
 	valueByMetric := make(map[*MetricInfo]interface{})
 	timestampByGroupId := make(map[int]float64)
 	groupIds := make(map[int]bool)
@@ -378,7 +433,7 @@ func (c *timeSeriesCollectionType) LookupBatch(
 		var avalue metrics.Value
 		mlist.Index(i, &avalue)
 		kind, subType := types.FromGoValueWithSubType(avalue.Value)
-		id := c.metricInfoStore.Register(&avalue, kind, subType)
+		id := c.metricInfoStore.Register(&avalue, kind, subType, policy)
 		if kind == types.Dist {
 			distributionRollOvers := c.distributionRollOversByPath[avalue.Path]
 			if distributionRollOvers == nil {
@@ -420,7 +475,8 @@ func (c *timeSeriesCollectionType) LookupBatch(
 		if c.timeSeries[id] == nil {
 			thisTs := timestampByGroupId[id.GroupId()]
 			c.timeSeries[id] = newTimeSeriesType(
-				id, thisTs, value, c.metrics)
+				c.applicationId,
+				id, thisTs, value, c.metrics, c.rollUpTiers)
 			newOnes = append(newOnes, c.timeSeries[id])
 		} else {
 			fetched[c.timeSeries[id]] = value
@@ -437,12 +493,17 @@ func (c *timeSeriesCollectionType) LookupBatch(
 	for groupId, ts := range timestampByGroupId {
 		if c.timestampSeries[groupId] == nil {
 			c.timestampSeries[groupId] = newTimeStampSeriesType(
-				groupId, ts, c.metrics)
+				c.applicationId, groupId, ts, c.metrics)
 			newTs = append(newTs, c.timestampSeries[groupId])
 		} else {
 			fetchedTimeStamps[c.timestampSeries[groupId]] = ts
 		}
 	}
+	c.metrics.Cardinality.LogBatch(
+		c.applicationId,
+		uint(len(c.timeSeries)),
+		uint(len(valueByMetric)),
+		uint(len(newOnes)))
 	return
 }
 
@@ -465,9 +526,17 @@ func (c *timeSeriesCollectionType) updateTimeStampSeriesAndTimeSeries(
 	newTs []*timestampSeriesType,
 	tsFetched map[*timestampSeriesType]float64,
 	tsNotFetched []*timestampSeriesType,
-	supplier *pageQueueType) (result int) {
+	supplier *pageQueueType,
+	changes *batchChangesType) (result int) {
 	var reclaimLowList, reclaimHighList []pageListType
+	updateRetentionClasses(newOnes, newTs, tsFetched)
 	addedCount := len(newOnes)
+	if changes != nil {
+		changes.Added = make(map[string]bool, len(newOnes))
+		for i := range newOnes {
+			changes.Added[newOnes[i].id.Path()] = true
+		}
+	}
 	timestamps := make(
 		map[int]float64,
 		len(newTs)+len(tsFetched)+len(tsNotFetched))
@@ -507,6 +576,9 @@ func (c *timeSeriesCollectionType) updateTimeStampSeriesAndTimeSeries(
 
 			if needToAdd {
 				addedCount++
+				if changes != nil {
+					changes.Added[timeSeries.id.Path()] = true
+				}
 			}
 			// If status went from inactive to active.
 			if justActivated {
@@ -528,6 +600,10 @@ func (c *timeSeriesCollectionType) updateTimeStampSeriesAndTimeSeries(
 				reclaimHighList,
 				notFetched[i].PageList())
 			inactiveCount++
+			if changes != nil {
+				changes.Removed = append(
+					changes.Removed, notFetched[i].id.Path())
+			}
 		}
 	}
 
@@ -580,29 +656,47 @@ func (c *timeSeriesCollectionType) MarkInactive(
 		c.updateTimeStampSeriesAndTimeSeries(
 			nil, nil, timeSeriesList,
 			nil, nil, timestampSeriesList,
-			supplier)
+			supplier, nil)
 	}
 }
 
 // Add batch of values.
 // timestamp is the timestamp of scotty.
+// If changes is non-nil, AddBatch records in it what the batch changed.
 func (c *timeSeriesCollectionType) AddBatch(
 	timestamp float64,
 	mlist metrics.List,
-	supplier *pageQueueType) (result uint, err error) {
+	supplier *pageQueueType,
+	changes *batchChangesType) (result uint, err error) {
 	c.statusChangeLock.Lock()
 	defer c.statusChangeLock.Unlock()
-	fetched, newOnes, notFetched, tsFetched, newTs, tsNotFetched, err := c.LookupBatch(timestamp, mlist)
+	fetched, newOnes, notFetched, tsFetched, newTs, tsNotFetched, err := c.LookupBatch(
+		timestamp, mlist, supplier)
 	if err != nil {
 		return
 	}
 	result = uint(c.updateTimeStampSeriesAndTimeSeries(
 		newOnes, fetched, notFetched,
 		newTs, tsFetched, tsNotFetched,
-		supplier))
+		supplier, changes))
+	if timestamp > c.lastBatchTimeStamp {
+		c.lastBatchTimeStamp = timestamp
+	}
+	if changes != nil {
+		changes.Full = c.walEpoch != changes.Epoch
+		c.walEpoch = changes.Epoch
+	}
 	return
 }
 
+// LastBatchTimeStamp returns the scotty timestamp of the latest batch
+// added to this instance.
+func (c *timeSeriesCollectionType) LastBatchTimeStamp() float64 {
+	c.statusChangeLock.Lock()
+	defer c.statusChangeLock.Unlock()
+	return c.lastBatchTimeStamp
+}
+
 func (c *timeSeriesCollectionType) byWhateverGroupBy(
 	timeSeries []*timeSeriesType,
 	partition partitionType,
@@ -714,7 +808,7 @@ func (c *timeSeriesCollectionType) TsdbTimeSeries(
 	result tsdb.TimeSeries, early float64, ok bool) {
 	timeSeries, timestampSeries := c.TsAndTimeStampsByName(name)
 	if len(timeSeries) == 0 {
-		return nil, 0.0, false
+		return c.tsdbDistDerivedTimeSeries(name, start, end)
 	}
 	partition := GroupMetricByPathAndNumeric.orderedPartition(timeSeries)
 	tslen := len(timeSeries)
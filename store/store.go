@@ -6,6 +6,7 @@ import (
 	"github.com/Symantec/tricorder/go/tricorder"
 	"github.com/Symantec/tricorder/go/tricorder/types"
 	"github.com/Symantec/tricorder/go/tricorder/units"
+	"sort"
 	"time"
 )
 
@@ -40,7 +41,18 @@ func (s *Store) registerEndpoint(endpointId interface{}) {
 		return
 	}
 	s.byApplication[endpointId] = newTimeSeriesCollectionType(
-		endpointId, s.metrics)
+		endpointId, s.metrics, s.rollUpTiers)
+}
+
+func (s *Store) unregisterEndpoint(endpointId interface{}) {
+	if s.byApplication[endpointId] == nil {
+		return
+	}
+	// The pages of the endpoint stay in the page queue until they are
+	// reused, but its roll ups go away with it.
+	s.supplier.AddRollUpBytes(-s.byApplication[endpointId].DropRollUps())
+	delete(s.byApplication, endpointId)
+	s.metrics.Cardinality.Forget(endpointId)
 }
 
 func (s *Store) isRegistered(endpointId interface{}) bool {
@@ -59,15 +71,29 @@ func (s *Store) shallowCopy() *Store {
 		byApplication: byApplicationCopy,
 		supplier:      s.supplier,
 		metrics:       s.metrics,
+		rollUpTiers:   s.rollUpTiers,
+		wal:           s.wal,
+		walKeyFunc:    s.walKeyFunc,
 	}
 }
 
 func (s *Store) addBatch(
 	endpointId interface{},
 	timestamp float64,
-	mlist metrics.List) (uint, error) {
-	return s.byApplication[endpointId].AddBatch(
-		timestamp, mlist, s.supplier)
+	mlist metrics.List) (result uint, err error) {
+	key, changes := s.walBatchChanges(endpointId)
+	if result, err = s.byApplication[endpointId].AddBatch(
+		timestamp,
+		mlist,
+		s.supplier,
+		changes); err != nil {
+		return
+	}
+	if changes != nil {
+		// log counts errors in the write-ahead log metrics.
+		s.wal.log(key, timestamp, mlist, changes)
+	}
+	return
 }
 
 func (s *Store) byNameAndEndpoint(
@@ -87,6 +113,46 @@ func (s *Store) tsdbTimeSeries(
 	return s.byApplication[endpointId].TsdbTimeSeries(name, start, end)
 }
 
+func (s *Store) tsdbDistTimeSeries(
+	name string,
+	endpointId interface{},
+	start, end float64) (tsdb.DistTimeSeries, float64, bool) {
+	return s.byApplication[endpointId].TsdbDistTimeSeries(name, start, end)
+}
+
+func (s *Store) setRollUpTiers(tiers []RollUpTier) {
+	if len(s.byApplication) > 0 {
+		panic("SetRollUpTiers called after registering endpoints")
+	}
+	s.rollUpTiers = make([]RollUpTier, len(tiers))
+	copy(s.rollUpTiers, tiers)
+	sort.Sort(rollUpTiersByDurationType(s.rollUpTiers))
+}
+
+func (s *Store) rollUpTiersCopy() []RollUpTier {
+	result := make([]RollUpTier, len(s.rollUpTiers))
+	copy(result, s.rollUpTiers)
+	return result
+}
+
+func (s *Store) tsdbTimeSeriesRollUp(
+	name string,
+	endpointId interface{},
+	tier int,
+	field RollUpField,
+	start, end float64) (tsdb.TimeSeries, float64, bool) {
+	return s.byApplication[endpointId].TsdbTimeSeriesRollUp(
+		name, tier, field, start, end)
+}
+
+func (s *Store) rollUpsByNameAndEndpoint(
+	name string,
+	endpointId interface{},
+	tier int,
+	start, end float64) ([]RollUpValue, bool) {
+	return s.byApplication[endpointId].RollUps(name, tier, start, end)
+}
+
 func (s *Store) byPrefixAndEndpoint(
 	prefix string,
 	endpointId interface{},
@@ -243,6 +309,14 @@ func (s *Store) registerMetrics(d *tricorder.DirectorySpec) (err error) {
 		"Number of pages used for timestamps."); err != nil {
 		return
 	}
+	if err = d.RegisterMetricInGroup(
+		"/compressionRatio",
+		primitiveMetrics.CompressionRatio,
+		storeGroup,
+		units.None,
+		"Uncompressed size over compressed size of full compressed pages"); err != nil {
+		return
+	}
 	if err = d.RegisterMetricInGroup(
 		"/totalPagesInUseCount",
 		func() int64 {
@@ -253,5 +327,67 @@ func (s *Store) registerMetrics(d *tricorder.DirectorySpec) (err error) {
 		"Total number of pages used."); err != nil {
 		return
 	}
+	var cardinalityStats cardinalityStatsType
+	cardinalityGroup := tricorder.NewGroup()
+	cardinalityGroup.RegisterUpdateFunc(func() time.Time {
+		metrics.Cardinality.Stats(&cardinalityStats)
+		return time.Now()
+	})
+	if err = d.RegisterMetricInGroup(
+		"/cardinality/seriesCount",
+		&cardinalityStats.SeriesCount,
+		cardinalityGroup,
+		units.None,
+		"Number of time series across all endpoints"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/cardinality/maxSeriesPerEndpoint",
+		&cardinalityStats.MaxSeriesCount,
+		cardinalityGroup,
+		units.None,
+		"Most time series any one endpoint has"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/cardinality/largestEndpoint",
+		&cardinalityStats.LargestEndpoint,
+		cardinalityGroup,
+		units.None,
+		"Endpoint with the most time series"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/cardinality/maxSeriesPerApp",
+		&cardinalityStats.MaxAppSeriesCount,
+		cardinalityGroup,
+		units.None,
+		"Most time series any one application has"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/cardinality/largestApp",
+		&cardinalityStats.LargestApp,
+		cardinalityGroup,
+		units.None,
+		"Application with the most time series"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/cardinality/newSeriesPerSweep",
+		&cardinalityStats.NewSeriesCount,
+		cardinalityGroup,
+		units.None,
+		"New time series in the latest batch of each endpoint"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/cardinality/newSeriesCount",
+		&cardinalityStats.TotalNewSeriesCount,
+		cardinalityGroup,
+		units.None,
+		"Number of new time series since startup"); err != nil {
+		return
+	}
 	return
 }
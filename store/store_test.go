@@ -1,7 +1,9 @@
 package store_test
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"github.com/Symantec/scotty"
 	"github.com/Symantec/scotty/hostid"
 	"github.com/Symantec/scotty/metrics"
@@ -3500,6 +3502,97 @@ func TestHighPriorityEviction(t *testing.T) {
 	}
 }
 
+func TestCompressedStore(t *testing.T) {
+	uncompressedStore := newStore(
+		t, "TestCompressedStoreUncompressed", 4, 1000, 1.0, 10)
+	compressedStore := newStore(
+		t, "TestCompressedStoreCompressed", 4, 1000, 1.0, 10)
+	compressedStore.SetCompressed(true)
+	if !compressedStore.IsCompressed() {
+		t.Fatal("Expected store to be compressed")
+	}
+	aMetric := metrics.SimpleList{
+		{
+			Path:        "/foo/float",
+			Description: "A description",
+		},
+		{
+			Path:        "/foo/int",
+			Description: "A description",
+		},
+		{
+			Path:        "/foo/string",
+			Description: "A description",
+		},
+	}
+	names := []string{"/foo/float", "/foo/int", "/foo/string"}
+	for _, aStore := range []*store.Store{uncompressedStore, compressedStore} {
+		aStore.RegisterEndpoint(kEndpoint0)
+		for i := 0; i < 200; i++ {
+			ts := 1500000000.0 + 60.0*float64(i) + 0.001*float64(i%7)
+			aMetric[0].Value = float64(i%13) * 1.5
+			aMetric[1].Value = int64(i / 3)
+			aMetric[2].Value = fmt.Sprintf("value %d", i/10)
+			list := aMetric[:]
+			// Every so often, metrics go missing
+			if i%50 == 49 {
+				list = aMetric[:1]
+			}
+			if _, err := aStore.AddBatch(
+				kEndpoint0, ts, list.Sorted()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, name := range names {
+		var expected, actual []store.Record
+		uncompressedStore.ByNameAndEndpoint(
+			name, kEndpoint0, 0.0, math.Inf(1), store.AppendTo(&expected))
+		compressedStore.ByNameAndEndpoint(
+			name, kEndpoint0, 0.0, math.Inf(1), store.AppendTo(&actual))
+		if len(expected) < 20 {
+			t.Errorf("%s: Expected many values, got %d", name, len(expected))
+		}
+		assertValueDeepEquals(t, expected, actual)
+
+		// Try a range in the middle
+		expected, actual = nil, nil
+		uncompressedStore.ByNameAndEndpoint(
+			name, kEndpoint0, 1500003000.0, 1500006000.0,
+			store.AppendTo(&expected))
+		compressedStore.ByNameAndEndpoint(
+			name, kEndpoint0, 1500003000.0, 1500006000.0,
+			store.AppendTo(&actual))
+		assertValueDeepEquals(t, expected, actual)
+	}
+	for _, name := range names[:2] {
+		expected, _, _ := uncompressedStore.TsdbTimeSeries(
+			name, kEndpoint0, 1500000000.0, 1500020000.0)
+		actual, _, _ := compressedStore.TsdbTimeSeries(
+			name, kEndpoint0, 1500000000.0, 1500020000.0)
+		assertValueDeepEquals(t, expected, actual)
+	}
+	assertValueDeepEquals(
+		t,
+		iterateByPath(uncompressedStore, kEndpoint0),
+		iterateByPath(compressedStore, kEndpoint0))
+}
+
+// iterateByPath returns what a fresh named iterator yields for endpointId
+// keyed by path.
+func iterateByPath(
+	aStore *store.Store, endpointId interface{}) map[string][]store.Record {
+	result := make(map[string][]store.Record)
+	iterator, _ := aStore.NamedIteratorForEndpoint("anIterator", endpointId, 0)
+	var r store.Record
+	for iterator.Next(&r) {
+		record := r
+		record.Info = nil
+		result[r.Info.Path()] = append(result[r.Info.Path()], record)
+	}
+	return result
+}
+
 func addBatch(
 	t *testing.T,
 	astore *store.Store,
@@ -3565,3 +3658,468 @@ func addDataForHighPriorityEvictionTest(s *store.Store) {
 	aMetric[1].Value = int64(34)
 	s.AddBatch(kEndpoint0, 130, aMetric[:2].Sorted())
 }
+
+func TestRollUpTiers(t *testing.T) {
+	aStore := newStore(t, "TestRollUpTiers", 2, 8, 1.0, 10)
+	aStore.SetRollUpTiers([]store.RollUpTier{
+		{Duration: 10 * time.Minute, Count: 100},
+		{Duration: time.Minute, Count: 5},
+	})
+	assertValueDeepEquals(
+		t,
+		[]store.RollUpTier{
+			{Duration: time.Minute, Count: 5},
+			{Duration: 10 * time.Minute, Count: 100},
+		},
+		aStore.RollUpTiers())
+	aStore.RegisterEndpoint(kEndpoint0)
+	aMetric := metrics.SimpleList{
+		{
+			Path:        "/foo/bar",
+			Description: "A description",
+		},
+	}
+	for i := 0; i < 60; i++ {
+		aMetric[0].Value = int64(i)
+		addBatch(
+			t, aStore, kEndpoint0, 30.0*float64(i), aMetric[:].Sorted(), 1)
+	}
+	_, earliest, ok := aStore.TsdbTimeSeries(
+		"/foo/bar", kEndpoint0, 0.0, 1800.0)
+	if !ok || earliest <= 0.0 {
+		t.Fatalf("Expected raw values to be evicted, got %v", earliest)
+	}
+
+	// Finest tier has dropped old values, coarsest tier has not.
+	_, fineEarliest, ok := aStore.TsdbTimeSeriesRollUp(
+		"/foo/bar", kEndpoint0, 0, store.RollUpAvg, 0.0, 1800.0)
+	if !ok || fineEarliest <= 0.0 {
+		t.Errorf("Expected finest tier to drop values, got %v", fineEarliest)
+	}
+	timeSeries, coarseEarliest, ok := aStore.TsdbTimeSeriesRollUp(
+		"/foo/bar", kEndpoint0, 1, store.RollUpAvg, 0.0, 1800.0)
+	if !ok {
+		t.Fatal("Expected coarsest tier")
+	}
+	assertValueEquals(t, 0.0, coarseEarliest)
+	assertValueEquals(t, 0.0, timeSeries[0].Ts)
+	// Average of 0..19
+	assertValueEquals(t, 9.5, timeSeries[0].Value)
+	for i := 1; i < len(timeSeries); i++ {
+		if timeSeries[i].Ts <= timeSeries[i-1].Ts {
+			t.Error("Expected time series in ascending order")
+		}
+	}
+	assertValueEquals(t, 1770.0, timeSeries[len(timeSeries)-1].Ts)
+
+	rollUps, ok := aStore.RollUpsByNameAndEndpoint(
+		"/foo/bar", kEndpoint0, 1, 0.0, 1800.0)
+	if !ok || len(rollUps) == 0 {
+		t.Fatal("Expected rolled up values")
+	}
+	assertValueDeepEquals(
+		t,
+		store.RollUpValue{
+			Start: 0.0, Min: 0.0, Max: 19.0, Avg: 9.5, Count: 20},
+		rollUps[0])
+
+	// No tier
+	_, ok = aStore.RollUpsByNameAndEndpoint(
+		"/foo/bar", kEndpoint0, 2, 0.0, 1800.0)
+	assertValueEquals(t, false, ok)
+
+	// Rolled up values survive a checkpoint
+	var buffer bytes.Buffer
+	if err := aStore.WriteCheckpoint(&buffer, checkpointKey); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := store.ReadCheckpoint(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredStore := newStore(t, "TestRollUpTiersRestored", 2, 8, 1.0, 10)
+	restoredStore.SetRollUpTiers(aStore.RollUpTiers())
+	restoredStore.RegisterEndpoint(kEndpoint0)
+	assertValueEquals(
+		t,
+		true,
+		restoredStore.RestoreEndpoint(kEndpoint0, "endpoint0", checkpoint))
+	restoredRollUps, _ := restoredStore.RollUpsByNameAndEndpoint(
+		"/foo/bar", kEndpoint0, 1, 0.0, 1800.0)
+	assertValueDeepEquals(t, rollUps, restoredRollUps)
+}
+
+func TestPageQuota(t *testing.T) {
+	quotaStore := newStore(t, "TestPageQuota", 2, 20, 1.0, 10)
+	quotaStore.SetPageQuota(0.25)
+	// Endpoints of app "1001" get a bigger quota
+	quotaStore.SetAppPageQuota("1001", 0.5)
+	assertValueEquals(t, 0.25, quotaStore.PageQuota())
+	noQuotaStore := newStore(t, "TestPageQuotaNoQuota", 2, 20, 1.0, 10)
+	aMetric := metrics.SimpleList{
+		{
+			Path:        "/foo/bar",
+			Description: "A description",
+		},
+	}
+	noisyMetrics := make(metrics.SimpleList, 5)
+	for i := range noisyMetrics {
+		noisyMetrics[i].Path = fmt.Sprintf("/noisy/%d", i)
+		noisyMetrics[i].Description = "A description"
+	}
+	for _, aStore := range []*store.Store{quotaStore, noQuotaStore} {
+		aStore.RegisterEndpoint(kEndpoint0)
+		aStore.RegisterEndpoint(kEndpoint1)
+		for i := 0; i < 4; i++ {
+			aMetric[0].Value = int64(i)
+			addBatch(
+				t,
+				aStore,
+				kEndpoint0,
+				100.0+10.0*float64(i),
+				aMetric[:].Sorted(),
+				1)
+		}
+		// kEndpoint1 suddenly publishes lots of changing metrics
+		for i := 0; i < 50; i++ {
+			for j := range noisyMetrics {
+				noisyMetrics[j].Value = int64(i*10 + j)
+			}
+			addBatch(
+				t,
+				aStore,
+				kEndpoint1,
+				200.0+10.0*float64(i),
+				noisyMetrics[:].Sorted(),
+				5)
+		}
+	}
+
+	// With a quota, kEndpoint0 keeps its history.
+	var result []store.Record
+	quotaStore.ByNameAndEndpoint(
+		"/foo/bar", kEndpoint0, 0.0, 1000.0, store.AppendTo(&result))
+	assertValueEquals(t, 4, len(result))
+	result = nil
+	noQuotaStore.ByNameAndEndpoint(
+		"/foo/bar", kEndpoint0, 0.0, 1000.0, store.AppendTo(&result))
+	if len(result) >= 4 {
+		t.Errorf("Expected kEndpoint0 to lose history, got %d", len(result))
+	}
+
+	usage := quotaStore.PageUsageByEndpoint()
+	if assertValueEquals(t, 2, len(usage)) {
+		assertValueEquals(t, kEndpoint1, usage[0].EndpointId)
+		assertValueEquals(t, uint(5), usage[0].Quota)
+		assertValueEquals(t, uint(5), usage[0].PageCount)
+		assertValueEquals(t, kEndpoint0, usage[1].EndpointId)
+		assertValueEquals(t, uint(10), usage[1].Quota)
+		assertValueEquals(t, uint(4), usage[1].PageCount)
+	}
+}
+
+func TestRetentionClasses(t *testing.T) {
+	policy, err := store.NewRetentionClasses([]store.RetentionRule{
+		{PathRegex: "^/sys/memory/", Class: store.HighRetention},
+		{PathRegex: "^/noisy/", Class: store.LowRetention},
+		{PathRegex: "^/sys/", Class: store.NormalRetention},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(
+		t, store.HighRetention, policy.RetentionClass("/sys/memory/free"))
+	assertValueEquals(
+		t, store.NormalRetention, policy.RetentionClass("/sys/cpu"))
+	assertValueEquals(t, store.LowRetention, policy.RetentionClass("/noisy/a"))
+	assertValueEquals(t, store.NormalRetention, policy.RetentionClass("/foo"))
+	if _, err := store.NewRetentionClasses(
+		[]store.RetentionRule{{PathRegex: "("}}); err == nil {
+		t.Error("Expected bad regex to fail")
+	}
+
+	aStore := newStore(t, "TestRetentionClasses", 2, 1, 1.0, 10)
+	aStore.SetExpanding(true)
+	aStore.SetRetentionPolicy(policy)
+	aStore.RegisterEndpoint(kEndpoint0)
+	aMetric := metrics.SimpleList{
+		{
+			Path:        "/foo",
+			Description: "A description",
+		},
+		{
+			Path:        "/noisy/a",
+			Description: "A description",
+		},
+		{
+			Path:        "/sys/memory/free",
+			Description: "A description",
+		},
+	}
+	for i := 0; i < 20; i++ {
+		for j := range aMetric {
+			aMetric[j].Value = int64(i)
+		}
+		addBatch(
+			t, aStore, kEndpoint0, 100.0*float64(i+1), aMetric.Sorted(), 3)
+	}
+	valueCount := func(path string) int {
+		var result []store.Record
+		aStore.ByNameAndEndpoint(
+			path, kEndpoint0, 0.0, 10000.0, store.AppendTo(&result))
+		return len(result)
+	}
+	for _, path := range []string{"/foo", "/noisy/a", "/sys/memory/free"} {
+		assertValueEquals(t, 20, valueCount(path))
+	}
+
+	// 41 pages in all: the unused page the store started with, 10 pages
+	// of timestamps, and 10 pages for each metric. The latest page of
+	// each series isn't prioritised yet. Removing 10 pages removes the
+	// unused page and the 9 prioritised pages of /noisy/a.
+	aStore.LessenPageCount(10.5 / 41.0)
+	assertValueEquals(t, 20, valueCount("/foo"))
+	assertValueEquals(t, 20, valueCount("/sys/memory/free"))
+	assertValueEquals(t, 2, valueCount("/noisy/a"))
+}
+
+type changingPolicyType struct {
+	current store.RetentionPolicy
+}
+
+func (c *changingPolicyType) RetentionClass(
+	path string) store.RetentionClass {
+	return c.current.RetentionClass(path)
+}
+
+func (c *changingPolicyType) Current() store.RetentionPolicy {
+	return c.current
+}
+
+func TestRetentionPolicyChanges(t *testing.T) {
+	normal, err := store.NewRetentionClasses(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lowBar, err := store.NewRetentionClasses([]store.RetentionRule{
+		{PathRegex: "^/bar$", Class: store.LowRetention},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &changingPolicyType{current: normal}
+	aStore := newStore(t, "TestRetentionPolicyChanges", 2, 1, 1.0, 10)
+	aStore.SetExpanding(true)
+	aStore.SetRetentionPolicy(policy)
+	aStore.RegisterEndpoint(kEndpoint0)
+	aMetric := metrics.SimpleList{
+		{
+			Path:        "/bar",
+			Description: "A description",
+		},
+		{
+			Path:        "/foo",
+			Description: "A description",
+		},
+	}
+	for i := 0; i < 20; i++ {
+		// The policy changes after /bar already exists.
+		if i == 10 {
+			policy.current = lowBar
+		}
+		for j := range aMetric {
+			aMetric[j].Value = int64(i)
+		}
+		addBatch(
+			t, aStore, kEndpoint0, 100.0*float64(i+1), aMetric.Sorted(), 2)
+	}
+	valueCount := func(path string) int {
+		var result []store.Record
+		aStore.ByNameAndEndpoint(
+			path, kEndpoint0, 0.0, 10000.0, store.AppendTo(&result))
+		return len(result)
+	}
+
+	// 31 pages in all: the unused page the store started with, 10 pages
+	// of timestamps, and 10 pages for each metric. All 9 full pages of
+	// /bar, including the ones that filled up before the policy changed,
+	// are now low retention. Removing 8 pages removes the unused page and
+	// the 7 oldest pages of /bar.
+	aStore.LessenPageCount(8.5 / 31.0)
+	assertValueEquals(t, 20, valueCount("/foo"))
+	assertValueEquals(t, 6, valueCount("/bar"))
+}
+
+func TestCardinality(t *testing.T) {
+	aStore := newStore(t, "TestCardinality", 2, 100, 1.0, 10)
+	aStore.RegisterEndpoint(kEndpoint0)
+	aStore.RegisterEndpoint(kEndpoint1)
+	aStore.RegisterEndpoint(kEndpoint2)
+	aMetric := metrics.SimpleList{
+		{Path: "/foo/a", Description: "A description", Value: int64(1)},
+		{Path: "/foo/b", Description: "A description", Value: int64(2)},
+		{Path: "/foo/c", Description: "A description", Value: int64(3)},
+	}
+	addBatch(t, aStore, kEndpoint0, 100.0, aMetric[:1].Sorted(), 1)
+	addBatch(t, aStore, kEndpoint1, 100.0, aMetric[:2].Sorted(), 2)
+	addBatch(t, aStore, kEndpoint2, 100.0, aMetric[:2].Sorted(), 2)
+	// kEndpoint0 gains two series
+	addBatch(t, aStore, kEndpoint0, 110.0, aMetric[:].Sorted(), 2)
+	// kEndpoint1 loses a series but still has it in the store
+	addBatch(t, aStore, kEndpoint1, 110.0, aMetric[:1].Sorted(), 1)
+
+	byEndpoint := aStore.CardinalityByEndpoint()
+	if assertValueEquals(t, 3, len(byEndpoint)) {
+		assertValueEquals(
+			t,
+			store.Cardinality{
+				EndpointId:          kEndpoint0,
+				SeriesCount:         3,
+				ActiveSeriesCount:   3,
+				NewSeriesCount:      2,
+				TotalNewSeriesCount: 3,
+			},
+			byEndpoint[0])
+		assertValueEquals(
+			t,
+			store.Cardinality{
+				EndpointId:          kEndpoint1,
+				SeriesCount:         2,
+				ActiveSeriesCount:   1,
+				TotalNewSeriesCount: 2,
+			},
+			byEndpoint[1])
+		assertValueEquals(t, kEndpoint2, byEndpoint[2].EndpointId)
+	}
+
+	byApp := aStore.CardinalityByApp()
+	if assertValueEquals(t, 2, len(byApp)) {
+		assertValueEquals(
+			t,
+			store.AppCardinality{
+				AppName:             "1001",
+				EndpointCount:       2,
+				SeriesCount:         5,
+				ActiveSeriesCount:   5,
+				NewSeriesCount:      4,
+				TotalNewSeriesCount: 5,
+			},
+			byApp[0])
+		assertValueEquals(t, "1002", byApp[1].AppName)
+	}
+
+	// Copies of the store share the same counts
+	aStore.MarkEndpointInactive(120.0, kEndpoint0)
+	byEndpoint = aStore.ShallowCopy().CardinalityByEndpoint()
+	if assertValueEquals(t, 3, len(byEndpoint)) {
+		assertValueEquals(t, uint(3), byEndpoint[0].SeriesCount)
+		assertValueEquals(t, uint(0), byEndpoint[0].ActiveSeriesCount)
+	}
+}
+
+func TestUnregisterEndpoint(t *testing.T) {
+	aStore := newStore(t, "TestUnregisterEndpoint", 2, 100, 1.0, 10)
+	aStore.RegisterEndpoint(kEndpoint0)
+	aStore.RegisterEndpoint(kEndpoint1)
+	aMetric := metrics.SimpleList{
+		{Path: "/foo/a", Description: "A description", Value: int64(1)},
+	}
+	addBatch(t, aStore, kEndpoint0, 100.0, aMetric, 1)
+	addBatch(t, aStore, kEndpoint1, 100.0, aMetric, 1)
+	aStore.MarkEndpointInactive(110.0, kEndpoint0)
+	storeCopy := aStore.ShallowCopy()
+	storeCopy.UnregisterEndpoint(kEndpoint0)
+	// Unregistering an unknown endpoint is a no-op
+	storeCopy.UnregisterEndpoint(kEndpoint2)
+	assertValueEquals(t, false, storeCopy.IsRegistered(kEndpoint0))
+	assertValueEquals(t, true, storeCopy.IsRegistered(kEndpoint1))
+	// The original store is unaffected
+	assertValueEquals(t, true, aStore.IsRegistered(kEndpoint0))
+	byEndpoint := storeCopy.CardinalityByEndpoint()
+	if assertValueEquals(t, 1, len(byEndpoint)) {
+		assertValueEquals(t, kEndpoint1, byEndpoint[0].EndpointId)
+	}
+}
+
+func newDistributionForTesting(
+	generation uint64, sum float64, counts ...uint64) *messages.Distribution {
+	upperLimits := []float64{10.0, 20.0, 30.0}
+	result := &messages.Distribution{Generation: generation, Sum: sum}
+	for i, count := range counts {
+		var bucket messages.RangeWithCount
+		if i > 0 {
+			bucket.Lower = upperLimits[i-1]
+		}
+		if i < len(upperLimits) {
+			bucket.Upper = upperLimits[i]
+		}
+		bucket.Count = count
+		result.Ranges = append(result.Ranges, &bucket)
+	}
+	return result
+}
+
+func TestTsdbTimeSeriesFromDistribution(t *testing.T) {
+	aStore := newStore(
+		t, "TestTsdbTimeSeriesFromDistribution", 2, 100, 1.0, 10)
+	aStore.RegisterEndpoint(kEndpoint0)
+	aMetric := metrics.SimpleList{
+		{
+			Path:        "/foo/latency",
+			Description: "A description",
+		},
+	}
+	aMetric[0].Value = newDistributionForTesting(1, 120.0, 0, 4, 4, 0)
+	addBatch(t, aStore, kEndpoint0, 100.0, aMetric[:].Sorted(), 1)
+	aMetric[0].Value = newDistributionForTesting(2, 300.0, 0, 4, 8, 2)
+	addBatch(t, aStore, kEndpoint0, 110.0, aMetric[:].Sorted(), 1)
+	// The distribution starts over
+	aMetric[0].Value = newDistributionForTesting(1, 10.0, 2, 0, 0, 0)
+	addBatch(t, aStore, kEndpoint0, 120.0, aMetric[:].Sorted(), 1)
+
+	assertDerived := func(name string, expected tsdb.TimeSeries) {
+		actual, _, ok := aStore.TsdbTimeSeries(
+			name, kEndpoint0, 0.0, 1000.0)
+		if !ok {
+			t.Errorf("Expected to find %s", name)
+			return
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("%s: Expected %v, got %v", name, expected, actual)
+		}
+	}
+	assertDerived(
+		"/foo/latency.count",
+		tsdb.TimeSeries{{100.0, 8.0}, {110.0, 14.0}, {120.0, 2.0}})
+	assertDerived(
+		"/foo/latency.sum",
+		tsdb.TimeSeries{{100.0, 120.0}, {110.0, 300.0}, {120.0, 10.0}})
+	// Averages and percentiles cover only the new values at each time.
+	assertDerived(
+		"/foo/latency.avg",
+		tsdb.TimeSeries{{110.0, 30.0}, {120.0, 5.0}})
+	assertDerived(
+		"/foo/latency.p50",
+		tsdb.TimeSeries{{110.0, 27.5}, {120.0, 10.0}})
+	assertDerived(
+		"/foo/latency.p99",
+		tsdb.TimeSeries{{110.0, 30.0}, {120.0, 10.0}})
+
+	for _, name := range []string{
+		"/foo/latency.p100", "/foo/latency.max", "/foo/nosuch.p50"} {
+		if _, _, ok := aStore.TsdbTimeSeries(
+			name, kEndpoint0, 0.0, 1000.0); ok {
+			t.Errorf("Expected not to find %s", name)
+		}
+	}
+	assertValueDeepEquals(
+		t,
+		[]string{
+			"/foo/latency.p50",
+			"/foo/latency.p99",
+			"/foo/latency.count",
+			"/foo/latency.sum",
+			"/foo/latency.avg",
+		},
+		store.DistributionDerivedNames("/foo/latency"))
+}
@@ -18,6 +18,20 @@ type storePrimitiveMetricsType struct {
 	UniqueMetricValueCount int64
 	TimeStampPageCount     int64
 	LatestEvictedTimeStamp float64
+	// Bytes used by compressed pages that have filled up
+	CompressedBytes uint64
+	// Bytes the data in CompressedBytes would use if uncompressed
+	UncompressedBytes uint64
+}
+
+// CompressionRatio returns the ratio of uncompressed size to compressed
+// size of full compressed pages. Returns 0 if there are no full
+// compressed pages.
+func (s *storePrimitiveMetricsType) CompressionRatio() float64 {
+	if s.CompressedBytes == 0 {
+		return 0.0
+	}
+	return float64(s.UncompressedBytes) / float64(s.CompressedBytes)
 }
 
 func (s *storePrimitiveMetricsType) TimeSpan() time.Duration {
@@ -26,6 +40,7 @@ func (s *storePrimitiveMetricsType) TimeSpan() time.Duration {
 
 type storeMetricsType struct {
 	PagesPerMetricDist *tricorder.NonCumulativeDistribution
+	Cardinality        cardinalityTrackerType
 	lock               sync.Mutex
 	values             storePrimitiveMetricsType
 }
@@ -87,6 +102,17 @@ func (s *storeMetricsType) AddEmptyTimeStampPage() {
 	s.values.TimeStampPageCount += 1
 }
 
+// Call when a compressed page fills up.
+// compressedBytes is the size of the data in the page;
+// uncompressedBytes is what that size would be uncompressed.
+func (s *storeMetricsType) AddFullCompressedPage(
+	compressedBytes, uncompressedBytes uint) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.values.CompressedBytes += uint64(compressedBytes)
+	s.values.UncompressedBytes += uint64(uncompressedBytes)
+}
+
 // Call when adding values to any value series.
 // count is the number of values added.
 func (s *storeMetricsType) AddUniqueValues(count int) {
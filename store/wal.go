@@ -0,0 +1,653 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/tricorder/go/tricorder"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
+	"github.com/Symantec/tricorder/go/tricorder/units"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// This file contains all the code for the write-ahead log which records
+// each batch added to the store so that batches added since the last
+// checkpoint survive a restart.
+//
+// The log is a directory of numbered segment files. Each segment is a
+// series of records. Each record is a 4 byte length and a 4 byte CRC32
+// of the payload, both little endian, followed by the payload which is
+// an independently gob encoded walRecordType. Because each record stands
+// alone, a reader can skip a torn record at the end of a segment and still
+// read the next segment.
+//
+// A record holds only the values that the store added for a batch along
+// with the paths of the time series that the batch made inactive. Since
+// the store skips values that didn't change, so does the log. Each call
+// to Rotate and each failed write starts a new epoch. The first record of
+// each endpoint in an epoch holds all of that endpoint's values so that
+// the segments from the start of an epoch on can be replayed without the
+// ones before it.
+//
+// Callers encode their own records. A single goroutine owns the segment
+// file and writes the encoded records that callers send it over a buffered
+// channel.
+
+const (
+	kWALSegmentSuffix = ".wal"
+	kWALHeaderSize    = 8
+	// Longer records are assumed to be corrupt.
+	kWALMaxRecordSize = 1 << 30
+	// Number of records waiting to be written before callers block
+	kWALQueueSize = 1024
+)
+
+var (
+	errBadWALRecord = errors.New("store: corrupt write-ahead log record")
+	errWALClosed    = errors.New("store: write-ahead log closed")
+)
+
+func init() {
+	gob.Register(&messages.Distribution{})
+}
+
+// walRecordType is a single batch in the write-ahead log.
+type walRecordType struct {
+	Key       string
+	TimeStamp float64
+	// If true, Values holds every value in the batch, not just the changed
+	// ones.
+	Full   bool
+	Values []metrics.Value
+	// Paths in previous batch but not this one
+	Removed []string
+}
+
+// walMetricsType contains metrics for the write-ahead log.
+type walMetricsType struct {
+	RecordCount  uint64
+	ByteCount    uint64
+	ErrorCount   uint64
+	SegmentCount uint64
+}
+
+// walRequestType is a single request to the goroutine writing the log.
+type walRequestType struct {
+	// The encoded record to write
+	Encoded []byte
+	// The epoch of the record
+	Epoch uint64
+	// True if the record holds all the values of its endpoint
+	Full bool
+	// If non-nil, this is a request to rotate or close rather than to
+	// write a record. The writer sends its response on Done.
+	Done chan walResponseType
+	// True if this is a request to close
+	Close bool
+}
+
+type walResponseType struct {
+	Seq uint64
+	Err error
+}
+
+// WAL is an append-only write-ahead log of the batches added to a Store.
+// Records are written without syncing to disk so they survive a crash of
+// the process but not necessarily a crash of the machine.
+// WAL instances may be safely used with multiple goroutines.
+type WAL struct {
+	dir             string
+	maxSegmentBytes int64
+	// Current epoch. Accessed atomically.
+	epoch    uint64
+	requests chan walRequestType
+	// Closed when the writer goroutine exits
+	done chan struct{}
+	// Owned by the writer goroutine
+	file *os.File
+	seq  uint64
+	size int64
+	// Protects metrics
+	lock    sync.Mutex
+	metrics walMetricsType
+}
+
+// Rotate starts a new segment and returns its sequence number. Call
+// Rotate just before writing a checkpoint. Once the checkpoint is safely
+// written, pass the returned sequence number to RemoveSegmentsBefore.
+func (w *WAL) Rotate() (uint64, error) {
+	return w.rotate()
+}
+
+// RemoveSegmentsBefore removes the segments that come before the segment
+// with given sequence number.
+func (w *WAL) RemoveSegmentsBefore(seq uint64) error {
+	return w.removeSegmentsBefore(seq)
+}
+
+// Close closes this instance. Batches added to a Store after its WAL is
+// closed are not logged.
+func (w *WAL) Close() error {
+	return w.close()
+}
+
+// RegisterMetrics registers metrics for this instance with the tricorder
+// directory d.
+func (w *WAL) RegisterMetrics(d *tricorder.DirectorySpec) error {
+	return w.registerMetrics(d)
+}
+
+// WALReplay holds the records read from a write-ahead log for replaying
+// onto endpoints. WALReplay instances may be safely used with multiple
+// goroutines.
+type WALReplay struct {
+	lock         sync.Mutex
+	byKey        map[string][]*walRecordType
+	skippedCount int
+}
+
+// Len returns the number of endpoints in this instance not yet replayed.
+func (r *WALReplay) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.byKey)
+}
+
+// SkippedCount returns the number of segments with a torn or corrupt
+// record. Replay of such a segment stops at that record.
+func (r *WALReplay) SkippedCount() int {
+	return r.skippedCount
+}
+
+func walSegmentName(seq uint64) string {
+	return fmt.Sprintf("%016d%s", seq, kWALSegmentSuffix)
+}
+
+// walSegments returns the sequence numbers of the segments in dir in
+// ascending order.
+func walSegments(dir string) ([]uint64, error) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var result []uint64
+	for _, fileInfo := range fileInfos {
+		name := fileInfo.Name()
+		if !strings.HasSuffix(name, kWALSegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(
+			strings.TrimSuffix(name, kWALSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		result = append(result, seq)
+	}
+	sort.Sort(uint64SliceType(result))
+	return result, nil
+}
+
+type uint64SliceType []uint64
+
+func (u uint64SliceType) Len() int { return len(u) }
+
+func (u uint64SliceType) Less(i, j int) bool { return u[i] < u[j] }
+
+func (u uint64SliceType) Swap(i, j int) { u[i], u[j] = u[j], u[i] }
+
+func openWAL(dir string, maxSegmentBytes int64) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	segments, err := walSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := &WAL{
+		dir:             dir,
+		maxSegmentBytes: maxSegmentBytes,
+		// Collections start at epoch 0 so that their first record is full.
+		epoch:    1,
+		requests: make(chan walRequestType, kWALQueueSize),
+		done:     make(chan struct{}),
+		metrics:  walMetricsType{SegmentCount: uint64(len(segments))},
+	}
+	if len(segments) > 0 {
+		result.seq = segments[len(segments)-1]
+	}
+	if err := result.startSegment(); err != nil {
+		return nil, err
+	}
+	go result.loop()
+	return result, nil
+}
+
+// startSegment closes the current segment and starts the next one.
+// Only the writer goroutine may call startSegment once it has started.
+func (w *WAL) startSegment() error {
+	if w.file != nil {
+		w.file.Sync()
+		w.file.Close()
+		w.file = nil
+	}
+	file, err := os.OpenFile(
+		path.Join(w.dir, walSegmentName(w.seq+1)),
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		0644)
+	if err != nil {
+		return err
+	}
+	w.seq++
+	w.file = file
+	w.size = 0
+	w.lock.Lock()
+	w.metrics.SegmentCount++
+	w.lock.Unlock()
+	return nil
+}
+
+// newEpoch starts a new epoch and returns it.
+func (w *WAL) newEpoch() uint64 {
+	return atomic.AddUint64(&w.epoch, 1)
+}
+
+func (w *WAL) currentEpoch() uint64 {
+	return atomic.LoadUint64(&w.epoch)
+}
+
+func (w *WAL) logError() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.metrics.ErrorCount++
+}
+
+// walValues returns the values in mlist that changes says must be logged.
+func walValues(
+	mlist metrics.List, changes *batchChangesType) []metrics.Value {
+	length := mlist.Len()
+	var result []metrics.Value
+	if changes.Full {
+		result = make([]metrics.Value, 0, length)
+	}
+	for i := 0; i < length; i++ {
+		var value metrics.Value
+		mlist.Index(i, &value)
+		if changes.Full || changes.Added[value.Path] {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func encodeWALRecord(record *walRecordType) ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.Write(make([]byte, kWALHeaderSize))
+	if err := gob.NewEncoder(&buffer).Encode(record); err != nil {
+		return nil, err
+	}
+	result := buffer.Bytes()
+	payload := result[kWALHeaderSize:]
+	binary.LittleEndian.PutUint32(result[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(result[4:8], crc32.ChecksumIEEE(payload))
+	return result, nil
+}
+
+// log queues the batch that the store just added under key for writing.
+// changes is what the batch changed in the store.
+func (w *WAL) log(
+	key string,
+	timestamp float64,
+	mlist metrics.List,
+	changes *batchChangesType) error {
+	record := &walRecordType{
+		Key:       key,
+		TimeStamp: timestamp,
+		Full:      changes.Full,
+		Values:    walValues(mlist, changes),
+		Removed:   changes.Removed,
+	}
+	encoded, err := encodeWALRecord(record)
+	if err != nil {
+		w.logError()
+		return err
+	}
+	return w.send(walRequestType{
+		Encoded: encoded, Epoch: changes.Epoch, Full: changes.Full})
+}
+
+func (w *WAL) send(request walRequestType) error {
+	select {
+	case w.requests <- request:
+		return nil
+	case <-w.done:
+		return errWALClosed
+	}
+}
+
+// call sends a rotate or close request and waits for the response.
+func (w *WAL) call(closeLog bool) (uint64, error) {
+	request := walRequestType{
+		Done: make(chan walResponseType, 1), Close: closeLog}
+	if err := w.send(request); err != nil {
+		return 0, err
+	}
+	select {
+	case response := <-request.Done:
+		return response.Seq, response.Err
+	case <-w.done:
+		// The writer responds before it exits.
+		select {
+		case response := <-request.Done:
+			return response.Seq, response.Err
+		default:
+			return 0, errWALClosed
+		}
+	}
+}
+
+// loop writes the records that callers send until this log is closed.
+func (w *WAL) loop() {
+	defer close(w.done)
+	for request := range w.requests {
+		if request.Done == nil {
+			w.write(&request)
+			continue
+		}
+		if request.Close {
+			request.Done <- walResponseType{Err: w.closeFile()}
+			return
+		}
+		var response walResponseType
+		if w.file == nil {
+			response.Err = errWALClosed
+		} else if response.Err = w.startSegment(); response.Err != nil {
+			w.logError()
+		} else {
+			w.newEpoch()
+			response.Seq = w.seq
+		}
+		request.Done <- response
+	}
+}
+
+// write writes a single record. Only the writer goroutine calls write.
+func (w *WAL) write(request *walRequestType) {
+	if w.file == nil {
+		return
+	}
+	// The epoch started after the caller encoded this record so the
+	// segments that the record depends on may be removed. The next record
+	// of the endpoint will be full. Since a new epoch starts only just
+	// before a checkpoint or after a failed write, the checkpoint has
+	// this batch or the log already lost it.
+	if !request.Full && request.Epoch != w.currentEpoch() {
+		return
+	}
+	if _, err := w.file.Write(request.Encoded); err != nil {
+		w.logError()
+		// Part of the record may have made it to disk. Readers stop at
+		// such a record, so start over in a new segment with each
+		// endpoint logging all its values. If that fails too, this log
+		// stays closed.
+		w.newEpoch()
+		w.startSegment()
+		return
+	}
+	w.size += int64(len(request.Encoded))
+	w.lock.Lock()
+	w.metrics.RecordCount++
+	w.metrics.ByteCount += uint64(len(request.Encoded))
+	w.lock.Unlock()
+	if w.maxSegmentBytes > 0 && w.size >= w.maxSegmentBytes {
+		if err := w.startSegment(); err != nil {
+			w.logError()
+		}
+	}
+}
+
+func (w *WAL) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+	w.file.Sync()
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *WAL) rotate() (uint64, error) {
+	return w.call(false)
+}
+
+func (w *WAL) removeSegmentsBefore(seq uint64) error {
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment >= seq {
+			break
+		}
+		if err := os.Remove(
+			path.Join(w.dir, walSegmentName(segment))); err != nil {
+			return err
+		}
+		w.lock.Lock()
+		w.metrics.SegmentCount--
+		w.lock.Unlock()
+	}
+	return nil
+}
+
+func (w *WAL) close() error {
+	_, err := w.call(true)
+	if err == errWALClosed {
+		return nil
+	}
+	return err
+}
+
+func (w *WAL) getMetrics(metrics *walMetricsType) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	*metrics = w.metrics
+}
+
+func (w *WAL) registerMetrics(d *tricorder.DirectorySpec) (err error) {
+	var metrics walMetricsType
+	group := tricorder.NewGroup()
+	group.RegisterUpdateFunc(func() time.Time {
+		w.getMetrics(&metrics)
+		return time.Now()
+	})
+	if err = d.RegisterMetricInGroup(
+		"/recordCount",
+		&metrics.RecordCount,
+		group,
+		units.None,
+		"Number of records written to the write-ahead log"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/byteCount",
+		&metrics.ByteCount,
+		group,
+		units.Byte,
+		"Number of bytes written to the write-ahead log"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/errorCount",
+		&metrics.ErrorCount,
+		group,
+		units.None,
+		"Number of failed writes to the write-ahead log"); err != nil {
+		return
+	}
+	if err = d.RegisterMetricInGroup(
+		"/segmentCount",
+		&metrics.SegmentCount,
+		group,
+		units.None,
+		"Number of write-ahead log segments on disk"); err != nil {
+		return
+	}
+	return
+}
+
+// readWALRecord reads the next record from r. readWALRecord returns io.EOF
+// if there are no more records and errBadWALRecord if the next record is
+// torn or corrupt.
+func readWALRecord(r io.Reader) (*walRecordType, error) {
+	var header [kWALHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errBadWALRecord
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > kWALMaxRecordSize {
+		return nil, errBadWALRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errBadWALRecord
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, errBadWALRecord
+	}
+	var record walRecordType
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(
+		&record); err != nil {
+		return nil, errBadWALRecord
+	}
+	return &record, nil
+}
+
+// readWALSegment appends the records in the segment file to result
+// stopping at the first bad record. readWALSegment returns true if it
+// stopped at a bad record.
+func readWALSegment(
+	fileName string, result map[string][]*walRecordType) (
+	skipped bool, err error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	reader := bytes.NewReader(contents)
+	for {
+		record, err := readWALRecord(reader)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return true, nil
+		}
+		result[record.Key] = append(result[record.Key], record)
+	}
+}
+
+func readWAL(dir string) (*WALReplay, error) {
+	segments, err := walSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := &WALReplay{byKey: make(map[string][]*walRecordType)}
+	for _, segment := range segments {
+		skipped, err := readWALSegment(
+			path.Join(dir, walSegmentName(segment)), result.byKey)
+		if err != nil {
+			return nil, err
+		}
+		if skipped {
+			result.skippedCount++
+		}
+	}
+	return result, nil
+}
+
+// take removes and returns the records for key.
+func (r *WALReplay) take(key string) []*walRecordType {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := r.byKey[key]
+	delete(r.byKey, key)
+	return result
+}
+
+// walListType rebuilds the complete batches of an endpoint from its
+// write-ahead log records.
+type walListType struct {
+	byPath map[string]metrics.Value
+}
+
+// Apply applies record and returns the complete batch.
+func (w *walListType) Apply(record *walRecordType) metrics.SimpleList {
+	if record.Full || w.byPath == nil {
+		w.byPath = make(map[string]metrics.Value, len(record.Values))
+	}
+	for _, path := range record.Removed {
+		delete(w.byPath, path)
+	}
+	for _, value := range record.Values {
+		w.byPath[value.Path] = value
+	}
+	result := make(metrics.SimpleList, 0, len(w.byPath))
+	for _, value := range w.byPath {
+		result = append(result, value)
+	}
+	sort.Sort(result)
+	return result
+}
+
+// walBatchChanges returns the key under which to log a batch for
+// endpointId along with where to record what the batch changes.
+// walBatchChanges returns nil changes if the batch is not to be logged.
+func (s *Store) walBatchChanges(endpointId interface{}) (
+	key string, changes *batchChangesType) {
+	if s.wal == nil {
+		return
+	}
+	if key = s.walKeyFunc(endpointId); key == "" {
+		return
+	}
+	return key, &batchChangesType{Epoch: s.wal.currentEpoch()}
+}
+
+func (s *Store) replayEndpoint(
+	endpointId interface{}, key string, replay *WALReplay) (
+	count int, err error) {
+	records := replay.take(key)
+	if len(records) == 0 {
+		return
+	}
+	collection := s.byApplication[endpointId]
+	var list walListType
+	for _, record := range records {
+		batch := list.Apply(record)
+		// Skip batches that the store already has either from a
+		// checkpoint or from being logged twice.
+		if record.TimeStamp <= collection.LastBatchTimeStamp() {
+			continue
+		}
+		if _, err = s.addBatch(endpointId, record.TimeStamp, batch); err != nil {
+			return
+		}
+		count++
+	}
+	return
+}
@@ -0,0 +1,185 @@
+package store_test
+
+import (
+	"bytes"
+	"github.com/Symantec/scotty/metrics"
+	"github.com/Symantec/scotty/store"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func newWALDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func walSegmentFiles(t *testing.T, dir string) (result []string) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fileInfo := range fileInfos {
+		result = append(result, path.Join(dir, fileInfo.Name()))
+	}
+	return
+}
+
+func assertSameRecords(
+	t *testing.T, expectedStore, actualStore *store.Store, name string) {
+	var expected, actual []store.Record
+	expectedStore.ByNameAndEndpoint(
+		name, kEndpoint0, 0.0, 1000.0, store.AppendTo(&expected))
+	actualStore.ByNameAndEndpoint(
+		name, kEndpoint0, 0.0, 1000.0, store.AppendTo(&actual))
+	if assertValueEquals(t, len(expected), len(actual)) {
+		for i := range expected {
+			assertValueEquals(t, expected[i].TimeStamp, actual[i].TimeStamp)
+			assertValueEquals(t, expected[i].Value, actual[i].Value)
+			assertValueEquals(t, expected[i].Active, actual[i].Active)
+		}
+	}
+}
+
+func TestWALReplayAfterCheckpoint(t *testing.T) {
+	dir := newWALDir(t)
+	defer os.RemoveAll(dir)
+	wal, err := store.OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aStore := newStore(t, "TestWALReplayAfterCheckpoint", 2, 100, 1.0, 10)
+	aStore.SetWAL(wal, checkpointKey)
+	aStore.RegisterEndpoint(kEndpoint0)
+	aStore.RegisterEndpoint(kEndpoint1)
+	aMetric := metrics.SimpleList{
+		{Path: "/foo/bar", Description: "A description"},
+		{Path: "/foo/baz", Description: "A description"},
+	}
+	aMetric[0].Value = int64(6)
+	aMetric[1].Value = "hello"
+	addBatch(t, aStore, kEndpoint0, 900.0, aMetric[:].Sorted(), 2)
+	aMetric[0].Value = int64(16)
+	addBatch(t, aStore, kEndpoint0, 910.0, aMetric[:].Sorted(), 1)
+	// kEndpoint1 has no key so it is not logged
+	addBatch(t, aStore, kEndpoint1, 910.0, aMetric[:].Sorted(), 2)
+
+	seq, err := wal.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	if err := aStore.WriteCheckpoint(&buffer, checkpointKey); err != nil {
+		t.Fatal(err)
+	}
+
+	aMetric[0].Value = int64(26)
+	addBatch(t, aStore, kEndpoint0, 920.0, aMetric[:].Sorted(), 1)
+	// /foo/baz goes inactive
+	aMetric[0].Value = int64(36)
+	addBatch(t, aStore, kEndpoint0, 930.0, aMetric[:1].Sorted(), 2)
+	// /foo/baz comes back
+	aMetric[1].Value = "goodbye"
+	addBatch(t, aStore, kEndpoint0, 940.0, aMetric[:].Sorted(), 1)
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err := store.ReadCheckpoint(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, err := store.ReadWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, 1, replay.Len())
+	assertValueEquals(t, 0, replay.SkippedCount())
+
+	restoredStore := newStore(
+		t, "TestWALReplayAfterCheckpointRestored", 2, 100, 1.0, 10)
+	restoredStore.RegisterEndpoint(kEndpoint0)
+	assertValueEquals(
+		t,
+		true,
+		restoredStore.RestoreEndpoint(kEndpoint0, "endpoint0", checkpoint))
+	// Batches at 900 and 910 are still in the log, but the checkpoint
+	// already has them.
+	count, err := restoredStore.ReplayEndpoint(kEndpoint0, "endpoint0", replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, 3, count)
+	assertValueEquals(t, 0, replay.Len())
+	for _, name := range []string{"/foo/bar", "/foo/baz"} {
+		assertSameRecords(t, aStore, restoredStore, name)
+	}
+
+	// Segments before the checkpoint can go.
+	if err := wal.RemoveSegmentsBefore(seq); err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, 1, len(walSegmentFiles(t, dir)))
+}
+
+func TestWALSegmentsAndTornTail(t *testing.T) {
+	dir := newWALDir(t)
+	defer os.RemoveAll(dir)
+	// Every record gets its own segment
+	wal, err := store.OpenWAL(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aStore := newStore(t, "TestWALSegmentsAndTornTail", 2, 100, 1.0, 10)
+	aStore.SetWAL(wal, checkpointKey)
+	aStore.RegisterEndpoint(kEndpoint0)
+	aMetric := metrics.SimpleList{
+		{Path: "/foo/bar", Description: "A description"},
+	}
+	for i := 0; i < 4; i++ {
+		aMetric[0].Value = int64(i)
+		addBatch(
+			t, aStore, kEndpoint0, 900.0+10.0*float64(i), aMetric, 1)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 4 segments with one record each plus an empty one
+	files := walSegmentFiles(t, dir)
+	if !assertValueEquals(t, 5, len(files)) {
+		return
+	}
+	// Tear the last record
+	info, err := os.Stat(files[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(files[3], info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := store.ReadWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, 1, replay.SkippedCount())
+	restoredStore := newStore(
+		t, "TestWALSegmentsAndTornTailRestored", 2, 100, 1.0, 10)
+	restoredStore.RegisterEndpoint(kEndpoint0)
+	count, err := restoredStore.ReplayEndpoint(kEndpoint0, "endpoint0", replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValueEquals(t, 3, count)
+	var actual []store.Record
+	restoredStore.ByNameAndEndpoint(
+		"/foo/bar", kEndpoint0, 0.0, 1000.0, store.AppendTo(&actual))
+	if assertValueEquals(t, 3, len(actual)) {
+		assertValueEquals(t, 920.0, actual[0].TimeStamp)
+		assertValueEquals(t, int64(2), actual[0].Value)
+	}
+}
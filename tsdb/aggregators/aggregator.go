@@ -94,6 +94,10 @@ func _new(
 		rateSpecCopy := *optionalRateSpec
 		result.optionalRateSpec = &rateSpecCopy
 	}
+	if agg.percentile > 0.0 {
+		return &distDownSampleType{
+			downSampleType: result, percentile: agg.percentile}
+	}
 	return result
 
 }
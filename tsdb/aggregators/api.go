@@ -12,6 +12,18 @@ import (
 type Aggregator struct {
 	aggListCreater func(size int) aggregatorListType
 	updaterCreater updaterCreaterType
+	// 0 - 100 for percentile aggregators; 0 for all others
+	percentile float64
+}
+
+func newPercentileAggregator(p float64) *Aggregator {
+	return &Aggregator{
+		aggListCreater: func(size int) aggregatorListType {
+			return newPercentileListType(size, p)
+		},
+		updaterCreater: kLinearInterpolation,
+		percentile:     p,
+	}
 }
 
 var (
@@ -45,6 +57,15 @@ var (
 		},
 		updaterCreater: kLinearInterpolation,
 	}
+	// Percentile aggregators. When aggregating distributions, these
+	// merge the buckets of all the distributions in each time slice
+	// before taking the percentile.
+	P50  = newPercentileAggregator(50.0)
+	P75  = newPercentileAggregator(75.0)
+	P90  = newPercentileAggregator(90.0)
+	P95  = newPercentileAggregator(95.0)
+	P99  = newPercentileAggregator(99.0)
+	P999 = newPercentileAggregator(99.9)
 )
 
 var (
@@ -54,6 +75,12 @@ var (
 		"max":   Max,
 		"min":   Min,
 		"sum":   Sum,
+		"p50":   P50,
+		"p75":   P75,
+		"p90":   P90,
+		"p95":   P95,
+		"p99":   P99,
+		"p999":  P999,
 	}
 )
 
@@ -134,6 +161,11 @@ type RateSpec struct {
 // optionalRateSpec is the TSDB rate specification. If non-nil,
 // the Aggregate method reports rate of change per second in aggregated
 // values instead of the actual aggregated values.
+//
+// If aggregator is a percentile aggregator such as P99, the returned
+// value is also a tsdb.DistAggregator. Distributions passed to its
+// AddDist method are merged within each time slice regardless of
+// downSampleAggregator, fillPolicy, and optionalRateSpec.
 func New(
 	start, end float64,
 	aggregator *Aggregator,
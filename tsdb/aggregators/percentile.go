@@ -0,0 +1,202 @@
+package aggregators
+
+import (
+	"github.com/Symantec/scotty/tsdb"
+	"math"
+	"sort"
+)
+
+// percentile returns the given percentile (0-100) of sorted values
+// interpolating linearly between the closest ranks.
+func percentile(sortedValues []float64, p float64) float64 {
+	pos := p / 100.0 * float64(len(sortedValues)-1)
+	lower := math.Floor(pos)
+	idx := int(lower)
+	if idx+1 >= len(sortedValues) {
+		return sortedValues[len(sortedValues)-1]
+	}
+	return sortedValues[idx] + (pos-lower)*(sortedValues[idx+1]-sortedValues[idx])
+}
+
+// percentileListType aggregates plain values by taking a percentile of
+// them.
+type percentileListType struct {
+	percentile float64
+	values     [][]float64
+}
+
+func newPercentileListType(size int, p float64) *percentileListType {
+	return &percentileListType{
+		percentile: p, values: make([][]float64, size)}
+}
+
+func (p *percentileListType) Len() int {
+	return len(p.values)
+}
+
+func (p *percentileListType) Add(index int, value float64) {
+	p.values[index] = append(p.values[index], value)
+}
+
+func (p *percentileListType) Get(index int) (float64, bool) {
+	values := p.values[index]
+	if len(values) == 0 {
+		return 0.0, false
+	}
+	sort.Float64s(values)
+	return percentile(values, p.percentile), true
+}
+
+func (p *percentileListType) Clear() {
+	for i := range p.values {
+		p.values[i] = p.values[i][:0]
+	}
+}
+
+// bucketType is a single bucket of a distribution. The first bucket of a
+// distribution has a lower bound of -Inf; the last one has an upper bound
+// of +Inf.
+type bucketType struct {
+	Lower float64
+	Upper float64
+}
+
+// histogramType holds merged distribution buckets. Buckets from different
+// distributions with the same bounds are merged into one. histogramType
+// treats values as evenly spread within each bucket except for values in
+// buckets with no lower or upper bound which it treats as being at the
+// one bound.
+type histogramType struct {
+	counts map[bucketType]uint64
+	total  uint64
+}
+
+func (h *histogramType) Add(upperLimits []float64, counts []uint64) {
+	if h.counts == nil {
+		h.counts = make(map[bucketType]uint64)
+	}
+	lower := math.Inf(-1)
+	for i, count := range counts {
+		upper := math.Inf(1)
+		if i < len(upperLimits) {
+			upper = upperLimits[i]
+		}
+		// A bucket with neither bound says nothing about where its
+		// values are.
+		if count > 0 && (i > 0 || i < len(upperLimits)) {
+			h.counts[bucketType{Lower: lower, Upper: upper}] += count
+			h.total += count
+		}
+		lower = upper
+	}
+}
+
+// cumulative returns how many values are at or below x. If inclusive is
+// false, cumulative does not count values treated as being exactly at x.
+func (h *histogramType) cumulative(x float64, inclusive bool) (result float64) {
+	for bucket, count := range h.counts {
+		switch {
+		case math.IsInf(bucket.Lower, -1):
+			if x > bucket.Upper || inclusive && x == bucket.Upper {
+				result += float64(count)
+			}
+		case math.IsInf(bucket.Upper, 1):
+			if x > bucket.Lower || inclusive && x == bucket.Lower {
+				result += float64(count)
+			}
+		case x >= bucket.Upper:
+			result += float64(count)
+		case x > bucket.Lower:
+			result += float64(count) * (x - bucket.Lower) / (bucket.Upper - bucket.Lower)
+		}
+	}
+	return
+}
+
+// Percentile returns the given percentile (0-100) of the values in this
+// instance or false if this instance has no values.
+func (h *histogramType) Percentile(p float64) (float64, bool) {
+	if h.total == 0 {
+		return 0.0, false
+	}
+	var bounds []float64
+	seen := make(map[float64]bool)
+	for bucket := range h.counts {
+		for _, bound := range []float64{bucket.Lower, bucket.Upper} {
+			if !math.IsInf(bound, 0) && !seen[bound] {
+				seen[bound] = true
+				bounds = append(bounds, bound)
+			}
+		}
+	}
+	sort.Float64s(bounds)
+	rank := p / 100.0 * float64(h.total)
+	lastCumulative := 0.0
+	for i, bound := range bounds {
+		cumulative := h.cumulative(bound, true)
+		if cumulative < rank && i < len(bounds)-1 {
+			lastCumulative = cumulative
+			continue
+		}
+		if i == 0 {
+			return bound, true
+		}
+		// Values are evenly spread between the previous bound and this one.
+		beforeBound := h.cumulative(bound, false)
+		if rank >= beforeBound || beforeBound == lastCumulative {
+			return bound, true
+		}
+		previous := bounds[i-1]
+		return previous + (rank-lastCumulative)/(beforeBound-lastCumulative)*(bound-previous), true
+	}
+	return 0.0, false
+}
+
+func (h *histogramType) Clear() {
+	h.counts = nil
+	h.total = 0
+}
+
+// distDownSampleType is the tsdb.DistAggregator implementation used with
+// percentile aggregators. Distributions added to it are merged bucket by
+// bucket within each time slice before taking the percentile. Plain time
+// series added to it are aggregated like any other aggregator would.
+type distDownSampleType struct {
+	*downSampleType
+	percentile float64
+	histograms []histogramType
+	hasDists   bool
+}
+
+func (d *distDownSampleType) AddDist(values tsdb.DistTimeSeries) {
+	if d.histograms == nil {
+		d.histograms = make([]histogramType, d.size)
+	}
+	d.hasDists = true
+	for i := range values {
+		if values[i].Ts < d.clampStart {
+			continue
+		}
+		index := d.downSamplePolicy.IndexOf(values[i].Ts)
+		if index >= d.size {
+			break
+		}
+		d.histograms[index].Add(values[i].UpperLimits, values[i].Counts)
+	}
+}
+
+func (d *distDownSampleType) Aggregate() (result tsdb.TimeSeries) {
+	if !d.hasDists {
+		return d.downSampleType.Aggregate()
+	}
+	for i := range d.histograms {
+		value, ok := d.histograms[i].Percentile(d.percentile)
+		if ok {
+			result = append(result, tsdb.TsValue{
+				Ts:    d.downSamplePolicy.TSOf(i),
+				Value: value,
+			})
+		}
+	}
+	return
+}
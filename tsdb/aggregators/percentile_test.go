@@ -0,0 +1,90 @@
+package aggregators_test
+
+import (
+	"github.com/Symantec/scotty/tsdb"
+	"github.com/Symantec/scotty/tsdb/aggregators"
+	"reflect"
+	"testing"
+)
+
+func TestP50None(t *testing.T) {
+	tester := newAggregatorTester(aggregators.P50, aggregators.None)
+	tester.ExpectNoneForNoValues()
+	tester.Expect(5.0, 5.0)
+	tester.Expect(3.75)
+	tester.Expect(2.5, 4.0, 1.0, 3.0, 2.0)
+	tester.Expect(1.5, -1.25, 1.5, 3.5)
+	tester.Verify(t)
+}
+
+func TestP90Zero(t *testing.T) {
+	tester := newAggregatorTester(aggregators.P90, aggregators.Zero)
+	tester.Expect(0.0)
+	tester.Expect(9.1, 10.0, 9.0, 8.0, 7.0, 6.0, 5.0, 4.0, 3.0, 2.0, 1.0)
+	tester.Expect(7.0, 7.0)
+	tester.Verify(t)
+}
+
+func TestPercentileMergesDistributions(t *testing.T) {
+	agg := aggregators.New(
+		0.0, 300.0,
+		aggregators.P50,
+		100.0,
+		aggregators.Avg,
+		aggregators.None,
+		nil)
+	distAgg, ok := agg.(tsdb.DistAggregator)
+	if !ok {
+		t.Fatal("Expected a tsdb.DistAggregator")
+	}
+	hostA := tsdb.DistTimeSeries{
+		{
+			Ts:          90.0,
+			UpperLimits: []float64{10.0, 20.0, 30.0},
+			Counts:      []uint64{2, 4, 4, 0},
+		},
+		{
+			Ts:          200.0,
+			UpperLimits: []float64{15.0, 25.0},
+			Counts:      []uint64{0, 2, 0},
+		},
+	}
+	hostB := tsdb.DistTimeSeries{
+		{
+			Ts:          110.0,
+			UpperLimits: []float64{10.0, 20.0, 30.0},
+			Counts:      []uint64{0, 0, 4, 6},
+		},
+		{
+			Ts:          210.0,
+			UpperLimits: []float64{10.0, 20.0, 30.0},
+			Counts:      []uint64{0, 2, 0, 0},
+		},
+	}
+	distAgg.AddDist(hostA)
+	distAgg.AddDist(hostB)
+	// The p50 of hostA alone is 17.5 and of hostB alone is 30.0 at 100.0.
+	// Merging the buckets first gives 25.0, not their average.
+	// At 200.0, the buckets differ and are merged as evenly spread values.
+	expected := tsdb.TimeSeries{{100.0, 25.0}, {200.0, 17.5}}
+	if actual := distAgg.Aggregate(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestPercentileOfPlainTimeSeries(t *testing.T) {
+	agg := aggregators.New(
+		0.0, 200.0,
+		aggregators.P50,
+		100.0,
+		aggregators.Avg,
+		aggregators.None,
+		nil)
+	agg.Add(tsdb.TimeSeries{{100.0, 3.0}, {120.0, 5.0}})
+	agg.Add(tsdb.TimeSeries{{110.0, 10.0}})
+	agg.Add(tsdb.TimeSeries{{130.0, 20.0}})
+	expected := tsdb.TimeSeries{{100.0, 10.0}}
+	if actual := agg.Aggregate(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
@@ -37,12 +37,40 @@ func (t TimeSeries) MarshalJSON() ([]byte, error) {
 	return t.marshalJSON()
 }
 
-// TagSet represents a set of tsdb tags for time series in scotty.
-type TagSet struct {
-	HostName  string
-	AppName   string
-	Region    string
-	IpAddress string
+// DistTsValue represents the values a distribution gained at a timestamp.
+// DistTsValue instances must be treated as immutable.
+type DistTsValue struct {
+	// In seconds since Jan 1, 1970
+	Ts float64
+	// The upper limits of the buckets
+	UpperLimits []float64
+	// The number of values in each bucket. Counts is always one longer
+	// than UpperLimits as the last bucket has no upper limit.
+	Counts []uint64
+}
+
+// DistTimeSeries represents a time series of distribution values sorted
+// by time stamp in ascending order. DistTimeSeries instances must be
+// treated as immutable.
+type DistTimeSeries []DistTsValue
+
+// Names of the built-in tags of every endpoint. Any other tag comes from
+// the host labels of the endpoint's machine.
+const (
+	HostName  = "HostName"
+	AppName   = "appname"
+	Region    = "region"
+	IpAddress = "ipaddress"
+)
+
+// TagSet represents a set of tsdb tags for time series in scotty such as
+// {"HostName": "web01", "appname": "scotty"}.
+type TagSet map[string]string
+
+// Key returns a string uniquely identifying the tags and values in this
+// instance so that tag sets can be used as map keys.
+func (t TagSet) Key() string {
+	return t.key()
 }
 
 // TaggedTimeSeries represents a single tagged timeSeries.
@@ -57,14 +85,18 @@ type TaggedTimeSeriesSet struct {
 	MetricName string
 	// the data
 	Data []TaggedTimeSeries
-	// If true, Data is grouped by the "HostName" tag
-	GroupedByHostName bool
-	// If true, Data is grouped by the "appname" tag
-	GroupedByAppName bool
-	// If true, Data is grouped by the "region" tag
-	GroupedByRegion bool
-	// If true, data is grouped by the "ipaddress" tag
-	GroupedByIpAddress bool
+	// The names of the tags by which data is grouped
+	GroupedBy []string
+}
+
+// IsGroupedBy returns true if data is grouped by the tag called name.
+func (t *TaggedTimeSeriesSet) IsGroupedBy(name string) bool {
+	for _, groupedBy := range t.GroupedBy {
+		if groupedBy == name {
+			return true
+		}
+	}
+	return false
 }
 
 // Aggregator aggregates time series together.
@@ -75,6 +107,14 @@ type Aggregator interface {
 	Aggregate() TimeSeries
 }
 
+// DistAggregator is an Aggregator that can also aggregate distribution
+// time series by merging their buckets.
+type DistAggregator interface {
+	Aggregator
+	// AddDist adds a distribution time series
+	AddDist(values DistTimeSeries)
+}
+
 // AggregatorGenerator produces Aggregator values.
 // start is the start time for the aggregator inclusive;
 // end is the end time for the aggregator exclusive.
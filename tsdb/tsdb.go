@@ -6,6 +6,12 @@ import (
 	"sort"
 )
 
+const (
+	// Separates tag names and values in tag set keys. Tag names
+	// and values may not contain it.
+	kTagSeparator = "\x00"
+)
+
 func (t TimeSeries) marshalJSON() ([]byte, error) {
 	b := &bytes.Buffer{}
 	fmt.Fprintf(b, "{")
@@ -23,3 +29,19 @@ func (t TimeSeries) earlyTruncate(earliest float64) TimeSeries {
 	idx := sort.Search(len(t), func(i int) bool { return t[i].Ts >= earliest })
 	return t[idx:]
 }
+
+func (t TagSet) key() string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buffer bytes.Buffer
+	for _, name := range names {
+		buffer.WriteString(name)
+		buffer.WriteString(kTagSeparator)
+		buffer.WriteString(t[name])
+		buffer.WriteString(kTagSeparator)
+	}
+	return buffer.String()
+}
@@ -34,6 +34,29 @@ func TestEarliest(t *testing.T) {
 	}
 }
 
+func TestTagSetKey(t *testing.T) {
+	tags := tsdb.TagSet{tsdb.HostName: "web01", "owner": "alice", "env": ""}
+	same := tsdb.TagSet{"env": "", "owner": "alice", tsdb.HostName: "web01"}
+	assertValueEquals(t, tags.Key(), same.Key())
+	if tags.Key() == (tsdb.TagSet{tsdb.HostName: "web01", "owner": "alice"}).Key() {
+		t.Error("Expected different keys")
+	}
+	if tags.Key() == (tsdb.TagSet{tsdb.HostName: "web01", "owner": "bob", "env": ""}).Key() {
+		t.Error("Expected different keys")
+	}
+	assertValueEquals(t, "", tsdb.TagSet(nil).Key())
+}
+
+func TestIsGroupedBy(t *testing.T) {
+	set := &tsdb.TaggedTimeSeriesSet{GroupedBy: []string{tsdb.AppName, "owner"}}
+	if !set.IsGroupedBy(tsdb.AppName) || !set.IsGroupedBy("owner") {
+		t.Error("Expected grouped by appname and owner")
+	}
+	if set.IsGroupedBy(tsdb.HostName) {
+		t.Error("Expected not grouped by HostName")
+	}
+}
+
 func assertValueEquals(t *testing.T, expected, actual interface{}) bool {
 	t.Helper()
 	if expected != actual {
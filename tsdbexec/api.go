@@ -36,12 +36,14 @@ func Suggest(
 }
 
 // Query corresponds to the /api/query TSDB API call.
+// labelNames are the names of the host labels that queries may use as tags.
 func Query(
 	request *tsdbjson.QueryRequest,
 	endpoints *machine.EndpointStore,
-	minDownSampleTime time.Duration) (
+	minDownSampleTime time.Duration,
+	labelNames []string) (
 	result []tsdbjson.TimeSeries, err error) {
-	return query(request, endpoints, minDownSampleTime)
+	return query(request, endpoints, minDownSampleTime, labelNames)
 }
 
 // RunParsedQueries works like Query except that it accepts a slice of
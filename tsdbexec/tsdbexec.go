@@ -7,6 +7,7 @@ import (
 	"github.com/Symantec/scotty/machine"
 	"github.com/Symantec/scotty/suggest"
 	"github.com/Symantec/scotty/tsdb"
+	"github.com/Symantec/scotty/tsdb/aggregators"
 	"github.com/Symantec/scotty/tsdbimpl"
 	"github.com/Symantec/scotty/tsdbjson"
 	"github.com/Symantec/tricorder/go/tricorder/duration"
@@ -73,9 +74,10 @@ func ensureDurationAtLeast(
 func query(
 	request *tsdbjson.QueryRequest,
 	endpoints *machine.EndpointStore,
-	minDownSampleTime time.Duration) (
+	minDownSampleTime time.Duration,
+	labelNames []string) (
 	result []tsdbjson.TimeSeries, err error) {
-	parsedQueries, err := tsdbjson.ParseQueryRequest(request)
+	parsedQueries, err := tsdbjson.ParseQueryRequest(request, labelNames)
 	if err != nil {
 		return
 	}
@@ -122,30 +124,17 @@ func runSingleParsedQuery(
 	minDownSampleTime time.Duration) (
 	result *tsdb.TaggedTimeSeriesSet, err error) {
 	var options tsdbimpl.QueryOptions
-	options.HostNameFilter, err = newTagFilter(
-		request.Options.HostNameFilter)
-	if err != nil {
-		return
-	}
-	options.AppNameFilter, err = newTagFilter(
-		request.Options.AppNameFilter)
-	if err != nil {
-		return
-	}
-	options.RegionFilter, err = newTagFilter(
-		request.Options.RegionFilter)
-	if err != nil {
-		return
-	}
-	options.IpAddressFilter, err = newTagFilter(
-		request.Options.IpAddressFilter)
-	if err != nil {
-		return
+	for name, spec := range request.Options.Filters {
+		var filter tsdb.TagFilter
+		if filter, err = newTagFilter(spec); err != nil {
+			return
+		}
+		if options.Filters == nil {
+			options.Filters = make(map[string]tsdb.TagFilter)
+		}
+		options.Filters[name] = filter
 	}
-	options.GroupByAppName = request.Options.GroupByAppName
-	options.GroupByHostName = request.Options.GroupByHostName
-	options.GroupByRegion = request.Options.GroupByRegion
-	options.GroupByIpAddress = request.Options.GroupByIpAddress
+	options.GroupBy = request.Options.GroupBy
 	if request.Aggregator.DownSample == nil {
 		return nil, tsdbjson.ErrUnsupportedAggregator
 	}
@@ -161,6 +150,8 @@ func runSingleParsedQuery(
 	if err != nil {
 		return
 	}
+	options.DownSample, _ = aggregators.ByName(
+		request.Aggregator.DownSample.Type)
 	request.EnsureStartTimeRecentEnough()
 	return tsdbimpl.Query(
 		endpoints,
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"github.com/Symantec/scotty/machine"
 	"github.com/Symantec/scotty/tsdb"
+	"github.com/Symantec/scotty/tsdb/aggregators"
 )
 
 var (
@@ -18,25 +19,24 @@ var (
 
 // QueryOptions contain optional configurations for the query function.
 type QueryOptions struct {
-	// Filter for "HostName" tag values. Optional
-	HostNameFilter tsdb.TagFilter
-	// Filter for "appname" tag values. Optional
-	AppNameFilter tsdb.TagFilter
-	// Filter for "region" tag value. Optional
-	RegionFilter tsdb.TagFilter
-	// Filter for "ipaddress" tag value. Optional
-	IpAddressFilter tsdb.TagFilter
-	// True if results should be grouped by "HostName" tag
-	GroupByHostName bool
-	// True if results should be groupd by "appname" tag.
-	GroupByAppName bool
-	// True if results should be grouped by region
-	GroupByRegion bool
-	// True if results should be grouped by ip address
-	GroupByIpAddress bool
+	// Filters for tag values keyed by tag name such as tsdb.HostName or
+	// the name of a host label. Optional
+	Filters map[string]tsdb.TagFilter
+	// The names of the tags by which results should be grouped. Optional
+	GroupBy []string
+	// The aggregator that down samples each time series such as
+	// aggregators.Max. Query uses it to choose which rolled up values
+	// stand in for values the store has evicted. nil means
+	// aggregators.Avg. Optional
+	DownSample *aggregators.Aggregator
 }
 
 // Query queries scotty for given tsdb query.
+//
+// If metricName names a distribution and the aggregators that aggregator
+// generates are tsdb.DistAggregator instances, Query merges the
+// distribution of each endpoint in the same group with AddDist.
+// Otherwise, Query ignores distributions.
 func Query(
 	endpoints *machine.EndpointStore,
 	metricName string,
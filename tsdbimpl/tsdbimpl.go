@@ -2,26 +2,134 @@ package tsdbimpl
 
 import (
 	"github.com/Symantec/scotty/machine"
+	"github.com/Symantec/scotty/store"
 	"github.com/Symantec/scotty/tsdb"
+	"github.com/Symantec/scotty/tsdb/aggregators"
 )
 
+// tagValue returns the value of the tag called name for e. Tags other than
+// the built-in ones come from the host labels of e's machine.
+func tagValue(e *machine.Endpoint, name string) string {
+	switch name {
+	case tsdb.HostName:
+		return e.App.EP.HostName()
+	case tsdb.AppName:
+		return e.App.EP.AppName()
+	case tsdb.Region:
+		return e.M.Region
+	case tsdb.IpAddress:
+		return e.M.IpAddress
+	}
+	return e.M.Labels[name]
+}
+
 func (o *QueryOptions) isIncluded(e *machine.Endpoint) bool {
-	if o.HostNameFilter != nil && !o.HostNameFilter.Filter(
-		e.App.EP.HostName()) {
-		return false
+	for name, filter := range o.Filters {
+		if !filter.Filter(tagValue(e, name)) {
+			return false
+		}
 	}
-	if o.AppNameFilter != nil && !o.AppNameFilter.Filter(e.App.EP.AppName()) {
-		return false
+	return true
+}
+
+// isGroupedBy returns true if results are grouped by the tag called name.
+func (o *QueryOptions) isGroupedBy(name string) bool {
+	for _, groupBy := range o.GroupBy {
+		if groupBy == name {
+			return true
+		}
 	}
-	if o.RegionFilter != nil && !o.RegionFilter.Filter(e.M.Region) {
-		return false
+	return false
+}
+
+// tags returns the tags of e by which to group.
+func (o *QueryOptions) tags(e *machine.Endpoint) tsdb.TagSet {
+	if len(o.GroupBy) == 0 {
+		return nil
+	}
+	result := make(tsdb.TagSet, len(o.GroupBy))
+	for _, name := range o.GroupBy {
+		result[name] = tagValue(e, name)
+	}
+	return result
+}
+
+// fetchedTimeSeriesType is the time series of one metric for one endpoint.
+type fetchedTimeSeriesType struct {
+	Values tsdb.TimeSeries
+	// Set instead of Values if the metric is a distribution
+	DistValues tsdb.DistTimeSeries
+	IsDist     bool
+}
+
+// AddTo adds this time series to aggregator. AddTo returns false if
+// this time series is a distribution and aggregator cannot aggregate
+// distributions.
+func (f *fetchedTimeSeriesType) AddTo(aggregator tsdb.Aggregator) bool {
+	if !f.IsDist {
+		aggregator.Add(f.Values)
+		return true
 	}
-	if o.IpAddressFilter != nil && !o.IpAddressFilter.Filter(e.M.IpAddress) {
+	distAggregator, ok := aggregator.(tsdb.DistAggregator)
+	if !ok {
 		return false
 	}
+	distAggregator.AddDist(f.DistValues)
 	return true
 }
 
+// rollUpField returns which rolled up values stand in for evicted values
+// when downSample down samples a time series. rollUpField returns false
+// if no rolled up values can stand in as is the case when counting values.
+func rollUpField(downSample *aggregators.Aggregator) (
+	store.RollUpField, bool) {
+	switch downSample {
+	case aggregators.Min:
+		return store.RollUpMin, true
+	case aggregators.Max:
+		return store.RollUpMax, true
+	case aggregators.Sum:
+		return store.RollUpSum, true
+	case aggregators.Count:
+		return store.RollUpAvg, false
+	}
+	return store.RollUpAvg, true
+}
+
+// fetchTimeSeries fetches the time series for given metric and endpoint.
+// If the store has evicted values at start, fetchTimeSeries uses the
+// finest roll up tier that goes back to start or the coarsest tier if none
+// do. tierCount is the number of roll up tiers in astore. field selects
+// the rolled up values to use. If metric is a distribution,
+// fetchTimeSeries fetches the distribution instead.
+func fetchTimeSeries(
+	astore *store.Store,
+	tierCount int,
+	field store.RollUpField,
+	metricName string,
+	endpointId interface{},
+	start, end float64) (
+	result fetchedTimeSeriesType, earliest float64, ok bool) {
+	timeSeries, earliest, ok := astore.TsdbTimeSeries(
+		metricName, endpointId, start, end)
+	if !ok {
+		result.DistValues, earliest, ok = astore.TsdbDistTimeSeries(
+			metricName, endpointId, start, end)
+		result.IsDist = true
+		return
+	}
+	for tier := 0; tier < tierCount && earliest > start; tier++ {
+		tierTimeSeries, tierEarliest, tierOk := astore.TsdbTimeSeriesRollUp(
+			metricName, endpointId, tier, field, start, end)
+		if !tierOk {
+			break
+		}
+		timeSeries, earliest = tierTimeSeries, tierEarliest
+	}
+	result.Values = timeSeries
+	return
+}
+
 func query(
 	endpoints *machine.EndpointStore,
 	metricName string,
@@ -32,36 +140,42 @@ func query(
 		options = &QueryOptions{}
 	}
 	apps, store := endpoints.AllWithStore()
+	tierCount := len(store.RollUpTiers())
+	field, ok := rollUpField(options.DownSample)
+	if !ok {
+		tierCount = 0
+	}
 	var taggedTimeSeriesSlice []tsdb.TaggedTimeSeries
 	var metricNameFound bool
 
-	// We are grouping by everything
-	if options.GroupByHostName && options.GroupByAppName && options.GroupByRegion && options.GroupByIpAddress {
+	// We are grouping by host and application so each endpoint is its
+	// own group.
+	if options.isGroupedBy(tsdb.HostName) && options.isGroupedBy(tsdb.AppName) {
 		for i := range apps {
 			if options.isIncluded(apps[i]) {
-				timeSeries, earliest, ok := store.TsdbTimeSeries(
+				timeSeries, earliest, ok := fetchTimeSeries(
+					store,
+					tierCount,
+					field,
 					metricName,
 					apps[i].App.EP,
 					start,
 					end)
 				if ok {
-					metricNameFound = true
 					var aggregator tsdb.Aggregator
 					aggregator, err = aggregatorGen(start, end)
 					if err != nil {
 						return
 					}
-					aggregator.Add(timeSeries)
+					if !timeSeries.AddTo(aggregator) {
+						continue
+					}
+					metricNameFound = true
 					aggregatedTimeSeries := aggregator.Aggregate().EarlyTruncate(earliest)
 					if len(aggregatedTimeSeries) != 0 {
 						taggedTimeSeriesSlice = append(
 							taggedTimeSeriesSlice, tsdb.TaggedTimeSeries{
-								Tags: tsdb.TagSet{
-									HostName:  apps[i].App.EP.HostName(),
-									AppName:   apps[i].App.EP.AppName(),
-									Region:    apps[i].M.Region,
-									IpAddress: apps[i].M.IpAddress,
-								},
+								Tags:   options.tags(apps[i]),
 								Values: aggregatedTimeSeries,
 							})
 					}
@@ -69,43 +183,44 @@ func query(
 			}
 		}
 	} else {
-		// We aren't grouping by everything so we need multiple aggregators
-		// to merge.
-		aggregatorMap := make(map[tsdb.TagSet]tsdb.Aggregator)
-		earliestMap := make(map[tsdb.TagSet]float64)
+		// Endpoints may share a group so we need multiple aggregators
+		// to merge. All three maps are keyed by tag set key.
+		tagSetMap := make(map[string]tsdb.TagSet)
+		aggregatorMap := make(map[string]tsdb.Aggregator)
+		earliestMap := make(map[string]float64)
 		for i := range apps {
 			if options.isIncluded(apps[i]) {
-				timeSeries, earliest, ok := store.TsdbTimeSeries(
+				timeSeries, earliest, ok := fetchTimeSeries(
+					store,
+					tierCount,
+					field,
 					metricName,
 					apps[i].App.EP,
 					start,
 					end)
 				if ok {
-					metricNameFound = true
-					var tagSet tsdb.TagSet
-					if options.GroupByHostName {
-						tagSet.HostName = apps[i].App.EP.HostName()
-					}
-					if options.GroupByAppName {
-						tagSet.AppName = apps[i].App.EP.AppName()
-					}
-					if options.GroupByRegion {
-						tagSet.Region = apps[i].M.Region
-					}
-					if options.GroupByIpAddress {
-						tagSet.IpAddress = apps[i].M.IpAddress
-					}
-					aggregator := aggregatorMap[tagSet]
-					if aggregator == nil {
+					tagSet := options.tags(apps[i])
+					key := tagSet.Key()
+					aggregator := aggregatorMap[key]
+					isNew := aggregator == nil
+					if isNew {
 						aggregator, err = aggregatorGen(start, end)
 						if err != nil {
 							return
 						}
-						aggregatorMap[tagSet] = aggregator
 					}
-					aggregator.Add(timeSeries)
-					if earliest > earliestMap[tagSet] {
-						earliestMap[tagSet] = earliest
+					if !timeSeries.AddTo(aggregator) {
+						continue
+					}
+					// Add the aggregator only after it has a time series
+					// so that we don't emit empty groups.
+					if isNew {
+						aggregatorMap[key] = aggregator
+						tagSetMap[key] = tagSet
+					}
+					metricNameFound = true
+					if earliest > earliestMap[key] {
+						earliestMap[key] = earliest
 					}
 				}
 			}
@@ -116,7 +231,7 @@ func query(
 				if len(aggregatedTimeSeries) != 0 {
 					taggedTimeSeriesSlice = append(
 						taggedTimeSeriesSlice, tsdb.TaggedTimeSeries{
-							Tags:   k,
+							Tags:   tagSetMap[k],
 							Values: aggregatedTimeSeries,
 						})
 				}
@@ -125,12 +240,9 @@ func query(
 	}
 	if metricNameFound {
 		return &tsdb.TaggedTimeSeriesSet{
-			MetricName:         metricName,
-			Data:               taggedTimeSeriesSlice,
-			GroupedByHostName:  options.GroupByHostName,
-			GroupedByAppName:   options.GroupByAppName,
-			GroupedByRegion:    options.GroupByRegion,
-			GroupedByIpAddress: options.GroupByIpAddress,
+			MetricName: metricName,
+			Data:       taggedTimeSeriesSlice,
+			GroupedBy:  options.GroupBy,
 		}, nil
 	}
 	return nil, ErrNoSuchMetric
@@ -4,6 +4,7 @@ import (
 	"github.com/Symantec/Dominator/lib/mdb"
 	"github.com/Symantec/scotty/application"
 	"github.com/Symantec/scotty/awsinfo"
+	"github.com/Symantec/scotty/hostlabels"
 	"github.com/Symantec/scotty/machine"
 	"github.com/Symantec/scotty/metrics"
 	"github.com/Symantec/scotty/namesandports"
@@ -13,6 +14,7 @@ import (
 	"github.com/Symantec/scotty/tsdbimpl"
 	"github.com/Symantec/tricorder/go/tricorder"
 	"github.com/Symantec/tricorder/go/tricorder/duration"
+	"github.com/Symantec/tricorder/go/tricorder/messages"
 	"reflect"
 	"sort"
 	"testing"
@@ -225,7 +227,7 @@ func TestAPI(t *testing.T) {
 	)
 
 	options := &tsdbimpl.QueryOptions{
-		GroupByHostName: true,
+		GroupBy: []string{tsdb.HostName},
 	}
 	if taggedTimeSeriesSet, err = tsdbimpl.Query(
 		appStatus,
@@ -249,7 +251,7 @@ func TestAPI(t *testing.T) {
 		Data: []tsdb.TaggedTimeSeries{
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host1",
+					tsdb.HostName: "host1",
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 35.5}, {520.0, 37.5}, {540.0, 39.0},
@@ -257,7 +259,7 @@ func TestAPI(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host2",
+					tsdb.HostName: "host2",
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 55.5}, {520.0, 57.5}, {540.0, 59.0},
@@ -265,14 +267,14 @@ func TestAPI(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host3",
+					tsdb.HostName: "host3",
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 75.5}, {520.0, 77.5}, {540.0, 79.0},
 				},
 			},
 		},
-		GroupedByHostName: true,
+		GroupedBy: []string{tsdb.HostName},
 	}
 	assertTaggedTimeSeriesSetEquals(
 		t,
@@ -281,7 +283,7 @@ func TestAPI(t *testing.T) {
 	)
 
 	options = &tsdbimpl.QueryOptions{
-		GroupByHostName: true,
+		GroupBy: []string{tsdb.HostName},
 	}
 	if taggedTimeSeriesSet, err = tsdbimpl.Query(
 		appStatus,
@@ -301,8 +303,8 @@ func TestAPI(t *testing.T) {
 		t.Fatal(err)
 	}
 	expected = &tsdb.TaggedTimeSeriesSet{
-		MetricName:        "/foo",
-		GroupedByHostName: true,
+		MetricName: "/foo",
+		GroupedBy:  []string{tsdb.HostName},
 	}
 	assertTaggedTimeSeriesSetEquals(
 		t,
@@ -311,7 +313,7 @@ func TestAPI(t *testing.T) {
 	)
 
 	options = &tsdbimpl.QueryOptions{
-		GroupByAppName: true,
+		GroupBy: []string{tsdb.AppName},
 	}
 	if taggedTimeSeriesSet, err = tsdbimpl.Query(
 		appStatus,
@@ -335,7 +337,7 @@ func TestAPI(t *testing.T) {
 		Data: []tsdb.TaggedTimeSeries{
 			{
 				Tags: tsdb.TagSet{
-					AppName: "AnotherApp",
+					tsdb.AppName: "AnotherApp",
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 60.5}, {520.0, 62.5}, {540.0, 64},
@@ -343,14 +345,14 @@ func TestAPI(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					AppName: application.HealthAgentName,
+					tsdb.AppName: application.HealthAgentName,
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 50.5}, {520.0, 52.5}, {540.0, 54.0},
 				},
 			},
 		},
-		GroupedByAppName: true,
+		GroupedBy: []string{tsdb.AppName},
 	}
 	assertTaggedTimeSeriesSetEquals(
 		t,
@@ -359,8 +361,7 @@ func TestAPI(t *testing.T) {
 	)
 
 	options = &tsdbimpl.QueryOptions{
-		GroupByHostName: true,
-		GroupByAppName:  true,
+		GroupBy: []string{tsdb.HostName, tsdb.AppName},
 	}
 	if taggedTimeSeriesSet, err = tsdbimpl.Query(
 		appStatus,
@@ -384,8 +385,8 @@ func TestAPI(t *testing.T) {
 		Data: []tsdb.TaggedTimeSeries{
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host1",
-					AppName:  "AnotherApp",
+					tsdb.HostName: "host1",
+					tsdb.AppName:  "AnotherApp",
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 40.5}, {520.0, 42.5}, {540.0, 44.0},
@@ -393,8 +394,8 @@ func TestAPI(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host1",
-					AppName:  application.HealthAgentName,
+					tsdb.HostName: "host1",
+					tsdb.AppName:  application.HealthAgentName,
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 30.5}, {520.0, 32.5}, {540.0, 34.0},
@@ -402,8 +403,8 @@ func TestAPI(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host2",
-					AppName:  "AnotherApp",
+					tsdb.HostName: "host2",
+					tsdb.AppName:  "AnotherApp",
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 60.5}, {520.0, 62.5}, {540.0, 64.0},
@@ -411,8 +412,8 @@ func TestAPI(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host2",
-					AppName:  application.HealthAgentName,
+					tsdb.HostName: "host2",
+					tsdb.AppName:  application.HealthAgentName,
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 50.5}, {520.0, 52.5}, {540.0, 54.0},
@@ -420,8 +421,8 @@ func TestAPI(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host3",
-					AppName:  "AnotherApp",
+					tsdb.HostName: "host3",
+					tsdb.AppName:  "AnotherApp",
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 80.5}, {520.0, 82.5}, {540.0, 84.0},
@@ -429,16 +430,15 @@ func TestAPI(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host3",
-					AppName:  application.HealthAgentName,
+					tsdb.HostName: "host3",
+					tsdb.AppName:  application.HealthAgentName,
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 70.5}, {520.0, 72.5}, {540.0, 74.0},
 				},
 			},
 		},
-		GroupedByHostName: true,
-		GroupedByAppName:  true,
+		GroupedBy: []string{tsdb.HostName, tsdb.AppName},
 	}
 	assertTaggedTimeSeriesSetEquals(
 		t,
@@ -447,8 +447,7 @@ func TestAPI(t *testing.T) {
 	)
 
 	options = &tsdbimpl.QueryOptions{
-		GroupByHostName: true,
-		GroupByAppName:  true,
+		GroupBy: []string{tsdb.HostName, tsdb.AppName},
 	}
 	if taggedTimeSeriesSet, err = tsdbimpl.Query(
 		appStatus,
@@ -468,9 +467,8 @@ func TestAPI(t *testing.T) {
 		t.Fatal(err)
 	}
 	expected = &tsdb.TaggedTimeSeriesSet{
-		MetricName:        "/foo",
-		GroupedByHostName: true,
-		GroupedByAppName:  true,
+		MetricName: "/foo",
+		GroupedBy:  []string{tsdb.HostName, tsdb.AppName},
 	}
 	assertTaggedTimeSeriesSetEquals(
 		t,
@@ -479,14 +477,15 @@ func TestAPI(t *testing.T) {
 	)
 
 	options = &tsdbimpl.QueryOptions{
-		HostNameFilter: tagFilter(func(s string) bool {
-			return s == "host2" || s == "host3"
-		}),
-		AppNameFilter: tagFilter(func(s string) bool {
-			return s == application.HealthAgentName
-		}),
-		GroupByHostName: true,
-		GroupByAppName:  true,
+		Filters: map[string]tsdb.TagFilter{
+			tsdb.HostName: tagFilter(func(s string) bool {
+				return s == "host2" || s == "host3"
+			}),
+			tsdb.AppName: tagFilter(func(s string) bool {
+				return s == application.HealthAgentName
+			}),
+		},
+		GroupBy: []string{tsdb.HostName, tsdb.AppName},
 	}
 	if taggedTimeSeriesSet, err = tsdbimpl.Query(
 		appStatus,
@@ -510,8 +509,8 @@ func TestAPI(t *testing.T) {
 		Data: []tsdb.TaggedTimeSeries{
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host2",
-					AppName:  application.HealthAgentName,
+					tsdb.HostName: "host2",
+					tsdb.AppName:  application.HealthAgentName,
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 50.5}, {520.0, 52.5}, {540.0, 54.0},
@@ -519,16 +518,15 @@ func TestAPI(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host3",
-					AppName:  application.HealthAgentName,
+					tsdb.HostName: "host3",
+					tsdb.AppName:  application.HealthAgentName,
 				},
 				Values: tsdb.TimeSeries{
 					{500.0, 70.5}, {520.0, 72.5}, {540.0, 74.0},
 				},
 			},
 		},
-		GroupedByHostName: true,
-		GroupedByAppName:  true,
+		GroupedBy: []string{tsdb.HostName, tsdb.AppName},
 	}
 	assertTaggedTimeSeriesSetEquals(
 		t,
@@ -537,12 +535,14 @@ func TestAPI(t *testing.T) {
 	)
 
 	options = &tsdbimpl.QueryOptions{
-		HostNameFilter: tagFilter(func(s string) bool {
-			return s == "host2" || s == "host3"
-		}),
-		AppNameFilter: tagFilter(func(s string) bool {
-			return s == application.HealthAgentName
-		}),
+		Filters: map[string]tsdb.TagFilter{
+			tsdb.HostName: tagFilter(func(s string) bool {
+				return s == "host2" || s == "host3"
+			}),
+			tsdb.AppName: tagFilter(func(s string) bool {
+				return s == application.HealthAgentName
+			}),
+		},
 	}
 	if taggedTimeSeriesSet, err = tsdbimpl.Query(
 		appStatus,
@@ -578,12 +578,14 @@ func TestAPI(t *testing.T) {
 	)
 
 	options = &tsdbimpl.QueryOptions{
-		HostNameFilter: tagFilter(func(s string) bool {
-			return s == "host2" || s == "host3"
-		}),
-		AppNameFilter: tagFilter(func(s string) bool {
-			return s == "No app"
-		}),
+		Filters: map[string]tsdb.TagFilter{
+			tsdb.HostName: tagFilter(func(s string) bool {
+				return s == "host2" || s == "host3"
+			}),
+			tsdb.AppName: tagFilter(func(s string) bool {
+				return s == "No app"
+			}),
+		},
 	}
 	if _, err = tsdbimpl.Query(
 		appStatus,
@@ -604,6 +606,405 @@ func TestAPI(t *testing.T) {
 	}
 }
 
+func TestQueryUsesRollUps(t *testing.T) {
+	// Rolled up values take 11 of the 19 pages.
+	aStore := newStore(t, "TestQueryUsesRollUps", 2, 19, 1.0, 10)
+	aStore.SetRollUpTiers([]store.RollUpTier{
+		{Duration: time.Minute, Count: 5},
+		{Duration: 10 * time.Minute, Count: 100},
+	})
+	appStatus := machine.NewEndpointStore(
+		aStore,
+		awsinfo.Config{CloudWatchRefresh: 5 * time.Minute},
+		3)
+	appStatus.UpdateMachines(100.0, toMachines([]string{"host1"}))
+	endpointId, aStore := appStatus.ByHostAndName(
+		"host1", application.HealthAgentName)
+	var data []float64
+	for i := 0; i < 60; i++ {
+		data = append(data, 30.0*float64(i), float64(i))
+	}
+	addValues(t, aStore, endpointId.App.EP, "/foo", data...)
+	taggedTimeSeriesSet, err := tsdbimpl.Query(
+		appStatus,
+		"/foo",
+		func(start, end float64) (tsdb.Aggregator, error) {
+			return aggregators.New(
+				start,
+				end,
+				aggregators.Avg,
+				600.0,
+				aggregators.Avg,
+				aggregators.None,
+				nil), nil
+		},
+		0.0, 1800.0,
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Raw values start at 1560 and the 1 minute tier starts at 1260, so
+	// only the 10 minute tier goes back far enough. Downsampled values
+	// are centered on multiples of 600.
+	expected := &tsdb.TaggedTimeSeriesSet{
+		MetricName: "/foo",
+		Data: []tsdb.TaggedTimeSeries{
+			{
+				Values: tsdb.TimeSeries{
+					{600.0, 29.5}, {1200.0, 45.5},
+				},
+			},
+		},
+	}
+	assertTaggedTimeSeriesSetEquals(t, expected, taggedTimeSeriesSet)
+
+	// Down sampling with max uses the largest rolled up values
+	taggedTimeSeriesSet, err = tsdbimpl.Query(
+		appStatus,
+		"/foo",
+		func(start, end float64) (tsdb.Aggregator, error) {
+			return aggregators.New(
+				start,
+				end,
+				aggregators.Avg,
+				600.0,
+				aggregators.Max,
+				aggregators.None,
+				nil), nil
+		},
+		0.0, 1800.0,
+		&tsdbimpl.QueryOptions{DownSample: aggregators.Max})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected.Data[0].Values = tsdb.TimeSeries{{600.0, 39.0}, {1200.0, 51.0}}
+	assertTaggedTimeSeriesSetEquals(t, expected, taggedTimeSeriesSet)
+}
+
+// addDistValues adds a distribution with buckets bounded by 10, 20, and 30
+// to given endpoint. Each value is the timestamp followed by the 4 bucket
+// counts.
+func addDistValues(
+	t *testing.T,
+	aStore *store.Store,
+	endpointId interface{},
+	path string,
+	data ...float64) {
+	if len(data)%5 != 0 {
+		t.Fatal("Timestamp and 4 counts expected")
+	}
+	aMetric := metrics.SimpleList{
+		{
+			Path:        path,
+			Description: "A description",
+		},
+	}
+	upperLimits := []float64{10.0, 20.0, 30.0}
+	for i := 0; i < len(data); i += 5 {
+		dist := &messages.Distribution{Generation: uint64(i + 1)}
+		for j := 0; j < 4; j++ {
+			var bucket messages.RangeWithCount
+			if j > 0 {
+				bucket.Lower = upperLimits[j-1]
+			}
+			if j < len(upperLimits) {
+				bucket.Upper = upperLimits[j]
+			}
+			bucket.Count = uint64(data[i+j+1])
+			dist.Ranges = append(dist.Ranges, &bucket)
+		}
+		aMetric[0].TimeStamp = duration.FloatToTime(data[i])
+		aMetric[0].Value = dist
+		if _, err := aStore.AddBatch(endpointId, 1000.0, aMetric); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestQueryMergesDistributions(t *testing.T) {
+	appStatus := machine.NewEndpointStore(
+		newStore(t, "TestQueryMergesDistributions", 2, 100, 1.0, 10),
+		awsinfo.Config{CloudWatchRefresh: 5 * time.Minute},
+		3)
+	appStatus.UpdateMachines(
+		100.0, toMachines([]string{"host1", "host2", "host3"}))
+	appStatus.UpdateEndpoints(
+		100.0,
+		map[string]machine.EndpointObservation{
+			"host1": {
+				SeqNo:     1,
+				Endpoints: namesandports.NamesAndPorts{"AnotherApp": {Port: 6997}},
+			},
+			"host2": {
+				SeqNo:     1,
+				Endpoints: namesandports.NamesAndPorts{"AnotherApp": {Port: 6997}},
+			},
+		})
+	endpointId, aStore := appStatus.ByHostAndName("host1", "AnotherApp")
+	addDistValues(t, aStore, endpointId.App.EP, "/latency",
+		100.0, 0, 0, 0, 0,
+		200.0, 2, 4, 4, 0)
+	endpointId, aStore = appStatus.ByHostAndName("host2", "AnotherApp")
+	addDistValues(t, aStore, endpointId.App.EP, "/latency",
+		100.0, 0, 0, 0, 0,
+		200.0, 0, 0, 4, 6)
+	endpointId, aStore = appStatus.ByHostAndName(
+		"host3", application.HealthAgentName)
+	addDistValues(t, aStore, endpointId.App.EP, "/latency",
+		100.0, 0, 0, 0, 0,
+		200.0, 0, 4, 0, 0)
+	aggregatorGen := func(agg *aggregators.Aggregator) tsdb.AggregatorGenerator {
+		return func(start, end float64) (tsdb.Aggregator, error) {
+			return aggregators.New(
+				start,
+				end,
+				agg,
+				100.0,
+				aggregators.Avg,
+				aggregators.None,
+				nil), nil
+		}
+	}
+	taggedTimeSeriesSet, err := tsdbimpl.Query(
+		appStatus,
+		"/latency",
+		aggregatorGen(aggregators.P50),
+		0.0, 300.0,
+		&tsdbimpl.QueryOptions{GroupBy: []string{tsdb.AppName}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The p50 of host1 alone is 17.5 and of host2 alone is 30.0, but
+	// merging their buckets gives 25.0.
+	expected := &tsdb.TaggedTimeSeriesSet{
+		MetricName: "/latency",
+		Data: []tsdb.TaggedTimeSeries{
+			{
+				Tags:   tsdb.TagSet{tsdb.AppName: "AnotherApp"},
+				Values: tsdb.TimeSeries{{200.0, 25.0}},
+			},
+			{
+				Tags:   tsdb.TagSet{tsdb.AppName: application.HealthAgentName},
+				Values: tsdb.TimeSeries{{200.0, 15.0}},
+			},
+		},
+		GroupedBy: []string{tsdb.AppName},
+	}
+	assertTaggedTimeSeriesSetEquals(t, expected, taggedTimeSeriesSet)
+
+	// Aggregators that can't merge distributions don't see them.
+	if _, err := tsdbimpl.Query(
+		appStatus,
+		"/latency",
+		aggregatorGen(aggregators.Avg),
+		0.0, 300.0,
+		nil); err != tsdbimpl.ErrNoSuchMetric {
+		t.Error("Expected ErrNoSuchMetric")
+	}
+
+	// Groups with only distributions don't show up as empty groups.
+	endpointId, aStore = appStatus.ByHostAndName(
+		"host1", application.HealthAgentName)
+	addValues(t, aStore, endpointId.App.EP, "/latency",
+		100.0, 7.0,
+		200.0, 9.0)
+	taggedTimeSeriesSet, err = tsdbimpl.Query(
+		appStatus,
+		"/latency",
+		func(start, end float64) (tsdb.Aggregator, error) {
+			return aggregators.New(
+				start,
+				end,
+				aggregators.Count,
+				100.0,
+				aggregators.Avg,
+				aggregators.None,
+				nil), nil
+		},
+		0.0, 300.0,
+		&tsdbimpl.QueryOptions{GroupBy: []string{tsdb.AppName}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = &tsdb.TaggedTimeSeriesSet{
+		MetricName: "/latency",
+		Data: []tsdb.TaggedTimeSeries{
+			{
+				Tags:   tsdb.TagSet{tsdb.AppName: application.HealthAgentName},
+				Values: tsdb.TimeSeries{{100.0, 1.0}, {200.0, 1.0}},
+			},
+		},
+		GroupedBy: []string{tsdb.AppName},
+	}
+	assertTaggedTimeSeriesSetEquals(t, expected, taggedTimeSeriesSet)
+}
+
+func TestQueryHostLabels(t *testing.T) {
+	appStatus := machine.NewEndpointStore(
+		newStore(t, "TestQueryHostLabels", 2, 100, 1.0, 10),
+		awsinfo.Config{CloudWatchRefresh: 5 * time.Minute},
+		3)
+	appStatus.SetHostLabels(hostlabels.Config{
+		"owner": "Tags.owner",
+		"env":   "Tags.env",
+	})
+	appStatus.UpdateMachines(
+		100.0,
+		[]mdb.Machine{
+			{
+				Hostname: "host1",
+				Tags:     map[string]string{"owner": "alice", "env": "prod"},
+			},
+			{
+				Hostname: "host2",
+				Tags:     map[string]string{"owner": "alice", "env": "dev"},
+			},
+			{
+				Hostname: "host3",
+				Tags:     map[string]string{"owner": "bob", "env": "prod"},
+			},
+			{
+				Hostname: "host4",
+			},
+		})
+	for i, hostName := range []string{"host1", "host2", "host3", "host4"} {
+		endpointId, aStore := appStatus.ByHostAndName(
+			hostName, application.HealthAgentName)
+		addValues(t, aStore, endpointId.App.EP, "/foo",
+			100.0, 10.0*float64(i+1))
+	}
+	aggregatorGen := func(start, end float64) (tsdb.Aggregator, error) {
+		return aggregators.New(
+			start,
+			end,
+			aggregators.Avg,
+			100.0,
+			aggregators.Avg,
+			aggregators.None,
+			nil), nil
+	}
+	taggedTimeSeriesSet, err := tsdbimpl.Query(
+		appStatus,
+		"/foo",
+		aggregatorGen,
+		0.0, 200.0,
+		&tsdbimpl.QueryOptions{GroupBy: []string{"owner"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &tsdb.TaggedTimeSeriesSet{
+		MetricName: "/foo",
+		Data: []tsdb.TaggedTimeSeries{
+			{
+				Tags:   tsdb.TagSet{"owner": ""},
+				Values: tsdb.TimeSeries{{100.0, 40.0}},
+			},
+			{
+				Tags:   tsdb.TagSet{"owner": "alice"},
+				Values: tsdb.TimeSeries{{100.0, 15.0}},
+			},
+			{
+				Tags:   tsdb.TagSet{"owner": "bob"},
+				Values: tsdb.TimeSeries{{100.0, 30.0}},
+			},
+		},
+		GroupedBy: []string{"owner"},
+	}
+	assertTaggedTimeSeriesSetEquals(t, expected, taggedTimeSeriesSet)
+
+	taggedTimeSeriesSet, err = tsdbimpl.Query(
+		appStatus,
+		"/foo",
+		aggregatorGen,
+		0.0, 200.0,
+		&tsdbimpl.QueryOptions{
+			Filters: map[string]tsdb.TagFilter{
+				"env": tagFilter(func(s string) bool {
+					return s == "prod"
+				}),
+			},
+			GroupBy: []string{
+				tsdb.HostName,
+				tsdb.AppName,
+				tsdb.Region,
+				tsdb.IpAddress,
+				"owner",
+				"env",
+			},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = &tsdb.TaggedTimeSeriesSet{
+		MetricName: "/foo",
+		Data: []tsdb.TaggedTimeSeries{
+			{
+				Tags: tsdb.TagSet{
+					tsdb.HostName:  "host1",
+					tsdb.AppName:   application.HealthAgentName,
+					tsdb.Region:    "",
+					tsdb.IpAddress: "",
+					"owner":        "alice",
+					"env":          "prod",
+				},
+				Values: tsdb.TimeSeries{{100.0, 10.0}},
+			},
+			{
+				Tags: tsdb.TagSet{
+					tsdb.HostName:  "host3",
+					tsdb.AppName:   application.HealthAgentName,
+					tsdb.Region:    "",
+					tsdb.IpAddress: "",
+					"owner":        "bob",
+					"env":          "prod",
+				},
+				Values: tsdb.TimeSeries{{100.0, 30.0}},
+			},
+		},
+		GroupedBy: []string{
+			tsdb.HostName,
+			tsdb.AppName,
+			tsdb.Region,
+			tsdb.IpAddress,
+			"owner",
+			"env",
+		},
+	}
+	assertTaggedTimeSeriesSetEquals(t, expected, taggedTimeSeriesSet)
+
+	// Built-in and label filters combine
+	taggedTimeSeriesSet, err = tsdbimpl.Query(
+		appStatus,
+		"/foo",
+		aggregatorGen,
+		0.0, 200.0,
+		&tsdbimpl.QueryOptions{
+			Filters: map[string]tsdb.TagFilter{
+				tsdb.HostName: tagFilter(func(s string) bool {
+					return s != "host1"
+				}),
+				"owner": tagFilter(func(s string) bool {
+					return s == "alice"
+				}),
+			},
+			GroupBy: []string{"env"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = &tsdb.TaggedTimeSeriesSet{
+		MetricName: "/foo",
+		Data: []tsdb.TaggedTimeSeries{
+			{
+				Tags:   tsdb.TagSet{"env": "dev"},
+				Values: tsdb.TimeSeries{{100.0, 20.0}},
+			},
+		},
+		GroupedBy: []string{"env"},
+	}
+	assertTaggedTimeSeriesSetEquals(t, expected, taggedTimeSeriesSet)
+}
+
 func newStore(
 	t *testing.T,
 	testName string,
@@ -660,16 +1061,7 @@ func (b byTagsType) Swap(i, j int) {
 }
 
 func (b byTagsType) Less(i, j int) bool {
-	if b[i].Tags.HostName < b[j].Tags.HostName {
-		return true
-	}
-	if b[i].Tags.HostName > b[j].Tags.HostName {
-		return false
-	}
-	if b[i].Tags.AppName < b[j].Tags.AppName {
-		return true
-	}
-	return false
+	return b[i].Tags.Key() < b[j].Tags.Key()
 }
 
 type tagFilter func(s string) bool
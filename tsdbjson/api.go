@@ -12,13 +12,13 @@ import (
 
 const (
 	// HostName tag name
-	HostName = "HostName"
+	HostName = tsdb.HostName
 	// AppName tag name
-	AppName = "appname"
+	AppName = tsdb.AppName
 	// Region tag name
-	Region = "region"
+	Region = tsdb.Region
 	// IpAddress tag name
-	IpAddress = "ipaddress"
+	IpAddress = tsdb.IpAddress
 )
 
 var (
@@ -74,7 +74,9 @@ type Query struct {
 	DownSample string `json:"downsample"`
 	// The filters
 	Filters []*Filter `json:"filters"`
-	// Tags field for cloud health proxy only. Scotty does not support it.
+	// Tags to group by in TSDB escaped form. A value of "*" groups by the
+	// tag alone; any other value is also a literal_or filter. A tag here
+	// may not also have a filter in Filters.
 	Tags map[string]string `json:"tags"`
 }
 
@@ -136,22 +138,11 @@ type AggregatorSpec struct {
 
 // ParsedQueryOptions represents the optional items in a parsed query
 type ParsedQueryOptions struct {
-	// Optional filter on host name
-	HostNameFilter *FilterSpec
-	// Optional filter on application name
-	AppNameFilter *FilterSpec
-	// Optional filter on region
-	RegionFilter *FilterSpec
-	// Optional filter on ipaddress
-	IpAddressFilter *FilterSpec
-	// True if results should be grouped by host name
-	GroupByHostName bool
-	// True if results should be grouped by application name
-	GroupByAppName bool
-	// True if results should be grouped by region
-	GroupByRegion bool
-	// True if results should be grouped by ip address
-	GroupByIpAddress bool
+	// Optional filters keyed by tag name such as HostName or the name
+	// of a host label
+	Filters map[string]*FilterSpec
+	// The names of the tags by which results should be grouped
+	GroupBy []string
 }
 
 // ParsedQuery represents a single query in a parsed /api/query request
@@ -175,10 +166,19 @@ func (p *ParsedQuery) EnsureStartTimeRecentEnough() {
 }
 
 // ParseQueryRequest takes a JSON /api/query request as input and returns
-// zero or more parsed queries.
+// zero or more parsed queries. labelNames are the names of the host labels
+// that queries may use as tags in addition to HostName, AppName, Region,
+// and IpAddress. ParseQueryRequest returns an error if a query uses any
+// other tag or has more than one filter on the same tag.
 func ParseQueryRequest(
-	request *QueryRequest) ([]ParsedQuery, error) {
-	return parseQueryRequest(request)
+	request *QueryRequest, labelNames []string) ([]ParsedQuery, error) {
+	return parseQueryRequest(request, labelNames)
+}
+
+// IsTagk returns true if tagk is HostName, AppName, Region, IpAddress or
+// one of labelNames.
+func IsTagk(tagk string, labelNames []string) bool {
+	return isTagk(tagk, labelNames)
 }
 
 // TimeSeries represents a single time series in JSON.
@@ -7,6 +7,7 @@ import (
 	"github.com/Symantec/scotty/tsdb"
 	"github.com/Symantec/scotty/tsdb/aggregators"
 	"github.com/Symantec/tricorder/go/tricorder/duration"
+	"sort"
 	"strings"
 	"time"
 )
@@ -19,6 +20,13 @@ const (
 	kMaxDownSampleBuckets = 1000
 )
 
+const (
+	// Value in the tags of a query meaning group by that tag without
+	// filtering.
+	kWildcard  = "*"
+	kLiteralOr = "literal_or"
+)
+
 var (
 	kErrTimeRangeTooBig = errors.New(
 		"Please use a smaller time range or larger downsample size.")
@@ -31,7 +39,7 @@ type tagFilterInfoType struct {
 
 var (
 	kTagFiltersByName = map[string]*tagFilterInfoType{
-		"literal_or": {
+		kLiteralOr: {
 			New: newLiteralOr,
 			Description: &FilterDescription{
 				Examples:    "host=literal_or(web01),  host=literal_or(web01|web02|web03)  {\"type\":\"literal_or\",\"tagk\":\"host\",\"filter\":\"web01|web02|web03\",\"groupBy\":false}",
@@ -125,17 +133,14 @@ func newTimeSeriesSlice(
 	result := make([]TimeSeries, len(taggedTimeSeriesSet.Data))
 	for i, taggedTimeSeries := range taggedTimeSeriesSet.Data {
 		tags := make(map[string]string)
-		if taggedTimeSeriesSet.GroupedByHostName {
-			tags[HostName] = escape(taggedTimeSeries.Tags.HostName)
-		}
-		if taggedTimeSeriesSet.GroupedByAppName {
-			tags[AppName] = escape(taggedTimeSeries.Tags.AppName)
+		for _, name := range taggedTimeSeriesSet.GroupedBy {
+			tags[escape(name)] = escape(taggedTimeSeries.Tags[name])
 		}
 		aggregateTags := []string{}
-		if !taggedTimeSeriesSet.GroupedByHostName {
+		if !taggedTimeSeriesSet.IsGroupedBy(HostName) {
 			aggregateTags = append(aggregateTags, HostName)
 		}
-		if !taggedTimeSeriesSet.GroupedByAppName {
+		if !taggedTimeSeriesSet.IsGroupedBy(AppName) {
 			aggregateTags = append(aggregateTags, AppName)
 		}
 		result[i] = TimeSeries{
@@ -227,7 +232,47 @@ func (p *ParsedQuery) ensureStartTimeRecentEnough() {
 	}
 }
 
-func parseQueryRequest(request *QueryRequest) (
+// addFilter adds a filter on the tag called name. A nil spec means
+// no filter. If groupBy is true, addFilter also groups by that tag.
+// addFilter returns an error if there is already a filter on that tag.
+func (o *ParsedQueryOptions) addFilter(
+	name string, spec *FilterSpec, groupBy bool) error {
+	if spec != nil {
+		if o.Filters[name] != nil {
+			return errors.New(
+				fmt.Sprintf("More than one filter on tagk: '%s'", name))
+		}
+		if o.Filters == nil {
+			o.Filters = make(map[string]*FilterSpec)
+		}
+		o.Filters[name] = spec
+	}
+	if !groupBy {
+		return nil
+	}
+	for _, groupByName := range o.GroupBy {
+		if groupByName == name {
+			return nil
+		}
+	}
+	o.GroupBy = append(o.GroupBy, name)
+	return nil
+}
+
+func isTagk(tagk string, labelNames []string) bool {
+	switch tagk {
+	case HostName, AppName, Region, IpAddress:
+		return true
+	}
+	for _, name := range labelNames {
+		if name == tagk {
+			return true
+		}
+	}
+	return false
+}
+
+func parseQueryRequest(request *QueryRequest, labelNames []string) (
 	result []ParsedQuery, err error) {
 	parsedQueries := make([]ParsedQuery, len(request.Queries))
 	endInMillis := request.EndInMillis
@@ -249,36 +294,44 @@ func parseQueryRequest(request *QueryRequest) (
 		}
 		parsedQueries[i].Start = float64(request.StartInMillis) / 1000.0
 		parsedQueries[i].End = float64(endInMillis) / 1000.0
+		// Like OpenTSDB, tags are shorthand for filters that group by.
+		tagks := make([]string, 0, len(request.Queries[i].Tags))
+		for tagk := range request.Queries[i].Tags {
+			tagks = append(tagks, tagk)
+		}
+		sort.Strings(tagks)
+		for _, tagk := range tagks {
+			name := unescape(tagk)
+			if !isTagk(name, labelNames) {
+				err = errors.New(
+					fmt.Sprintf("Unrecognised tagk: '%s'", tagk))
+				return
+			}
+			var spec *FilterSpec
+			if tagv := request.Queries[i].Tags[tagk]; tagv != kWildcard {
+				spec = &FilterSpec{Type: kLiteralOr, Value: tagv}
+			}
+			if err = parsedQueries[i].Options.addFilter(
+				name, spec, true); err != nil {
+				return
+			}
+		}
 		for _, filter := range request.Queries[i].Filters {
-			if filter.Tagk == IpAddress {
-				parsedQueries[i].Options.IpAddressFilter = &FilterSpec{
-					Type:  filter.Type,
-					Value: filter.Filter,
-				}
-				parsedQueries[i].Options.GroupByIpAddress = filter.GroupBy
-			} else if filter.Tagk == Region {
-				parsedQueries[i].Options.RegionFilter = &FilterSpec{
-					Type:  filter.Type,
-					Value: filter.Filter,
-				}
-				parsedQueries[i].Options.GroupByRegion = filter.GroupBy
-			} else if filter.Tagk == HostName {
-				parsedQueries[i].Options.HostNameFilter = &FilterSpec{
-					Type:  filter.Type,
-					Value: filter.Filter,
-				}
-				parsedQueries[i].Options.GroupByHostName = filter.GroupBy
-			} else if filter.Tagk == AppName {
-				parsedQueries[i].Options.AppNameFilter = &FilterSpec{
-					Type:  filter.Type,
-					Value: filter.Filter,
-				}
-				parsedQueries[i].Options.GroupByAppName = filter.GroupBy
-			} else {
+			name := unescape(filter.Tagk)
+			if !isTagk(name, labelNames) {
 				err = errors.New(
 					fmt.Sprintf("Unrecognised tagk: '%s'", filter.Tagk))
 				return
 			}
+			if err = parsedQueries[i].Options.addFilter(
+				name,
+				&FilterSpec{
+					Type:  filter.Type,
+					Value: filter.Filter,
+				},
+				filter.GroupBy); err != nil {
+				return
+			}
 		}
 	}
 	return parsedQueries, nil
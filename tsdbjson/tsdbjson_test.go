@@ -8,6 +8,10 @@ import (
 	"testing"
 )
 
+var (
+	kLabelNames = []string{"env", "owner"}
+)
+
 func TestJson(t *testing.T) {
 	timeSeriesSet := &tsdb.TaggedTimeSeriesSet{
 		MetricName: "someMetric",
@@ -39,7 +43,7 @@ func TestJson(t *testing.T) {
 		Data: []tsdb.TaggedTimeSeries{
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host1",
+					tsdb.HostName: "host1",
 				},
 				Values: tsdb.TimeSeries{
 					{48.0, 72.0},
@@ -48,14 +52,14 @@ func TestJson(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host2",
+					tsdb.HostName: "host2",
 				},
 				Values: tsdb.TimeSeries{
 					{21.0, 29.0},
 				},
 			},
 		},
-		GroupedByHostName: true,
+		GroupedBy: []string{tsdb.HostName},
 	}
 	expected = []tsdbjson.TimeSeries{
 		{
@@ -84,7 +88,7 @@ func TestJson(t *testing.T) {
 		Data: []tsdb.TaggedTimeSeries{
 			{
 				Tags: tsdb.TagSet{
-					AppName: "app1",
+					tsdb.AppName: "app1",
 				},
 				Values: tsdb.TimeSeries{
 					{2.0, 3.0},
@@ -93,14 +97,14 @@ func TestJson(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					AppName: "app2",
+					tsdb.AppName: "app2",
 				},
 				Values: tsdb.TimeSeries{
 					{13.0, 21.0},
 				},
 			},
 		},
-		GroupedByAppName: true,
+		GroupedBy: []string{tsdb.AppName},
 	}
 	expected = []tsdbjson.TimeSeries{
 		{
@@ -129,8 +133,8 @@ func TestJson(t *testing.T) {
 		Data: []tsdb.TaggedTimeSeries{
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host1",
-					AppName:  "app1",
+					tsdb.HostName: "host1",
+					tsdb.AppName:  "app1",
 				},
 				Values: tsdb.TimeSeries{
 					{101.0, 202.0},
@@ -138,16 +142,15 @@ func TestJson(t *testing.T) {
 			},
 			{
 				Tags: tsdb.TagSet{
-					HostName: "host2",
-					AppName:  "app2",
+					tsdb.HostName: "host2",
+					tsdb.AppName:  "app2",
 				},
 				Values: tsdb.TimeSeries{
 					{303.0, 404.0},
 				},
 			},
 		},
-		GroupedByHostName: true,
-		GroupedByAppName:  true,
+		GroupedBy: []string{tsdb.HostName, tsdb.AppName},
 	}
 	expected = []tsdbjson.TimeSeries{
 		{
@@ -189,7 +192,7 @@ func TestParseQueryBadRequest(t *testing.T) {
 			},
 		},
 	}
-	_, err := tsdbjson.ParseQueryRequest(request)
+	_, err := tsdbjson.ParseQueryRequest(request, nil)
 	assertValueEquals(t, tsdbjson.ErrBadValue, err)
 }
 
@@ -270,7 +273,7 @@ func TestParseQueryRequest(t *testing.T) {
 			},
 		},
 	}
-	parsedRequests, err := tsdbjson.ParseQueryRequest(request)
+	parsedRequests, err := tsdbjson.ParseQueryRequest(request, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -300,25 +303,26 @@ func TestParseQueryRequest(t *testing.T) {
 			Start: 1456789123.125,
 			End:   1511789123.125,
 			Options: tsdbjson.ParsedQueryOptions{
-				HostNameFilter: &tsdbjson.FilterSpec{
-					Type:  "literal_or",
-					Value: "some_20Host",
-				},
-				AppNameFilter: &tsdbjson.FilterSpec{
-					Type:  "wildcard",
-					Value: "some_20App",
-				},
-				RegionFilter: &tsdbjson.FilterSpec{
-					Type:  "literal_or",
-					Value: "some_20Region",
-				},
-				IpAddressFilter: &tsdbjson.FilterSpec{
-					Type:  "literal_or",
-					Value: "some_20IpAddress",
+				Filters: map[string]*tsdbjson.FilterSpec{
+					tsdbjson.HostName: {
+						Type:  "literal_or",
+						Value: "some_20Host",
+					},
+					tsdbjson.AppName: {
+						Type:  "wildcard",
+						Value: "some_20App",
+					},
+					tsdbjson.Region: {
+						Type:  "literal_or",
+						Value: "some_20Region",
+					},
+					tsdbjson.IpAddress: {
+						Type:  "literal_or",
+						Value: "some_20IpAddress",
+					},
 				},
-				GroupByHostName:  true,
-				GroupByRegion:    true,
-				GroupByIpAddress: true,
+				GroupBy: []string{
+					tsdbjson.HostName, tsdbjson.Region, tsdbjson.IpAddress},
 			},
 		},
 		{
@@ -333,6 +337,135 @@ func TestParseQueryRequest(t *testing.T) {
 	assertValueDeepEquals(t, expected, parsedRequests)
 }
 
+func TestHostLabels(t *testing.T) {
+	request := &tsdbjson.QueryRequest{
+		StartInMillis: 1456789123125,
+		EndInMillis:   1511789123125,
+		Queries: []*tsdbjson.Query{
+			{
+				Metric:     "aMetric",
+				Aggregator: "avg",
+				DownSample: "10m-avg",
+				Filters: []*tsdbjson.Filter{
+					{
+						Type:    "literal_or",
+						Tagk:    "owner",
+						Filter:  "alice|bob",
+						GroupBy: true,
+					},
+					{
+						Type:   "wildcard",
+						Tagk:   "env",
+						Filter: "prod*",
+					},
+				},
+			},
+		},
+	}
+	parsedRequests, err := tsdbjson.ParseQueryRequest(request, kLabelNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := tsdbjson.ParsedQueryOptions{
+		Filters: map[string]*tsdbjson.FilterSpec{
+			"owner": {Type: "literal_or", Value: "alice|bob"},
+			"env":   {Type: "wildcard", Value: "prod*"},
+		},
+		GroupBy: []string{"owner"},
+	}
+	assertValueDeepEquals(t, expected, parsedRequests[0].Options)
+
+	timeSeriesSet := &tsdb.TaggedTimeSeriesSet{
+		MetricName: "aMetric",
+		Data: []tsdb.TaggedTimeSeries{
+			{
+				Tags: tsdb.TagSet{
+					tsdb.Region: "us-east-1",
+					"owner":     "Alice Smith",
+				},
+				Values: tsdb.TimeSeries{
+					{101.0, 202.0},
+				},
+			},
+		},
+		GroupedBy: []string{tsdb.Region, "owner"},
+	}
+	expectedSlice := []tsdbjson.TimeSeries{
+		{
+			Metric: "aMetric",
+			Tags: map[string]string{
+				"region": "us-east-1",
+				"owner":  "Alice_20Smith",
+			},
+			AggregateTags: []string{"HostName", "appname"},
+			Dps: tsdb.TimeSeries{
+				{101.0, 202.0},
+			},
+		},
+	}
+	assertTimeSeriesSliceEquals(
+		t, expectedSlice, tsdbjson.NewTimeSeriesSlice(timeSeriesSet))
+}
+
+func TestQueryTags(t *testing.T) {
+	request := &tsdbjson.QueryRequest{
+		StartInMillis: 1456789123125,
+		EndInMillis:   1511789123125,
+		Queries: []*tsdbjson.Query{
+			{
+				Metric:     "aMetric",
+				Aggregator: "avg",
+				Tags: map[string]string{
+					"HostName": "web01|web02",
+					"owner":    "*",
+					"env":      "*",
+				},
+				Filters: []*tsdbjson.Filter{
+					{
+						Type:    "literal_or",
+						Tagk:    "appname",
+						Filter:  "scotty",
+						GroupBy: true,
+					},
+					{
+						Type:   "wildcard",
+						Tagk:   "env",
+						Filter: "prod*",
+					},
+				},
+			},
+		},
+	}
+	parsedRequests, err := tsdbjson.ParseQueryRequest(request, kLabelNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := tsdbjson.ParsedQueryOptions{
+		Filters: map[string]*tsdbjson.FilterSpec{
+			"HostName": {Type: "literal_or", Value: "web01|web02"},
+			"appname":  {Type: "literal_or", Value: "scotty"},
+			"env":      {Type: "wildcard", Value: "prod*"},
+		},
+		GroupBy: []string{"HostName", "env", "owner", "appname"},
+	}
+	assertValueDeepEquals(t, expected, parsedRequests[0].Options)
+
+	request.Queries[0].Tags = map[string]string{"env": "prod"}
+	if _, err := tsdbjson.ParseQueryRequest(request, kLabelNames); err == nil {
+		t.Error("Expected error for tag and filter on same tagk")
+	}
+
+	request.Queries[0].Tags = map[string]string{"": "*"}
+	if _, err := tsdbjson.ParseQueryRequest(request, kLabelNames); err == nil {
+		t.Error("Expected error for empty tagk")
+	}
+
+	request.Queries[0].Tags = map[string]string{"owner": "*"}
+	if _, err := tsdbjson.ParseQueryRequest(request, nil); err == nil {
+		t.Error("Expected error for unrecognised tagk")
+	}
+}
+
 func TestTagFilter(t *testing.T) {
 	tagFilter, err := tsdbjson.NewTagFilter(
 		"literal_or", "Bad_20To|the_20bone")
@@ -390,7 +523,7 @@ func unescape(orig string) string {
 			},
 		},
 	}
-	parsedRequests, err := tsdbjson.ParseQueryRequest(request)
+	parsedRequests, err := tsdbjson.ParseQueryRequest(request, nil)
 	if err != nil {
 		panic(err)
 	}